@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/csv"
 	"encoding/json"
@@ -10,64 +11,100 @@ import (
 	"io"
 	"math"
 	"math/big"
+	"math/rand"
 	"os"
 	"path/filepath"
 	"regexp"
 	"sort"
 	"strings"
+
+	"golang.org/x/text/unicode/norm"
 )
 
 type csvTable struct {
 	Path    string
 	Headers []string
 	Rows    []map[string]string
+	// lazy is set instead of Rows for files loaded in streaming mode.
+	lazy *lazyRows
 }
 
 type colProfile struct {
-	RowCount                int      `json:"row_count"`
-	NonEmptyCount           int      `json:"non_empty_count"`
-	NullCount               int      `json:"null_count"`
-	UniqueNonEmptyCount     int      `json:"unique_non_empty_count"`
-	IsUniqueNonEmpty        bool     `json:"is_unique_non_empty"`
-	UniquenessRatioNonEmpty float64  `json:"uniqueness_ratio_non_empty"`
-	NumericRatio            float64  `json:"numeric_ratio"`
-	BoolRatio               float64  `json:"bool_ratio"`
-	AvgLenSample            float64  `json:"avg_len_sample"`
-	MaxLenSample            float64  `json:"max_len_sample"`
-	HeaderTokens            []string `json:"header_tokens"`
+	RowCount         int      `json:"row_count"`
+	NonEmptyCount    int      `json:"non_empty_count"`
+	NullCount        int      `json:"null_count"`
+	IsUniqueNonEmpty bool     `json:"is_unique_non_empty"`
+	NumericRatio     float64  `json:"numeric_ratio"`
+	BoolRatio        float64  `json:"bool_ratio"`
+	AvgLenSample     float64  `json:"avg_len_sample"`
+	MaxLenSample     float64  `json:"max_len_sample"`
+	HeaderTokens     []string `json:"header_tokens"`
+	InferredType     string   `json:"inferred_type"`
+	// canonSet holds the distinct non-empty canonical values of a unique
+	// column, reused by findKeyMatch so streaming tables are not rescanned per
+	// column pair. It is nil for columns with duplicates, which cannot be keys.
+	canonSet map[string]struct{}
 }
 
 type configPayload struct {
-	ReferenceCSV             string      `json:"reference_csv"`
-	CandidateCSV             string      `json:"candidate_csv"`
-	SampleSizeMapping        int         `json:"sample_size_mapping,omitempty"`
-	ColumnWeighting          interface{} `json:"column_weighting"`
-	MissingReferenceColScore float64     `json:"missing_reference_column_score"`
-	ExtraCandidatePenalize   bool        `json:"extra_candidate_columns_penalize"`
+	ReferenceCSV             string            `json:"reference_csv"`
+	CandidateCSV             string            `json:"candidate_csv"`
+	SampleSizeMapping        int               `json:"sample_size_mapping,omitempty"`
+	MappingSampleSeed        int64             `json:"mapping_sample_seed"`
+	TextMode                 string            `json:"text_mode"`
+	MappingOverrides         map[string]string `json:"mapping_overrides,omitempty"`
+	ColumnWeighting          interface{}       `json:"column_weighting"`
+	MissingReferenceColScore float64           `json:"missing_reference_column_score"`
+	MinMappingConfidence     float64           `json:"min_mapping_confidence"`
+	MinSampleSimilarity      float64           `json:"min_sample_similarity"`
+	Delimiter                string            `json:"delimiter,omitempty"`
+	UnicodeNormalize         bool              `json:"unicode_normalize"`
+	ExtraCandidatePenalize   bool              `json:"extra_candidate_columns_penalize"`
 }
 
 type refProfilePayload struct {
-	RowCount      int      `json:"row_count"`
-	ColumnCount   int      `json:"column_count"`
-	UniqueColumns []string `json:"unique_columns"`
+	RowCount      int                             `json:"row_count"`
+	ColumnCount   int                             `json:"column_count"`
+	UniqueColumns []string                        `json:"unique_columns"`
+	Columns       map[string]columnProfileSummary `json:"columns"`
 }
 
 type candProfilePayload struct {
-	RowCount    int `json:"row_count"`
-	ColumnCount int `json:"column_count"`
+	RowCount    int                             `json:"row_count"`
+	ColumnCount int                             `json:"column_count"`
+	Columns     map[string]columnProfileSummary `json:"columns"`
+}
+
+// columnProfileSummary is the per-column slice of colProfile surfaced in the
+// report so users can see why columns were (not) mapped to each other.
+type columnProfileSummary struct {
+	InferredType string  `json:"inferred_type"`
+	NumericRatio float64 `json:"numeric_ratio"`
+	BoolRatio    float64 `json:"bool_ratio"`
+	NullCount    int     `json:"null_count"`
 }
 
 type keyCandidate struct {
-	ReferenceColumn      string  `json:"reference_column"`
-	CandidateColumn      string  `json:"candidate_column"`
-	CompleteSetMatch     bool    `json:"complete_set_match"`
-	IntersectionCount    int     `json:"intersection_count"`
-	CandidateKeyCoverage float64 `json:"candidate_key_coverage"`
-	ReferenceKeyCoverage float64 `json:"reference_key_coverage"`
-	HeaderSimilarity     float64 `json:"header_similarity"`
-	ReferenceNonEmpty    int     `json:"reference_non_empty_count"`
-	CandidateNonEmpty    int     `json:"candidate_non_empty_count"`
-	Score                float64 `json:"score"`
+	ReferenceColumn      string             `json:"reference_column"`
+	CandidateColumn      string             `json:"candidate_column"`
+	CompleteSetMatch     bool               `json:"complete_set_match"`
+	IntersectionCount    int                `json:"intersection_count"`
+	CandidateKeyCoverage float64            `json:"candidate_key_coverage"`
+	ReferenceKeyCoverage float64            `json:"reference_key_coverage"`
+	HeaderSimilarity     float64            `json:"header_similarity"`
+	ReferenceNonEmpty    int                `json:"reference_non_empty_count"`
+	CandidateNonEmpty    int                `json:"candidate_non_empty_count"`
+	Score                float64            `json:"score"`
+	ScoreComponents      keyScoreComponents `json:"score_components"`
+}
+
+// keyScoreComponents are the unrounded terms that sum to keyCandidate.Score.
+type keyScoreComponents struct {
+	CompleteBonus     float64 `json:"complete_bonus"`
+	CandidateCoverage float64 `json:"candidate_coverage_term"`
+	ReferenceCoverage float64 `json:"reference_coverage_term"`
+	HeaderSimilarity  float64 `json:"header_similarity_term"`
+	Support           float64 `json:"support_term"`
 }
 
 type keyMatchPayload struct {
@@ -102,6 +139,7 @@ type mappingPair struct {
 	TypeCompatibility float64 `json:"type_compatibility"`
 	SampleSimilarity  float64 `json:"sample_similarity"`
 	MappingConfidence float64 `json:"mapping_confidence"`
+	Overridden        bool    `json:"overridden,omitempty"`
 }
 
 type columnMappingPayload struct {
@@ -109,6 +147,7 @@ type columnMappingPayload struct {
 	ReferenceUnmatched   []string               `json:"reference_unmatched"`
 	CandidateUnmatched   []string               `json:"candidate_unmatched"`
 	MappingConfidenceAvg float64                `json:"mapping_confidence_avg"`
+	UnmatchedReasons     map[string]string      `json:"reference_unmatched_reasons,omitempty"`
 	PairCandidatesTop    []mappingPair          `json:"pair_candidates_top"`
 }
 
@@ -122,6 +161,9 @@ type perColumnScore struct {
 	RowCountScored    int     `json:"row_count_scored,omitempty"`
 	HeaderSimilarity  float64 `json:"header_similarity,omitempty"`
 	SampleSimilarity  float64 `json:"sample_similarity,omitempty"`
+	Overridden        bool    `json:"overridden,omitempty"`
+	// ValueDistOverlap is set with -value-dist; see valueDistributionOverlap.
+	ValueDistOverlap *float64 `json:"value_distribution_overlap,omitempty"`
 }
 
 type scoresPayload struct {
@@ -158,8 +200,93 @@ type reportPayload struct {
 	KeyMatch         keyMatchPayload      `json:"key_match"`
 	ColumnMapping    columnMappingPayload `json:"column_mapping"`
 	Scores           scoresPayload        `json:"scores"`
+	// MappingAccuracy is set with -expected-mapping; see checkExpectedMapping.
+	MappingAccuracy *mappingAccuracyPayload `json:"mapping_accuracy,omitempty"`
+}
+
+type mappingAccuracyPayload struct {
+	Expected   int               `json:"expected"`
+	Correct    int               `json:"correct"`
+	Accuracy   float64           `json:"accuracy"`
+	Mismatches []mappingMismatch `json:"mismatches"`
+}
+
+type mappingMismatch struct {
+	ReferenceColumn   string  `json:"reference_column"`
+	ExpectedCandidate string  `json:"expected_candidate"`
+	ActualCandidate   *string `json:"actual_candidate"`
+}
+
+type multiReportPayload struct {
+	ReferenceCSV string          `json:"reference_csv"`
+	Reports      []reportPayload `json:"reports"`
+	Ranking      []rankingEntry  `json:"ranking"`
+}
+
+type rankingEntry struct {
+	Rank                           int     `json:"rank"`
+	CandidateCSV                   string  `json:"candidate_csv"`
+	Status                         string  `json:"status"`
+	OverallScoreWithCoverage       float64 `json:"overall_score_with_coverage"`
+	DatasetSimilarityEqualWeighted float64 `json:"dataset_similarity_equal_weighted"`
+	CoverageReference              float64 `json:"coverage_reference"`
+}
+
+const (
+	textModeLevenshtein = "levenshtein"
+	textModeTokenSet    = "tokenset"
+	tokenSetMinRunes    = 48
+)
+
+type compareOptions struct {
+	SampleSizeMapping int
+	// MappingSampleSeed seeds the choice of aligned rows sampled for mapping.
+	MappingSampleSeed int64
+	TextMode          string
+	MappingOverrides  map[string]string
+	PenalizeExtra     bool
+	// SkipUnicodeNormalize keeps cell text as read instead of folding NBSP-like
+	// spaces and composing to NFC (-unicode-normalize=false).
+	SkipUnicodeNormalize bool
+	// StreamThreshold is the file size in bytes above which CSVs are indexed
+	// and read lazily instead of loaded into memory; 0 disables streaming.
+	StreamThreshold int64
+	// Delimiter separates fields in both input files; 0 means ','.
+	Delimiter rune
+	// ReferenceFormat and CandidateFormat are "csv" (default) or "jsonl".
+	ReferenceFormat string
+	CandidateFormat string
+	// Thresholds overrides defaultMappingThresholds when non-nil.
+	Thresholds *mappingThresholds
+	// ValueDistTopK enables the per-column top-K value distribution check; 0 disables it.
+	ValueDistTopK int
+	// ExpectedMapping is the known {reference: candidate} mapping (e.g.
+	// shuffle-csv's rename map) the column mapping is checked against.
+	ExpectedMapping map[string]string
+	// CompactJSON writes the JSON report minified instead of indented.
+	CompactJSON bool
+}
+
+// mappingThresholds are the cutoffs a column pair must meet (either one) to map.
+type mappingThresholds struct {
+	MinConfidence float64
+	MinSampleSim  float64
+}
+
+var defaultMappingThresholds = mappingThresholds{MinConfidence: 0.55, MinSampleSim: 0.85}
+
+func (o compareOptions) thresholds() mappingThresholds {
+	if o.Thresholds == nil {
+		return defaultMappingThresholds
+	}
+	return *o.Thresholds
 }
 
+const (
+	formatCSV   = "csv"
+	formatJSONL = "jsonl"
+)
+
 var (
 	reNumeric          = regexp.MustCompile(`^[+-]?(?:\d+\.?\d*|\.\d+)$`)
 	reToken            = regexp.MustCompile(`[a-z0-9]+`)
@@ -184,108 +311,445 @@ var (
 
 func main() {
 	reference := flag.String("reference", "outputs/sample_products_reference.csv", "Reference CSV (ground truth)")
-	candidate := flag.String("candidate", "outputs/sample_products_candidate1.csv", "Candidate CSV to evaluate")
+	var candidates stringListFlag
+	flag.Var(&candidates, "candidate", "Candidate CSV to evaluate (repeatable; default outputs/sample_products_candidate1.csv)")
+	candidatesGlob := flag.String("candidates", "", "Optional glob of candidate CSVs to evaluate against the same reference")
 	outputJSON := flag.String("output-json", "", "Optional path to write JSON report")
 	sampleSizeMapping := flag.Int("sample-size-mapping", 256, "Aligned-row sample size used for column mapping confidence")
+	mappingSampleSeed := flag.Int64("mapping-sample-seed", defaultMappingSampleSeed, "Seed for the aligned rows sampled by -sample-size-mapping")
+	textMode := flag.String("text-mode", textModeLevenshtein, "Text similarity for long values: levenshtein|tokenset")
+	penalizeExtra := flag.Bool("penalize-extra", false, "Scale the overall score by matched/(matched+unmatched candidate columns)")
+	unicodeNormalize := flag.Bool("unicode-normalize", true, "Apply Unicode NFC and fold non-breaking spaces before comparing text")
+	streamThresholdMB := flag.Int64("stream-threshold-mb", 512, "Index CSVs larger than this many MB and read rows lazily (0 = always load into memory)")
+	minConfidence := flag.Float64("min-confidence", defaultMappingThresholds.MinConfidence, "Minimum mapping confidence for a column pair to map")
+	minSampleSim := flag.Float64("min-sample-sim", defaultMappingThresholds.MinSampleSim, "Minimum aligned-sample similarity for a column pair to map regardless of confidence")
+	minOverall := flag.Float64("min-overall", 0, "Exit with code 2 when any overall score with coverage is below this (0 = no gate)")
+	valueDist := flag.Bool("value-dist", false, "Report top-K value distribution overlap for each matched column")
+	valueDistK := flag.Int("value-dist-k", 20, "Number of most frequent values compared by -value-dist")
+	referenceFormat := flag.String("reference-format", formatCSV, "Reference file format: csv|jsonl")
+	candidateFormat := flag.String("candidate-format", formatCSV, "Candidate file format: csv|jsonl")
+	delimiter := flag.String("delimiter", ",", `Field delimiter for reference and candidate CSVs (e.g. ";" or "\t")`)
+	compactJSON := flag.Bool("compact-json", false, "Write the JSON report minified instead of indented")
+	mappingOverride := flag.String("mapping-override", "", "Optional JSON file of {reference_column: candidate_column} pairs forced before heuristic mapping")
+	expectedMapping := flag.String("expected-mapping", "", "Optional JSON file of the true {reference_column: candidate_column} mapping (e.g. shuffle-csv -mapping-out); adds mapping_accuracy to the report")
 	flag.Parse()
 
-	report, err := compareCSVFiles(*reference, *candidate, *sampleSizeMapping)
+	candidatePaths, err := resolveCandidatePaths(candidates, *candidatesGlob)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "compare error: %v\n", err)
+		fmt.Fprintf(os.Stderr, "candidate error: %v\n", err)
 		os.Exit(1)
 	}
-
-	payload, err := json.MarshalIndent(report, "", "  ")
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "json encode error: %v\n", err)
+	opts := compareOptions{
+		SampleSizeMapping:    *sampleSizeMapping,
+		MappingSampleSeed:    *mappingSampleSeed,
+		TextMode:             *textMode,
+		PenalizeExtra:        *penalizeExtra,
+		SkipUnicodeNormalize: !*unicodeNormalize,
+		StreamThreshold:      *streamThresholdMB << 20,
+		ReferenceFormat:      *referenceFormat,
+		CandidateFormat:      *candidateFormat,
+		Thresholds:           &mappingThresholds{MinConfidence: *minConfidence, MinSampleSim: *minSampleSim},
+		CompactJSON:          *compactJSON,
+	}
+	if *valueDist {
+		opts.ValueDistTopK = *valueDistK
+	}
+	if opts.Delimiter, err = parseDelimiter(*delimiter); err != nil {
+		fmt.Fprintf(os.Stderr, "delimiter error: %v\n", err)
 		os.Exit(1)
 	}
-
-	if *outputJSON != "" {
-		if err := os.MkdirAll(filepath.Dir(*outputJSON), 0o755); err != nil {
-			fmt.Fprintf(os.Stderr, "mkdir error: %v\n", err)
+	if *mappingOverride != "" {
+		opts.MappingOverrides, err = loadMappingOverrides(*mappingOverride)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "mapping override error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if *expectedMapping != "" {
+		opts.ExpectedMapping, err = loadMappingOverrides(*expectedMapping)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "expected mapping error: %v\n", err)
 			os.Exit(1)
 		}
-		if err := os.WriteFile(*outputJSON, append(payload, '\n'), 0o644); err != nil {
-			fmt.Fprintf(os.Stderr, "write report error: %v\n", err)
+	}
+
+	if len(candidatePaths) > 1 {
+		multi, err := compareCandidateFiles(*reference, candidatePaths, opts)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "compare error: %v\n", err)
 			os.Exit(1)
 		}
-		fmt.Printf("Wrote JSON report: %s\n", *outputJSON)
+		payload := mustMarshalReport(multi, opts.CompactJSON)
+		if *outputJSON != "" {
+			writeReportJSON(*outputJSON, payload)
+			for _, entry := range multi.Ranking {
+				fmt.Printf("#%d %s: overall=%.12f similarity=%.12f coverage_reference=%.12f status=%s\n",
+					entry.Rank, entry.CandidateCSV, entry.OverallScoreWithCoverage, entry.DatasetSimilarityEqualWeighted, entry.CoverageReference, entry.Status)
+			}
+		} else {
+			fmt.Println(string(payload))
+		}
+		for _, entry := range multi.Ranking {
+			exitIfBelowMinOverall(entry.CandidateCSV, entry.OverallScoreWithCoverage, *minOverall)
+		}
+		return
+	}
+
+	report, err := compareCSVFilesWithOptions(*reference, candidatePaths[0], opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "compare error: %v\n", err)
+		os.Exit(1)
+	}
+
+	payload := mustMarshalReport(report, opts.CompactJSON)
+	if *outputJSON != "" {
+		writeReportJSON(*outputJSON, payload)
 		fmt.Printf("Status: %s\n", report.Status)
 		fmt.Printf("Dataset similarity (equal weighted): %.12f\n", report.Scores.DatasetSimilarityEqualWeighted)
 		fmt.Printf("Coverage (reference/candidate): %.12f / %.12f\n", report.RowAlignment.CoverageReference, report.RowAlignment.CoverageCandidate)
 		fmt.Printf("Overall score with coverage: %.12f\n", report.Scores.OverallScoreWithCoverage)
+	} else {
+		fmt.Println(string(payload))
+	}
+	exitIfBelowMinOverall(report.Config.CandidateCSV, report.Scores.OverallScoreWithCoverage, *minOverall)
+}
+
+// belowMinOverall reports whether score fails the -min-overall gate; a
+// non-positive minimum disables the gate.
+func belowMinOverall(score, minOverall float64) bool {
+	return minOverall > 0 && score < minOverall
+}
+
+func exitIfBelowMinOverall(candidate string, score, minOverall float64) {
+	if !belowMinOverall(score, minOverall) {
 		return
 	}
-	fmt.Println(string(payload))
+	fmt.Fprintf(os.Stderr, "quality gate failed: %s overall score with coverage %.6f is below -min-overall %.6f\n", candidate, score, minOverall)
+	os.Exit(2)
+}
+
+// mustMarshalReport encodes v indented, or minified with compact
+// (-compact-json).
+func mustMarshalReport(v any, compact bool) []byte {
+	var payload []byte
+	var err error
+	if compact {
+		payload, err = json.Marshal(v)
+	} else {
+		payload, err = json.MarshalIndent(v, "", "  ")
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "json encode error: %v\n", err)
+		os.Exit(1)
+	}
+	return payload
+}
+
+func writeReportJSON(path string, payload []byte) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		fmt.Fprintf(os.Stderr, "mkdir error: %v\n", err)
+		os.Exit(1)
+	}
+	if err := os.WriteFile(path, append(payload, '\n'), 0o644); err != nil {
+		fmt.Fprintf(os.Stderr, "write report error: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Wrote JSON report: %s\n", path)
+}
+
+type stringListFlag []string
+
+func (f *stringListFlag) String() string { return strings.Join(*f, ",") }
+
+func (f *stringListFlag) Set(v string) error {
+	*f = append(*f, v)
+	return nil
+}
+
+func resolveCandidatePaths(explicit []string, glob string) ([]string, error) {
+	paths := append([]string(nil), explicit...)
+	if strings.TrimSpace(glob) != "" {
+		matches, err := filepath.Glob(glob)
+		if err != nil {
+			return nil, err
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("no candidate files match %q", glob)
+		}
+		sort.Strings(matches)
+		paths = append(paths, matches...)
+	}
+	if len(paths) == 0 {
+		paths = append(paths, "outputs/sample_products_candidate1.csv")
+	}
+	return paths, nil
 }
 
 func compareCSVFiles(referenceCSV, candidateCSV string, sampleSizeMapping int) (reportPayload, error) {
-	if sampleSizeMapping < 0 {
-		sampleSizeMapping = 0
+	return compareCSVFilesWithOptions(referenceCSV, candidateCSV, compareOptions{
+		SampleSizeMapping: sampleSizeMapping,
+		MappingSampleSeed: defaultMappingSampleSeed,
+		TextMode:          textModeLevenshtein,
+	})
+}
+
+func compareCSVFilesWithOptions(referenceCSV, candidateCSV string, opts compareOptions) (reportPayload, error) {
+	opts, err := normalizeCompareOptions(opts)
+	if err != nil {
+		return reportPayload{}, err
 	}
-	ref, err := loadCSV(referenceCSV)
+	ref, err := loadTable(referenceCSV, opts.ReferenceFormat, opts)
 	if err != nil {
 		return reportPayload{}, err
 	}
-	cand, err := loadCSV(candidateCSV)
+	defer ref.Close()
+	cand, err := loadTable(candidateCSV, opts.CandidateFormat, opts)
 	if err != nil {
 		return reportPayload{}, err
 	}
+	defer cand.Close()
+	if err := validateMappingOverrides(ref, cand, opts.MappingOverrides); err != nil {
+		return reportPayload{}, err
+	}
+	report := compareTables(ref, profileColumns(ref), cand, opts)
+	report.MappingAccuracy = checkExpectedMapping(report.ColumnMapping, opts.ExpectedMapping)
+	if err := firstErr(ref.readErr(), cand.readErr()); err != nil {
+		return reportPayload{}, err
+	}
+	return report, nil
+}
 
+func compareCandidateFiles(referenceCSV string, candidateCSVs []string, opts compareOptions) (multiReportPayload, error) {
+	opts, err := normalizeCompareOptions(opts)
+	if err != nil {
+		return multiReportPayload{}, err
+	}
+	ref, err := loadTable(referenceCSV, opts.ReferenceFormat, opts)
+	if err != nil {
+		return multiReportPayload{}, err
+	}
+	defer ref.Close()
 	refProfiles := profileColumns(ref)
+	reports := make([]reportPayload, 0, len(candidateCSVs))
+	for _, path := range candidateCSVs {
+		cand, err := loadTable(path, opts.CandidateFormat, opts)
+		if err != nil {
+			return multiReportPayload{}, fmt.Errorf("%s: %w", path, err)
+		}
+		if err := validateMappingOverrides(ref, cand, opts.MappingOverrides); err != nil {
+			cand.Close()
+			return multiReportPayload{}, fmt.Errorf("%s: %w", path, err)
+		}
+		report := compareTables(ref, refProfiles, cand, opts)
+		report.MappingAccuracy = checkExpectedMapping(report.ColumnMapping, opts.ExpectedMapping)
+		cand.Close()
+		if err := firstErr(ref.readErr(), cand.readErr()); err != nil {
+			return multiReportPayload{}, fmt.Errorf("%s: %w", path, err)
+		}
+		reports = append(reports, report)
+	}
+	return multiReportPayload{
+		ReferenceCSV: ref.Path,
+		Reports:      reports,
+		Ranking:      rankReports(reports),
+	}, nil
+}
+
+func rankReports(reports []reportPayload) []rankingEntry {
+	ranking := make([]rankingEntry, 0, len(reports))
+	for _, r := range reports {
+		ranking = append(ranking, rankingEntry{
+			CandidateCSV:                   r.Config.CandidateCSV,
+			Status:                         r.Status,
+			OverallScoreWithCoverage:       r.Scores.OverallScoreWithCoverage,
+			DatasetSimilarityEqualWeighted: r.Scores.DatasetSimilarityEqualWeighted,
+			CoverageReference:              r.RowAlignment.CoverageReference,
+		})
+	}
+	sort.SliceStable(ranking, func(i, j int) bool {
+		if ranking[i].OverallScoreWithCoverage == ranking[j].OverallScoreWithCoverage {
+			return ranking[i].CandidateCSV < ranking[j].CandidateCSV
+		}
+		return ranking[i].OverallScoreWithCoverage > ranking[j].OverallScoreWithCoverage
+	})
+	for i := range ranking {
+		ranking[i].Rank = i + 1
+	}
+	return ranking
+}
+
+func normalizeCompareOptions(opts compareOptions) (compareOptions, error) {
+	if opts.SampleSizeMapping < 0 {
+		opts.SampleSizeMapping = 0
+	}
+	if opts.TextMode == "" {
+		opts.TextMode = textModeLevenshtein
+	}
+	if opts.TextMode != textModeLevenshtein && opts.TextMode != textModeTokenSet {
+		return opts, fmt.Errorf("unknown text mode %q (want %s or %s)", opts.TextMode, textModeLevenshtein, textModeTokenSet)
+	}
+	for _, f := range []*string{&opts.ReferenceFormat, &opts.CandidateFormat} {
+		if *f == "" {
+			*f = formatCSV
+		}
+		if *f != formatCSV && *f != formatJSONL {
+			return opts, fmt.Errorf("unknown input format %q (want %s or %s)", *f, formatCSV, formatJSONL)
+		}
+	}
+	return opts, nil
+}
+
+func loadMappingOverrides(path string) (map[string]string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	overrides := map[string]string{}
+	if err := json.Unmarshal(b, &overrides); err != nil {
+		return nil, fmt.Errorf("parse mapping override %s: %w", path, err)
+	}
+	return overrides, nil
+}
+
+// checkExpectedMapping compares the chosen column mapping with the expected
+// one. It returns nil when no mapping is expected.
+func checkExpectedMapping(mapping columnMappingPayload, expected map[string]string) *mappingAccuracyPayload {
+	if len(expected) == 0 {
+		return nil
+	}
+	refCols := make([]string, 0, len(expected))
+	for refCol := range expected {
+		refCols = append(refCols, refCol)
+	}
+	sort.Strings(refCols)
+
+	out := &mappingAccuracyPayload{Expected: len(expected), Mismatches: []mappingMismatch{}}
+	for _, refCol := range refCols {
+		want := expected[refCol]
+		pair, ok := mapping.Mapping[refCol]
+		if ok && pair.CandidateColumn == want {
+			out.Correct++
+			continue
+		}
+		var actual *string
+		if ok {
+			candCol := pair.CandidateColumn
+			actual = &candCol
+		}
+		out.Mismatches = append(out.Mismatches, mappingMismatch{ReferenceColumn: refCol, ExpectedCandidate: want, ActualCandidate: actual})
+	}
+	out.Accuracy = float64(out.Correct) / float64(out.Expected)
+	return out
+}
+
+func validateMappingOverrides(ref, cand csvTable, overrides map[string]string) error {
+	refCols := make(map[string]struct{}, len(ref.Headers))
+	for _, h := range ref.Headers {
+		refCols[h] = struct{}{}
+	}
+	candCols := make(map[string]struct{}, len(cand.Headers))
+	for _, h := range cand.Headers {
+		candCols[h] = struct{}{}
+	}
+	seenCand := make(map[string]string, len(overrides))
+	refKeys := make([]string, 0, len(overrides))
+	for refCol := range overrides {
+		refKeys = append(refKeys, refCol)
+	}
+	sort.Strings(refKeys)
+	for _, refCol := range refKeys {
+		candCol := overrides[refCol]
+		if _, ok := refCols[refCol]; !ok {
+			return fmt.Errorf("mapping override: reference column %q not found", refCol)
+		}
+		if _, ok := candCols[candCol]; !ok {
+			return fmt.Errorf("mapping override: candidate column %q not found", candCol)
+		}
+		if other, dup := seenCand[candCol]; dup {
+			return fmt.Errorf("mapping override: candidate column %q assigned to both %q and %q", candCol, other, refCol)
+		}
+		seenCand[candCol] = refCol
+	}
+	return nil
+}
+
+func compareTables(ref csvTable, refProfiles map[string]colProfile, cand csvTable, opts compareOptions) reportPayload {
 	candProfiles := profileColumns(cand)
 	keyMatch := findKeyMatch(ref, cand, refProfiles, candProfiles)
 	if !keyMatch.FoundUsableMatch {
-		return zeroResult(ref, cand, refProfiles, candProfiles, keyMatch, rowAlignmentPayload{}), nil
+		return zeroResult(ref, cand, refProfiles, candProfiles, keyMatch, rowAlignmentPayload{}, opts)
 	}
 
 	refKey := derefStr(keyMatch.ReferenceColumn)
 	candKey := derefStr(keyMatch.CandidateColumn)
 	alignment := alignRowsByKey(ref, cand, refKey, candKey)
 	if alignment.MatchedRows == 0 {
-		return zeroResult(ref, cand, refProfiles, candProfiles, keyMatch, alignment), nil
+		return zeroResult(ref, cand, refProfiles, candProfiles, keyMatch, alignment, opts)
 	}
 
-	columnMapping := mapColumns(ref, cand, refProfiles, candProfiles, alignment.Pairs, sampleSizeMapping)
-	scores := scoreColumns(ref, cand, alignment.Pairs, columnMapping.Mapping)
+	columnMapping := mapColumns(ref, cand, refProfiles, candProfiles, sampleMappingPairs(alignment.Pairs, opts.SampleSizeMapping, opts.MappingSampleSeed), opts.MappingOverrides, opts.thresholds())
+	scores := scoreColumns(ref, cand, alignment.Pairs, columnMapping, opts)
 	scores.OverallScoreWithCoverage = scores.DatasetSimilarityEqualWeighted * alignment.CoverageReference
+	if opts.PenalizeExtra {
+		scores.OverallScoreWithCoverage *= extraColumnPenaltyFactor(scores.MappedReferenceColumns, len(columnMapping.CandidateUnmatched))
+	}
 
 	return reportPayload{
 		Status: ternary(alignment.Complete, "ok", "partial_key_match"),
 		Config: configPayload{
 			ReferenceCSV:             ref.Path,
 			CandidateCSV:             cand.Path,
-			SampleSizeMapping:        sampleSizeMapping,
+			SampleSizeMapping:        opts.SampleSizeMapping,
+			MappingSampleSeed:        opts.MappingSampleSeed,
+			TextMode:                 opts.TextMode,
+			MappingOverrides:         opts.MappingOverrides,
 			ColumnWeighting:          map[string]string{"columns": "equal"},
 			MissingReferenceColScore: 0.0,
-			ExtraCandidatePenalize:   false,
+			MinMappingConfidence:     opts.thresholds().MinConfidence,
+			MinSampleSimilarity:      opts.thresholds().MinSampleSim,
+			Delimiter:                delimiterLabel(opts.Delimiter),
+			UnicodeNormalize:         !opts.SkipUnicodeNormalize,
+			ExtraCandidatePenalize:   opts.PenalizeExtra,
 		},
 		ReferenceProfile: refProfilePayload{
-			RowCount:      len(ref.Rows),
+			RowCount:      ref.rowCount(),
 			ColumnCount:   len(ref.Headers),
 			UniqueColumns: uniqueColumns(refProfiles, ref.Headers),
+			Columns:       summarizeProfiles(refProfiles),
 		},
 		CandidateProfile: candProfilePayload{
-			RowCount:    len(cand.Rows),
+			RowCount:    cand.rowCount(),
 			ColumnCount: len(cand.Headers),
+			Columns:     summarizeProfiles(candProfiles),
 		},
 		RowAlignment:  alignment.withoutPairs(),
 		KeyMatch:      keyMatch,
 		ColumnMapping: columnMapping,
 		Scores:        scores,
 		Summary:       buildSummary(ternary(alignment.Complete, "ok", "partial_key_match"), alignment, keyMatch, scores),
-	}, nil
+	}
+}
+
+// extraColumnPenaltyFactor is matched/(matched+extra): each candidate column
+// that maps to no reference column dilutes the score like a zero-similarity column.
+func extraColumnPenaltyFactor(matched, extra int) float64 {
+	if matched == 0 {
+		return 0
+	}
+	return float64(matched) / float64(matched+extra)
 }
 
 func loadCSV(path string) (csvTable, error) {
+	return loadCSVWithDelimiter(path, ',')
+}
+
+func loadCSVWithDelimiter(path string, delimiter rune) (csvTable, error) {
 	b, err := os.ReadFile(path)
 	if err != nil {
 		return csvTable{}, err
 	}
 	b = bytes.TrimPrefix(b, []byte{0xEF, 0xBB, 0xBF})
-	r := csv.NewReader(bytes.NewReader(b))
-	r.FieldsPerRecord = -1
+	r := newCSVReader(bytes.NewReader(b), delimiter)
 	headers, err := r.Read()
 	if err != nil {
 		return csvTable{}, err
@@ -299,26 +763,322 @@ func loadCSV(path string) (csvTable, error) {
 		if err != nil {
 			return csvTable{}, err
 		}
-		row := make(map[string]string, len(headers))
-		for i, h := range headers {
-			if i < len(rec) {
-				row[h] = rec[i]
-			} else {
-				row[h] = ""
+		rows = append(rows, recordToRow(headers, rec))
+	}
+	return csvTable{Path: path, Headers: headers, Rows: rows}, nil
+}
+
+// loadTable loads path as format and, unless opts.SkipUnicodeNormalize is
+// set, applies normalizeUnicode to every cell (as rows are read for streaming
+// tables).
+func loadTable(path, format string, opts compareOptions) (csvTable, error) {
+	var t csvTable
+	var err error
+	if format == formatJSONL {
+		t, err = loadJSONL(path)
+	} else {
+		t, err = loadCSVWithThreshold(path, opts.StreamThreshold, opts.Delimiter)
+	}
+	if err != nil || opts.SkipUnicodeNormalize {
+		return t, err
+	}
+	if t.lazy != nil {
+		t.lazy.normalize = true
+		return t, nil
+	}
+	for _, row := range t.Rows {
+		normalizeRowUnicode(row)
+	}
+	return t, nil
+}
+
+// loadJSONL flattens each object's top-level keys into a row. Headers are the
+// union of keys in first-seen order; missing keys and nulls become empty cells
+// and nested values are kept as compact JSON.
+func loadJSONL(path string) (csvTable, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return csvTable{}, err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 0, 1024*1024), 64*1024*1024)
+	var headers []string
+	seen := map[string]struct{}{}
+	var rows []map[string]string
+	line := 0
+	for sc.Scan() {
+		line++
+		text := bytes.TrimSpace(sc.Bytes())
+		if line == 1 {
+			text = bytes.TrimPrefix(text, []byte{0xEF, 0xBB, 0xBF})
+		}
+		if len(text) == 0 {
+			continue
+		}
+		dec := json.NewDecoder(bytes.NewReader(text))
+		dec.UseNumber()
+		tok, err := dec.Token()
+		if err != nil || tok != json.Delim('{') {
+			return csvTable{}, fmt.Errorf("%s:%d: expected a JSON object", path, line)
+		}
+		row := map[string]string{}
+		for dec.More() {
+			keyTok, err := dec.Token()
+			if err != nil {
+				return csvTable{}, fmt.Errorf("%s:%d: %w", path, line, err)
+			}
+			key := keyTok.(string)
+			var v any
+			if err := dec.Decode(&v); err != nil {
+				return csvTable{}, fmt.Errorf("%s:%d: %w", path, line, err)
 			}
+			if _, ok := seen[key]; !ok {
+				seen[key] = struct{}{}
+				headers = append(headers, key)
+			}
+			row[key] = jsonCellString(v)
+		}
+		if _, err := dec.Token(); err != nil {
+			return csvTable{}, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		if _, err := dec.Token(); err != io.EOF {
+			return csvTable{}, fmt.Errorf("%s:%d: unexpected data after the JSON object", path, line)
 		}
 		rows = append(rows, row)
 	}
+	if err := sc.Err(); err != nil {
+		return csvTable{}, err
+	}
+	for _, row := range rows {
+		for _, h := range headers {
+			if _, ok := row[h]; !ok {
+				row[h] = ""
+			}
+		}
+	}
 	return csvTable{Path: path, Headers: headers, Rows: rows}, nil
 }
 
-func zeroResult(ref, cand csvTable, refProfiles, candProfiles map[string]colProfile, keyMatch keyMatchPayload, alignment rowAlignmentPayload) reportPayload {
+func jsonCellString(v any) string {
+	switch t := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return t
+	case json.Number:
+		return t.String()
+	case bool:
+		if t {
+			return "true"
+		}
+		return "false"
+	default:
+		b, err := json.Marshal(t)
+		if err != nil {
+			return fmt.Sprint(t)
+		}
+		return string(b)
+	}
+}
+
+func recordToRow(headers, rec []string) map[string]string {
+	row := make(map[string]string, len(headers))
+	for i, h := range headers {
+		if i < len(rec) {
+			row[h] = rec[i]
+		} else {
+			row[h] = ""
+		}
+	}
+	return row
+}
+
+// loadCSVWithThreshold loads small files into memory and indexes files larger
+// than threshold bytes for lazy row access. Callers must Close the table.
+func loadCSVWithThreshold(path string, threshold int64, delimiter rune) (csvTable, error) {
+	if threshold > 0 {
+		st, err := os.Stat(path)
+		if err != nil {
+			return csvTable{}, err
+		}
+		if st.Size() > threshold {
+			return loadCSVStreaming(path, delimiter)
+		}
+	}
+	return loadCSVWithDelimiter(path, delimiter)
+}
+
+func newCSVReader(r io.Reader, delimiter rune) *csv.Reader {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	if delimiter != 0 {
+		cr.Comma = delimiter
+	}
+	return cr
+}
+
+// parseDelimiter accepts a single character, or "\t"/"tab" for tabs.
+func parseDelimiter(s string) (rune, error) {
+	switch s {
+	case `\t`, "tab":
+		return '\t', nil
+	}
+	rs := []rune(s)
+	if len(rs) != 1 || rs[0] == '"' || rs[0] == '\r' || rs[0] == '\n' {
+		return 0, fmt.Errorf("invalid delimiter %q (want a single character other than quote or newline)", s)
+	}
+	return rs[0], nil
+}
+
+// delimiterLabel is the report form of a delimiter; the default comma is omitted.
+func delimiterLabel(d rune) string {
+	switch d {
+	case 0, ',':
+		return ""
+	case '\t':
+		return `\t`
+	}
+	return string(d)
+}
+
+// lazyRows keeps an open file and the byte offset of every data record so rows
+// can be decoded on demand through io.ReaderAt.
+type lazyRows struct {
+	file    *os.File
+	size    int64
+	offsets []int64
+	comma   rune
+	err     error
+	// normalize applies normalizeUnicode to each row as it is decoded.
+	normalize bool
+}
+
+// loadCSVStreaming makes one pass over path recording where each record
+// starts, without keeping any row values in memory.
+func loadCSVStreaming(path string, delimiter rune) (csvTable, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return csvTable{}, err
+	}
+	st, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return csvTable{}, err
+	}
+	var start int64
+	bom := make([]byte, 3)
+	if n, _ := f.ReadAt(bom, 0); n == 3 && bytes.Equal(bom, []byte{0xEF, 0xBB, 0xBF}) {
+		start = 3
+	}
+	r := newCSVReader(bufio.NewReaderSize(io.NewSectionReader(f, start, st.Size()-start), 1<<20), delimiter)
+	headers, err := r.Read()
+	if err != nil {
+		f.Close()
+		return csvTable{}, err
+	}
+	lazy := &lazyRows{file: f, size: st.Size(), comma: delimiter}
+	for {
+		off := r.InputOffset()
+		_, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			f.Close()
+			return csvTable{}, err
+		}
+		lazy.offsets = append(lazy.offsets, start+off)
+	}
+	return csvTable{Path: path, Headers: headers, lazy: lazy}, nil
+}
+
+func (t csvTable) rowCount() int {
+	if t.lazy != nil {
+		return len(t.lazy.offsets)
+	}
+	return len(t.Rows)
+}
+
+// row returns row i. In streaming mode a read error yields an empty row and is
+// reported later by readErr.
+func (t csvTable) row(i int) map[string]string {
+	if t.lazy == nil {
+		return t.Rows[i]
+	}
+	off := t.lazy.offsets[i]
+	r := newCSVReader(io.NewSectionReader(t.lazy.file, off, t.lazy.size-off), t.lazy.comma)
+	rec, err := r.Read()
+	if err != nil && t.lazy.err == nil {
+		t.lazy.err = fmt.Errorf("%s: read row %d: %w", t.Path, i, err)
+	}
+	return t.lazy.decode(t.Headers, rec)
+}
+
+// eachRow calls fn for every row in order, reading streaming tables
+// sequentially rather than seeking per row.
+func (t csvTable) eachRow(fn func(i int, row map[string]string)) {
+	if t.lazy == nil {
+		for i, row := range t.Rows {
+			fn(i, row)
+		}
+		return
+	}
+	if len(t.lazy.offsets) == 0 {
+		return
+	}
+	first := t.lazy.offsets[0]
+	r := newCSVReader(bufio.NewReaderSize(io.NewSectionReader(t.lazy.file, first, t.lazy.size-first), 1<<20), t.lazy.comma)
+	for i := range t.lazy.offsets {
+		rec, err := r.Read()
+		if err != nil {
+			if t.lazy.err == nil {
+				t.lazy.err = fmt.Errorf("%s: read row %d: %w", t.Path, i, err)
+			}
+			return
+		}
+		fn(i, t.lazy.decode(t.Headers, rec))
+	}
+}
+
+func (l *lazyRows) decode(headers, rec []string) map[string]string {
+	row := recordToRow(headers, rec)
+	if l.normalize {
+		normalizeRowUnicode(row)
+	}
+	return row
+}
+
+func (t csvTable) readErr() error {
+	if t.lazy == nil {
+		return nil
+	}
+	return t.lazy.err
+}
+
+func (t csvTable) Close() error {
+	if t.lazy == nil {
+		return nil
+	}
+	return t.lazy.file.Close()
+}
+
+func firstErr(errs ...error) error {
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func zeroResult(ref, cand csvTable, refProfiles, candProfiles map[string]colProfile, keyMatch keyMatchPayload, alignment rowAlignmentPayload, opts compareOptions) reportPayload {
 	if alignment.ReferenceRows == 0 && alignment.CandidateRows == 0 {
 		alignment = rowAlignmentPayload{
 			Complete:          false,
 			MatchedRows:       0,
-			ReferenceRows:     len(ref.Rows),
-			CandidateRows:     len(cand.Rows),
+			ReferenceRows:     ref.rowCount(),
+			CandidateRows:     cand.rowCount(),
 			CoverageReference: 0,
 			CoverageCandidate: 0,
 		}
@@ -344,16 +1104,23 @@ func zeroResult(ref, cand csvTable, refProfiles, candProfiles map[string]colProf
 		Config: configPayload{
 			ReferenceCSV:             ref.Path,
 			CandidateCSV:             cand.Path,
+			TextMode:                 opts.TextMode,
+			MappingOverrides:         opts.MappingOverrides,
 			ColumnWeighting:          map[string]string{"columns": "equal"},
 			MissingReferenceColScore: 0.0,
-			ExtraCandidatePenalize:   false,
+			MinMappingConfidence:     opts.thresholds().MinConfidence,
+			MinSampleSimilarity:      opts.thresholds().MinSampleSim,
+			Delimiter:                delimiterLabel(opts.Delimiter),
+			UnicodeNormalize:         !opts.SkipUnicodeNormalize,
+			ExtraCandidatePenalize:   opts.PenalizeExtra,
 		},
 		ReferenceProfile: refProfilePayload{
-			RowCount:      len(ref.Rows),
+			RowCount:      ref.rowCount(),
 			ColumnCount:   len(ref.Headers),
 			UniqueColumns: uniqueColumns(refProfiles, ref.Headers),
+			Columns:       summarizeProfiles(refProfiles),
 		},
-		CandidateProfile: candProfilePayload{RowCount: len(cand.Rows), ColumnCount: len(cand.Headers)},
+		CandidateProfile: candProfilePayload{RowCount: cand.rowCount(), ColumnCount: len(cand.Headers), Columns: summarizeProfiles(candProfiles)},
 		RowAlignment:     alignment.withoutPairs(),
 		KeyMatch:         keyMatch,
 		ColumnMapping: columnMappingPayload{
@@ -396,28 +1163,50 @@ func (r rowAlignmentPayload) withoutPairs() rowAlignmentPayload {
 	return r
 }
 
+// profileColumns profiles every column in a single pass over the table:
+// non-empty counts, canonical value sets and the first profileSampleSize
+// non-empty values used for the type and length ratios.
 func profileColumns(table csvTable) map[string]colProfile {
 	out := make(map[string]colProfile, len(table.Headers))
-	rowCount := len(table.Rows)
+	rowCount := table.rowCount()
+	nonEmptyCounts := make(map[string]int, len(table.Headers))
+	// canonSets only tracks columns that are still unique; the first duplicate
+	// drops the set, so memory stays bounded by the key-candidate columns.
+	canonSets := make(map[string]map[string]struct{}, len(table.Headers))
+	samples := make(map[string][]string, len(table.Headers))
 	for _, h := range table.Headers {
-		vals := make([]string, 0, rowCount)
-		for _, row := range table.Rows {
-			vals = append(vals, row[h])
-		}
-		nonEmpty := make([]string, 0, len(vals))
-		canonSet := make(map[string]struct{})
-		for _, v := range vals {
+		canonSets[h] = make(map[string]struct{})
+	}
+	table.eachRow(func(_ int, row map[string]string) {
+		for _, h := range table.Headers {
+			v := row[h]
 			if isEmpty(v) {
 				continue
 			}
-			nonEmpty = append(nonEmpty, v)
-			canonSet[canonicalScalar(v)] = struct{}{}
+			nonEmptyCounts[h]++
+			if set := canonSets[h]; set != nil {
+				c := canonicalScalar(v)
+				if _, dup := set[c]; dup {
+					canonSets[h] = nil
+				} else {
+					set[c] = struct{}{}
+				}
+			}
+			if len(samples[h]) < profileSampleSize {
+				samples[h] = append(samples[h], v)
+			}
+		}
+	})
+	for _, h := range table.Headers {
+		nonEmpty := samples[h]
+		canonSet := canonSets[h]
+		nonEmptyCount := nonEmptyCounts[h]
+		isUnique := nonEmptyCount > 0 && canonSet != nil
+		if !isUnique {
+			canonSet = nil
 		}
-		nonEmptyCount := len(nonEmpty)
-		uniqNonEmptyCount := len(canonSet)
-		isUnique := nonEmptyCount > 0 && uniqNonEmptyCount == nonEmptyCount
 
-		sampleN := min(500, nonEmptyCount)
+		sampleN := len(nonEmpty)
 		numericHits, boolHits := 0, 0
 		var totalLen float64
 		maxLen := 0
@@ -441,56 +1230,98 @@ func profileColumns(table csvTable) map[string]colProfile {
 			boolRatio = float64(boolHits) / float64(sampleN)
 			avgLen = totalLen / float64(sampleN)
 		}
-		uniqRatio := 0.0
-		if nonEmptyCount > 0 {
-			uniqRatio = float64(uniqNonEmptyCount) / float64(nonEmptyCount)
-		}
 
 		out[h] = colProfile{
-			RowCount:                rowCount,
-			NonEmptyCount:           nonEmptyCount,
-			NullCount:               rowCount - nonEmptyCount,
-			UniqueNonEmptyCount:     uniqNonEmptyCount,
-			IsUniqueNonEmpty:        isUnique,
-			UniquenessRatioNonEmpty: uniqRatio,
-			NumericRatio:            numRatio,
-			BoolRatio:               boolRatio,
-			AvgLenSample:            avgLen,
-			MaxLenSample:            float64(maxLen),
-			HeaderTokens:            headerTokens(h),
+			RowCount:         rowCount,
+			NonEmptyCount:    nonEmptyCount,
+			NullCount:        rowCount - nonEmptyCount,
+			IsUniqueNonEmpty: isUnique,
+			NumericRatio:     numRatio,
+			BoolRatio:        boolRatio,
+			AvgLenSample:     avgLen,
+			MaxLenSample:     float64(maxLen),
+			HeaderTokens:     headerTokens(h),
+			InferredType:     inferColumnType(numRatio, boolRatio),
+			canonSet:         canonSet,
+		}
+	}
+	return out
+}
+
+// profileSampleSize caps the non-empty values per column that feed the
+// numeric, boolean and length ratios.
+const profileSampleSize = 500
+
+const (
+	columnTypeNumeric = "numeric"
+	columnTypeBoolean = "boolean"
+	columnTypeText    = "text"
+	columnTypeMixed   = "mixed"
+)
+
+// inferColumnType labels a column from its sampled parse ratios, using the
+// same 0.9 cutoff as typeCompatibilityScore. Booleans win over numerics so a
+// 0/1 flag column reads as boolean; a column with at most 10% numeric or
+// boolean values is text, anything in between is mixed.
+func inferColumnType(numRatio, boolRatio float64) string {
+	switch {
+	case boolRatio >= 0.9:
+		return columnTypeBoolean
+	case numRatio >= 0.9:
+		return columnTypeNumeric
+	case numRatio <= 0.1 && boolRatio <= 0.1:
+		return columnTypeText
+	default:
+		return columnTypeMixed
+	}
+}
+
+func summarizeProfiles(profiles map[string]colProfile) map[string]columnProfileSummary {
+	out := make(map[string]columnProfileSummary, len(profiles))
+	for col, p := range profiles {
+		out[col] = columnProfileSummary{
+			InferredType: p.InferredType,
+			NumericRatio: p.NumericRatio,
+			BoolRatio:    p.BoolRatio,
+			NullCount:    p.NullCount,
 		}
 	}
 	return out
 }
 
 func findKeyMatch(ref, cand csvTable, refProfiles, candProfiles map[string]colProfile) keyMatchPayload {
-	_ = candProfiles
 	candidates := make([]keyCandidate, 0)
 	for _, refCol := range ref.Headers {
-		if !refProfiles[refCol].IsUniqueNonEmpty {
-			continue
-		}
-		refVals, refSet := nonEmptyCanonValues(ref.Rows, refCol)
-		if len(refSet) != len(refVals) {
+		refP := refProfiles[refCol]
+		if !refP.IsUniqueNonEmpty {
 			continue
 		}
+		refSet := refP.canonSet
 		for _, candCol := range cand.Headers {
-			candVals, candSet := nonEmptyCanonValues(cand.Rows, candCol)
-			if len(candSet) != len(candVals) {
+			candP := candProfiles[candCol]
+			if !candP.IsUniqueNonEmpty {
 				continue
 			}
+			candSet := candP.canonSet
 			intersection := setIntersectionCount(refSet, candSet)
 			if intersection == 0 {
 				continue
 			}
-			complete := len(ref.Rows) == len(cand.Rows) && len(candVals) == len(refVals) && setsEqual(refSet, candSet)
+			complete := ref.rowCount() == cand.rowCount() && candP.NonEmptyCount == refP.NonEmptyCount && setsEqual(refSet, candSet)
 			candCoverage := float64(intersection) / maxFloat(float64(len(candSet)), 1)
 			refCoverage := float64(intersection) / maxFloat(float64(len(refSet)), 1)
-			refSupport := safeDiv(float64(len(refSet)), float64(len(ref.Rows)))
-			candSupport := safeDiv(float64(len(candSet)), float64(len(cand.Rows)))
+			refSupport := safeDiv(float64(len(refSet)), float64(ref.rowCount()))
+			candSupport := safeDiv(float64(len(candSet)), float64(cand.rowCount()))
 			supportScore := minFloat(refSupport, candSupport)
 			hScore := headerSimilarity(refCol, candCol)
-			keyScore := ternaryFloat(complete, 10.0, 0.0) + (candCoverage * 2.0) + refCoverage + hScore + (supportScore * 3.0)
+			components := keyScoreComponents{
+				CompleteBonus:     ternaryFloat(complete, 10.0, 0.0),
+				CandidateCoverage: candCoverage * 2.0,
+				ReferenceCoverage: refCoverage,
+				HeaderSimilarity:  hScore,
+				Support:           supportScore * 3.0,
+			}
+			keyScore := components.CompleteBonus + components.CandidateCoverage + components.ReferenceCoverage + components.HeaderSimilarity + components.Support
 			candidates = append(candidates, keyCandidate{
 				ReferenceColumn:      refCol,
 				CandidateColumn:      candCol,
@@ -499,9 +1330,10 @@ func findKeyMatch(ref, cand csvTable, refProfiles, candProfiles map[string]colPr
 				CandidateKeyCoverage: round6(candCoverage),
 				ReferenceKeyCoverage: round6(refCoverage),
 				HeaderSimilarity:     round6(hScore),
-				ReferenceNonEmpty:    len(refVals),
-				CandidateNonEmpty:    len(candVals),
+				ReferenceNonEmpty:    refP.NonEmptyCount,
+				CandidateNonEmpty:    candP.NonEmptyCount,
 				Score:                keyScore,
+				ScoreComponents:      components,
 			})
 		}
 	}
@@ -543,53 +1375,53 @@ func findKeyMatch(ref, cand csvTable, refProfiles, candProfiles map[string]colPr
 }
 
 func alignRowsByKey(ref, cand csvTable, refKey, candKey string) rowAlignmentPayload {
-	refIndex := make(map[string]int, len(ref.Rows))
+	refIndex := make(map[string]int, ref.rowCount())
 	dupRef := 0
-	for i, row := range ref.Rows {
+	ref.eachRow(func(i int, row map[string]string) {
 		k := canonicalScalar(row[refKey])
 		if k == "" {
-			continue
+			return
 		}
 		if _, exists := refIndex[k]; exists {
 			dupRef++
-			continue
+			return
 		}
 		refIndex[k] = i
-	}
-	pairs := make([][2]int, 0, len(cand.Rows))
-	seenRef := make(map[int]struct{}, len(cand.Rows))
+	})
+	pairs := make([][2]int, 0, cand.rowCount())
+	seenRef := make(map[int]struct{}, cand.rowCount())
 	missing := 0
 	dupCandMatches := 0
-	for ci, row := range cand.Rows {
+	cand.eachRow(func(ci int, row map[string]string) {
 		k := canonicalScalar(row[candKey])
 		if k == "" {
 			missing++
-			continue
+			return
 		}
 		ri, ok := refIndex[k]
 		if !ok {
 			missing++
-			continue
+			return
 		}
 		if _, exists := seenRef[ri]; exists {
 			dupCandMatches++
-			continue
+			return
 		}
 		seenRef[ri] = struct{}{}
 		pairs = append(pairs, [2]int{ri, ci})
-	}
+	})
 	sort.Slice(pairs, func(i, j int) bool { return pairs[i][0] < pairs[j][0] })
 	matched := len(pairs)
-	complete := dupRef == 0 && dupCandMatches == 0 && missing == 0 && matched == len(ref.Rows) && matched == len(cand.Rows)
+	complete := dupRef == 0 && dupCandMatches == 0 && missing == 0 && matched == ref.rowCount() && matched == cand.rowCount()
 	return rowAlignmentPayload{
 		Complete:                      complete,
 		ReferenceKey:                  refKey,
 		CandidateKey:                  candKey,
 		MatchedRows:                   matched,
-		ReferenceRows:                 len(ref.Rows),
-		CandidateRows:                 len(cand.Rows),
-		CoverageReference:             safeDiv(float64(matched), float64(len(ref.Rows))),
-		CoverageCandidate:             safeDiv(float64(matched), float64(len(cand.Rows))),
+		ReferenceRows:                 ref.rowCount(),
+		CandidateRows:                 cand.rowCount(),
+		CoverageReference:             safeDiv(float64(matched), float64(ref.rowCount())),
+		CoverageCandidate:             safeDiv(float64(matched), float64(cand.rowCount())),
 		DuplicateReferenceKeys:        dupRef,
 		DuplicateCandidateMatches:     dupCandMatches,
 		MissingCandidateKeysOrMissing: missing,
@@ -597,43 +1429,101 @@ func alignRowsByKey(ref, cand csvTable, refKey, candKey string) rowAlignmentPayl
 	}
 }
 
-func mapColumns(ref, cand csvTable, refProfiles, candProfiles map[string]colProfile, pairs [][2]int, sampleSize int) columnMappingPayload {
-	samplePairs := pairs
-	if sampleSize > 0 && len(samplePairs) > sampleSize {
-		samplePairs = samplePairs[:sampleSize]
+const defaultMappingSampleSeed = 1
+
+// sampleMappingPairs picks sampleSize aligned pairs spread across all of
+// pairs with a seeded RNG, so mapping confidence is not biased toward the
+// first rows. The picks keep their original order; sampleSize <= 0 or
+// >= len(pairs) returns pairs unchanged.
+func sampleMappingPairs(pairs [][2]int, sampleSize int, seed int64) [][2]int {
+	if sampleSize <= 0 || len(pairs) <= sampleSize {
+		return pairs
 	}
+	idx := rand.New(rand.NewSource(seed)).Perm(len(pairs))[:sampleSize]
+	sort.Ints(idx)
+	out := make([][2]int, len(idx))
+	for i, j := range idx {
+		out[i] = pairs[j]
+	}
+	return out
+}
+
+// mapColumns scores every reference/candidate column pair on the aligned
+// samplePairs (see sampleMappingPairs) and selects the mapping.
+func mapColumns(ref, cand csvTable, refProfiles, candProfiles map[string]colProfile, samplePairs [][2]int, overrides map[string]string, th mappingThresholds) columnMappingPayload {
+	refSamples := sampleColumnValues(ref, samplePairs, 0)
+	candSamples := sampleColumnValues(cand, samplePairs, 1)
 	allPairs := make([]mappingPair, 0, len(ref.Headers)*len(cand.Headers))
 	for _, refCol := range ref.Headers {
 		for _, candCol := range cand.Headers {
 			h := headerSimilarity(refCol, candCol)
 			t := typeCompatibilityScore(refProfiles[refCol], candProfiles[candCol])
-			s := sampleColumnSimilarityFast(ref, cand, samplePairs, refCol, candCol)
-			conf := (0.35 * h) + (0.10 * t) + (0.55 * s)
-			allPairs = append(allPairs, mappingPair{
-				ReferenceColumn:   refCol,
-				CandidateColumn:   candCol,
-				HeaderSimilarity:  round6(h),
-				TypeCompatibility: round6(t),
-				SampleSimilarity:  round6(s),
-				MappingConfidence: round6(conf),
-			})
+			s := sampleColumnSimilarityCached(refSamples[refCol], candSamples[candCol])
+			allPairs = append(allPairs, newMappingPair(refCol, candCol, h, t, s))
 		}
 	}
+	return selectColumnMapping(ref, cand, allPairs, overrides, th)
+}
+
+func newMappingPair(refCol, candCol string, h, t, s float64) mappingPair {
+	conf := (0.35 * h) + (0.10 * t) + (0.55 * s)
+	return mappingPair{
+		ReferenceColumn:   refCol,
+		CandidateColumn:   candCol,
+		HeaderSimilarity:  round6(h),
+		TypeCompatibility: round6(t),
+		SampleSimilarity:  round6(s),
+		MappingConfidence: round6(conf),
+	}
+}
+
+func selectColumnMapping(ref, cand csvTable, allPairs []mappingPair, overrides map[string]string, th mappingThresholds) columnMappingPayload {
 	sort.Slice(allPairs, func(i, j int) bool {
 		a, b := allPairs[i], allPairs[j]
-		if a.MappingConfidence == b.MappingConfidence {
-			if a.SampleSimilarity == b.SampleSimilarity {
-				return a.HeaderSimilarity > b.HeaderSimilarity
-			}
+		if a.MappingConfidence != b.MappingConfidence {
+			return a.MappingConfidence > b.MappingConfidence
+		}
+		if a.SampleSimilarity != b.SampleSimilarity {
 			return a.SampleSimilarity > b.SampleSimilarity
 		}
-		return a.MappingConfidence > b.MappingConfidence
+		if a.HeaderSimilarity != b.HeaderSimilarity {
+			return a.HeaderSimilarity > b.HeaderSimilarity
+		}
+		if a.CandidateColumn != b.CandidateColumn {
+			return a.CandidateColumn < b.CandidateColumn
+		}
+		return a.ReferenceColumn < b.ReferenceColumn
 	})
 
 	usedRef := map[string]struct{}{}
 	usedCand := map[string]struct{}{}
 	mapping := map[string]mappingPair{}
 	var confs []float64
+	if len(overrides) > 0 {
+		pairIndex := make(map[[2]string]int, len(allPairs))
+		for i, p := range allPairs {
+			pairIndex[[2]string{p.ReferenceColumn, p.CandidateColumn}] = i
+		}
+		for _, refCol := range ref.Headers {
+			candCol, ok := overrides[refCol]
+			if !ok {
+				continue
+			}
+			if _, used := usedCand[candCol]; used {
+				continue
+			}
+			idx, ok := pairIndex[[2]string{refCol, candCol}]
+			if !ok {
+				continue
+			}
+			p := allPairs[idx]
+			p.Overridden = true
+			mapping[refCol] = p
+			usedRef[refCol] = struct{}{}
+			usedCand[candCol] = struct{}{}
+			confs = append(confs, p.MappingConfidence)
+		}
+	}
 	for _, p := range allPairs {
 		if _, ok := usedRef[p.ReferenceColumn]; ok {
 			continue
@@ -641,7 +1531,7 @@ func mapColumns(ref, cand csvTable, refProfiles, candProfiles map[string]colProf
 		if _, ok := usedCand[p.CandidateColumn]; ok {
 			continue
 		}
-		if p.MappingConfidence < 0.55 && p.SampleSimilarity < 0.85 {
+		if p.MappingConfidence < th.MinConfidence && p.SampleSimilarity < th.MinSampleSim {
 			continue
 		}
 		mapping[p.ReferenceColumn] = p
@@ -655,6 +1545,7 @@ func mapColumns(ref, cand csvTable, refProfiles, candProfiles map[string]colProf
 			refUnmatched = append(refUnmatched, h)
 		}
 	}
+	reasons := unmatchedReasons(refUnmatched, allPairs, mapping, th)
 	candUnmatched := make([]string, 0)
 	for _, h := range cand.Headers {
 		if _, ok := usedCand[h]; !ok {
@@ -667,11 +1558,50 @@ func mapColumns(ref, cand csvTable, refProfiles, candProfiles map[string]colProf
 		ReferenceUnmatched:   refUnmatched,
 		CandidateUnmatched:   candUnmatched,
 		MappingConfidenceAvg: avgFloat(confs),
+		UnmatchedReasons:     reasons,
 		PairCandidatesTop:    allPairs[:topN],
 	}
 }
 
-func scoreColumns(ref, cand csvTable, pairs [][2]int, mapping map[string]mappingPair) scoresPayload {
+// unmatchedReasons explains each unmatched reference column using its best
+// candidate pair; allPairs must already be sorted best-first.
+func unmatchedReasons(refUnmatched []string, allPairs []mappingPair, mapping map[string]mappingPair, th mappingThresholds) map[string]string {
+	if len(refUnmatched) == 0 {
+		return nil
+	}
+	mappedBy := make(map[string]string, len(mapping))
+	for refCol, p := range mapping {
+		mappedBy[p.CandidateColumn] = refCol
+	}
+	best := make(map[string]mappingPair, len(refUnmatched))
+	for _, p := range allPairs {
+		if _, ok := best[p.ReferenceColumn]; !ok {
+			best[p.ReferenceColumn] = p
+		}
+	}
+	reasons := make(map[string]string, len(refUnmatched))
+	for _, refCol := range refUnmatched {
+		p, ok := best[refCol]
+		switch {
+		case !ok:
+			reasons[refCol] = "no candidate columns"
+		case mappedBy[p.CandidateColumn] != "":
+			reasons[refCol] = fmt.Sprintf("best candidate '%s' confidence %.2f already mapped to '%s'", p.CandidateColumn, p.MappingConfidence, mappedBy[p.CandidateColumn])
+		default:
+			reasons[refCol] = fmt.Sprintf("best candidate '%s' confidence %.2f below threshold %.2f (sample similarity %.2f < %.2f)",
+				p.CandidateColumn, p.MappingConfidence, th.MinConfidence, p.SampleSimilarity, th.MinSampleSim)
+		}
+	}
+	return reasons
+}
+
+func scoreColumns(ref, cand csvTable, pairs [][2]int, columnMapping columnMappingPayload, opts compareOptions) scoresPayload {
+	mapping := columnMapping.Mapping
+	stats := make(map[string]*columnPairStats, len(mapping))
+	for refCol, mp := range mapping {
+		stats[refCol] = &columnPairStats{RefCol: refCol, CandCol: mp.CandidateColumn}
+	}
+	scanColumnPairs(ref, cand, pairs, stats, opts.TextMode, opts.ValueDistTopK > 0)
 	per := make([]perColumnScore, 0, len(ref.Headers))
 	total := 0.0
 	mapped := 0
@@ -683,13 +1613,20 @@ func scoreColumns(ref, cand csvTable, pairs [][2]int, mapping map[string]mapping
 				CandidateColumn: nil,
 				Similarity:      0,
 				Matched:         false,
+				Reason:          columnMapping.UnmatchedReasons[refCol],
 			})
 			continue
 		}
-		s := fullColumnSimilarity(ref, cand, pairs, refCol, mp.CandidateColumn)
+		st := stats[refCol]
+		s := safeDiv(st.simSum, float64(len(pairs)))
 		total += s
 		mapped++
 		candCol := mp.CandidateColumn
+		var dist *float64
+		if opts.ValueDistTopK > 0 {
+			d := round6(valueDistributionOverlap(st.refCounts, st.candCounts, len(pairs), opts.ValueDistTopK))
+			dist = &d
+		}
 		per = append(per, perColumnScore{
 			ReferenceColumn:   refCol,
 			CandidateColumn:   &candCol,
@@ -699,6 +1636,8 @@ func scoreColumns(ref, cand csvTable, pairs [][2]int, mapping map[string]mapping
 			RowCountScored:    len(pairs),
 			HeaderSimilarity:  mp.HeaderSimilarity,
 			SampleSimilarity:  mp.SampleSimilarity,
+			Overridden:        mp.Overridden,
+			ValueDistOverlap:  dist,
 		})
 	}
 	ds := safeDiv(total, float64(len(ref.Headers)))
@@ -717,8 +1656,8 @@ func sampleColumnSimilarityFast(ref, cand csvTable, pairs [][2]int, refCol, cand
 	exact := 0.0
 	samePresence := 0.0
 	for _, p := range pairs {
-		rv := ref.Rows[p[0]][refCol]
-		cv := cand.Rows[p[1]][candCol]
+		rv := ref.row(p[0])[refCol]
+		cv := cand.row(p[1])[candCol]
 		re := isEmpty(rv)
 		ce := isEmpty(cv)
 		if re == ce {
@@ -732,18 +1671,138 @@ func sampleColumnSimilarityFast(ref, cand csvTable, pairs [][2]int, refCol, cand
 	return (0.85 * (exact / n)) + (0.15 * (samePresence / n))
 }
 
-func fullColumnSimilarity(ref, cand csvTable, pairs [][2]int, refCol, candCol string) float64 {
-	if len(pairs) == 0 {
+// columnSample caches one column's canonical sample values so mapping can
+// score every column pair without re-reading the aligned rows.
+type columnSample struct {
+	Canon []string
+	Empty []bool
+}
+
+// sampleColumnValues reads each sampled row once and fills every column's
+// sample from it.
+func sampleColumnValues(table csvTable, pairs [][2]int, side int) map[string]columnSample {
+	out := make(map[string]columnSample, len(table.Headers))
+	for _, h := range table.Headers {
+		out[h] = columnSample{
+			Canon: make([]string, len(pairs)),
+			Empty: make([]bool, len(pairs)),
+		}
+	}
+	for i, p := range pairs {
+		row := table.row(p[side])
+		for _, h := range table.Headers {
+			v := row[h]
+			out[h].Canon[i] = canonicalScalar(v)
+			out[h].Empty[i] = isEmpty(v)
+		}
+	}
+	return out
+}
+
+func sampleColumnSimilarityCached(refS, candS columnSample) float64 {
+	n := len(refS.Canon)
+	if n == 0 || len(candS.Canon) != n {
 		return 0
 	}
-	sum := 0.0
-	for _, p := range pairs {
-		sum += valueSimilarity(ref.Rows[p[0]][refCol], cand.Rows[p[1]][candCol])
+	exact := 0.0
+	samePresence := 0.0
+	for i := 0; i < n; i++ {
+		if refS.Empty[i] == candS.Empty[i] {
+			samePresence += 1
+		}
+		if refS.Canon[i] == candS.Canon[i] {
+			exact += 1
+		}
+	}
+	return (0.85 * (exact / float64(n))) + (0.15 * (samePresence / float64(n)))
+}
+
+// columnPairStats accumulates one mapped column pair over the aligned rows:
+// the summed value similarity and, for -value-dist, the canonical value
+// counts of each side.
+type columnPairStats struct {
+	RefCol, CandCol string
+	simSum          float64
+	refCounts       map[string]int
+	candCounts      map[string]int
+}
+
+// scanColumnPairs fills stats in a single pass over pairs, reading each
+// aligned row once rather than once per column. pairs must be sorted by
+// reference row (as alignRowsByKey returns them) so the reference side is
+// read sequentially; candidate rows are fetched by index.
+func scanColumnPairs(ref, cand csvTable, pairs [][2]int, stats map[string]*columnPairStats, textMode string, withCounts bool) {
+	if len(pairs) == 0 || len(stats) == 0 {
+		return
+	}
+	if withCounts {
+		for _, st := range stats {
+			st.refCounts = map[string]int{}
+			st.candCounts = map[string]int{}
+		}
+	}
+	next := 0
+	ref.eachRow(func(ri int, refRow map[string]string) {
+		for next < len(pairs) && pairs[next][0] == ri {
+			candRow := cand.row(pairs[next][1])
+			for _, st := range stats {
+				rv, cv := refRow[st.RefCol], candRow[st.CandCol]
+				st.simSum += valueSimilarityWithMode(rv, cv, textMode)
+				if withCounts {
+					st.refCounts[canonicalScalar(rv)]++
+					st.candCounts[canonicalScalar(cv)]++
+				}
+			}
+			next++
+		}
+	})
+}
+
+// valueDistributionOverlap compares the value frequencies of two aligned
+// columns over n pairs, ignoring which row each value sits in. It sums
+// min(share_ref, share_cand) over the union of both sides' top-k canonical
+// values, so identical multisets score 1 even when assignments are shuffled.
+func valueDistributionOverlap(refCounts, candCounts map[string]int, n, k int) float64 {
+	if n == 0 {
+		return 0
+	}
+	keys := map[string]struct{}{}
+	for _, v := range topKValues(refCounts, k) {
+		keys[v] = struct{}{}
 	}
-	return sum / float64(len(pairs))
+	for _, v := range topKValues(candCounts, k) {
+		keys[v] = struct{}{}
+	}
+	total := float64(n)
+	overlap := 0.0
+	for v := range keys {
+		overlap += minFloat(float64(refCounts[v])/total, float64(candCounts[v])/total)
+	}
+	return overlap
+}
+
+func topKValues(counts map[string]int, k int) []string {
+	vals := make([]string, 0, len(counts))
+	for v := range counts {
+		vals = append(vals, v)
+	}
+	sort.Slice(vals, func(i, j int) bool {
+		if counts[vals[i]] != counts[vals[j]] {
+			return counts[vals[i]] > counts[vals[j]]
+		}
+		return vals[i] < vals[j]
+	})
+	if len(vals) > k {
+		vals = vals[:k]
+	}
+	return vals
 }
 
 func valueSimilarity(a, b string) float64 {
+	return valueSimilarityWithMode(a, b, textModeLevenshtein)
+}
+
+func valueSimilarityWithMode(a, b, textMode string) float64 {
 	if isEmpty(a) && isEmpty(b) {
 		return 1
 	}
@@ -775,9 +1834,33 @@ func valueSimilarity(a, b string) float64 {
 			return math.Max(0, 1-(math.Abs(af-bf)/denom))
 		}
 	}
+	if textMode == textModeTokenSet && max(len([]rune(an)), len([]rune(bn))) > tokenSetMinRunes {
+		return tokenSetSimilarity(an, bn)
+	}
 	return normalizedLevenshteinSimilarity(an, bn)
 }
 
+func tokenSetSimilarity(a, b string) float64 {
+	aSet := tokenSet(a)
+	bSet := tokenSet(b)
+	if len(aSet) == 0 && len(bSet) == 0 {
+		return normalizedLevenshteinSimilarity(a, b)
+	}
+	if len(aSet) == 0 || len(bSet) == 0 {
+		return 0
+	}
+	return float64(setIntersectionCount(aSet, bSet)) / float64(setUnionCount(aSet, bSet))
+}
+
+func tokenSet(v string) map[string]struct{} {
+	tokens := reToken.FindAllString(strings.ToLower(v), -1)
+	set := make(map[string]struct{}, len(tokens))
+	for _, t := range tokens {
+		set[t] = struct{}{}
+	}
+	return set
+}
+
 func normalizedLevenshteinSimilarity(a, b string) float64 {
 	if a == b {
 		return 1
@@ -882,6 +1965,26 @@ func isEmpty(v string) bool { return strings.TrimSpace(v) == "" }
 
 func normalizeText(v string) string { return strings.TrimSpace(v) }
 
+// normalizeUnicode folds NBSP-like spaces and composes to NFC, so "é" and
+// "e\u0301" compare equal.
+func normalizeUnicode(v string) string {
+	return norm.NFC.String(strings.Map(foldNBSP, v))
+}
+
+func normalizeRowUnicode(row map[string]string) {
+	for k, v := range row {
+		row[k] = normalizeUnicode(v)
+	}
+}
+
+func foldNBSP(r rune) rune {
+	switch r {
+	case '\u00a0', '\u2007', '\u202f':
+		return ' '
+	}
+	return r
+}
+
 func parseBool(v string) (bool, bool) {
 	s := strings.ToLower(normalizeText(v))
 	switch s {
@@ -979,21 +2082,6 @@ func canonHeaderToken(t string) string {
 	return t
 }
 
-func nonEmptyCanonValues(rows []map[string]string, col string) ([]string, map[string]struct{}) {
-	vals := make([]string, 0, len(rows))
-	set := make(map[string]struct{}, len(rows))
-	for _, r := range rows {
-		v := r[col]
-		if isEmpty(v) {
-			continue
-		}
-		c := canonicalScalar(v)
-		vals = append(vals, c)
-		set[c] = struct{}{}
-	}
-	return vals, set
-}
-
 func setsEqual(a, b map[string]struct{}) bool {
 	if len(a) != len(b) {
 		return false
@@ -3,12 +3,15 @@ package main
 import (
 	"bytes"
 	"encoding/csv"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"math"
+	"math/rand"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"testing"
 )
@@ -498,6 +501,934 @@ func TestCompareCSV_AlternateKeyChosenWhenGTINColumnMissing(t *testing.T) {
 	}
 }
 
+func TestValueSimilarity_TokenSetModeToleratesSentenceReordering(t *testing.T) {
+	a := "Gentle cleansing foam for sensitive skin. Removes make-up thoroughly. Dermatologically tested and free of perfume."
+	b := "Dermatologically tested and free of perfume. Gentle cleansing foam for sensitive skin. Removes make-up thoroughly."
+
+	lev := valueSimilarityWithMode(a, b, textModeLevenshtein)
+	tok := valueSimilarityWithMode(a, b, textModeTokenSet)
+	if !(tok > 0.99) {
+		t.Fatalf("expected tokenset similarity near 1.0 for reordered sentences, got %.15f", tok)
+	}
+	if !(lev < 0.8) {
+		t.Fatalf("expected levenshtein similarity well below 1.0 for reordered sentences, got %.15f", lev)
+	}
+	if !(tok > lev) {
+		t.Fatalf("expected tokenset (%.15f) to score above levenshtein (%.15f)", tok, lev)
+	}
+}
+
+func TestValueSimilarity_TokenSetModeKeepsLevenshteinForShortValues(t *testing.T) {
+	a, b := "Balea", "Baela"
+	if got, want := valueSimilarityWithMode(a, b, textModeTokenSet), normalizedLevenshteinSimilarity(a, b); !almostEqual(got, want) {
+		t.Fatalf("expected short values to use levenshtein in tokenset mode, got %.15f want %.15f", got, want)
+	}
+}
+
+func TestCompareCandidateFiles_RanksCandidatesByOverallScore(t *testing.T) {
+	tmpDir := t.TempDir()
+	ref, cand := buildWideTables(120, 8)
+	refPath := filepath.Join(tmpDir, "reference.csv")
+	if err := writeCSVRows(refPath, tableToCSVRows(ref)); err != nil {
+		t.Fatalf("writeCSVRows reference error: %v", err)
+	}
+
+	exact := filepath.Join(tmpDir, "a_exact.csv")
+	if err := writeCSVRows(exact, tableToCSVRows(cand)); err != nil {
+		t.Fatalf("writeCSVRows exact error: %v", err)
+	}
+	mutated := filepath.Join(tmpDir, "b_mutated.csv")
+	mutatedRows := tableToCSVRows(cand)
+	textIdx := mustColumnIndex(mutatedRows.Header, "field_02_value")
+	for i := range mutatedRows.Records {
+		if i%4 == 0 {
+			mutatedRows.Records[i][textIdx] += " (refill)"
+		}
+	}
+	if err := writeCSVRows(mutated, mutatedRows); err != nil {
+		t.Fatalf("writeCSVRows mutated error: %v", err)
+	}
+	subset := filepath.Join(tmpDir, "c_subset.csv")
+	subsetRows := tableToCSVRows(cand)
+	subsetRows.Records = subsetRows.Records[:60]
+	if err := writeCSVRows(subset, subsetRows); err != nil {
+		t.Fatalf("writeCSVRows subset error: %v", err)
+	}
+
+	// Pass candidates in non-ranked order to make sure ranking sorts them.
+	multi, err := compareCandidateFiles(refPath, []string{subset, exact, mutated}, compareOptions{SampleSizeMapping: 256})
+	if err != nil {
+		t.Fatalf("compareCandidateFiles error: %v", err)
+	}
+	if len(multi.Reports) != 3 {
+		t.Fatalf("expected 3 reports, got %d", len(multi.Reports))
+	}
+	if multi.Reports[0].Config.CandidateCSV != subset {
+		t.Fatalf("expected reports to keep input order, first is %q", multi.Reports[0].Config.CandidateCSV)
+	}
+	wantOrder := []string{exact, mutated, subset}
+	if len(multi.Ranking) != len(wantOrder) {
+		t.Fatalf("expected %d ranking entries, got %d", len(wantOrder), len(multi.Ranking))
+	}
+	for i, want := range wantOrder {
+		got := multi.Ranking[i]
+		if got.CandidateCSV != want {
+			t.Fatalf("rank %d: expected %s, got %s (score %.15f)", i+1, want, got.CandidateCSV, got.OverallScoreWithCoverage)
+		}
+		if got.Rank != i+1 {
+			t.Fatalf("expected rank %d, got %d", i+1, got.Rank)
+		}
+	}
+	if !almostEqual(multi.Ranking[0].OverallScoreWithCoverage, 1.0) {
+		t.Fatalf("expected exact candidate overall score 1.0, got %.15f", multi.Ranking[0].OverallScoreWithCoverage)
+	}
+	if !almostEqual(multi.Ranking[2].CoverageReference, 0.5) {
+		t.Fatalf("expected subset candidate reference coverage 0.5, got %.15f", multi.Ranking[2].CoverageReference)
+	}
+}
+
+func TestMapColumns_TiedCandidateColumnsPickAlphabeticallyFirst(t *testing.T) {
+	ref := csvTable{Path: "ref.csv", Headers: []string{"sku", "price"}}
+	cand := csvTable{Path: "cand.csv", Headers: []string{"sku", "price_b", "price_a"}}
+	for i := 0; i < 40; i++ {
+		price := fmt.Sprintf("%d.99", i%7)
+		ref.Rows = append(ref.Rows, map[string]string{"sku": fmt.Sprintf("SKU-%03d", i), "price": price})
+		cand.Rows = append(cand.Rows, map[string]string{"sku": fmt.Sprintf("SKU-%03d", i), "price_b": price, "price_a": price})
+	}
+	refProfiles := profileColumns(ref)
+	candProfiles := profileColumns(cand)
+	alignment := alignRowsByKey(ref, cand, "sku", "sku")
+
+	for run := 0; run < 25; run++ {
+		mapping := mapColumns(ref, cand, refProfiles, candProfiles, sampleMappingPairs(alignment.Pairs, 256, defaultMappingSampleSeed), nil, defaultMappingThresholds)
+		mp, ok := mapping.Mapping["price"]
+		if !ok {
+			t.Fatalf("run %d: expected price to be mapped", run)
+		}
+		if mp.CandidateColumn != "price_a" {
+			t.Fatalf("run %d: expected tie to resolve to price_a, got %s", run, mp.CandidateColumn)
+		}
+		if !containsString(mapping.CandidateUnmatched, "price_b") {
+			t.Fatalf("run %d: expected price_b to be left unmatched", run)
+		}
+	}
+}
+
+func TestCompareCSV_MappingOverrideCorrectsWrongHeuristicPick(t *testing.T) {
+	tmpDir := t.TempDir()
+	refRows := csvRows{Header: []string{"sku", "net_price"}}
+	candRows := csvRows{Header: []string{"sku_code", "net_price", "amount"}}
+	for i := 0; i < 60; i++ {
+		sku := fmt.Sprintf("SKU-%03d", i)
+		price := fmt.Sprintf("%d.%02d", 2+i%9, (i*7)%100)
+		decoy := price
+		if i%2 == 1 {
+			decoy = fmt.Sprintf("%d.%02d", 20+i%9, (i*7)%100)
+		}
+		refRows.Records = append(refRows.Records, []string{sku, price})
+		candRows.Records = append(candRows.Records, []string{sku, decoy, price})
+	}
+	refPath := filepath.Join(tmpDir, "reference.csv")
+	candPath := filepath.Join(tmpDir, "candidate.csv")
+	if err := writeCSVRows(refPath, refRows); err != nil {
+		t.Fatalf("writeCSVRows reference error: %v", err)
+	}
+	if err := writeCSVRows(candPath, candRows); err != nil {
+		t.Fatalf("writeCSVRows candidate error: %v", err)
+	}
+
+	heuristic, err := compareCSVFiles(refPath, candPath, 256)
+	if err != nil {
+		t.Fatalf("compareCSVFiles error: %v", err)
+	}
+	before := perColumnByName(heuristic.Scores.PerReferenceColumn, "net_price")
+	if before == nil || before.CandidateColumn == nil || *before.CandidateColumn != "net_price" {
+		t.Fatalf("expected heuristic to (wrongly) map net_price -> net_price, got %+v", before)
+	}
+	if !(before.Similarity < 0.9) {
+		t.Fatalf("expected decoy mapping similarity < 0.9, got %.15f", before.Similarity)
+	}
+
+	overridePath := filepath.Join(tmpDir, "override.json")
+	if err := os.WriteFile(overridePath, []byte(`{"net_price": "amount"}`), 0o644); err != nil {
+		t.Fatalf("write override error: %v", err)
+	}
+	overrides, err := loadMappingOverrides(overridePath)
+	if err != nil {
+		t.Fatalf("loadMappingOverrides error: %v", err)
+	}
+	corrected, err := compareCSVFilesWithOptions(refPath, candPath, compareOptions{SampleSizeMapping: 256, MappingOverrides: overrides})
+	if err != nil {
+		t.Fatalf("compareCSVFilesWithOptions error: %v", err)
+	}
+	after := perColumnByName(corrected.Scores.PerReferenceColumn, "net_price")
+	if after == nil || after.CandidateColumn == nil || *after.CandidateColumn != "amount" {
+		t.Fatalf("expected override to map net_price -> amount, got %+v", after)
+	}
+	if !after.Overridden {
+		t.Fatalf("expected overridden flag on net_price score")
+	}
+	if !almostEqual(after.Similarity, 1.0) {
+		t.Fatalf("expected overridden similarity 1.0, got %.15f", after.Similarity)
+	}
+	if !(corrected.Scores.DatasetSimilarityEqualWeighted > heuristic.Scores.DatasetSimilarityEqualWeighted) {
+		t.Fatalf("expected override to raise dataset similarity: before=%.15f after=%.15f",
+			heuristic.Scores.DatasetSimilarityEqualWeighted, corrected.Scores.DatasetSimilarityEqualWeighted)
+	}
+	if !containsString(corrected.ColumnMapping.CandidateUnmatched, "net_price") {
+		t.Fatalf("expected decoy net_price candidate column to be unmatched after override")
+	}
+
+	if _, err := compareCSVFilesWithOptions(refPath, candPath, compareOptions{MappingOverrides: map[string]string{"net_price": "missing"}}); err == nil {
+		t.Fatalf("expected error for override pointing at unknown candidate column")
+	}
+}
+
+func TestMapColumns_CachedSamplesMatchFullScan(t *testing.T) {
+	ref, cand := buildWideTables(300, 40)
+	refProfiles := profileColumns(ref)
+	candProfiles := profileColumns(cand)
+	alignment := alignRowsByKey(ref, cand, "sku", "sku_code")
+	if !alignment.Complete {
+		t.Fatalf("expected complete alignment on synthetic wide tables")
+	}
+
+	got := mapColumns(ref, cand, refProfiles, candProfiles, sampleMappingPairs(alignment.Pairs, 256, defaultMappingSampleSeed), nil, defaultMappingThresholds)
+	want := mapColumnsFullScan(ref, cand, refProfiles, candProfiles, alignment.Pairs, 256)
+
+	gotJSON, err := json.Marshal(got)
+	if err != nil {
+		t.Fatalf("marshal cached mapping: %v", err)
+	}
+	wantJSON, err := json.Marshal(want)
+	if err != nil {
+		t.Fatalf("marshal full-scan mapping: %v", err)
+	}
+	if !bytes.Equal(gotJSON, wantJSON) {
+		t.Fatalf("cached mapping differs from full-scan mapping\ncached:    %s\nfull-scan: %s", gotJSON, wantJSON)
+	}
+	if len(got.Mapping) != len(ref.Headers) {
+		t.Fatalf("expected all %d reference columns mapped, got %d", len(ref.Headers), len(got.Mapping))
+	}
+}
+
+func TestFindKeyMatch_ScoreComponentsSumToScore(t *testing.T) {
+	ref := csvTable{Path: "ref.csv", Headers: []string{"sku", "name"}}
+	cand := csvTable{Path: "cand.csv", Headers: []string{"sku_code", "name"}}
+	for i := 0; i < 20; i++ {
+		ref.Rows = append(ref.Rows, map[string]string{"sku": fmt.Sprintf("SKU-%03d", i), "name": fmt.Sprintf("Item %d", i)})
+		if i < 15 {
+			cand.Rows = append(cand.Rows, map[string]string{"sku_code": fmt.Sprintf("SKU-%03d", i), "name": fmt.Sprintf("Item %d", i)})
+		}
+	}
+	km := findKeyMatch(ref, cand, profileColumns(ref), profileColumns(cand))
+	if len(km.Candidates) == 0 {
+		t.Fatal("expected key candidates")
+	}
+	top := km.Candidates[0]
+	c := top.ScoreComponents
+	sum := c.CompleteBonus + c.CandidateCoverage + c.ReferenceCoverage + c.HeaderSimilarity + c.Support
+	if !almostEqual(sum, top.Score) {
+		t.Fatalf("components sum to %.15f, score is %.15f", sum, top.Score)
+	}
+	if c.CompleteBonus != 0 || !almostEqual(c.CandidateCoverage, 2.0) || !almostEqual(c.ReferenceCoverage, 0.75) {
+		t.Fatalf("unexpected components: %+v", c)
+	}
+}
+
+func TestCompareCSV_PenalizeExtraLowersScoreOnlyWhenEnabled(t *testing.T) {
+	tmpDir := t.TempDir()
+	refRows := csvRows{Header: []string{"sku", "name", "price"}}
+	candRows := csvRows{Header: []string{"sku", "name", "price", "internal_note"}}
+	for i := 0; i < 30; i++ {
+		sku := fmt.Sprintf("SKU-%03d", i)
+		name := fmt.Sprintf("Item %d", i)
+		price := fmt.Sprintf("%d.99", i%9)
+		refRows.Records = append(refRows.Records, []string{sku, name, price})
+		candRows.Records = append(candRows.Records, []string{sku, name, price, fmt.Sprintf("zz-%d", i*i)})
+	}
+	refPath := filepath.Join(tmpDir, "reference.csv")
+	candPath := filepath.Join(tmpDir, "candidate.csv")
+	if err := writeCSVRows(refPath, refRows); err != nil {
+		t.Fatalf("writeCSVRows reference error: %v", err)
+	}
+	if err := writeCSVRows(candPath, candRows); err != nil {
+		t.Fatalf("writeCSVRows candidate error: %v", err)
+	}
+
+	plain, err := compareCSVFilesWithOptions(refPath, candPath, compareOptions{SampleSizeMapping: 256})
+	if err != nil {
+		t.Fatalf("compareCSVFilesWithOptions error: %v", err)
+	}
+	if !almostEqual(plain.Scores.OverallScoreWithCoverage, 1.0) || plain.Config.ExtraCandidatePenalize {
+		t.Fatalf("expected unpenalized score 1.0, got %.15f (penalize=%v)", plain.Scores.OverallScoreWithCoverage, plain.Config.ExtraCandidatePenalize)
+	}
+
+	penalized, err := compareCSVFilesWithOptions(refPath, candPath, compareOptions{SampleSizeMapping: 256, PenalizeExtra: true})
+	if err != nil {
+		t.Fatalf("compareCSVFilesWithOptions error: %v", err)
+	}
+	if !penalized.Config.ExtraCandidatePenalize {
+		t.Fatalf("expected config to record extra-column penalty")
+	}
+	if !almostEqual(penalized.Scores.DatasetSimilarityEqualWeighted, 1.0) {
+		t.Fatalf("dataset similarity should be unaffected, got %.15f", penalized.Scores.DatasetSimilarityEqualWeighted)
+	}
+	if !almostEqual(penalized.Scores.OverallScoreWithCoverage, 0.75) {
+		t.Fatalf("expected penalized score 3/4, got %.15f", penalized.Scores.OverallScoreWithCoverage)
+	}
+}
+
+func TestLoadTable_UnicodeNormalizeFoldsCompositionAndNBSP(t *testing.T) {
+	pairs := [][2]string{
+		{"Cr\u00e8me br\u00fbl\u00e9e", "Cre\u0300me bru\u0302le\u0301e"},
+		{"Dove Shampoo 250 ml", "Dove\u00a0Shampoo\u202f250\u00a0ml"},
+	}
+	path := filepath.Join(t.TempDir(), "values.csv")
+	content := "a,b\n"
+	for _, p := range pairs {
+		content += p[0] + "," + p[1] + "\n"
+	}
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("write csv: %v", err)
+	}
+
+	for _, streaming := range []bool{false, true} {
+		opts := compareOptions{}
+		if streaming {
+			opts.StreamThreshold = 1
+		}
+		table, err := loadTable(path, formatCSV, opts)
+		if err != nil {
+			t.Fatalf("loadTable: %v", err)
+		}
+		table.eachRow(func(i int, row map[string]string) {
+			if got := valueSimilarity(row["a"], row["b"]); !almostEqual(got, 1.0) {
+				t.Fatalf("streaming=%v: normalized similarity(%q, %q) = %.15f, want 1", streaming, row["a"], row["b"], got)
+			}
+			if canonicalScalar(row["a"]) != canonicalScalar(row["b"]) {
+				t.Fatalf("streaming=%v: canonicalScalar differs for %q and %q", streaming, row["a"], row["b"])
+			}
+		})
+		table.Close()
+	}
+
+	raw, err := loadTable(path, formatCSV, compareOptions{SkipUnicodeNormalize: true})
+	if err != nil {
+		t.Fatalf("loadTable: %v", err)
+	}
+	defer raw.Close()
+	raw.eachRow(func(i int, row map[string]string) {
+		if got := valueSimilarity(row["a"], row["b"]); !(got < 1.0) {
+			t.Fatalf("raw similarity(%q, %q) = %.15f, want < 1", row["a"], row["b"], got)
+		}
+	})
+}
+
+func TestCompareCSV_StreamingMatchesInMemory(t *testing.T) {
+	tmpDir := t.TempDir()
+	ref, cand := buildWideTables(120, 8)
+	refRows := tableToCSVRows(ref)
+	candRows := tableToCSVRows(cand)
+	// Quoted commas and embedded newlines must survive offset-based reads.
+	refRows.Records[3][1] = "multi\nline, \"quoted\" value"
+	candRows.Records[3][1] = refRows.Records[3][1]
+	rng := rand.New(rand.NewSource(5))
+	rng.Shuffle(len(candRows.Records), func(i, j int) {
+		candRows.Records[i], candRows.Records[j] = candRows.Records[j], candRows.Records[i]
+	})
+	refPath := filepath.Join(tmpDir, "reference.csv")
+	candPath := filepath.Join(tmpDir, "candidate.csv")
+	if err := writeCSVRows(refPath, refRows); err != nil {
+		t.Fatalf("writeCSVRows reference error: %v", err)
+	}
+	if err := writeCSVRows(candPath, candRows); err != nil {
+		t.Fatalf("writeCSVRows candidate error: %v", err)
+	}
+
+	streamed, err := loadCSVWithThreshold(candPath, 1, ',')
+	if err != nil {
+		t.Fatalf("loadCSVWithThreshold error: %v", err)
+	}
+	defer streamed.Close()
+	if streamed.lazy == nil || streamed.Rows != nil {
+		t.Fatalf("expected candidate to be loaded lazily")
+	}
+	inMemory, err := loadCSV(candPath)
+	if err != nil {
+		t.Fatalf("loadCSV error: %v", err)
+	}
+	if streamed.rowCount() != len(inMemory.Rows) {
+		t.Fatalf("row count %d, want %d", streamed.rowCount(), len(inMemory.Rows))
+	}
+	for _, i := range []int{0, 3, len(inMemory.Rows) - 1} {
+		if got, want := fmt.Sprint(streamed.row(i)), fmt.Sprint(inMemory.Rows[i]); got != want {
+			t.Fatalf("row %d = %s, want %s", i, got, want)
+		}
+	}
+
+	memReport, err := compareCSVFilesWithOptions(refPath, candPath, compareOptions{SampleSizeMapping: 256})
+	if err != nil {
+		t.Fatalf("in-memory compare error: %v", err)
+	}
+	streamReport, err := compareCSVFilesWithOptions(refPath, candPath, compareOptions{SampleSizeMapping: 256, StreamThreshold: 1})
+	if err != nil {
+		t.Fatalf("streaming compare error: %v", err)
+	}
+	memJSON, _ := json.Marshal(memReport)
+	streamJSON, _ := json.Marshal(streamReport)
+	if !bytes.Equal(memJSON, streamJSON) {
+		t.Fatalf("streaming report differs from in-memory report:\n%s\n---\n%s", memJSON, streamJSON)
+	}
+}
+
+func TestCompareCSV_JSONLCandidateMatchesEquivalentCSV(t *testing.T) {
+	tmpDir := t.TempDir()
+	refRows := csvRows{Header: []string{"gtin", "name", "price_eur", "has_pills", "eyecatchers"}}
+	var jsonl bytes.Buffer
+	for i := 0; i < 25; i++ {
+		gtin := fmt.Sprintf("40000000%05d", i)
+		name := fmt.Sprintf("Product \"%d\", large", i)
+		price := fmt.Sprintf("%d.%02d", 1+i%9, (i*11)%100)
+		pills := []string{"True", "False"}[i%2]
+		eyecatchers := ""
+		if i%3 == 0 {
+			eyecatchers = "Neu"
+		}
+		refRows.Records = append(refRows.Records, []string{gtin, name, price, pills, eyecatchers})
+
+		obj := map[string]any{"gtin": gtin, "name": name, "price_eur": json.Number(price), "has_pills": i%2 == 0}
+		if eyecatchers != "" {
+			obj["eyecatchers"] = eyecatchers
+		} else if i%2 == 0 {
+			obj["eyecatchers"] = nil
+		}
+		line, err := json.Marshal(obj)
+		if err != nil {
+			t.Fatal(err)
+		}
+		jsonl.Write(line)
+		jsonl.WriteByte('\n')
+	}
+	refPath := filepath.Join(tmpDir, "reference.csv")
+	candPath := filepath.Join(tmpDir, "candidate.jsonl")
+	if err := writeCSVRows(refPath, refRows); err != nil {
+		t.Fatalf("writeCSVRows reference error: %v", err)
+	}
+	if err := os.WriteFile(candPath, jsonl.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	table, err := loadJSONL(candPath)
+	if err != nil {
+		t.Fatalf("loadJSONL error: %v", err)
+	}
+	if len(table.Rows) != 25 || len(table.Headers) != 5 {
+		t.Fatalf("loaded %d rows / headers %v, want 25 rows and 5 headers", len(table.Rows), table.Headers)
+	}
+	if table.Rows[1]["eyecatchers"] != "" || table.Rows[2]["eyecatchers"] != "" {
+		t.Fatalf("missing and null keys should load as empty cells")
+	}
+
+	report, err := compareCSVFilesWithOptions(refPath, candPath, compareOptions{SampleSizeMapping: 256, CandidateFormat: formatJSONL})
+	if err != nil {
+		t.Fatalf("compareCSVFilesWithOptions error: %v", err)
+	}
+	if !almostEqual(report.Scores.DatasetSimilarityEqualWeighted, 1.0) || !almostEqual(report.Scores.OverallScoreWithCoverage, 1.0) {
+		t.Fatalf("expected JSONL candidate to score 1.0, got similarity=%.15f overall=%.15f",
+			report.Scores.DatasetSimilarityEqualWeighted, report.Scores.OverallScoreWithCoverage)
+	}
+	if _, err := compareCSVFilesWithOptions(refPath, candPath, compareOptions{CandidateFormat: "xml"}); err == nil {
+		t.Fatalf("expected error for unknown candidate format")
+	}
+
+	for _, bad := range []string{`{"sku":"a"} {"sku":"b"}`, `{"sku":"a"}x`, `{"sku":"a"`} {
+		badPath := filepath.Join(tmpDir, "bad.jsonl")
+		if err := os.WriteFile(badPath, []byte(bad+"\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := loadJSONL(badPath); err == nil || !strings.Contains(err.Error(), "bad.jsonl:1:") {
+			t.Fatalf("expected %q to be rejected with its line number, got %v", bad, err)
+		}
+	}
+}
+
+func TestCompareCSV_UnmatchedColumnReportsBestRejectedCandidate(t *testing.T) {
+	tmpDir := t.TempDir()
+	refRows := csvRows{Header: []string{"sku", "name", "weight_g"}}
+	candRows := csvRows{Header: []string{"sku", "name", "colour"}}
+	for i := 0; i < 40; i++ {
+		sku := fmt.Sprintf("SKU-%03d", i)
+		name := fmt.Sprintf("Item %d", i)
+		refRows.Records = append(refRows.Records, []string{sku, name, fmt.Sprintf("%d", 100+i*7)})
+		candRows.Records = append(candRows.Records, []string{sku, name, []string{"red", "green", "blue"}[i%3]})
+	}
+	refPath := filepath.Join(tmpDir, "reference.csv")
+	candPath := filepath.Join(tmpDir, "candidate.csv")
+	if err := writeCSVRows(refPath, refRows); err != nil {
+		t.Fatalf("writeCSVRows reference error: %v", err)
+	}
+	if err := writeCSVRows(candPath, candRows); err != nil {
+		t.Fatalf("writeCSVRows candidate error: %v", err)
+	}
+
+	report, err := compareCSVFiles(refPath, candPath, 256)
+	if err != nil {
+		t.Fatalf("compareCSVFiles error: %v", err)
+	}
+	col := perColumnByName(report.Scores.PerReferenceColumn, "weight_g")
+	if col == nil || col.Matched {
+		t.Fatalf("expected weight_g to stay unmatched, got %+v", col)
+	}
+	var conf float64
+	for _, p := range report.ColumnMapping.PairCandidatesTop {
+		if p.ReferenceColumn == "weight_g" && p.CandidateColumn == "colour" {
+			conf = p.MappingConfidence
+		}
+	}
+	want := fmt.Sprintf("best candidate 'colour' confidence %.2f below threshold", conf)
+	if !strings.HasPrefix(col.Reason, want) {
+		t.Fatalf("expected reason starting %q, got %q", want, col.Reason)
+	}
+	if report.ColumnMapping.UnmatchedReasons["weight_g"] != col.Reason {
+		t.Fatalf("expected column mapping to carry the same reason, got %q", report.ColumnMapping.UnmatchedReasons["weight_g"])
+	}
+	if named := perColumnByName(report.Scores.PerReferenceColumn, "name"); named == nil || named.Reason != "" {
+		t.Fatalf("matched columns should not carry a reason, got %+v", named)
+	}
+}
+
+func TestCompareCSV_LowerMinConfidenceMapsNoisyColumn(t *testing.T) {
+	tmpDir := t.TempDir()
+	refRows := csvRows{Header: []string{"sku", "name", "brand"}}
+	candRows := csvRows{Header: []string{"sku", "name", "brand_label"}}
+	for i := 0; i < 40; i++ {
+		sku := fmt.Sprintf("SKU-%03d", i)
+		brand := fmt.Sprintf("Brand %d", i%5)
+		noisy := brand
+		if i%5 != 0 {
+			noisy = fmt.Sprintf("Label %d", i)
+		}
+		refRows.Records = append(refRows.Records, []string{sku, fmt.Sprintf("Item %d", i), brand})
+		candRows.Records = append(candRows.Records, []string{sku, fmt.Sprintf("Item %d", i), noisy})
+	}
+	refPath := filepath.Join(tmpDir, "reference.csv")
+	candPath := filepath.Join(tmpDir, "candidate.csv")
+	if err := writeCSVRows(refPath, refRows); err != nil {
+		t.Fatalf("writeCSVRows reference error: %v", err)
+	}
+	if err := writeCSVRows(candPath, candRows); err != nil {
+		t.Fatalf("writeCSVRows candidate error: %v", err)
+	}
+
+	strict, err := compareCSVFilesWithOptions(refPath, candPath, compareOptions{SampleSizeMapping: 256})
+	if err != nil {
+		t.Fatalf("compareCSVFilesWithOptions error: %v", err)
+	}
+	if strict.Scores.MappedReferenceColumns != 2 {
+		t.Fatalf("expected brand to stay unmatched at default thresholds, mapped=%d", strict.Scores.MappedReferenceColumns)
+	}
+	if strict.Config.MinMappingConfidence != 0.55 || strict.Config.MinSampleSimilarity != 0.85 {
+		t.Fatalf("expected default thresholds echoed in config, got %+v", strict.Config)
+	}
+
+	loose, err := compareCSVFilesWithOptions(refPath, candPath, compareOptions{
+		SampleSizeMapping: 256,
+		Thresholds:        &mappingThresholds{MinConfidence: 0.3, MinSampleSim: 0.85},
+	})
+	if err != nil {
+		t.Fatalf("compareCSVFilesWithOptions error: %v", err)
+	}
+	if loose.Scores.MappedReferenceColumns != 3 {
+		t.Fatalf("expected lower min confidence to map brand, mapped=%d", loose.Scores.MappedReferenceColumns)
+	}
+	if col := perColumnByName(loose.Scores.PerReferenceColumn, "brand"); col == nil || col.CandidateColumn == nil || *col.CandidateColumn != "brand_label" {
+		t.Fatalf("expected brand -> brand_label, got %+v", col)
+	}
+	if loose.Config.MinMappingConfidence != 0.3 {
+		t.Fatalf("expected config to echo min confidence 0.3, got %v", loose.Config.MinMappingConfidence)
+	}
+}
+
+func TestCompareCSV_ValueDistDetectsSwappedAssignments(t *testing.T) {
+	tmpDir := t.TempDir()
+	refRows := csvRows{Header: []string{"sku", "size"}}
+	candRows := csvRows{Header: []string{"sku", "size"}}
+	sizes := make([]string, 60)
+	for i := range sizes {
+		sizes[i] = []string{"S", "M", "L", "XL"}[i%4]
+	}
+	swapped := append([]string(nil), sizes...)
+	rand.New(rand.NewSource(3)).Shuffle(len(swapped), func(i, j int) { swapped[i], swapped[j] = swapped[j], swapped[i] })
+	for i := range sizes {
+		sku := fmt.Sprintf("SKU-%03d", i)
+		refRows.Records = append(refRows.Records, []string{sku, sizes[i]})
+		candRows.Records = append(candRows.Records, []string{sku, swapped[i]})
+	}
+	refPath := filepath.Join(tmpDir, "reference.csv")
+	candPath := filepath.Join(tmpDir, "candidate.csv")
+	if err := writeCSVRows(refPath, refRows); err != nil {
+		t.Fatalf("writeCSVRows reference error: %v", err)
+	}
+	if err := writeCSVRows(candPath, candRows); err != nil {
+		t.Fatalf("writeCSVRows candidate error: %v", err)
+	}
+
+	plain, err := compareCSVFilesWithOptions(refPath, candPath, compareOptions{SampleSizeMapping: 256})
+	if err != nil {
+		t.Fatalf("compareCSVFilesWithOptions error: %v", err)
+	}
+	if col := perColumnByName(plain.Scores.PerReferenceColumn, "size"); col == nil || col.ValueDistOverlap != nil {
+		t.Fatalf("expected no distribution overlap without -value-dist, got %+v", col)
+	}
+
+	report, err := compareCSVFilesWithOptions(refPath, candPath, compareOptions{SampleSizeMapping: 256, ValueDistTopK: 10})
+	if err != nil {
+		t.Fatalf("compareCSVFilesWithOptions error: %v", err)
+	}
+	col := perColumnByName(report.Scores.PerReferenceColumn, "size")
+	if col == nil || !col.Matched || col.ValueDistOverlap == nil {
+		t.Fatalf("expected matched size column with distribution overlap, got %+v", col)
+	}
+	if !almostEqual(*col.ValueDistOverlap, 1.0) {
+		t.Fatalf("expected identical multisets to overlap 1.0, got %.15f", *col.ValueDistOverlap)
+	}
+	if !(col.Similarity < 0.75) {
+		t.Fatalf("expected shuffled assignments to lower cell similarity, got %.15f", col.Similarity)
+	}
+}
+
+func TestCompareCSV_BelowMinOverallGatesOnScore(t *testing.T) {
+	tmpDir := t.TempDir()
+	rows := csvRows{Header: []string{"id", "name"}}
+	for i := 0; i < 20; i++ {
+		rows.Records = append(rows.Records, []string{fmt.Sprintf("%d", i), fmt.Sprintf("item %d", i)})
+	}
+	refPath := filepath.Join(tmpDir, "reference.csv")
+	candPath := filepath.Join(tmpDir, "candidate.csv")
+	if err := writeCSVRows(refPath, rows); err != nil {
+		t.Fatalf("writeCSVRows reference error: %v", err)
+	}
+	if err := writeCSVRows(candPath, rows); err != nil {
+		t.Fatalf("writeCSVRows candidate error: %v", err)
+	}
+	report, err := compareCSVFiles(refPath, candPath, 256)
+	if err != nil {
+		t.Fatalf("compareCSVFiles error: %v", err)
+	}
+	score := report.Scores.OverallScoreWithCoverage
+	if belowMinOverall(score, score-0.01) {
+		t.Fatalf("score %.6f should pass a lower minimum", score)
+	}
+	if !belowMinOverall(score, score+0.01) {
+		t.Fatalf("score %.6f should fail a higher minimum", score)
+	}
+	if belowMinOverall(0, 0) {
+		t.Fatalf("a zero minimum must disable the gate")
+	}
+}
+
+func TestMustMarshalReport_CompactJSONRoundTrips(t *testing.T) {
+	rows := csvRows{Header: []string{"sku", "name", "price"}}
+	for i := 0; i < 20; i++ {
+		rows.Records = append(rows.Records, []string{fmt.Sprintf("SKU-%03d", i), fmt.Sprintf("Item %d", i), fmt.Sprintf("%d.49", i%7)})
+	}
+	path := filepath.Join(t.TempDir(), "products.csv")
+	if err := writeCSVRows(path, rows); err != nil {
+		t.Fatalf("writeCSVRows error: %v", err)
+	}
+	report, err := compareCSVFiles(path, path, 256)
+	if err != nil {
+		t.Fatalf("compareCSVFiles error: %v", err)
+	}
+
+	pretty := mustMarshalReport(report, false)
+	compact := mustMarshalReport(report, true)
+	if bytes.ContainsAny(compact, "\n\t") || bytes.Contains(compact, []byte(": ")) {
+		t.Fatalf("expected compact report without newlines or indentation, got %.200s", compact)
+	}
+	if len(compact) >= len(pretty) {
+		t.Fatalf("expected compact report (%d bytes) to be smaller than pretty (%d bytes)", len(compact), len(pretty))
+	}
+	var fromCompact, fromPretty any
+	if err := json.Unmarshal(compact, &fromCompact); err != nil {
+		t.Fatalf("compact report does not decode: %v", err)
+	}
+	if err := json.Unmarshal(pretty, &fromPretty); err != nil {
+		t.Fatalf("pretty report does not decode: %v", err)
+	}
+	a, _ := json.Marshal(fromCompact)
+	b, _ := json.Marshal(fromPretty)
+	if !bytes.Equal(a, b) {
+		t.Fatalf("compact and pretty reports decode to different values")
+	}
+}
+
+func TestCompareCSV_ProfileColumnsReportInferredType(t *testing.T) {
+	tmpDir := t.TempDir()
+	rows := csvRows{Header: []string{"sku", "price", "description", "in_stock", "note"}}
+	for i := 0; i < 20; i++ {
+		note := fmt.Sprintf("%d", i)
+		if i%2 == 0 {
+			note = fmt.Sprintf("see item %d", i)
+		}
+		rows.Records = append(rows.Records, []string{
+			fmt.Sprintf("SKU-%03d", i),
+			fmt.Sprintf("%d.95", i+2),
+			fmt.Sprintf("Gentle cream number %d for dry skin", i),
+			ternary(i%3 == 0, "yes", "no"),
+			note,
+		})
+	}
+	path := filepath.Join(tmpDir, "products.csv")
+	if err := writeCSVRows(path, rows); err != nil {
+		t.Fatalf("writeCSVRows error: %v", err)
+	}
+	report, err := compareCSVFiles(path, path, 256)
+	if err != nil {
+		t.Fatalf("compareCSVFiles error: %v", err)
+	}
+
+	want := map[string]string{
+		"price":       columnTypeNumeric,
+		"description": columnTypeText,
+		"in_stock":    columnTypeBoolean,
+		"note":        columnTypeMixed,
+	}
+	for col, typ := range want {
+		if got := report.ReferenceProfile.Columns[col].InferredType; got != typ {
+			t.Errorf("reference %s inferred %q, want %q", col, got, typ)
+		}
+		if got := report.CandidateProfile.Columns[col].InferredType; got != typ {
+			t.Errorf("candidate %s inferred %q, want %q", col, got, typ)
+		}
+	}
+}
+
+func TestCompareCSV_ExpectedMappingReportsAccuracy(t *testing.T) {
+	tmpDir := t.TempDir()
+	refRows := csvRows{Header: []string{"sku", "product_name", "net_price"}}
+	candRows := csvRows{Header: []string{"article_name", "sku_code", "net_price", "amount"}}
+	for i := 0; i < 60; i++ {
+		sku := fmt.Sprintf("SKU-%03d", i)
+		name := fmt.Sprintf("Gentle cream %d", i)
+		price := fmt.Sprintf("%d.%02d", 2+i%9, (i*7)%100)
+		decoy := price
+		if i%2 == 1 {
+			decoy = fmt.Sprintf("%d.%02d", 20+i%9, (i*7)%100)
+		}
+		refRows.Records = append(refRows.Records, []string{sku, name, price})
+		candRows.Records = append(candRows.Records, []string{name, sku, decoy, price})
+	}
+	refPath := filepath.Join(tmpDir, "reference.csv")
+	candPath := filepath.Join(tmpDir, "candidate.csv")
+	if err := writeCSVRows(refPath, refRows); err != nil {
+		t.Fatalf("writeCSVRows reference error: %v", err)
+	}
+	if err := writeCSVRows(candPath, candRows); err != nil {
+		t.Fatalf("writeCSVRows candidate error: %v", err)
+	}
+	expectedPath := filepath.Join(tmpDir, "expected.json")
+	if err := os.WriteFile(expectedPath, []byte(`{"sku": "sku_code", "product_name": "article_name", "net_price": "amount"}`), 0o644); err != nil {
+		t.Fatalf("write expected mapping error: %v", err)
+	}
+	expected, err := loadMappingOverrides(expectedPath)
+	if err != nil {
+		t.Fatalf("loadMappingOverrides error: %v", err)
+	}
+
+	plain, err := compareCSVFiles(refPath, candPath, 256)
+	if err != nil {
+		t.Fatalf("compareCSVFiles error: %v", err)
+	}
+	if plain.MappingAccuracy != nil {
+		t.Fatalf("expected no mapping_accuracy without an expected mapping")
+	}
+
+	checked, err := compareCSVFilesWithOptions(refPath, candPath, compareOptions{SampleSizeMapping: 256, ExpectedMapping: expected})
+	if err != nil {
+		t.Fatalf("compareCSVFilesWithOptions error: %v", err)
+	}
+	acc := checked.MappingAccuracy
+	if acc == nil || acc.Expected != 3 || acc.Correct != 2 || len(acc.Mismatches) != 1 {
+		t.Fatalf("expected 2/3 correct mappings, got %+v", acc)
+	}
+	miss := acc.Mismatches[0]
+	if miss.ReferenceColumn != "net_price" || miss.ExpectedCandidate != "amount" || miss.ActualCandidate == nil || *miss.ActualCandidate != "net_price" {
+		t.Fatalf("expected net_price -> net_price to be reported as a mismatch, got %+v", miss)
+	}
+
+	fixed, err := compareCSVFilesWithOptions(refPath, candPath, compareOptions{SampleSizeMapping: 256, MappingOverrides: map[string]string{"net_price": "amount"}, ExpectedMapping: expected})
+	if err != nil {
+		t.Fatalf("compareCSVFilesWithOptions error: %v", err)
+	}
+	if fixed.MappingAccuracy == nil || !almostEqual(fixed.MappingAccuracy.Accuracy, 1.0) || len(fixed.MappingAccuracy.Mismatches) != 0 {
+		t.Fatalf("expected 100%% accuracy for the correct mapping, got %+v", fixed.MappingAccuracy)
+	}
+}
+
+func TestMapColumns_SeededSampleSpansAllAlignedRows(t *testing.T) {
+	ref := csvTable{Path: "ref.csv", Headers: []string{"sku", "price"}}
+	cand := csvTable{Path: "cand.csv", Headers: []string{"sku", "cost"}}
+	for i := 0; i < 400; i++ {
+		price := fmt.Sprintf("%d.%02d", 1+i%13, (i*17)%100)
+		candPrice := price
+		if i < 50 {
+			// The first rows were exported before a repricing and all disagree.
+			candPrice = fmt.Sprintf("%d.%02d", 90+i%7, (i*3)%100)
+		}
+		ref.Rows = append(ref.Rows, map[string]string{"sku": fmt.Sprintf("SKU-%03d", i), "price": price})
+		cand.Rows = append(cand.Rows, map[string]string{"sku": fmt.Sprintf("SKU-%03d", i), "cost": candPrice})
+	}
+	refProfiles := profileColumns(ref)
+	candProfiles := profileColumns(cand)
+	alignment := alignRowsByKey(ref, cand, "sku", "sku")
+
+	sampleSim := func(pairs [][2]int) float64 {
+		mapping := mapColumns(ref, cand, refProfiles, candProfiles, pairs, nil, defaultMappingThresholds)
+		for _, p := range mapping.PairCandidatesTop {
+			if p.ReferenceColumn == "price" && p.CandidateColumn == "cost" {
+				return p.SampleSimilarity
+			}
+		}
+		t.Fatalf("price/cost pair missing from %+v", mapping.PairCandidatesTop)
+		return 0
+	}
+	head := sampleSim(alignment.Pairs[:50])
+	sampled := sampleSim(sampleMappingPairs(alignment.Pairs, 50, defaultMappingSampleSeed))
+	if !(head < 0.5) || !(sampled > 0.75) {
+		t.Fatalf("expected head-truncated sample to miss the match (%.3f) and the seeded sample to find it (%.3f)", head, sampled)
+	}
+
+	a := sampleMappingPairs(alignment.Pairs, 50, 7)
+	b := sampleMappingPairs(alignment.Pairs, 50, 7)
+	if fmt.Sprint(a) != fmt.Sprint(b) {
+		t.Fatalf("expected the same seed to pick the same pairs")
+	}
+	if fmt.Sprint(a) == fmt.Sprint(sampleMappingPairs(alignment.Pairs, 50, 8)) {
+		t.Fatalf("expected a different seed to pick different pairs")
+	}
+	if got := sampleMappingPairs(alignment.Pairs, 0, 7); len(got) != len(alignment.Pairs) {
+		t.Fatalf("expected sample size 0 to keep all %d pairs, got %d", len(alignment.Pairs), len(got))
+	}
+}
+
+func BenchmarkMapColumns_Wide40(b *testing.B) {
+	ref, cand := buildWideTables(500, 40)
+	refProfiles := profileColumns(ref)
+	candProfiles := profileColumns(cand)
+	alignment := alignRowsByKey(ref, cand, "sku", "sku_code")
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		mapColumns(ref, cand, refProfiles, candProfiles, sampleMappingPairs(alignment.Pairs, 256, defaultMappingSampleSeed), nil, defaultMappingThresholds)
+	}
+}
+
+func BenchmarkMapColumns_Wide40FullScan(b *testing.B) {
+	ref, cand := buildWideTables(500, 40)
+	refProfiles := profileColumns(ref)
+	candProfiles := profileColumns(cand)
+	alignment := alignRowsByKey(ref, cand, "sku", "sku_code")
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		mapColumnsFullScan(ref, cand, refProfiles, candProfiles, alignment.Pairs, 256)
+	}
+}
+
+func TestProfileColumns_KeepsValueSetsOnlyForUniqueColumns(t *testing.T) {
+	table := csvTable{Path: "ref.csv", Headers: []string{"sku", "brand"}}
+	for i := 0; i < 10; i++ {
+		table.Rows = append(table.Rows, map[string]string{"sku": fmt.Sprintf("SKU-%03d", i), "brand": "Balea"})
+	}
+	profiles := profileColumns(table)
+	if p := profiles["sku"]; !p.IsUniqueNonEmpty || len(p.canonSet) != 10 {
+		t.Fatalf("expected sku to be unique with 10 values, got unique=%v values=%d", p.IsUniqueNonEmpty, len(p.canonSet))
+	}
+	if p := profiles["brand"]; p.IsUniqueNonEmpty || p.canonSet != nil || p.NonEmptyCount != 10 {
+		t.Fatalf("expected brand to drop its value set after a duplicate, got unique=%v values=%d non_empty=%d", p.IsUniqueNonEmpty, len(p.canonSet), p.NonEmptyCount)
+	}
+}
+
+// mapColumnsFullScan is the pre-caching mapping path: every column pair
+// re-reads the aligned sample rows via sampleColumnSimilarityFast.
+func mapColumnsFullScan(ref, cand csvTable, refProfiles, candProfiles map[string]colProfile, pairs [][2]int, sampleSize int) columnMappingPayload {
+	samplePairs := sampleMappingPairs(pairs, sampleSize, defaultMappingSampleSeed)
+	allPairs := make([]mappingPair, 0, len(ref.Headers)*len(cand.Headers))
+	for _, refCol := range ref.Headers {
+		for _, candCol := range cand.Headers {
+			h := headerSimilarity(refCol, candCol)
+			tc := typeCompatibilityScore(refProfiles[refCol], candProfiles[candCol])
+			s := sampleColumnSimilarityFast(ref, cand, samplePairs, refCol, candCol)
+			allPairs = append(allPairs, newMappingPair(refCol, candCol, h, tc, s))
+		}
+	}
+	return selectColumnMapping(ref, cand, allPairs, nil, defaultMappingThresholds)
+}
+
+// buildWideTables returns a reference table with a unique "sku" key plus
+// cols-1 mixed numeric/bool/text columns, and a candidate with renamed
+// headers, reversed column order and shuffled rows.
+func buildWideTables(rowCount, cols int) (csvTable, csvTable) {
+	refHeaders := []string{"sku"}
+	candHeaders := []string{"sku_code"}
+	for i := 1; i < cols; i++ {
+		refHeaders = append(refHeaders, fmt.Sprintf("field_%02d", i))
+		candHeaders = append(candHeaders, fmt.Sprintf("field_%02d_value", i))
+	}
+	ref := csvTable{Path: "ref_wide.csv", Headers: refHeaders}
+	cand := csvTable{Path: "cand_wide.csv"}
+	for i := len(candHeaders) - 1; i >= 0; i-- {
+		cand.Headers = append(cand.Headers, candHeaders[i])
+	}
+	rng := rand.New(rand.NewSource(20260224))
+	for r := 0; r < rowCount; r++ {
+		refRow := map[string]string{"sku": fmt.Sprintf("SKU-%05d", r)}
+		candRow := map[string]string{"sku_code": fmt.Sprintf("SKU-%05d", r)}
+		for i := 1; i < cols; i++ {
+			var v string
+			switch i % 4 {
+			case 0:
+				v = fmt.Sprintf("%d.%02d", rng.Intn(500), rng.Intn(100))
+			case 1:
+				v = strconv.FormatBool(rng.Intn(2) == 0)
+			case 2:
+				if rng.Intn(10) > 0 {
+					v = fmt.Sprintf("item %d variant %d", rng.Intn(50), i)
+				}
+			default:
+				v = strconv.Itoa(rng.Intn(20))
+			}
+			refRow[refHeaders[i]] = v
+			candRow[candHeaders[i]] = v
+		}
+		ref.Rows = append(ref.Rows, refRow)
+		cand.Rows = append(cand.Rows, candRow)
+	}
+	rng.Shuffle(len(cand.Rows), func(i, j int) { cand.Rows[i], cand.Rows[j] = cand.Rows[j], cand.Rows[i] })
+	return ref, cand
+}
+
+func perColumnByName(cols []perColumnScore, refCol string) *perColumnScore {
+	for i := range cols {
+		if cols[i].ReferenceColumn == refCol {
+			return &cols[i]
+		}
+	}
+	return nil
+}
+
+func tableToCSVRows(table csvTable) csvRows {
+	out := csvRows{Header: append([]string(nil), table.Headers...)}
+	for _, row := range table.Rows {
+		rec := make([]string, 0, len(table.Headers))
+		for _, h := range table.Headers {
+			rec = append(rec, row[h])
+		}
+		out.Records = append(out.Records, rec)
+	}
+	return out
+}
+
 type csvRows struct {
 	Header  []string
 	Records [][]string
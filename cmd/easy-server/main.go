@@ -1,7 +1,10 @@
 package main
 
 import (
+	"context"
+	"crypto/rand"
 	"database/sql"
+	"encoding/hex"
 	"encoding/json"
 	"encoding/xml"
 	"errors"
@@ -10,6 +13,7 @@ import (
 	"html/template"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
 	"strconv"
 	"strings"
@@ -21,6 +25,10 @@ import (
 const defaultAddr = "127.0.0.1:8080"
 const sitemapProtocolMaxURLs = 50000
 const defaultSitemapChunkSize = 10000
+const defaultDBOpenRetries = 3
+
+var dbRetryBaseDelay = 100 * time.Millisecond
+
 const searchMinChars = 3
 const searchPageSize = 10
 
@@ -34,6 +42,11 @@ func main() {
 	idCol := flag.String("id", "", "Name of the unique ID column used for lookup")
 	addr := flag.String("addr", defaultAddr, "HTTP listen address")
 	sitemapChunkSize := flag.Int("sitemap-chunk-size", defaultSitemapChunkSize, "Max product URLs per sitemap file (capped at 50000)")
+	tableName := flag.String("table", "", "Table to serve (default: first user table, alphabetically)")
+	httpLimits := registerHTTPServerFlags(flag.CommandLine)
+	compactJSON := flag.Bool("compact-json", false, "Write minified JSON responses instead of indented ones")
+	trustForwarded := flag.Bool("trust-forwarded", false, "Honor X-Forwarded-Proto (only enable behind a trusted proxy)")
+	dbOpenRetries := flag.Int("db-open-retries", defaultDBOpenRetries, "Retries with exponential backoff when the sqlite database is busy or not ready at startup")
 	flag.Parse()
 
 	if *dbPath == "" {
@@ -59,7 +72,15 @@ func main() {
 	}
 	defer db.Close()
 
-	table, err := firstUserTable(db)
+	var table string
+	err = retryWithBackoff(*dbOpenRetries, func() error {
+		if err := db.Ping(); err != nil {
+			return err
+		}
+		var err error
+		table, err = resolveTable(db, *tableName)
+		return err
+	})
 	if err != nil {
 		log.Fatalf("find table: %v", err)
 	}
@@ -72,6 +93,35 @@ func main() {
 		log.Fatalf("id column %q not found in table %q", *idCol, table)
 	}
 
+	mux := newServerMux(db, serverConfig{
+		Table:            table,
+		Cols:             cols,
+		IDCol:            *idCol,
+		SitemapChunkSize: *sitemapChunkSize,
+		CompactJSON:      *compactJSON,
+		TrustForwarded:   *trustForwarded,
+	})
+
+	log.Printf("easy-server listening on %s (table=%s id=%s)", *addr, table, *idCol)
+	srv := newHTTPServer(*addr, withRequestID(mux), *httpLimits)
+	if err := srv.ListenAndServe(); err != nil {
+		log.Fatalf("server error: %v", err)
+	}
+}
+
+type serverConfig struct {
+	Table            string
+	Cols             []string
+	IDCol            string
+	SitemapChunkSize int
+	CompactJSON      bool
+	TrustForwarded   bool
+}
+
+// newServerMux registers the storefront pages and the JSON API. API errors
+// use writeJSONError; HTML routes keep plain-text errors.
+func newServerMux(db *sql.DB, cfg serverConfig) *http.ServeMux {
+	table, cols, idCol, sitemapChunkSize := cfg.Table, cfg.Cols, cfg.IDCol, cfg.SitemapChunkSize
 	mux := http.NewServeMux()
 	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
@@ -82,14 +132,14 @@ func main() {
 			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 			return
 		}
-		total, err := countNonEmptyIDs(db, table, *idCol)
+		total, err := countNonEmptyIDs(db, table, idCol)
 		if err != nil {
 			http.Error(w, "internal error", http.StatusInternalServerError)
-			log.Printf("sitemap count error: %v", err)
+			logRequestf(r, "sitemap count error: %v", err)
 			return
 		}
-		baseURL := requestBaseURL(r)
-		payload := buildSitemapIndexXML(baseURL, total, *sitemapChunkSize)
+		baseURL := requestBaseURL(r, cfg.TrustForwarded)
+		payload := buildSitemapIndexXML(baseURL, total, sitemapChunkSize)
 		writeXML(w, payload)
 	})
 	mux.HandleFunc("/sitemaps/", func(w http.ResponseWriter, r *http.Request) {
@@ -102,76 +152,76 @@ func main() {
 			http.NotFound(w, r)
 			return
 		}
-		total, err := countNonEmptyIDs(db, table, *idCol)
+		total, err := countNonEmptyIDs(db, table, idCol)
 		if err != nil {
 			http.Error(w, "internal error", http.StatusInternalServerError)
-			log.Printf("sitemap count error: %v", err)
+			logRequestf(r, "sitemap count error: %v", err)
 			return
 		}
 		if total == 0 {
 			http.NotFound(w, r)
 			return
 		}
-		pageCount := (total + *sitemapChunkSize - 1) / *sitemapChunkSize
+		pageCount := (total + sitemapChunkSize - 1) / sitemapChunkSize
 		if pageNum < 1 || pageNum > pageCount {
 			http.NotFound(w, r)
 			return
 		}
-		offset := (pageNum - 1) * *sitemapChunkSize
-		ids, err := fetchProductIDsPage(db, table, *idCol, *sitemapChunkSize, offset)
+		offset := (pageNum - 1) * sitemapChunkSize
+		ids, err := fetchProductIDsPage(db, table, idCol, sitemapChunkSize, offset)
 		if err != nil {
 			http.Error(w, "internal error", http.StatusInternalServerError)
-			log.Printf("sitemap page error: %v", err)
+			logRequestf(r, "sitemap page error: %v", err)
 			return
 		}
-		baseURL := requestBaseURL(r)
+		baseURL := requestBaseURL(r, cfg.TrustForwarded)
 		payload := buildProductURLSetXML(baseURL, ids)
 		writeXML(w, payload)
 	})
 	mux.HandleFunc("/api/home", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodGet {
-			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			writeJSONError(w, http.StatusMethodNotAllowed, "method_not_allowed", "method not allowed")
 			return
 		}
 
-		payload, err := fetchHomePayload(db, table)
+		payload, err := fetchHomePayload(db, table, idCol)
 		if err != nil {
-			http.Error(w, "internal error", http.StatusInternalServerError)
-			log.Printf("home payload error: %v", err)
+			writeJSONError(w, http.StatusInternalServerError, "internal_error", "internal error")
+			logRequestf(r, "home payload error: %v", err)
 			return
 		}
 
-		writeJSON(w, payload)
+		writeJSON(w, payload, cfg.CompactJSON)
 	})
 	mux.HandleFunc("/api/search", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodGet {
-			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			writeJSONError(w, http.StatusMethodNotAllowed, "method_not_allowed", "method not allowed")
 			return
 		}
 		q := strings.TrimSpace(r.URL.Query().Get("q"))
 		if len([]rune(q)) < searchMinChars {
-			http.Error(w, fmt.Sprintf("query must be at least %d characters", searchMinChars), http.StatusBadRequest)
+			writeJSONError(w, http.StatusBadRequest, "query_too_short", fmt.Sprintf("query must be at least %d characters", searchMinChars))
 			return
 		}
 		page, ok := parsePageQueryParam(r, "page", 1)
 		if !ok {
-			http.Error(w, "invalid page", http.StatusBadRequest)
+			writeJSONError(w, http.StatusBadRequest, "invalid_page", "page must be a positive integer")
 			return
 		}
 		offset, ok := pageOffset(page, searchPageSize)
 		if !ok {
-			http.Error(w, "page value is too large", http.StatusBadRequest)
+			writeJSONError(w, http.StatusBadRequest, "page_out_of_range", "page value is too large")
 			return
 		}
 
-		payload, err := fetchSearchPayload(db, table, cols, *idCol, q, page, searchPageSize, offset)
+		payload, err := fetchSearchPayload(db, table, cols, idCol, q, page, searchPageSize, offset)
 		if err != nil {
-			http.Error(w, "internal error", http.StatusInternalServerError)
-			log.Printf("search error: %v", err)
+			writeJSONError(w, http.StatusInternalServerError, "internal_error", "internal error")
+			logRequestf(r, "search error: %v", err)
 			return
 		}
 
-		writeJSON(w, payload)
+		writeJSON(w, payload, cfg.CompactJSON)
 	})
 	mux.HandleFunc("/search", func(w http.ResponseWriter, r *http.Request) {
 		if r.URL.Path != "/search" {
@@ -182,7 +232,7 @@ func main() {
 		if err := searchPageTemplate.Execute(w, map[string]any{
 			"title": "Search | dimi",
 		}); err != nil {
-			log.Printf("template error: %v", err)
+			logRequestf(r, "template error: %v", err)
 		}
 	})
 	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
@@ -194,13 +244,13 @@ func main() {
 		if err := homePageTemplate.Execute(w, map[string]any{
 			"title": "dimi",
 		}); err != nil {
-			log.Printf("template error: %v", err)
+			logRequestf(r, "template error: %v", err)
 		}
 	})
 	mux.HandleFunc("/api/product/", func(w http.ResponseWriter, r *http.Request) {
 		rest := strings.TrimPrefix(r.URL.Path, "/api/product/")
 		if rest == "" || rest == r.URL.Path {
-			http.Error(w, "missing product id", http.StatusBadRequest)
+			writeJSONError(w, http.StatusBadRequest, "missing_id", "missing product id")
 			return
 		}
 
@@ -208,38 +258,38 @@ func main() {
 			id := strings.TrimSuffix(rest, "/similar")
 			id = strings.TrimSuffix(id, "/")
 			if id == "" {
-				http.Error(w, "missing product id", http.StatusBadRequest)
+				writeJSONError(w, http.StatusBadRequest, "missing_id", "missing product id")
 				return
 			}
 
-			similar, err := fetchSimilar(db, table, *idCol, id)
+			similar, err := fetchSimilar(db, table, idCol, id)
 			if errors.Is(err, sql.ErrNoRows) {
-				http.Error(w, "not found", http.StatusNotFound)
+				writeJSONError(w, http.StatusNotFound, "not_found", "product not found")
 				return
 			}
 			if err != nil {
-				http.Error(w, "internal error", http.StatusInternalServerError)
-				log.Printf("similar error: %v", err)
+				writeJSONError(w, http.StatusInternalServerError, "internal_error", "internal error")
+				logRequestf(r, "similar error: %v", err)
 				return
 			}
 
-			writeJSON(w, similar)
+			writeJSON(w, similar, cfg.CompactJSON)
 			return
 		}
 
 		id := strings.TrimSuffix(rest, "/")
-		row, err := fetchByID(db, table, cols, *idCol, id)
+		row, err := fetchByID(db, table, cols, idCol, id)
 		if errors.Is(err, sql.ErrNoRows) {
-			http.Error(w, "not found", http.StatusNotFound)
+			writeJSONError(w, http.StatusNotFound, "not_found", "product not found")
 			return
 		}
 		if err != nil {
-			http.Error(w, "internal error", http.StatusInternalServerError)
-			log.Printf("fetch error: %v", err)
+			writeJSONError(w, http.StatusInternalServerError, "internal_error", "internal error")
+			logRequestf(r, "fetch error: %v", err)
 			return
 		}
 
-		writeJSON(w, row)
+		writeJSON(w, row, cfg.CompactJSON)
 	})
 	mux.HandleFunc("/product/", func(w http.ResponseWriter, r *http.Request) {
 		id := strings.TrimPrefix(r.URL.Path, "/product/")
@@ -252,13 +302,98 @@ func main() {
 		if err := productPageTemplate.Execute(w, map[string]any{
 			"id": id,
 		}); err != nil {
-			log.Printf("template error: %v", err)
+			logRequestf(r, "template error: %v", err)
 		}
 	})
+	return mux
+}
 
-	log.Printf("easy-server listening on %s (table=%s id=%s)", *addr, table, *idCol)
-	if err := http.ListenAndServe(*addr, mux); err != nil {
-		log.Fatalf("server error: %v", err)
+const (
+	requestIDHeader    = "X-Request-ID"
+	maxRequestIDLength = 128
+)
+
+type requestIDKey struct{}
+
+// withRequestID reuses a client-supplied X-Request-ID (when short and
+// printable) or generates one, stores it in the request context and echoes it
+// in the response header.
+func withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := strings.TrimSpace(r.Header.Get(requestIDHeader))
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(requestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+	})
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 16)
+	}
+	return hex.EncodeToString(b[:])
+}
+
+// requestIDFrom returns the ID stored by withRequestID, or "".
+func requestIDFrom(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// logRequestf is log.Printf with the request's ID prepended, so handler error
+// lines can be matched to the client request.
+func logRequestf(r *http.Request, format string, args ...any) {
+	if id := requestIDFrom(r.Context()); id != "" {
+		log.Printf("request_id=%s "+format, append([]any{id}, args...)...)
+		return
+	}
+	log.Printf(format, args...)
+}
+
+// httpServerLimits bounds slow or oversized clients (slowloris) via the
+// http.Server timeouts and header size cap.
+type httpServerLimits struct {
+	ReadHeaderTimeout time.Duration
+	ReadTimeout       time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	MaxHeaderBytes    int
+}
+
+func registerHTTPServerFlags(fs *flag.FlagSet) *httpServerLimits {
+	limits := &httpServerLimits{}
+	fs.DurationVar(&limits.ReadHeaderTimeout, "read-header-timeout", 5*time.Second, "Max time to read request headers")
+	fs.DurationVar(&limits.ReadTimeout, "read-timeout", 15*time.Second, "Max time to read the full request")
+	fs.DurationVar(&limits.WriteTimeout, "write-timeout", 30*time.Second, "Max time to write the response")
+	fs.DurationVar(&limits.IdleTimeout, "idle-timeout", 60*time.Second, "Max keep-alive idle time between requests")
+	fs.IntVar(&limits.MaxHeaderBytes, "max-header-bytes", 1<<20, "Max request header size in bytes")
+	return limits
+}
+
+func newHTTPServer(addr string, handler http.Handler, limits httpServerLimits) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: limits.ReadHeaderTimeout,
+		ReadTimeout:       limits.ReadTimeout,
+		WriteTimeout:      limits.WriteTimeout,
+		IdleTimeout:       limits.IdleTimeout,
+		MaxHeaderBytes:    limits.MaxHeaderBytes,
 	}
 }
 
@@ -334,15 +469,21 @@ func buildProductURLSetXML(baseURL string, ids []string) urlSetXML {
 	}
 }
 
-func requestBaseURL(r *http.Request) string {
+// requestBaseURL builds the absolute base URL for sitemaps. trustForwarded
+// (-trust-forwarded) lets it take the scheme from X-Forwarded-Proto.
+func requestBaseURL(r *http.Request, trustForwarded bool) string {
 	scheme := "http"
-	if proto := strings.TrimSpace(r.Header.Get("X-Forwarded-Proto")); proto != "" {
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if trustForwarded {
+		proto := r.Header.Get("X-Forwarded-Proto")
 		if i := strings.Index(proto, ","); i >= 0 {
 			proto = proto[:i]
 		}
-		scheme = strings.TrimSpace(proto)
-	} else if r.TLS != nil {
-		scheme = "https"
+		if proto = strings.ToLower(strings.TrimSpace(proto)); proto == "http" || proto == "https" {
+			scheme = proto
+		}
 	}
 	host := r.Host
 	if host == "" {
@@ -425,16 +566,59 @@ func fetchProductIDsPage(db *sql.DB, table, idCol string, limit, offset int) ([]
 	return out, nil
 }
 
-func firstUserTable(db *sql.DB) (string, error) {
-	const q = `SELECT name FROM sqlite_master WHERE type='table' AND name NOT LIKE 'sqlite_%' ORDER BY name LIMIT 1`
-	var name string
-	if err := db.QueryRow(q).Scan(&name); err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return "", fmt.Errorf("no user tables found")
+// retryWithBackoff calls fn up to retries+1 times, doubling the delay after
+// each failure. It returns nil on the first success, else the last error.
+func retryWithBackoff(retries int, fn func() error) error {
+	delay := dbRetryBaseDelay
+	for attempt := 0; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= retries {
+			return err
+		}
+		log.Printf("sqlite not ready (attempt %d/%d): %v; retrying in %s", attempt+1, retries+1, err, delay)
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+func userTables(db *sql.DB) ([]string, error) {
+	const q = `SELECT name FROM sqlite_master WHERE type='table' AND name NOT LIKE 'sqlite_%' ORDER BY name`
+	rows, err := db.Query(q)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
 		}
+		names = append(names, name)
+	}
+	return names, rows.Err()
+}
+
+// resolveTable returns the requested table after checking it exists, or the
+// alphabetically-first user table when none was requested.
+func resolveTable(db *sql.DB, requested string) (string, error) {
+	tables, err := userTables(db)
+	if err != nil {
 		return "", err
 	}
-	return name, nil
+	if len(tables) == 0 {
+		return "", fmt.Errorf("no user tables found")
+	}
+	if requested != "" {
+		if !contains(tables, requested) {
+			return "", fmt.Errorf("table %q not found (available tables: %s)", requested, strings.Join(tables, ", "))
+		}
+		return requested, nil
+	}
+	if len(tables) > 1 {
+		log.Printf("warning: no -table given, using %q (available tables: %s)", tables[0], strings.Join(tables, ", "))
+	}
+	return tables[0], nil
 }
 
 func tableColumns(db *sql.DB, table string) ([]string, error) {
@@ -502,7 +686,7 @@ func fetchSimilar(db *sql.DB, table, idCol, id string) ([]map[string]any, error)
 		return []map[string]any{}, nil
 	}
 
-	baseSelect := fmt.Sprintf("SELECT gtin, name, brand, price_eur, currency, category_path, rating_value, rating_count FROM %s WHERE %s != ?", tableQ, idColQ)
+	baseSelect := fmt.Sprintf("SELECT %s, name, brand, price_eur, currency, category_path, rating_value, rating_count FROM %s WHERE %s != ?", idColQ, tableQ, idColQ)
 	var args []any
 	args = append(args, id)
 
@@ -536,15 +720,19 @@ func fetchSimilar(db *sql.DB, table, idCol, id string) ([]map[string]any, error)
 
 	var out []map[string]any
 	for rows.Next() {
-		var gtin, name, brandOut, currency, categoryOut sql.NullString
+		var idVal, name, brandOut, currency, categoryOut sql.NullString
 		var price sql.NullFloat64
 		var ratingVal sql.NullFloat64
 		var ratingCount sql.NullInt64
-		if err := rows.Scan(&gtin, &name, &brandOut, &price, &currency, &categoryOut, &ratingVal, &ratingCount); err != nil {
+		if err := rows.Scan(&idVal, &name, &brandOut, &price, &currency, &categoryOut, &ratingVal, &ratingCount); err != nil {
 			return nil, err
 		}
-		out = append(out, map[string]any{
-			"gtin":          gtin.String,
+		itemID := idVal.String
+		if strings.TrimSpace(itemID) == "" {
+			continue
+		}
+		item := map[string]any{
+			"id":            itemID,
 			"name":          name.String,
 			"brand":         brandOut.String,
 			"price_eur":     price.Float64,
@@ -552,7 +740,12 @@ func fetchSimilar(db *sql.DB, table, idCol, id string) ([]map[string]any, error)
 			"category_path": categoryOut.String,
 			"rating_value":  ratingVal.Float64,
 			"rating_count":  ratingCount.Int64,
-		})
+			"product_path":  productPath(itemID),
+		}
+		if idCol == "gtin" {
+			item["gtin"] = itemID
+		}
+		out = append(out, item)
 	}
 	if err := rows.Err(); err != nil {
 		return nil, err
@@ -588,7 +781,7 @@ type searchPayload struct {
 	Items          []map[string]any `json:"items"`
 }
 
-func fetchHomePayload(db *sql.DB, table string) (homePayload, error) {
+func fetchHomePayload(db *sql.DB, table, idCol string) (homePayload, error) {
 	sections := []homeSection{}
 
 	queries := []struct {
@@ -639,7 +832,7 @@ func fetchHomePayload(db *sql.DB, table string) (homePayload, error) {
 	}
 
 	for _, q := range queries {
-		items, err := fetchHomeSectionItems(db, table, q.where, q.order, q.limit, q.args...)
+		items, err := fetchHomeSectionItems(db, table, idCol, q.where, q.order, q.limit, q.args...)
 		if err != nil {
 			return homePayload{}, err
 		}
@@ -661,15 +854,15 @@ func fetchHomePayload(db *sql.DB, table string) (homePayload, error) {
 	}, nil
 }
 
-func fetchHomeSectionItems(db *sql.DB, table, where, order string, limit int, args ...any) ([]map[string]any, error) {
+func fetchHomeSectionItems(db *sql.DB, table, idCol, where, order string, limit int, args ...any) ([]map[string]any, error) {
 	if limit <= 0 {
 		limit = 12
 	}
 
 	tableQ := quoteIdent(table)
 	q := fmt.Sprintf(
-		`SELECT gtin, name, brand, price_eur, currency, category_path, rating_value, rating_count
-		 FROM %s`, tableQ,
+		`SELECT %s, name, brand, price_eur, currency, category_path, rating_value, rating_count
+		 FROM %s`, quoteIdent(idCol), tableQ,
 	)
 	if strings.TrimSpace(where) != "" {
 		q += " WHERE " + where
@@ -687,17 +880,20 @@ func fetchHomeSectionItems(db *sql.DB, table, where, order string, limit int, ar
 
 	var out []map[string]any
 	for rows.Next() {
-		var gtin, name, brand, currency, category sql.NullString
+		var idVal, name, brand, currency, category sql.NullString
 		var price sql.NullFloat64
 		var ratingVal sql.NullFloat64
 		var ratingCount sql.NullInt64
-		if err := rows.Scan(&gtin, &name, &brand, &price, &currency, &category, &ratingVal, &ratingCount); err != nil {
+		if err := rows.Scan(&idVal, &name, &brand, &price, &currency, &category, &ratingVal, &ratingCount); err != nil {
 			return nil, err
 		}
 
-		gtinVal := gtin.String
-		out = append(out, map[string]any{
-			"gtin":          gtinVal,
+		id := idVal.String
+		if strings.TrimSpace(id) == "" {
+			continue
+		}
+		item := map[string]any{
+			"id":            id,
 			"name":          name.String,
 			"brand":         brand.String,
 			"price_eur":     price.Float64,
@@ -705,9 +901,13 @@ func fetchHomeSectionItems(db *sql.DB, table, where, order string, limit int, ar
 			"category_path": category.String,
 			"rating_value":  ratingVal.Float64,
 			"rating_count":  ratingCount.Int64,
-			"product_path":  "/product/" + gtinVal,
-			"api_path":      "/api/product/" + gtinVal,
-		})
+			"product_path":  productPath(id),
+			"api_path":      "/api/product/" + url.PathEscape(id),
+		}
+		if idCol == "gtin" {
+			item["gtin"] = id
+		}
+		out = append(out, item)
 	}
 	if err := rows.Err(); err != nil {
 		return nil, err
@@ -726,12 +926,8 @@ func fetchSearchPayload(db *sql.DB, table string, cols []string, idCol, query st
 		return searchPayload{}, fmt.Errorf("no searchable columns available")
 	}
 
-	idSelectName := "gtin"
-	if !contains(cols, "gtin") {
-		idSelectName = idCol
-	}
-	if !contains(cols, idSelectName) {
-		return searchPayload{}, fmt.Errorf("id column %q not found for search result selection", idSelectName)
+	if !contains(cols, idCol) {
+		return searchPayload{}, fmt.Errorf("id column %q not found for search result selection", idCol)
 	}
 
 	pattern := "%" + escapeLikePattern(query) + "%"
@@ -750,7 +946,7 @@ func fetchSearchPayload(db *sql.DB, table string, cols []string, idCol, query st
 		return searchPayload{}, err
 	}
 
-	items, err := fetchSearchItems(db, table, searchFields, idSelectName, perPage, offset, whereClause, whereArgs...)
+	items, err := fetchSearchItems(db, table, searchFields, idCol, perPage, offset, whereClause, whereArgs...)
 	if err != nil {
 		return searchPayload{}, err
 	}
@@ -824,6 +1020,9 @@ func fetchSearchItems(db *sql.DB, table string, searchFields []string, idCol str
 			return nil, err
 		}
 		id := idVal.String
+		if strings.TrimSpace(id) == "" {
+			continue
+		}
 		item := map[string]any{
 			"id":            id,
 			"name":          name.String,
@@ -833,7 +1032,7 @@ func fetchSearchItems(db *sql.DB, table string, searchFields []string, idCol str
 			"category_path": category.String,
 			"rating_value":  ratingVal.Float64,
 			"rating_count":  ratingCount.Int64,
-			"product_path":  "/product/" + id,
+			"product_path":  productPath(id),
 		}
 		if idCol == "gtin" {
 			item["gtin"] = id
@@ -846,6 +1045,12 @@ func fetchSearchItems(db *sql.DB, table string, searchFields []string, idCol str
 	return out, nil
 }
 
+// productPath links a product card to /product/{id}, escaping id so ids with
+// "/" or "?" stay a single path segment.
+func productPath(id string) string {
+	return "/product/" + url.PathEscape(id)
+}
+
 func escapeLikePattern(s string) string {
 	replacer := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
 	return replacer.Replace(s)
@@ -887,15 +1092,33 @@ func maxIntValue() int64 {
 	return int64(^uint(0) >> 1)
 }
 
-func writeJSON(w http.ResponseWriter, v any) {
+// writeJSON encodes v indented, or minified with compact (-compact-json) for
+// machine consumers.
+func writeJSON(w http.ResponseWriter, v any, compact bool) {
 	w.Header().Set("Content-Type", "application/json")
 	enc := json.NewEncoder(w)
-	enc.SetIndent("", "  ")
+	if !compact {
+		enc.SetIndent("", "  ")
+	}
 	if err := enc.Encode(v); err != nil {
 		log.Printf("encode error: %v", err)
 	}
 }
 
+type jsonError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// writeJSONError writes {"error":{"code":...,"message":...}} with status.
+func writeJSONError(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(map[string]jsonError{"error": {Code: code, Message: message}}); err != nil {
+		log.Printf("encode error: %v", err)
+	}
+}
+
 func normalizeValue(v any) any {
 	switch t := v.(type) {
 	case []byte:
@@ -1477,7 +1700,7 @@ var productPageTemplate = template.Must(template.New("product").Parse(`<!doctype
             return;
           }
           gridEl.innerHTML = items.map(function (item) {
-            var gtin = item.gtin || "";
+            var href = item.product_path || ("/product/" + encodeURIComponent(item.gtin || item.id || ""));
             var name = escapeHtml(item.name || "Product");
             var brand = escapeHtml(item.brand || "Unknown brand");
             var price = escapeHtml(formatPrice(item));
@@ -1485,7 +1708,7 @@ var productPageTemplate = template.Must(template.New("product").Parse(`<!doctype
               ? ("★ " + item.rating_value.toFixed(1))
               : "";
             return (
-              '<a class="rec-card" href="/product/' + encodeURIComponent(gtin) + '">' +
+              '<a class="rec-card" href="' + escapeHtml(href) + '">' +
                 '<div class="rec-brand">' + brand + '</div>' +
                 '<div class="rec-name">' + name + '</div>' +
                 '<div class="rec-meta">' +
@@ -1914,8 +2137,7 @@ var homePageTemplate = template.Must(template.New("home").Parse(`<!doctype html>
       }
 
       function renderCard(item) {
-        var gtin = item.gtin || "";
-        var href = item.product_path || ("/product/" + encodeURIComponent(gtin));
+        var href = item.product_path || ("/product/" + encodeURIComponent(item.gtin || item.id || ""));
         var brand = escapeHtml(item.brand || "Unknown brand");
         var name = escapeHtml(item.name || "Product");
         var category = escapeHtml(item.category_path || "");
@@ -2293,8 +2515,9 @@ var searchPageTemplate = template.Must(template.New("search").Parse(`<!doctype h
       })
         .then(function (res) {
           if (!res.ok) {
-            return res.text().then(function (t) {
-              throw new Error(t || ("HTTP " + res.status));
+            return res.json().catch(function () { return null; }).then(function (body) {
+              var msg = body && body.error && body.error.message;
+              throw new Error(msg || ("HTTP " + res.status));
             });
           }
           return res.json();
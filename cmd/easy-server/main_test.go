@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	_ "modernc.org/sqlite"
+)
+
+func TestAPIErrorsUseJSONEnvelope(t *testing.T) {
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "products.sqlite"))
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	defer db.Close()
+	if _, err := db.Exec(`CREATE TABLE products (gtin TEXT, name TEXT, brand TEXT, price_eur REAL, category_path TEXT, rating_value REAL, rating_count INTEGER);
+		INSERT INTO products VALUES ('4000000000001', 'Soap', 'Balea', 0.95, 'Pflege', 4.5, 10);`); err != nil {
+		t.Fatalf("seed db: %v", err)
+	}
+	cols, err := tableColumns(db, "products")
+	if err != nil {
+		t.Fatalf("tableColumns: %v", err)
+	}
+	h := newServerMux(db, serverConfig{Table: "products", Cols: cols, IDCol: "gtin", SitemapChunkSize: defaultSitemapChunkSize})
+
+	for _, c := range []struct {
+		method, path string
+		status       int
+		code         string
+	}{
+		{http.MethodGet, "/api/product/does-not-exist", http.StatusNotFound, "not_found"},
+		{http.MethodGet, "/api/search?q=ab", http.StatusBadRequest, "query_too_short"},
+		{http.MethodPost, "/api/home", http.StatusMethodNotAllowed, "method_not_allowed"},
+	} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(c.method, c.path, nil))
+		if rec.Code != c.status || rec.Header().Get("Content-Type") != "application/json" {
+			t.Fatalf("%s %s: expected JSON %d, got %d %q", c.method, c.path, c.status, rec.Code, rec.Header().Get("Content-Type"))
+		}
+		var body struct {
+			Error jsonError `json:"error"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+			t.Fatalf("%s %s: expected a JSON error body, got %q: %v", c.method, c.path, rec.Body.String(), err)
+		}
+		if body.Error.Code != c.code || body.Error.Message == "" {
+			t.Fatalf("%s %s: unexpected error %+v", c.method, c.path, body.Error)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/product/", nil))
+	if rec.Code != http.StatusBadRequest || rec.Header().Get("Content-Type") == "application/json" {
+		t.Fatalf("expected the HTML route to keep its plain-text error, got %d %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+}
+
+func TestRequestID_EchoedAndLoggedOnError(t *testing.T) {
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "products.sqlite"))
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	defer db.Close()
+	if _, err := db.Exec(`CREATE TABLE products (gtin TEXT, name TEXT, brand TEXT, price_eur REAL);`); err != nil {
+		t.Fatalf("seed db: %v", err)
+	}
+	cols, err := tableColumns(db, "products")
+	if err != nil {
+		t.Fatalf("tableColumns: %v", err)
+	}
+	h := withRequestID(newServerMux(db, serverConfig{Table: "products", Cols: cols, IDCol: "gtin", SitemapChunkSize: defaultSitemapChunkSize}))
+	if _, err := db.Exec(`DROP TABLE products`); err != nil {
+		t.Fatalf("drop table: %v", err)
+	}
+
+	var errs bytes.Buffer
+	log.SetOutput(&errs)
+	defer log.SetOutput(os.Stderr)
+	req := httptest.NewRequest(http.MethodGet, "/api/home", nil)
+	req.Header.Set("X-Request-ID", "client-abc-123")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("expected 500 after dropping the table, got %d", rec.Code)
+	}
+	if got := rec.Header().Get("X-Request-ID"); got != "client-abc-123" {
+		t.Fatalf("expected the client request ID to be echoed, got %q", got)
+	}
+	if line := errs.String(); !strings.Contains(line, "request_id=client-abc-123 home payload error:") {
+		t.Fatalf("expected the error log line to carry the request ID, got %q", line)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/home", nil))
+	if id := rec.Header().Get("X-Request-ID"); len(id) != 16 {
+		t.Fatalf("expected a generated 16-char request ID, got %q", id)
+	}
+}
+
+func TestHomePayloadUsesConfiguredIDColumn(t *testing.T) {
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "products.sqlite"))
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	defer db.Close()
+	if _, err := db.Exec(`CREATE TABLE products (dan INTEGER, name TEXT, brand TEXT, price_eur REAL, currency TEXT, category_path TEXT, rating_value REAL, rating_count INTEGER, product_is_pharmacy INTEGER, has_eyecatchers INTEGER, has_pills INTEGER);
+		INSERT INTO products VALUES (101, 'Soap', 'Balea', 0.95, 'EUR', 'pflege', 4.5, 30, 0, 0, 0), (102, 'Soap bar', 'Balea', 1.49, 'EUR', 'pflege', 4.7, 20, 0, 0, 0);`); err != nil {
+		t.Fatalf("seed db: %v", err)
+	}
+	cols, err := tableColumns(db, "products")
+	if err != nil {
+		t.Fatalf("tableColumns: %v", err)
+	}
+	h := newServerMux(db, serverConfig{Table: "products", Cols: cols, IDCol: "dan", SitemapChunkSize: defaultSitemapChunkSize})
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/home", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200 from /api/home, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var home homePayload
+	if err := json.Unmarshal(rec.Body.Bytes(), &home); err != nil {
+		t.Fatalf("decode home payload: %v", err)
+	}
+	seen := 0
+	for _, s := range home.Sections {
+		for _, item := range s.Items {
+			id, _ := item["id"].(string)
+			if id != "101" && id != "102" {
+				t.Fatalf("home item in %q has id %q, want a dan value", s.ID, id)
+			}
+			if got := item["product_path"]; got != "/product/"+id {
+				t.Fatalf("home item %s has product_path %v", id, got)
+			}
+			if got := item["api_path"]; got != "/api/product/"+id {
+				t.Fatalf("home item %s has api_path %v", id, got)
+			}
+			seen++
+		}
+	}
+	if seen == 0 {
+		t.Fatalf("expected home items")
+	}
+
+	similar, err := fetchSimilar(db, "products", "dan", "101")
+	if err != nil {
+		t.Fatalf("similar: %v", err)
+	}
+	if len(similar) != 1 || similar[0]["product_path"] != "/product/102" {
+		t.Fatalf("expected one similar product linking to /product/102, got %v", similar)
+	}
+}
@@ -1,16 +1,24 @@
 package main
 
 import (
+	"context"
+	"crypto/rand"
 	"database/sql"
+	"embed"
+	"encoding/hex"
 	"encoding/json"
 	"encoding/xml"
 	"errors"
 	"flag"
 	"fmt"
 	"html/template"
+	"io"
 	"log"
+	"math"
 	"net/http"
+	"net/url"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
@@ -21,8 +29,20 @@ import (
 const defaultAddr = "127.0.0.1:18744"
 const sitemapProtocolMaxURLs = 50000
 const defaultSitemapChunkSize = 10000
+const defaultDBOpenRetries = 3
+
+var dbRetryBaseDelay = 100 * time.Millisecond
+
 const searchMinChars = 3
+const defaultSimilarLimit = 8
+
+const (
+	similarModeCategory      = "category"
+	similarModeBrandCategory = "brand-category"
+)
 const searchPageSize = 10
+const defaultMaxSearchPage = 1000
+const defaultBudgetMaxPrice = 5.0
 
 func main() {
 	flag.Usage = func() {
@@ -34,6 +54,18 @@ func main() {
 	idCol := flag.String("id", "", "Name of the unique ID column used for lookup")
 	addr := flag.String("addr", defaultAddr, "HTTP listen address")
 	sitemapChunkSize := flag.Int("sitemap-chunk-size", defaultSitemapChunkSize, "Max product URLs per sitemap file (capped at 50000)")
+	tableName := flag.String("table", "", "Table to serve (default: first user table, alphabetically)")
+	httpLimits := registerHTTPServerFlags(flag.CommandLine)
+	compactJSON := flag.Bool("compact-json", false, "Write minified JSON responses instead of indented ones")
+	trustForwarded := flag.Bool("trust-forwarded", false, "Honor X-Forwarded-Proto (only enable behind a trusted proxy)")
+	hideUnavailable := flag.Bool("hide-unavailable", false, "Exclude products with available = 0 from home and search results")
+	dbOpenRetries := flag.Int("db-open-retries", defaultDBOpenRetries, "Retries with exponential backoff when the sqlite database is busy or not ready at startup")
+	similarLimit := flag.Int("similar-limit", defaultSimilarLimit, "Max similar products shown on product pages")
+	budgetMaxPrice := flag.Float64("budget-max-price", defaultBudgetMaxPrice, "Highest price_eur listed in the home page Budget Finds section")
+	maxSearchPage := flag.Int("max-search-page", defaultMaxSearchPage, "Deepest search page served; later pages ask to narrow the query (0 disables the cap)")
+	similarMode := flag.String("similar-mode", similarModeCategory, "Similar-product ranking: category (same category first) or brand-category (brand+category, then brand, then category)")
+	logFormat := flag.String("log-format", "text", "Request log format: text or json")
+	templatesDir := flag.String("templates-dir", "", "Directory with home.html, product.html and/or search.html overriding the built-in page templates")
 	flag.Parse()
 
 	if *dbPath == "" {
@@ -42,6 +74,26 @@ func main() {
 	if *idCol == "" {
 		log.Fatal("missing -id column name")
 	}
+	if *logFormat != "text" && *logFormat != "json" {
+		log.Fatalf("invalid -log-format %q (want text or json)", *logFormat)
+	}
+	if *budgetMaxPrice <= 0 {
+		log.Fatalf("invalid -budget-max-price %v (want > 0)", *budgetMaxPrice)
+	}
+	if *templatesDir != "" {
+		names, err := loadTemplateOverrides(*templatesDir)
+		if err != nil {
+			log.Fatalf("templates: %v", err)
+		}
+		if len(names) == 0 {
+			log.Printf("templates: no home.html, product.html or search.html in %s; using the built-in templates", *templatesDir)
+		} else {
+			log.Printf("templates: overriding %s from %s", strings.Join(names, ", "), *templatesDir)
+		}
+	}
+	if *similarMode != similarModeCategory && *similarMode != similarModeBrandCategory {
+		log.Fatalf("invalid -similar-mode %q (want %s or %s)", *similarMode, similarModeCategory, similarModeBrandCategory)
+	}
 	if *sitemapChunkSize <= 0 {
 		*sitemapChunkSize = defaultSitemapChunkSize
 	}
@@ -59,7 +111,15 @@ func main() {
 	}
 	defer db.Close()
 
-	table, err := firstUserTable(db)
+	var table string
+	err = retryWithBackoff(*dbOpenRetries, func() error {
+		if err := db.Ping(); err != nil {
+			return err
+		}
+		var err error
+		table, err = resolveTable(db, *tableName)
+		return err
+	})
 	if err != nil {
 		log.Fatalf("find table: %v", err)
 	}
@@ -71,25 +131,71 @@ func main() {
 	if !contains(cols, *idCol) {
 		log.Fatalf("id column %q not found in table %q", *idCol, table)
 	}
+	if *hideUnavailable && !contains(cols, "available") {
+		log.Printf("-hide-unavailable: table %q has no available column; showing all products", table)
+		*hideUnavailable = false
+	}
+
+	mux := newServerMux(db, serverConfig{
+		Table:            table,
+		Cols:             cols,
+		IDCol:            *idCol,
+		SitemapChunkSize: *sitemapChunkSize,
+		MaxSearchPage:    *maxSearchPage,
+		BudgetMaxPrice:   *budgetMaxPrice,
+		SimilarLimit:     *similarLimit,
+		SimilarMode:      *similarMode,
+		HideUnavailable:  *hideUnavailable,
+		CompactJSON:      *compactJSON,
+		TrustForwarded:   *trustForwarded,
+	})
 
+	log.Printf("medium-server-1 listening on %s (table=%s id=%s)", *addr, table, *idCol)
+	srv := newHTTPServer(*addr, withRequestLogging(withRequestID(mux), log.Default(), *logFormat), *httpLimits)
+	if err := srv.ListenAndServe(); err != nil {
+		log.Fatalf("server error: %v", err)
+	}
+}
+
+type serverConfig struct {
+	Table            string
+	Cols             []string
+	IDCol            string
+	SitemapChunkSize int
+	MaxSearchPage    int
+	BudgetMaxPrice   float64
+	SimilarLimit     int
+	SimilarMode      string
+	HideUnavailable  bool
+	CompactJSON      bool
+	TrustForwarded   bool
+}
+
+func newServerMux(db *sql.DB, cfg serverConfig) *http.ServeMux {
+	table, cols, idCol := cfg.Table, cfg.Cols, cfg.IDCol
 	mux := http.NewServeMux()
 	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 		_, _ = w.Write([]byte("ok"))
 	})
+	mux.HandleFunc("/favicon.ico", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", staticCacheControl)
+		http.ServeFileFS(w, r, staticFiles, "static/favicon.ico")
+	})
+	mux.Handle("/static/", withStaticCaching(http.FileServerFS(staticFiles)))
 	mux.HandleFunc("/sitemap.xml", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodGet {
 			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 			return
 		}
-		total, err := countNonEmptyIDs(db, table, *idCol)
+		total, err := countNonEmptyIDs(db, table, idCol)
 		if err != nil {
 			http.Error(w, "internal error", http.StatusInternalServerError)
-			log.Printf("sitemap count error: %v", err)
+			logRequestf(r, "sitemap count error: %v", err)
 			return
 		}
-		baseURL := requestBaseURL(r)
-		payload := buildSitemapIndexXML(baseURL, total, *sitemapChunkSize)
+		baseURL := requestBaseURL(r, cfg.TrustForwarded)
+		payload := buildSitemapIndexXML(baseURL, total, cfg.SitemapChunkSize)
 		writeXML(w, payload)
 	})
 	mux.HandleFunc("/sitemaps/", func(w http.ResponseWriter, r *http.Request) {
@@ -102,29 +208,29 @@ func main() {
 			http.NotFound(w, r)
 			return
 		}
-		total, err := countNonEmptyIDs(db, table, *idCol)
+		total, err := countNonEmptyIDs(db, table, idCol)
 		if err != nil {
 			http.Error(w, "internal error", http.StatusInternalServerError)
-			log.Printf("sitemap count error: %v", err)
+			logRequestf(r, "sitemap count error: %v", err)
 			return
 		}
 		if total == 0 {
 			http.NotFound(w, r)
 			return
 		}
-		pageCount := (total + *sitemapChunkSize - 1) / *sitemapChunkSize
+		pageCount := (total + cfg.SitemapChunkSize - 1) / cfg.SitemapChunkSize
 		if pageNum < 1 || pageNum > pageCount {
 			http.NotFound(w, r)
 			return
 		}
-		offset := (pageNum - 1) * *sitemapChunkSize
-		ids, err := fetchProductIDsPage(db, table, *idCol, *sitemapChunkSize, offset)
+		offset := (pageNum - 1) * cfg.SitemapChunkSize
+		ids, err := fetchProductIDsPage(db, table, idCol, cfg.SitemapChunkSize, offset)
 		if err != nil {
 			http.Error(w, "internal error", http.StatusInternalServerError)
-			log.Printf("sitemap page error: %v", err)
+			logRequestf(r, "sitemap page error: %v", err)
 			return
 		}
-		baseURL := requestBaseURL(r)
+		baseURL := requestBaseURL(r, cfg.TrustForwarded)
 		payload := buildProductURLSetXML(baseURL, ids)
 		writeXML(w, payload)
 	})
@@ -143,16 +249,19 @@ func main() {
 				searchError = fmt.Sprintf("query must be at least %d characters", searchMinChars)
 			} else if page, ok = parsePageQueryParam(r, "page", 1); !ok {
 				searchError = "invalid page"
+			} else if msg := searchPageTooDeep(page, cfg.MaxSearchPage); msg != "" {
+				searchError = msg
 			} else {
 				offset, ok := pageOffset(page, searchPageSize)
 				if !ok {
 					searchError = "page value is too large"
 				} else {
-					payload, err := fetchSearchPayload(db, table, cols, *idCol, q, page, searchPageSize, offset)
+					payload, err := fetchSearchPayload(db, table, cols, idCol, q, page, searchPageSize, offset, cfg.HideUnavailable)
 					if err != nil {
 						searchError = "Could not load search results right now."
-						log.Printf("search error: %v", err)
+						logRequestf(r, "search error: %v", err)
 					} else {
+						payload.MaxSearchPage = cfg.MaxSearchPage
 						searchData = payload
 					}
 				}
@@ -164,7 +273,7 @@ func main() {
 			"search_data_json": mustJSONTemplateJS(searchData),
 			"search_error":     searchError,
 		}); err != nil {
-			log.Printf("template error: %v", err)
+			logRequestf(r, "template error: %v", err)
 		}
 	})
 	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
@@ -172,10 +281,10 @@ func main() {
 			http.NotFound(w, r)
 			return
 		}
-		payload, err := fetchHomePayload(db, table)
+		payload, err := fetchHomePayload(db, table, idCol, cfg.BudgetMaxPrice, cfg.HideUnavailable)
 		if err != nil {
 			http.Error(w, "internal error", http.StatusInternalServerError)
-			log.Printf("home payload error: %v", err)
+			logRequestf(r, "home payload error: %v", err)
 			return
 		}
 		w.Header().Set("Content-Type", "text/html; charset=utf-8")
@@ -183,7 +292,7 @@ func main() {
 			"title":          "dimi",
 			"home_data_json": mustJSONTemplateJS(payload),
 		}); err != nil {
-			log.Printf("template error: %v", err)
+			logRequestf(r, "template error: %v", err)
 		}
 	})
 	mux.HandleFunc("/product/", func(w http.ResponseWriter, r *http.Request) {
@@ -194,38 +303,70 @@ func main() {
 		}
 		id = strings.TrimSuffix(id, "/")
 
-		row, err := fetchByID(db, table, cols, *idCol, id)
+		row, err := fetchByID(db, table, cols, idCol, id)
 		if errors.Is(err, sql.ErrNoRows) {
 			http.Error(w, "not found", http.StatusNotFound)
 			return
 		}
 		if err != nil {
 			http.Error(w, "internal error", http.StatusInternalServerError)
-			log.Printf("fetch error: %v", err)
+			logRequestf(r, "fetch error: %v", err)
 			return
 		}
-		similar, err := fetchSimilar(db, table, *idCol, id)
+		similar, err := fetchSimilar(db, table, cols, idCol, id, cfg.SimilarLimit, cfg.SimilarMode)
 		if errors.Is(err, sql.ErrNoRows) {
 			similar = []map[string]any{}
 		} else if err != nil {
 			http.Error(w, "internal error", http.StatusInternalServerError)
-			log.Printf("similar error: %v", err)
+			logRequestf(r, "similar error: %v", err)
 			return
 		}
 
+		row["price_display"] = rowPriceDisplay(row)
+
 		w.Header().Set("Content-Type", "text/html; charset=utf-8")
 		if err := productPageTemplate.Execute(w, map[string]any{
 			"id":                id,
 			"product_data_json": mustJSONTemplateJS(row),
 			"similar_data_json": mustJSONTemplateJS(similar),
+			"canonical_url":     canonicalProductURL(row),
+			"breadcrumbs":       productBreadcrumbs(row),
 		}); err != nil {
-			log.Printf("template error: %v", err)
+			logRequestf(r, "template error: %v", err)
 		}
 	})
+	return mux
+}
 
-	log.Printf("medium-server-1 listening on %s (table=%s id=%s)", *addr, table, *idCol)
-	if err := http.ListenAndServe(*addr, mux); err != nil {
-		log.Fatalf("server error: %v", err)
+// httpServerLimits bounds slow or oversized clients (slowloris) via the
+// http.Server timeouts and header size cap.
+type httpServerLimits struct {
+	ReadHeaderTimeout time.Duration
+	ReadTimeout       time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	MaxHeaderBytes    int
+}
+
+func registerHTTPServerFlags(fs *flag.FlagSet) *httpServerLimits {
+	limits := &httpServerLimits{}
+	fs.DurationVar(&limits.ReadHeaderTimeout, "read-header-timeout", 5*time.Second, "Max time to read request headers")
+	fs.DurationVar(&limits.ReadTimeout, "read-timeout", 15*time.Second, "Max time to read the full request")
+	fs.DurationVar(&limits.WriteTimeout, "write-timeout", 30*time.Second, "Max time to write the response")
+	fs.DurationVar(&limits.IdleTimeout, "idle-timeout", 60*time.Second, "Max keep-alive idle time between requests")
+	fs.IntVar(&limits.MaxHeaderBytes, "max-header-bytes", 1<<20, "Max request header size in bytes")
+	return limits
+}
+
+func newHTTPServer(addr string, handler http.Handler, limits httpServerLimits) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: limits.ReadHeaderTimeout,
+		ReadTimeout:       limits.ReadTimeout,
+		WriteTimeout:      limits.WriteTimeout,
+		IdleTimeout:       limits.IdleTimeout,
+		MaxHeaderBytes:    limits.MaxHeaderBytes,
 	}
 }
 
@@ -238,6 +379,139 @@ func mustJSONTemplateJS(v any) template.JS {
 	return template.JS(b)
 }
 
+// loggingResponseWriter records the status code and body size written by a
+// handler so they can be logged once the request completes.
+type loggingResponseWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (lw *loggingResponseWriter) WriteHeader(code int) {
+	if lw.status == 0 {
+		lw.status = code
+	}
+	lw.ResponseWriter.WriteHeader(code)
+}
+
+func (lw *loggingResponseWriter) Write(b []byte) (int, error) {
+	if lw.status == 0 {
+		lw.status = http.StatusOK
+	}
+	n, err := lw.ResponseWriter.Write(b)
+	lw.bytes += n
+	return n, err
+}
+
+type requestLogEntry struct {
+	Method     string  `json:"method"`
+	Path       string  `json:"path"`
+	Status     int     `json:"status"`
+	Bytes      int     `json:"bytes"`
+	DurationMS float64 `json:"duration_ms"`
+	RequestID  string  `json:"request_id,omitempty"`
+}
+
+// staticFiles holds the favicon and any other assets served under /static/.
+//
+//go:embed static
+var staticFiles embed.FS
+
+const staticCacheControl = "public, max-age=86400"
+
+func withStaticCaching(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", staticCacheControl)
+		next.ServeHTTP(w, r)
+	})
+}
+
+func withRequestLogging(next http.Handler, logger *log.Logger, format string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		lw := &loggingResponseWriter{ResponseWriter: w}
+		next.ServeHTTP(lw, r)
+		if lw.status == 0 {
+			lw.status = http.StatusOK
+		}
+		entry := requestLogEntry{
+			Method:     r.Method,
+			Path:       r.URL.Path,
+			Status:     lw.status,
+			Bytes:      lw.bytes,
+			DurationMS: float64(time.Since(start).Microseconds()) / 1000,
+			RequestID:  lw.Header().Get(requestIDHeader),
+		}
+		if format == "json" {
+			b, _ := json.Marshal(entry)
+			logger.Print(string(b))
+			return
+		}
+		if entry.RequestID != "" {
+			logger.Printf("method=%s path=%q status=%d bytes=%d duration_ms=%.3f request_id=%s", entry.Method, entry.Path, entry.Status, entry.Bytes, entry.DurationMS, entry.RequestID)
+			return
+		}
+		logger.Printf("method=%s path=%q status=%d bytes=%d duration_ms=%.3f", entry.Method, entry.Path, entry.Status, entry.Bytes, entry.DurationMS)
+	})
+}
+
+const (
+	requestIDHeader    = "X-Request-ID"
+	maxRequestIDLength = 128
+)
+
+type requestIDKey struct{}
+
+// withRequestID reuses a client-supplied X-Request-ID (when short and
+// printable) or generates one, stores it in the request context and echoes it
+// in the response header.
+func withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := strings.TrimSpace(r.Header.Get(requestIDHeader))
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(requestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+	})
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 16)
+	}
+	return hex.EncodeToString(b[:])
+}
+
+// requestIDFrom returns the ID stored by withRequestID, or "".
+func requestIDFrom(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// logRequestf is log.Printf with the request's ID prepended, so handler error
+// lines can be matched to the client request and its access log line.
+func logRequestf(r *http.Request, format string, args ...any) {
+	if id := requestIDFrom(r.Context()); id != "" {
+		log.Printf("request_id=%s "+format, append([]any{id}, args...)...)
+		return
+	}
+	log.Printf(format, args...)
+}
+
 type sitemapIndexXML struct {
 	XMLName xml.Name        `xml:"sitemapindex"`
 	Xmlns   string          `xml:"xmlns,attr"`
@@ -297,7 +571,14 @@ func buildSitemapIndexXML(baseURL string, total, chunkSize int) sitemapIndexXML
 	}
 }
 
+// buildProductURLSetXML renders one sitemap file. IDs beyond the protocol's
+// 50000-URL limit are dropped with a log line; the handlers never pass more
+// than -sitemap-chunk-size (itself capped at that limit).
 func buildProductURLSetXML(baseURL string, ids []string) urlSetXML {
+	if len(ids) > sitemapProtocolMaxURLs {
+		log.Printf("sitemap: %d products exceed the %d URLs allowed per file; dropping %d", len(ids), sitemapProtocolMaxURLs, len(ids)-sitemapProtocolMaxURLs)
+		ids = ids[:sitemapProtocolMaxURLs]
+	}
 	items := make([]urlItemXML, 0, len(ids))
 	for _, id := range ids {
 		items = append(items, urlItemXML{
@@ -310,15 +591,21 @@ func buildProductURLSetXML(baseURL string, ids []string) urlSetXML {
 	}
 }
 
-func requestBaseURL(r *http.Request) string {
+// requestBaseURL builds the absolute base URL for sitemaps. trustForwarded
+// (-trust-forwarded) lets it take the scheme from X-Forwarded-Proto.
+func requestBaseURL(r *http.Request, trustForwarded bool) string {
 	scheme := "http"
-	if proto := strings.TrimSpace(r.Header.Get("X-Forwarded-Proto")); proto != "" {
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if trustForwarded {
+		proto := r.Header.Get("X-Forwarded-Proto")
 		if i := strings.Index(proto, ","); i >= 0 {
 			proto = proto[:i]
 		}
-		scheme = strings.TrimSpace(proto)
-	} else if r.TLS != nil {
-		scheme = "https"
+		if proto = strings.ToLower(strings.TrimSpace(proto)); proto == "http" || proto == "https" {
+			scheme = proto
+		}
 	}
 	host := r.Host
 	if host == "" {
@@ -366,6 +653,9 @@ func fetchProductIDsPage(db *sql.DB, table, idCol string, limit, offset int) ([]
 	if limit <= 0 {
 		limit = defaultSitemapChunkSize
 	}
+	if limit > sitemapProtocolMaxURLs {
+		limit = sitemapProtocolMaxURLs
+	}
 	q := fmt.Sprintf(
 		`SELECT %s FROM %s
 		 WHERE %s IS NOT NULL AND TRIM(CAST(%s AS TEXT)) != ''
@@ -401,16 +691,59 @@ func fetchProductIDsPage(db *sql.DB, table, idCol string, limit, offset int) ([]
 	return out, nil
 }
 
-func firstUserTable(db *sql.DB) (string, error) {
-	const q = `SELECT name FROM sqlite_master WHERE type='table' AND name NOT LIKE 'sqlite_%' ORDER BY name LIMIT 1`
-	var name string
-	if err := db.QueryRow(q).Scan(&name); err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return "", fmt.Errorf("no user tables found")
+// retryWithBackoff calls fn up to retries+1 times, doubling the delay after
+// each failure. It returns nil on the first success, else the last error.
+func retryWithBackoff(retries int, fn func() error) error {
+	delay := dbRetryBaseDelay
+	for attempt := 0; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= retries {
+			return err
 		}
+		log.Printf("sqlite not ready (attempt %d/%d): %v; retrying in %s", attempt+1, retries+1, err, delay)
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+func userTables(db *sql.DB) ([]string, error) {
+	const q = `SELECT name FROM sqlite_master WHERE type='table' AND name NOT LIKE 'sqlite_%' ORDER BY name`
+	rows, err := db.Query(q)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, rows.Err()
+}
+
+// resolveTable returns the requested table after checking it exists, or the
+// alphabetically-first user table when none was requested.
+func resolveTable(db *sql.DB, requested string) (string, error) {
+	tables, err := userTables(db)
+	if err != nil {
 		return "", err
 	}
-	return name, nil
+	if len(tables) == 0 {
+		return "", fmt.Errorf("no user tables found")
+	}
+	if requested != "" {
+		if !contains(tables, requested) {
+			return "", fmt.Errorf("table %q not found (available tables: %s)", requested, strings.Join(tables, ", "))
+		}
+		return requested, nil
+	}
+	if len(tables) > 1 {
+		log.Printf("warning: no -table given, using %q (available tables: %s)", tables[0], strings.Join(tables, ", "))
+	}
+	return tables[0], nil
 }
 
 func tableColumns(db *sql.DB, table string) ([]string, error) {
@@ -462,7 +795,17 @@ func fetchByID(db *sql.DB, table string, cols []string, idCol, id string) (map[s
 	return out, nil
 }
 
-func fetchSimilar(db *sql.DB, table, idCol, id string) ([]map[string]any, error) {
+// fetchSimilar returns up to limit products sharing the brand or category of
+// id. In similarModeCategory same-category rows rank first; in
+// similarModeBrandCategory same brand+category ranks above brand-only, which
+// ranks above category-only.
+func fetchSimilar(db *sql.DB, table string, cols []string, idCol, id string, limit int, mode string) ([]map[string]any, error) {
+	if !contains(cols, "brand") || !contains(cols, "category_path") {
+		return []map[string]any{}, nil
+	}
+	if limit <= 0 {
+		limit = defaultSimilarLimit
+	}
 	idColQ := quoteIdent(idCol)
 	tableQ := quoteIdent(table)
 
@@ -478,7 +821,7 @@ func fetchSimilar(db *sql.DB, table, idCol, id string) ([]map[string]any, error)
 		return []map[string]any{}, nil
 	}
 
-	baseSelect := fmt.Sprintf("SELECT gtin, name, brand, price_eur, currency, category_path, rating_value, rating_count FROM %s WHERE %s != ?", tableQ, idColQ)
+	baseSelect := fmt.Sprintf("SELECT %s, name, brand, price_eur, currency, category_path, rating_value, rating_count FROM %s WHERE %s != ?", idColQ, tableQ, idColQ)
 	var args []any
 	args = append(args, id)
 
@@ -494,14 +837,16 @@ func fetchSimilar(db *sql.DB, table, idCol, id string) ([]map[string]any, error)
 		args = append(args, brandVal)
 	}
 
-	order := " ORDER BY CASE WHEN category_path = ? THEN 0 ELSE 1 END, rating_value DESC, rating_count DESC LIMIT 8"
-	if catVal == "" {
-		order = " ORDER BY rating_value DESC, rating_count DESC LIMIT 8"
-	}
-
-	if catVal != "" {
+	order := " ORDER BY rating_value DESC, rating_count DESC LIMIT ?"
+	switch {
+	case mode == similarModeBrandCategory && brandVal != "" && catVal != "":
+		order = " ORDER BY CASE WHEN brand = ? AND category_path = ? THEN 0 WHEN brand = ? THEN 1 ELSE 2 END, rating_value DESC, rating_count DESC LIMIT ?"
+		args = append(args, brandVal, catVal, brandVal)
+	case catVal != "":
+		order = " ORDER BY CASE WHEN category_path = ? THEN 0 ELSE 1 END, rating_value DESC, rating_count DESC LIMIT ?"
 		args = append(args, catVal)
 	}
+	args = append(args, limit)
 
 	q := baseSelect + where + order
 	rows, err := db.Query(q, args...)
@@ -512,23 +857,33 @@ func fetchSimilar(db *sql.DB, table, idCol, id string) ([]map[string]any, error)
 
 	var out []map[string]any
 	for rows.Next() {
-		var gtin, name, brandOut, currency, categoryOut sql.NullString
+		var idVal, name, brandOut, currency, categoryOut sql.NullString
 		var price sql.NullFloat64
 		var ratingVal sql.NullFloat64
 		var ratingCount sql.NullInt64
-		if err := rows.Scan(&gtin, &name, &brandOut, &price, &currency, &categoryOut, &ratingVal, &ratingCount); err != nil {
+		if err := rows.Scan(&idVal, &name, &brandOut, &price, &currency, &categoryOut, &ratingVal, &ratingCount); err != nil {
 			return nil, err
 		}
-		out = append(out, map[string]any{
-			"gtin":          gtin.String,
+		itemID := idVal.String
+		if strings.TrimSpace(itemID) == "" {
+			continue
+		}
+		item := map[string]any{
+			"id":            itemID,
 			"name":          name.String,
 			"brand":         brandOut.String,
 			"price_eur":     price.Float64,
+			"price_display": nullPriceDisplay(price, currency.String),
 			"currency":      currency.String,
 			"category_path": categoryOut.String,
 			"rating_value":  ratingVal.Float64,
 			"rating_count":  ratingCount.Int64,
-		})
+			"product_path":  productPath(itemID),
+		}
+		if idCol == "gtin" {
+			item["gtin"] = itemID
+		}
+		out = append(out, item)
 	}
 	if err := rows.Err(); err != nil {
 		return nil, err
@@ -560,11 +915,17 @@ type searchPayload struct {
 	Total          int              `json:"total"`
 	TotalPages     int              `json:"total_pages"`
 	Returned       int              `json:"returned"`
+	MaxSearchPage  int              `json:"max_search_page,omitempty"`
 	SearchFields   []string         `json:"search_fields"`
 	Items          []map[string]any `json:"items"`
 }
 
-func fetchHomePayload(db *sql.DB, table string) (homePayload, error) {
+// fetchHomePayload builds the home sections; budgetMaxPrice caps Budget Finds
+// (<= 0 means defaultBudgetMaxPrice).
+func fetchHomePayload(db *sql.DB, table, idCol string, budgetMaxPrice float64, hideUnavailable bool) (homePayload, error) {
+	if budgetMaxPrice <= 0 {
+		budgetMaxPrice = defaultBudgetMaxPrice
+	}
 	sections := []homeSection{}
 
 	queries := []struct {
@@ -592,8 +953,9 @@ func fetchHomePayload(db *sql.DB, table string) (homePayload, error) {
 			id:    "budget-finds",
 			title: "Budget Finds",
 			desc:  "Low-price items with good customer feedback.",
-			where: "price_eur IS NOT NULL AND price_eur <= 5 AND rating_count >= 5",
+			where: "price_eur IS NOT NULL AND price_eur <= ? AND rating_count >= 5",
 			order: "rating_value DESC, rating_count DESC, price_eur ASC",
+			args:  []any{budgetMaxPrice},
 			limit: 12,
 		},
 		{
@@ -615,7 +977,7 @@ func fetchHomePayload(db *sql.DB, table string) (homePayload, error) {
 	}
 
 	for _, q := range queries {
-		items, err := fetchHomeSectionItems(db, table, q.where, q.order, q.limit, q.args...)
+		items, err := fetchHomeSectionItems(db, table, idCol, hideUnavailable, q.where, q.order, q.limit, q.args...)
 		if err != nil {
 			return homePayload{}, err
 		}
@@ -637,16 +999,23 @@ func fetchHomePayload(db *sql.DB, table string) (homePayload, error) {
 	}, nil
 }
 
-func fetchHomeSectionItems(db *sql.DB, table, where, order string, limit int, args ...any) ([]map[string]any, error) {
+func fetchHomeSectionItems(db *sql.DB, table, idCol string, hideUnavailable bool, where, order string, limit int, args ...any) ([]map[string]any, error) {
 	if limit <= 0 {
 		limit = 12
 	}
 
 	tableQ := quoteIdent(table)
 	q := fmt.Sprintf(
-		`SELECT gtin, name, brand, price_eur, currency, category_path, rating_value, rating_count
-		 FROM %s`, tableQ,
+		`SELECT %s, name, brand, price_eur, currency, category_path, rating_value, rating_count
+		 FROM %s`, quoteIdent(idCol), tableQ,
 	)
+	if cond := availabilityCondition("", hideUnavailable); cond != "" {
+		if strings.TrimSpace(where) != "" {
+			where = "(" + where + ") AND " + cond
+		} else {
+			where = cond
+		}
+	}
 	if strings.TrimSpace(where) != "" {
 		q += " WHERE " + where
 	}
@@ -663,26 +1032,34 @@ func fetchHomeSectionItems(db *sql.DB, table, where, order string, limit int, ar
 
 	var out []map[string]any
 	for rows.Next() {
-		var gtin, name, brand, currency, category sql.NullString
+		var idVal, name, brand, currency, category sql.NullString
 		var price sql.NullFloat64
 		var ratingVal sql.NullFloat64
 		var ratingCount sql.NullInt64
-		if err := rows.Scan(&gtin, &name, &brand, &price, &currency, &category, &ratingVal, &ratingCount); err != nil {
+		if err := rows.Scan(&idVal, &name, &brand, &price, &currency, &category, &ratingVal, &ratingCount); err != nil {
 			return nil, err
 		}
 
-		gtinVal := gtin.String
-		out = append(out, map[string]any{
-			"gtin":          gtinVal,
+		id := idVal.String
+		if strings.TrimSpace(id) == "" {
+			continue
+		}
+		item := map[string]any{
+			"id":            id,
 			"name":          name.String,
 			"brand":         brand.String,
 			"price_eur":     price.Float64,
+			"price_display": nullPriceDisplay(price, currency.String),
 			"currency":      currency.String,
 			"category_path": category.String,
 			"rating_value":  ratingVal.Float64,
 			"rating_count":  ratingCount.Int64,
-			"product_path":  "/product/" + gtinVal,
-		})
+			"product_path":  productPath(id),
+		}
+		if idCol == "gtin" {
+			item["gtin"] = id
+		}
+		out = append(out, item)
 	}
 	if err := rows.Err(); err != nil {
 		return nil, err
@@ -690,7 +1067,7 @@ func fetchHomeSectionItems(db *sql.DB, table, where, order string, limit int, ar
 	return out, nil
 }
 
-func fetchSearchPayload(db *sql.DB, table string, cols []string, idCol, query string, page, perPage, offset int) (searchPayload, error) {
+func fetchSearchPayload(db *sql.DB, table string, cols []string, idCol, query string, page, perPage, offset int, hideUnavailable bool) (searchPayload, error) {
 	searchFields := make([]string, 0, 3)
 	for _, c := range []string{"name", "brand", "category_path"} {
 		if contains(cols, c) {
@@ -701,12 +1078,8 @@ func fetchSearchPayload(db *sql.DB, table string, cols []string, idCol, query st
 		return searchPayload{}, fmt.Errorf("no searchable columns available")
 	}
 
-	idSelectName := "gtin"
-	if !contains(cols, "gtin") {
-		idSelectName = idCol
-	}
-	if !contains(cols, idSelectName) {
-		return searchPayload{}, fmt.Errorf("id column %q not found for search result selection", idSelectName)
+	if !contains(cols, idCol) {
+		return searchPayload{}, fmt.Errorf("id column %q not found for search result selection", idCol)
 	}
 
 	pattern := "%" + escapeLikePattern(query) + "%"
@@ -717,6 +1090,9 @@ func fetchSearchPayload(db *sql.DB, table string, cols []string, idCol, query st
 		whereArgs = append(whereArgs, pattern)
 	}
 	whereClause := strings.Join(whereParts, " OR ")
+	if cond := availabilityCondition("", hideUnavailable); cond != "" {
+		whereClause = "(" + whereClause + ") AND " + cond
+	}
 	tableQ := quoteIdent(table)
 
 	countQ := fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE (%s)", tableQ, whereClause)
@@ -725,7 +1101,7 @@ func fetchSearchPayload(db *sql.DB, table string, cols []string, idCol, query st
 		return searchPayload{}, err
 	}
 
-	items, err := fetchSearchItems(db, table, searchFields, idSelectName, perPage, offset, whereClause, whereArgs...)
+	items, err := fetchSearchItems(db, table, searchFields, idCol, perPage, offset, whereClause, whereArgs...)
 	if err != nil {
 		return searchPayload{}, err
 	}
@@ -799,16 +1175,20 @@ func fetchSearchItems(db *sql.DB, table string, searchFields []string, idCol str
 			return nil, err
 		}
 		id := idVal.String
+		if strings.TrimSpace(id) == "" {
+			continue
+		}
 		item := map[string]any{
 			"id":            id,
 			"name":          name.String,
 			"brand":         brand.String,
 			"price_eur":     price.Float64,
+			"price_display": nullPriceDisplay(price, currency.String),
 			"currency":      currency.String,
 			"category_path": category.String,
 			"rating_value":  ratingVal.Float64,
 			"rating_count":  ratingCount.Int64,
-			"product_path":  "/product/" + id,
+			"product_path":  productPath(id),
 		}
 		if idCol == "gtin" {
 			item["gtin"] = id
@@ -821,6 +1201,12 @@ func fetchSearchItems(db *sql.DB, table string, searchFields []string, idCol str
 	return out, nil
 }
 
+// productPath links a product card to /product/{id}, escaping id so ids with
+// "/" or "?" stay a single path segment.
+func productPath(id string) string {
+	return "/product/" + url.PathEscape(id)
+}
+
 func escapeLikePattern(s string) string {
 	replacer := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
 	return replacer.Replace(s)
@@ -858,14 +1244,37 @@ func pageOffset(page, perPage int) (int, bool) {
 	return int(p * sz), true
 }
 
+// searchPageTooDeep returns the error shown for a search page beyond the
+// -max-search-page cap, or "" when page is within it (or the cap is off).
+func searchPageTooDeep(page, maxPage int) string {
+	if maxPage <= 0 || page <= maxPage {
+		return ""
+	}
+	return fmt.Sprintf("page %d is too deep (max %d); narrow your query to see more results", page, maxPage)
+}
+
 func maxIntValue() int64 {
 	return int64(^uint(0) >> 1)
 }
 
-func writeJSON(w http.ResponseWriter, v any) {
+// availabilityCondition returns the SQL condition for -hide-unavailable with
+// prefix (e.g. "t.") on the column, or "" when the filter is off. main turns
+// the flag off when the table has no available column.
+func availabilityCondition(prefix string, hideUnavailable bool) string {
+	if !hideUnavailable {
+		return ""
+	}
+	return fmt.Sprintf("(%savailable = 1 OR %savailable IS NULL)", prefix, prefix)
+}
+
+// writeJSON encodes v indented, or minified with compact (-compact-json) for
+// machine consumers.
+func writeJSON(w http.ResponseWriter, v any, compact bool) {
 	w.Header().Set("Content-Type", "application/json")
 	enc := json.NewEncoder(w)
-	enc.SetIndent("", "  ")
+	if !compact {
+		enc.SetIndent("", "  ")
+	}
 	if err := enc.Encode(v); err != nil {
 		log.Printf("encode error: %v", err)
 	}
@@ -903,12 +1312,74 @@ func quoteIdent(s string) string {
 	return `"` + strings.ReplaceAll(s, `"`, `""`) + `"`
 }
 
+// templateOverride is a page template that -templates-dir can replace with
+// <dir>/<name>.html.
+type templateOverride struct {
+	name   string
+	target **template.Template
+	sample map[string]any
+}
+
+// loadTemplateOverrides parses <dir>/{home,product,search}.html where present
+// and executes each against sample page data before swapping any of them in.
+// It runs once in main before the server starts, so handlers only ever read
+// the package-level templates. It returns the names that were overridden.
+func loadTemplateOverrides(dir string) ([]string, error) {
+	type parsed struct {
+		target **template.Template
+		tmpl   *template.Template
+	}
+	var loaded []parsed
+	var names []string
+	for _, o := range pageTemplateOverrides() {
+		path := filepath.Join(dir, o.name+".html")
+		src, err := os.ReadFile(path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		tmpl, err := template.New(o.name).Parse(string(src))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		if err := tmpl.Execute(io.Discard, o.sample); err != nil {
+			return nil, fmt.Errorf("%s: sample render: %w", path, err)
+		}
+		loaded = append(loaded, parsed{o.target, tmpl})
+		names = append(names, o.name)
+	}
+	for _, p := range loaded {
+		*p.target = p.tmpl
+	}
+	return names, nil
+}
+
+// pageTemplateOverrides lists the overridable templates with data shaped like
+// what their handlers pass.
+func pageTemplateOverrides() []templateOverride {
+	row := map[string]any{"gtin": "4000000000001", "name": "Sample product", "brand": "Sample brand", "price_eur": 1.99, "currency": "EUR", "category_path": "Pflege > Seife"}
+	return []templateOverride{
+		{"home", &homePageTemplate, map[string]any{"title": "dimi", "home_data_json": mustJSONTemplateJS(homePayload{})}},
+		{"product", &productPageTemplate, map[string]any{
+			"id":                "4000000000001",
+			"product_data_json": mustJSONTemplateJS(row),
+			"similar_data_json": mustJSONTemplateJS([]map[string]any{row}),
+			"canonical_url":     "",
+			"breadcrumbs":       productBreadcrumbs(row),
+		}},
+		{"search", &searchPageTemplate, map[string]any{"title": "Search | dimi", "search_data_json": mustJSONTemplateJS(nil), "search_error": ""}},
+	}
+}
+
 var productPageTemplate = template.Must(template.New("product").Parse(`<!doctype html>
 <html lang="en">
 <head>
   <meta charset="utf-8" />
   <meta name="viewport" content="width=device-width, initial-scale=1" />
   <title>Product {{ .id }} | dimi</title>
+  {{ if .canonical_url }}<link rel="canonical" href="{{ .canonical_url }}" />{{ end }}
   <style>
     :root {
       --bg: #f5f3ef;
@@ -1000,6 +1471,9 @@ var productPageTemplate = template.Must(template.New("product").Parse(`<!doctype
     }
     .wrap { max-width: 1040px; margin: 40px auto 64px; padding: 0 20px; }
     .crumbs { font-size: 14px; color: var(--muted); margin-bottom: 14px; text-transform: capitalize; }
+    .crumbs a { color: inherit; text-decoration: none; }
+    .crumbs a:hover { color: var(--ink); text-decoration: underline; }
+    .crumb-sep { margin: 0 6px; }
     .card {
       background: var(--card);
       border: 1px solid var(--border);
@@ -1030,6 +1504,7 @@ var productPageTemplate = template.Must(template.New("product").Parse(`<!doctype
     .price-row { display: flex; align-items: center; gap: 12px; flex-wrap: wrap; margin: 14px 0; }
     .price { font-size: 26px; font-weight: 700; }
     .pill { font-size: 12px; color: var(--accent-2); border: 1px solid #fed7aa; background: #fff7ed; padding: 4px 10px; border-radius: 999px; }
+    .pill-stable { color: #0f766e; border-color: #99f6e4; background: #f0fdfa; }
     .meta { color: var(--muted); font-size: 14px; margin-bottom: 16px; }
     .meta span { display: inline-block; margin-right: 12px; }
     .cta {
@@ -1170,7 +1645,7 @@ var productPageTemplate = template.Must(template.New("product").Parse(`<!doctype
     </div>
   </div>
   <div class="wrap">
-    <div class="crumbs" id="product-crumbs">Loading product...</div>
+    <div class="crumbs" id="product-crumbs">{{ if .breadcrumbs }}{{ range $i, $c := .breadcrumbs }}{{ if $i }}<span class="crumb-sep">&rsaquo;</span>{{ end }}<a href="{{ $c.Href }}">{{ $c.Label }}</a>{{ end }}{{ else }}Loading product...{{ end }}</div>
     <div class="card">
       <div class="media" id="product-media">
         <span id="product-media-fallback">Loading image...</span>
@@ -1181,6 +1656,7 @@ var productPageTemplate = template.Must(template.New("product").Parse(`<!doctype
         <div class="price-row">
           <div class="price" id="product-price">Loading price…</div>
           <div class="pill">In stock</div>
+          <div class="pill pill-stable" id="product-price-stable" hidden></div>
         </div>
         <div class="meta" id="product-meta">
           <span>Product ID: <span id="product-id">{{ .id }}</span></span>
@@ -1234,6 +1710,7 @@ var productPageTemplate = template.Must(template.New("product").Parse(`<!doctype
       var brandEl = document.getElementById("product-brand");
       var nameEl = document.getElementById("product-name");
       var priceEl = document.getElementById("product-price");
+      var priceStableEl = document.getElementById("product-price-stable");
       var catWrapEl = document.getElementById("product-category-wrap");
       var catEl = document.getElementById("product-category");
       var descEl = document.getElementById("product-desc");
@@ -1289,6 +1766,15 @@ var productPageTemplate = template.Must(template.New("product").Parse(`<!doctype
         return meta || "Price not available";
       }
 
+      function formatStableSince(row) {
+        var raw = firstNonEmpty(row.gross_not_increased_since, row.net_not_increased_since);
+        var m = /^(\d{4})-(\d{2})-(\d{2})/.exec(raw);
+        if (!m) return "";
+        var d = new Date(Date.UTC(Number(m[1]), Number(m[2]) - 1, Number(m[3])));
+        if (Number.isNaN(d.getTime())) return "";
+        return d.toLocaleDateString("en-GB", { day: "numeric", month: "long", year: "numeric", timeZone: "UTC" });
+      }
+
       function setMedia(row, name) {
         if (!mediaEl) return;
         var src = firstNonEmpty(row.image, row.image_url, row.img, row.thumbnail);
@@ -1361,7 +1847,8 @@ var productPageTemplate = template.Must(template.New("product").Parse(`<!doctype
           category_path: true, seo_category: true,
           image: true, image_url: true, img: true, thumbnail: true,
           desc_productbeschreibung: true, metadata_description: true,
-          rating_value: true, rating_count: true
+          rating_value: true, rating_count: true,
+          gross_not_increased_since: true, net_not_increased_since: true
         };
         var rows = [];
         Object.keys(row || {}).sort().forEach(function (key) {
@@ -1388,11 +1875,16 @@ var productPageTemplate = template.Must(template.New("product").Parse(`<!doctype
         var desc = firstNonEmpty(row.desc_productbeschreibung, row.metadata_description);
 
         document.title = name + " | dimi";
-        if (crumbsEl) crumbsEl.textContent = category || "Product details";
+        if (crumbsEl && !crumbsEl.querySelector("a")) crumbsEl.textContent = category || "Product details";
         setMedia(row, name);
         setText(brandEl, brand, "Unknown brand");
         setText(nameEl, name, "Product");
         setText(priceEl, formatMainPrice(row), "Price not available");
+        if (priceStableEl) {
+          var stableSince = formatStableSince(row);
+          priceStableEl.textContent = stableSince ? "Price stable since " + stableSince : "";
+          priceStableEl.hidden = !stableSince;
+        }
 
         if (catWrapEl && catEl) {
           if (category) {
@@ -1426,7 +1918,7 @@ var productPageTemplate = template.Must(template.New("product").Parse(`<!doctype
           loadStatusEl.hidden = false;
           loadStatusEl.textContent = "Could not render product details right now.";
         }
-        if (crumbsEl) crumbsEl.textContent = "Product details";
+        if (crumbsEl && !crumbsEl.querySelector("a")) crumbsEl.textContent = "Product details";
         if (brandEl) brandEl.textContent = "Unavailable";
         if (nameEl) nameEl.textContent = "Product " + productId;
         if (priceEl) priceEl.textContent = "Price not available";
@@ -1440,7 +1932,7 @@ var productPageTemplate = template.Must(template.New("product").Parse(`<!doctype
           return;
         }
         gridEl.innerHTML = items.map(function (item) {
-          var gtin = item.gtin || "";
+          var href = item.product_path || ("/product/" + encodeURIComponent(item.gtin || item.id || ""));
           var name = escapeHtml(item.name || "Product");
           var brand = escapeHtml(item.brand || "Unknown brand");
           var price = escapeHtml(formatPrice(item));
@@ -1448,7 +1940,7 @@ var productPageTemplate = template.Must(template.New("product").Parse(`<!doctype
             ? ("★ " + item.rating_value.toFixed(1))
             : "";
           return (
-            '<a class="rec-card" href="/product/' + encodeURIComponent(gtin) + '">' +
+            '<a class="rec-card" href="' + escapeHtml(href) + '">' +
               '<div class="rec-brand">' + brand + '</div>' +
               '<div class="rec-name">' + name + '</div>' +
               '<div class="rec-meta">' +
@@ -1876,8 +2368,7 @@ var homePageTemplate = template.Must(template.New("home").Parse(`<!doctype html>
       }
 
       function renderCard(item) {
-        var gtin = item.gtin || "";
-        var href = item.product_path || ("/product/" + encodeURIComponent(gtin));
+        var href = item.product_path || ("/product/" + encodeURIComponent(item.gtin || item.id || ""));
         var brand = escapeHtml(item.brand || "Unknown brand");
         var name = escapeHtml(item.name || "Product");
         var category = escapeHtml(item.category_path || "");
@@ -2313,6 +2804,111 @@ func getString(row map[string]any, key string) string {
 	}
 }
 
+// formatPriceDisplay mirrors the client's Intl.NumberFormat("de-DE") currency
+// output ("4,99 €", "1.234,50 $") so non-JS consumers get the same string.
+func formatPriceDisplay(amount float64, currency string) string {
+	code := strings.ToUpper(strings.TrimSpace(currency))
+	if code == "" {
+		code = "EUR"
+	}
+	symbol := code
+	switch code {
+	case "EUR":
+		symbol = "€"
+	case "USD":
+		symbol = "$"
+	case "GBP":
+		symbol = "£"
+	}
+	s := strconv.FormatFloat(math.Abs(amount), 'f', 2, 64)
+	intPart, frac := s[:len(s)-3], s[len(s)-2:]
+	var b strings.Builder
+	if amount < 0 && s != "0.00" {
+		b.WriteString("-")
+	}
+	for i, d := range intPart {
+		if i > 0 && (len(intPart)-i)%3 == 0 {
+			b.WriteString(".")
+		}
+		b.WriteRune(d)
+	}
+	b.WriteString(",")
+	b.WriteString(frac)
+	b.WriteString(" ")
+	b.WriteString(symbol)
+	return b.String()
+}
+
+// nullPriceDisplay formats a scanned price, or "" when it is NULL.
+func nullPriceDisplay(price sql.NullFloat64, currency string) string {
+	if !price.Valid || math.IsNaN(price.Float64) {
+		return ""
+	}
+	return formatPriceDisplay(price.Float64, currency)
+}
+
+// rowPriceDisplay formats the price_eur column of a full product row.
+func rowPriceDisplay(row map[string]any) string {
+	raw := strings.TrimSpace(getString(row, "price_eur"))
+	if raw == "" {
+		return ""
+	}
+	f, err := strconv.ParseFloat(raw, 64)
+	if err != nil || math.IsNaN(f) {
+		return ""
+	}
+	return formatPriceDisplay(f, getString(row, "currency"))
+}
+
+// canonicalProductURL returns the first absolute http(s) URL among the
+// canonical_url, metadata_canonical and product_url columns, or "".
+func canonicalProductURL(row map[string]any) string {
+	for _, col := range []string{"canonical_url", "metadata_canonical", "product_url"} {
+		raw := strings.TrimSpace(getString(row, col))
+		if raw == "" {
+			continue
+		}
+		u, err := url.Parse(raw)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			continue
+		}
+		return u.String()
+	}
+	return ""
+}
+
+// breadcrumbLink is one level of the product page breadcrumb trail.
+type breadcrumbLink struct {
+	Label string
+	Href  string
+}
+
+// productBreadcrumbs builds one link per non-empty breadcrumb_1..4 level (or
+// breadcrumbs_path segment when those are missing), each pointing at the
+// cumulative /category/{path} up to that level.
+func productBreadcrumbs(row map[string]any) []breadcrumbLink {
+	var labels []string
+	for _, col := range []string{"breadcrumb_1", "breadcrumb_2", "breadcrumb_3", "breadcrumb_4"} {
+		if s := strings.TrimSpace(getString(row, col)); s != "" {
+			labels = append(labels, s)
+		}
+	}
+	if len(labels) == 0 {
+		for _, part := range strings.Split(getString(row, "breadcrumbs_path"), ">") {
+			if s := strings.TrimSpace(part); s != "" {
+				labels = append(labels, s)
+			}
+		}
+	}
+	out := make([]breadcrumbLink, 0, len(labels))
+	path := ""
+	for _, label := range labels {
+		path += "/" + url.PathEscape(label)
+		out = append(out, breadcrumbLink{Label: label, Href: "/category" + path})
+	}
+	return out
+}
+
 func firstNonEmpty(values ...string) string {
 	for _, v := range values {
 		if strings.TrimSpace(v) != "" {
@@ -0,0 +1,396 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWithRequestLogging_LogsNotFound(t *testing.T) {
+	var buf bytes.Buffer
+	h := withRequestLogging(http.NewServeMux(), log.New(&buf, "", 0), "text")
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/missing-page", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("expected 404, got %d", rec.Code)
+	}
+	line := buf.String()
+	if !strings.Contains(line, "status=404") || !strings.Contains(line, `path="/missing-page"`) {
+		t.Fatalf("unexpected log line: %q", line)
+	}
+}
+
+func TestProductPage_CanonicalLink(t *testing.T) {
+	var b strings.Builder
+	if err := productPageTemplate.Execute(&b, map[string]any{
+		"id":            "1",
+		"canonical_url": canonicalProductURL(map[string]any{"canonical_url": "https://shop.example/p/1?a=1&b=2"}),
+	}); err != nil {
+		t.Fatalf("template error: %v", err)
+	}
+	if want := `<link rel="canonical" href="https://shop.example/p/1?a=1&amp;b=2" />`; !strings.Contains(b.String(), want) {
+		t.Fatalf("expected escaped canonical link %s in page", want)
+	}
+
+	b.Reset()
+	if err := productPageTemplate.Execute(&b, map[string]any{"id": "1", "canonical_url": canonicalProductURL(map[string]any{"product_url": "/relative"})}); err != nil {
+		t.Fatalf("template error: %v", err)
+	}
+	if strings.Contains(b.String(), `rel="canonical"`) {
+		t.Fatalf("expected no canonical link for a relative URL")
+	}
+}
+
+func TestProductPage_BreadcrumbLinks(t *testing.T) {
+	var b strings.Builder
+	if err := productPageTemplate.Execute(&b, map[string]any{
+		"id":          "1",
+		"breadcrumbs": productBreadcrumbs(map[string]any{"breadcrumb_1": "Pflege", "breadcrumb_2": "Seife", "breadcrumb_3": nil}),
+	}); err != nil {
+		t.Fatalf("template error: %v", err)
+	}
+	page := b.String()
+	first := strings.Index(page, `<a href="/category/Pflege">Pflege</a>`)
+	second := strings.Index(page, `<a href="/category/Pflege/Seife">Seife</a>`)
+	if first < 0 || second < first {
+		t.Fatalf("expected one breadcrumb link per level in order")
+	}
+	if n := strings.Count(page, `href="/category/`); n != 2 {
+		t.Fatalf("expected 2 breadcrumb links, got %d", n)
+	}
+}
+
+func TestSearchPayload_PriceDisplay(t *testing.T) {
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "products.sqlite"))
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	defer db.Close()
+	if _, err := db.Exec(`CREATE TABLE products (gtin TEXT, name TEXT, brand TEXT, price_eur REAL, currency TEXT, category_path TEXT, rating_value REAL, rating_count INTEGER);
+		INSERT INTO products VALUES ('1', 'Soap', 'Balea', 4.99, 'EUR', 'pflege', 4.5, 10), ('2', 'Soap refill', 'Balea', NULL, 'EUR', 'pflege', 0, 0);`); err != nil {
+		t.Fatalf("seed db: %v", err)
+	}
+	cols := []string{"gtin", "name", "brand", "price_eur", "currency", "category_path", "rating_value", "rating_count"}
+
+	payload, err := fetchSearchPayload(db, "products", cols, "gtin", "soap", 1, 10, 0, false)
+	if err != nil {
+		t.Fatalf("search: %v", err)
+	}
+	got := map[string]any{}
+	for _, item := range payload.Items {
+		got[getString(item, "gtin")] = item["price_display"]
+	}
+	if got["1"] != "4,99 €" || got["2"] != "" {
+		t.Fatalf("expected price_display 4,99 € and empty for a NULL price, got %v", got)
+	}
+
+	if s := rowPriceDisplay(map[string]any{"price_eur": 1234.5, "currency": "EUR"}); s != "1.234,50 €" {
+		t.Fatalf("unexpected product price_display %q", s)
+	}
+	if s := formatPriceDisplay(-0.001, "usd"); s != "0,00 $" {
+		t.Fatalf("unexpected rounding of a tiny negative amount %q", s)
+	}
+}
+
+func TestHideUnavailable_FiltersHomeAndSearch(t *testing.T) {
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "products.sqlite"))
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	defer db.Close()
+	if _, err := db.Exec(`CREATE TABLE products (gtin TEXT, name TEXT, brand TEXT, price_eur REAL, currency TEXT, category_path TEXT, rating_value REAL, rating_count INTEGER, available INTEGER);
+		INSERT INTO products VALUES ('1', 'Soap', 'Balea', 4.99, 'EUR', 'pflege', 4.5, 10, 1), ('2', 'Soap refill', 'Balea', 2.99, 'EUR', 'pflege', 4.8, 20, 0), ('3', 'Soap bar', 'Dove', 1.99, 'EUR', 'pflege', 4.1, 5, NULL);`); err != nil {
+		t.Fatalf("seed db: %v", err)
+	}
+	cols := []string{"gtin", "name", "brand", "price_eur", "currency", "category_path", "rating_value", "rating_count", "available"}
+	for _, hide := range []bool{false, true} {
+		want := 3
+		if hide {
+			want = 2
+		}
+		payload, err := fetchSearchPayload(db, "products", cols, "gtin", "soap", 1, 10, 0, hide)
+		if err != nil {
+			t.Fatalf("search: %v", err)
+		}
+		if payload.Total != want || len(payload.Items) != want {
+			t.Fatalf("hide=%v: expected %d search results, got total=%d items=%d", hide, want, payload.Total, len(payload.Items))
+		}
+		items, err := fetchHomeSectionItems(db, "products", "gtin", hide, "rating_count > 0", "rating_value DESC", 10)
+		if err != nil {
+			t.Fatalf("home section: %v", err)
+		}
+		if len(items) != want {
+			t.Fatalf("hide=%v: expected %d home items, got %d", hide, want, len(items))
+		}
+		for _, item := range items {
+			if hide && getString(item, "gtin") == "2" {
+				t.Fatalf("unavailable product listed on home with -hide-unavailable")
+			}
+		}
+	}
+}
+
+func TestRequestID_EchoedAndLoggedOnError(t *testing.T) {
+	var access, errs bytes.Buffer
+	log.SetOutput(&errs)
+	defer log.SetOutput(os.Stderr)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/boom", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		logRequestf(r, "fetch error: %v", "forced")
+	})
+	h := withRequestLogging(withRequestID(mux), log.New(&access, "", 0), "text")
+
+	req := httptest.NewRequest(http.MethodGet, "/boom", nil)
+	req.Header.Set("X-Request-ID", "client-abc-123")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if got := rec.Header().Get("X-Request-ID"); got != "client-abc-123" {
+		t.Fatalf("expected the client request ID to be echoed, got %q", got)
+	}
+	if line := errs.String(); !strings.Contains(line, "request_id=client-abc-123 fetch error: forced") {
+		t.Fatalf("expected the error log line to carry the request ID, got %q", line)
+	}
+	if line := access.String(); !strings.Contains(line, "status=500") || !strings.Contains(line, "request_id=client-abc-123") {
+		t.Fatalf("expected the access log line to carry the request ID, got %q", line)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/boom", nil))
+	if id := rec.Header().Get("X-Request-ID"); len(id) != 16 {
+		t.Fatalf("expected a generated 16-char request ID, got %q", id)
+	}
+}
+
+func TestSearchPageTooDeep(t *testing.T) {
+	if msg := searchPageTooDeep(defaultMaxSearchPage, defaultMaxSearchPage); msg != "" {
+		t.Fatalf("expected the last allowed page to pass, got %q", msg)
+	}
+	if msg := searchPageTooDeep(1000000, defaultMaxSearchPage); msg != "page 1000000 is too deep (max 1000); narrow your query to see more results" {
+		t.Fatalf("unexpected message %q", msg)
+	}
+	if msg := searchPageTooDeep(1000000, 0); msg != "" {
+		t.Fatalf("expected a zero cap to disable the guard, got %q", msg)
+	}
+}
+
+func TestSitemapURLCapAndIndexPageCount(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	ids := make([]string, sitemapProtocolMaxURLs+1)
+	for i := range ids {
+		ids[i] = fmt.Sprintf("%d", i)
+	}
+	if n := len(buildProductURLSetXML("https://shop.example", ids[:defaultSitemapChunkSize]).Items); n != defaultSitemapChunkSize {
+		t.Fatalf("expected a full default-sized page, got %d URLs", n)
+	}
+	if n := len(buildProductURLSetXML("https://shop.example", ids).Items); n != sitemapProtocolMaxURLs {
+		t.Fatalf("expected the URL set to be capped at %d, got %d", sitemapProtocolMaxURLs, n)
+	}
+	if !strings.Contains(buf.String(), "dropping 1") {
+		t.Fatalf("expected the truncation to be logged, got %q", buf.String())
+	}
+
+	cases := []struct {
+		total, chunk, want int
+	}{
+		{50000, sitemapProtocolMaxURLs, 1},
+		{50001, sitemapProtocolMaxURLs, 2},
+		{100000, sitemapProtocolMaxURLs, 2},
+		{50000, defaultSitemapChunkSize, 5},
+		{50001, defaultSitemapChunkSize, 6},
+		{100000, defaultSitemapChunkSize, 10},
+		{100000, sitemapProtocolMaxURLs + 1, 2},
+		{0, defaultSitemapChunkSize, 1},
+	}
+	for _, c := range cases {
+		idx := buildSitemapIndexXML("https://shop.example", c.total, c.chunk)
+		if len(idx.Items) != c.want {
+			t.Fatalf("total=%d chunk=%d: expected %d sitemap files, got %d", c.total, c.chunk, c.want, len(idx.Items))
+		}
+		if last := idx.Items[len(idx.Items)-1].Loc; last != fmt.Sprintf("https://shop.example/sitemaps/products-%d.xml", c.want) {
+			t.Fatalf("total=%d chunk=%d: unexpected last sitemap %q", c.total, c.chunk, last)
+		}
+	}
+}
+
+func TestFetchProductIDsPage_CapsAtProtocolLimit(t *testing.T) {
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "products.sqlite"))
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	defer db.Close()
+	if _, err := db.Exec(`CREATE TABLE products (gtin TEXT);
+		WITH RECURSIVE n(i) AS (SELECT 1 UNION ALL SELECT i + 1 FROM n WHERE i < 50001)
+		INSERT INTO products SELECT printf('%013d', i) FROM n;`); err != nil {
+		t.Fatalf("seed db: %v", err)
+	}
+
+	ids, err := fetchProductIDsPage(db, "products", "gtin", sitemapProtocolMaxURLs+1, 0)
+	if err != nil {
+		t.Fatalf("fetchProductIDsPage: %v", err)
+	}
+	if len(ids) != sitemapProtocolMaxURLs {
+		t.Fatalf("expected the page to be capped at %d ids, got %d", sitemapProtocolMaxURLs, len(ids))
+	}
+	ids, err = fetchProductIDsPage(db, "products", "gtin", sitemapProtocolMaxURLs, sitemapProtocolMaxURLs)
+	if err != nil {
+		t.Fatalf("fetchProductIDsPage: %v", err)
+	}
+	if len(ids) != 1 || ids[0] != "0000000050001" {
+		t.Fatalf("expected the second page to hold only the 50001st id, got %v", ids)
+	}
+}
+
+func TestLoadTemplateOverrides_CustomProductTemplate(t *testing.T) {
+	orig := productPageTemplate
+	defer func() { productPageTemplate = orig }()
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "product.html"), []byte(`<p>custom {{.id}}</p>`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	names, err := loadTemplateOverrides(dir)
+	if err != nil || strings.Join(names, ",") != "product" {
+		t.Fatalf("expected only product to be overridden, got %v, %v", names, err)
+	}
+	var b strings.Builder
+	if err := productPageTemplate.Execute(&b, map[string]any{"id": "4000000000001"}); err != nil {
+		t.Fatalf("execute: %v", err)
+	}
+	if b.String() != "<p>custom 4000000000001</p>" {
+		t.Fatalf("expected the custom product template, got %q", b.String())
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "search.html"), []byte(`{{template "missing"}}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadTemplateOverrides(dir); err == nil {
+		t.Fatalf("expected a search template that cannot render to be rejected")
+	}
+}
+
+func TestEmbeddedFavicon(t *testing.T) {
+	rec := httptest.NewRecorder()
+	http.ServeFileFS(rec, httptest.NewRequest(http.MethodGet, "/favicon.ico", nil), staticFiles, "static/favicon.ico")
+	if rec.Code != http.StatusOK || !strings.HasPrefix(rec.Header().Get("Content-Type"), "image/") {
+		t.Fatalf("expected the embedded favicon, got %d %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	if b := rec.Body.Bytes(); len(b) < 6 || b[2] != 1 || b[4] != 1 {
+		t.Fatalf("expected an ICO header with one image, got % x", b[:6])
+	}
+}
+
+func TestFetchHomePayload_BudgetMaxPrice(t *testing.T) {
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "products.sqlite"))
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	defer db.Close()
+	if _, err := db.Exec(`CREATE TABLE products (gtin TEXT, name TEXT, brand TEXT, price_eur REAL, currency TEXT, category_path TEXT, rating_value REAL, rating_count INTEGER, product_is_pharmacy INTEGER, has_eyecatchers INTEGER, has_pills INTEGER);
+		INSERT INTO products VALUES ('1', 'Soap', 'Balea', 0.95, 'EUR', 'pflege', 4.5, 10, 0, 0, 0), ('2', 'Cream', 'Nivea', 7.49, 'EUR', 'pflege', 4.6, 30, 0, 0, 0), ('3', 'Perfume', 'Chanel', 89.0, 'EUR', 'duft', 4.9, 12, 0, 0, 0);`); err != nil {
+		t.Fatalf("seed db: %v", err)
+	}
+	budgetCount := func(maxPrice float64) int {
+		t.Helper()
+		home, err := fetchHomePayload(db, "products", "gtin", maxPrice, false)
+		if err != nil {
+			t.Fatalf("fetchHomePayload: %v", err)
+		}
+		for _, s := range home.Sections {
+			if s.ID == "budget-finds" {
+				return len(s.Items)
+			}
+		}
+		return 0
+	}
+	if n := budgetCount(defaultBudgetMaxPrice); n != 1 {
+		t.Fatalf("expected 1 budget product at the default cap, got %d", n)
+	}
+	if n := budgetCount(10); n != 2 {
+		t.Fatalf("expected 2 budget products at a 10 EUR cap, got %d", n)
+	}
+	if n := budgetCount(100); n != 3 {
+		t.Fatalf("expected all 3 products at a 100 EUR cap, got %d", n)
+	}
+}
+
+func TestProductPathsUseConfiguredIDColumn(t *testing.T) {
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "products.sqlite"))
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	defer db.Close()
+	if _, err := db.Exec(`CREATE TABLE products (dan INTEGER, name TEXT, brand TEXT, price_eur REAL, currency TEXT, category_path TEXT, rating_value REAL, rating_count INTEGER, product_is_pharmacy INTEGER, has_eyecatchers INTEGER, has_pills INTEGER);
+		INSERT INTO products VALUES (101, 'Soap', 'Balea', 0.95, 'EUR', 'pflege', 4.5, 10, 0, 0, 0), (102, 'Soap bar', 'Balea', 1.49, 'EUR', 'pflege', 4.7, 20, 0, 0, 0);`); err != nil {
+		t.Fatalf("seed db: %v", err)
+	}
+	cols := []string{"dan", "name", "brand", "price_eur", "currency", "category_path", "rating_value", "rating_count", "product_is_pharmacy", "has_eyecatchers", "has_pills"}
+
+	home, err := fetchHomePayload(db, "products", "dan", defaultBudgetMaxPrice, false)
+	if err != nil {
+		t.Fatalf("fetchHomePayload: %v", err)
+	}
+	seen := 0
+	for _, s := range home.Sections {
+		for _, item := range s.Items {
+			id := getString(item, "id")
+			if id != "101" && id != "102" {
+				t.Fatalf("home item in %q has id %q, want a dan value", s.ID, id)
+			}
+			if got := getString(item, "product_path"); got != "/product/"+id {
+				t.Fatalf("home item %s has product_path %q", id, got)
+			}
+			seen++
+		}
+	}
+	if seen == 0 {
+		t.Fatalf("expected home items")
+	}
+
+	payload, err := fetchSearchPayload(db, "products", cols, "dan", "soap", 1, 10, 0, false)
+	if err != nil {
+		t.Fatalf("search: %v", err)
+	}
+	if len(payload.Items) != 2 {
+		t.Fatalf("expected 2 search results, got %d", len(payload.Items))
+	}
+	for _, item := range payload.Items {
+		if got := getString(item, "product_path"); got != "/product/"+getString(item, "id") || got == "/product/" {
+			t.Fatalf("unexpected search product_path %q", got)
+		}
+	}
+
+	similar, err := fetchSimilar(db, "products", cols, "dan", "101", 4, similarModeCategory)
+	if err != nil {
+		t.Fatalf("similar: %v", err)
+	}
+	if len(similar) != 1 || getString(similar[0], "product_path") != "/product/102" {
+		t.Fatalf("expected one similar product linking to /product/102, got %v", similar)
+	}
+}
+
+func TestRequestBaseURL_TrustForwarded(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/sitemap.xml", nil)
+	req.Host = "shop.example"
+	req.Header.Set("X-Forwarded-Proto", "https")
+	if got := requestBaseURL(req, false); got != "http://shop.example" {
+		t.Fatalf("expected X-Forwarded-Proto to be ignored by default, got %q", got)
+	}
+	if got := requestBaseURL(req, true); got != "https://shop.example" {
+		t.Fatalf("expected the forwarded scheme with -trust-forwarded, got %q", got)
+	}
+	req.Header.Set("X-Forwarded-Proto", "javascript")
+	if got := requestBaseURL(req, true); got != "http://shop.example" {
+		t.Fatalf("expected a non-http(s) forwarded scheme to be rejected, got %q", got)
+	}
+}
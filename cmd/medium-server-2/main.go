@@ -1,29 +1,67 @@
 package main
 
 import (
+	"bytes"
+	"container/list"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
 	"database/sql"
+	"database/sql/driver"
+	"embed"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
 	"encoding/xml"
 	"errors"
 	"flag"
 	"fmt"
 	"html/template"
+	"io"
 	"log"
 	"math"
+	"net"
 	"net/http"
+	"net/netip"
+	"net/url"
 	"os"
+	"path/filepath"
+	"regexp"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
-	_ "modernc.org/sqlite"
+	"golang.org/x/text/unicode/norm"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
 )
 
 const defaultAddr = "127.0.0.1:18745"
 const sitemapProtocolMaxURLs = 50000
 const defaultSitemapChunkSize = 10000
-const searchMinChars = 3
-const searchPageSize = 10
+const defaultDBOpenRetries = 3
+
+var dbRetryBaseDelay = 100 * time.Millisecond
+
+const defaultSearchMinChars = 3
+const defaultSimilarLimit = 8
+
+const (
+	similarModeCategory      = "category"
+	similarModeBrandCategory = "brand-category"
+)
+const defaultSearchPageSize = 10
+const searchMaxPageSize = 50
+const defaultMaxSearchPage = 1000
+const defaultBudgetMaxPrice = 5.0
+const similarMaxPageSize = 24
+const bulkMaxIDs = 100
 
 func main() {
 	flag.Usage = func() {
@@ -35,6 +73,30 @@ func main() {
 	idCol := flag.String("id", "", "Name of the unique ID column used for lookup")
 	addr := flag.String("addr", defaultAddr, "HTTP listen address")
 	sitemapChunkSize := flag.Int("sitemap-chunk-size", defaultSitemapChunkSize, "Max product URLs per sitemap file (capped at 50000)")
+	tableName := flag.String("table", "", "Table to serve (default: first user table, alphabetically)")
+	httpLimits := registerHTTPServerFlags(flag.CommandLine)
+	compactJSON := flag.Bool("compact-json", false, "Write minified JSON responses instead of indented ones")
+	hideUnavailable := flag.Bool("hide-unavailable", false, "Exclude products with available = 0 from home and search results")
+	dbOpenRetries := flag.Int("db-open-retries", defaultDBOpenRetries, "Retries with exponential backoff when the sqlite database is busy or not ready at startup")
+	similarLimit := flag.Int("similar-limit", defaultSimilarLimit, "Max similar products shown on product pages")
+	similarMode := flag.String("similar-mode", similarModeCategory, "Similar-product ranking: category (same category first) or brand-category (brand+category, then brand, then category)")
+	similarFallback := flag.Bool("similar-fallback-popular", false, "Show top-rated products in the similar section when a product has no brand/category matches")
+	trustForwarded := flag.Bool("trust-forwarded", false, "Honor X-Forwarded-Proto/Host (only enable behind a trusted proxy)")
+	trustedProxies := flag.String("trusted-proxies", "", "Comma-separated proxy CIDRs or IPs (e.g. 10.0.0.0/8,::1); X-Forwarded-* headers are honored only from these peers")
+	allowedHosts := flag.String("allowed-hosts", "", "Comma-separated hosts allowed in generated absolute URLs (default: any well-formed Host)")
+	cacheTTL := flag.Duration("cache-ttl", 0, "Cache rendered home/product pages in memory for this long (0 disables)")
+	corsOrigin := flag.String("cors-origin", "", "Access-Control-Allow-Origin value for /api/ routes (empty disables CORS)")
+	logFormat := flag.String("log-format", "text", "Request log format: text or json")
+	templatesDir := flag.String("templates-dir", "", "Directory with home.html, product.html and/or search.html overriding the built-in page templates")
+	useFTS := flag.Bool("fts", false, "Use an SQLite FTS5 index for /search when available (falls back to LIKE)")
+	ftsIndexPath := flag.String("fts-index", "", "Scratch SQLite file holding the -fts index (default: a file in the OS temp dir derived from -path); the served database is never written")
+	searchMinChars := flag.Int("search-min-chars", defaultSearchMinChars, "Minimum search query length in characters, after whitespace normalization")
+	searchPageSize := flag.Int("search-page-size", defaultSearchPageSize, "Default search results per page (capped at 50; override per request with ?per_page=)")
+	budgetMaxPrice := flag.Float64("budget-max-price", defaultBudgetMaxPrice, "Highest price_eur listed in the home page Budget Finds section")
+	maxSearchPage := flag.Int("max-search-page", defaultMaxSearchPage, "Deepest search page served; later pages ask to narrow the query (0 disables the cap)")
+	adminUser := flag.String("admin-user", "", "Basic auth user required for /metrics (requires -admin-pass)")
+	adminPass := flag.String("admin-pass", "", "Basic auth password required for /metrics (requires -admin-user)")
+	adminAPI := flag.Bool("admin-protect-api", false, "Also require the admin basic auth credentials for /api/ routes (except the public /api/similar/)")
 	flag.Parse()
 
 	if *dbPath == "" {
@@ -43,24 +105,75 @@ func main() {
 	if *idCol == "" {
 		log.Fatal("missing -id column name")
 	}
+	if *logFormat != "text" && *logFormat != "json" {
+		log.Fatalf("invalid -log-format %q (want text or json)", *logFormat)
+	}
+	if *budgetMaxPrice <= 0 {
+		log.Fatalf("invalid -budget-max-price %v (want > 0)", *budgetMaxPrice)
+	}
+	proxies, err := parseTrustedProxies(*trustedProxies)
+	if err != nil {
+		log.Fatalf("invalid -trusted-proxies: %v", err)
+	}
+	if *templatesDir != "" {
+		names, err := loadTemplateOverrides(*templatesDir)
+		if err != nil {
+			log.Fatalf("templates: %v", err)
+		}
+		if len(names) == 0 {
+			log.Printf("templates: no home.html, product.html or search.html in %s; using the built-in templates", *templatesDir)
+		} else {
+			log.Printf("templates: overriding %s from %s", strings.Join(names, ", "), *templatesDir)
+		}
+	}
+	if *similarMode != similarModeCategory && *similarMode != similarModeBrandCategory {
+		log.Fatalf("invalid -similar-mode %q (want %s or %s)", *similarMode, similarModeCategory, similarModeBrandCategory)
+	}
+	if (*adminUser == "") != (*adminPass == "") {
+		log.Fatal("-admin-user and -admin-pass must be set together")
+	}
+	if *adminAPI && *adminUser == "" {
+		log.Fatal("-admin-protect-api requires -admin-user and -admin-pass")
+	}
 	if *sitemapChunkSize <= 0 {
 		*sitemapChunkSize = defaultSitemapChunkSize
 	}
 	if *sitemapChunkSize > sitemapProtocolMaxURLs {
 		*sitemapChunkSize = sitemapProtocolMaxURLs
 	}
+	if *searchPageSize <= 0 {
+		*searchPageSize = defaultSearchPageSize
+	}
+	if *searchPageSize > searchMaxPageSize {
+		*searchPageSize = searchMaxPageSize
+	}
 
 	if _, err := os.Stat(*dbPath); err != nil {
 		log.Fatalf("sqlite path error: %v", err)
 	}
 
-	db, err := sql.Open("sqlite", *dbPath)
+	indexPath := ""
+	if *useFTS {
+		indexPath = *ftsIndexPath
+		if indexPath == "" {
+			indexPath = defaultSearchIndexPath(*dbPath)
+		}
+	}
+	db, err := openProductsDB(*dbPath, indexPath)
 	if err != nil {
 		log.Fatalf("open sqlite: %v", err)
 	}
 	defer db.Close()
 
-	table, err := firstUserTable(db)
+	var table string
+	err = retryWithBackoff(*dbOpenRetries, func() error {
+		if err := db.Ping(); err != nil {
+			return err
+		}
+		var err error
+		table, err = resolveTable(db, *tableName)
+		return err
+	})
 	if err != nil {
 		log.Fatalf("find table: %v", err)
 	}
@@ -72,25 +185,159 @@ func main() {
 	if !contains(cols, *idCol) {
 		log.Fatalf("id column %q not found in table %q", *idCol, table)
 	}
+	if *hideUnavailable && !contains(cols, "available") {
+		log.Printf("-hide-unavailable: table %q has no available column; showing all products", table)
+		*hideUnavailable = false
+	}
+
+	ftsTable := ""
+	if *useFTS {
+		if !sqliteHasFTS5(db) {
+			log.Printf("fts: FTS5 not available in this sqlite build, using LIKE search")
+		} else if stamp, err := searchSourceStamp(*dbPath); err != nil {
+			log.Printf("fts: cannot stat %s, using LIKE search: %v", *dbPath, err)
+		} else if ftsTable, err = ensureSearchFTS(db, table, searchFieldsFor(cols), stamp); err != nil {
+			log.Printf("fts: index setup failed, using LIKE search: %v", err)
+			ftsTable = ""
+		}
+	}
+
+	metrics := newServerMetrics()
+	mux := newServerMux(db, serverConfig{
+		Table:            table,
+		Cols:             cols,
+		IDCol:            *idCol,
+		SitemapChunkSize: *sitemapChunkSize,
+		SearchPageSize:   *searchPageSize,
+		MaxSearchPage:    *maxSearchPage,
+		BudgetMaxPrice:   *budgetMaxPrice,
+		SearchMinChars:   *searchMinChars,
+		FTSTable:         ftsTable,
+		SimilarLimit:     *similarLimit,
+		SimilarMode:      *similarMode,
+		SimilarFallback:  *similarFallback,
+		HideUnavailable:  *hideUnavailable,
+		CompactJSON:      *compactJSON,
+		CORSOrigin:       strings.TrimSpace(*corsOrigin),
+		CacheTTL:         *cacheTTL,
+		AdminAuth: adminAuth{
+			User:       *adminUser,
+			Pass:       *adminPass,
+			ProtectAPI: *adminAPI,
+		},
+		BaseURL: baseURLPolicy{
+			TrustForwarded: *trustForwarded,
+			TrustedProxies: proxies,
+			AllowedHosts:   splitCommaList(*allowedHosts),
+			FallbackHost:   *addr,
+		},
+	}, metrics)
+
+	log.Printf("medium-server-2 listening on %s (table=%s id=%s)", *addr, table, *idCol)
+	srv := newHTTPServer(*addr, withRequestLogging(mux, log.Default(), *logFormat, metrics), *httpLimits)
+	if err := srv.ListenAndServe(); err != nil {
+		log.Fatalf("server error: %v", err)
+	}
+}
+
+// httpServerLimits bounds slow or oversized clients (slowloris) via the
+// http.Server timeouts and header size cap.
+type httpServerLimits struct {
+	ReadHeaderTimeout time.Duration
+	ReadTimeout       time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	MaxHeaderBytes    int
+}
 
+func registerHTTPServerFlags(fs *flag.FlagSet) *httpServerLimits {
+	limits := &httpServerLimits{}
+	fs.DurationVar(&limits.ReadHeaderTimeout, "read-header-timeout", 5*time.Second, "Max time to read request headers")
+	fs.DurationVar(&limits.ReadTimeout, "read-timeout", 15*time.Second, "Max time to read the full request")
+	fs.DurationVar(&limits.WriteTimeout, "write-timeout", 30*time.Second, "Max time to write the response")
+	fs.DurationVar(&limits.IdleTimeout, "idle-timeout", 60*time.Second, "Max keep-alive idle time between requests")
+	fs.IntVar(&limits.MaxHeaderBytes, "max-header-bytes", 1<<20, "Max request header size in bytes")
+	return limits
+}
+
+func newHTTPServer(addr string, handler http.Handler, limits httpServerLimits) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: limits.ReadHeaderTimeout,
+		ReadTimeout:       limits.ReadTimeout,
+		WriteTimeout:      limits.WriteTimeout,
+		IdleTimeout:       limits.IdleTimeout,
+		MaxHeaderBytes:    limits.MaxHeaderBytes,
+	}
+}
+
+type serverConfig struct {
+	Table            string
+	Cols             []string
+	IDCol            string
+	SitemapChunkSize int
+	SearchPageSize   int
+	MaxSearchPage    int
+	BudgetMaxPrice   float64
+	SearchMinChars   int
+	FTSTable         string
+	SimilarLimit     int
+	SimilarMode      string
+	SimilarFallback  bool
+	HideUnavailable  bool
+	CompactJSON      bool
+	CORSOrigin       string
+	CacheTTL         time.Duration
+	AdminAuth        adminAuth
+	BaseURL          baseURLPolicy
+}
+
+func splitCommaList(s string) []string {
+	var out []string
+	for _, part := range strings.Split(s, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			out = append(out, part)
+		}
+	}
+	return out
+}
+
+func newServerMux(db *sql.DB, cfg serverConfig, metrics *serverMetrics) http.Handler {
+	table, cols, idCol := cfg.Table, cfg.Cols, cfg.IDCol
+	minChars := cfg.SearchMinChars
+	if minChars <= 0 {
+		minChars = defaultSearchMinChars
+	}
+	var cache *pageCache
+	if cfg.CacheTTL > 0 {
+		cache = newPageCache(defaultPageCacheEntries, cfg.CacheTTL)
+	}
 	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics)
 	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 		_, _ = w.Write([]byte("ok"))
 	})
+	mux.HandleFunc("/favicon.ico", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", staticCacheControl)
+		http.ServeFileFS(w, r, staticFiles, "static/favicon.ico")
+	})
+	mux.Handle("/static/", withStaticCaching(http.FileServerFS(staticFiles)))
 	mux.HandleFunc("/sitemap.xml", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodGet {
 			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 			return
 		}
-		total, err := countNonEmptyIDs(db, table, *idCol)
+		total, err := countNonEmptyIDs(db, table, idCol)
 		if err != nil {
 			http.Error(w, "internal error", http.StatusInternalServerError)
-			log.Printf("sitemap count error: %v", err)
+			metrics.observeDBError("sitemap_count")
+			logRequestf(r, "sitemap count error: %v", err)
 			return
 		}
-		baseURL := requestBaseURL(r)
-		payload := buildSitemapIndexXML(baseURL, total, *sitemapChunkSize)
+		baseURL := cfg.BaseURL.requestBaseURL(r)
+		payload := buildSitemapIndexXML(baseURL, total, cfg.SitemapChunkSize)
 		writeXML(w, payload)
 	})
 	mux.HandleFunc("/sitemaps/", func(w http.ResponseWriter, r *http.Request) {
@@ -103,51 +350,64 @@ func main() {
 			http.NotFound(w, r)
 			return
 		}
-		total, err := countNonEmptyIDs(db, table, *idCol)
+		total, err := countNonEmptyIDs(db, table, idCol)
 		if err != nil {
 			http.Error(w, "internal error", http.StatusInternalServerError)
-			log.Printf("sitemap count error: %v", err)
+			metrics.observeDBError("sitemap_count")
+			logRequestf(r, "sitemap count error: %v", err)
 			return
 		}
 		if total == 0 {
 			http.NotFound(w, r)
 			return
 		}
-		pageCount := (total + *sitemapChunkSize - 1) / *sitemapChunkSize
+		pageCount := (total + cfg.SitemapChunkSize - 1) / cfg.SitemapChunkSize
 		if pageNum < 1 || pageNum > pageCount {
 			http.NotFound(w, r)
 			return
 		}
-		offset := (pageNum - 1) * *sitemapChunkSize
-		ids, err := fetchProductIDsPage(db, table, *idCol, *sitemapChunkSize, offset)
+		offset := (pageNum - 1) * cfg.SitemapChunkSize
+		slugCol := ""
+		if contains(cols, "slug") {
+			slugCol = "slug"
+		}
+		products, err := fetchProductIDsPage(db, table, idCol, sitemapImageColumn(cols), slugCol, slugOwnerOrder(cols, "o."), cfg.SitemapChunkSize, offset)
 		if err != nil {
 			http.Error(w, "internal error", http.StatusInternalServerError)
-			log.Printf("sitemap page error: %v", err)
+			metrics.observeDBError("sitemap_page")
+			logRequestf(r, "sitemap page error: %v", err)
 			return
 		}
-		baseURL := requestBaseURL(r)
-		payload := buildProductURLSetXML(baseURL, ids)
+		baseURL := cfg.BaseURL.requestBaseURL(r)
+		payload := buildProductURLSetXML(baseURL, products)
 		writeXML(w, payload)
 	})
-	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc("/", cache.wrap(func(w http.ResponseWriter, r *http.Request) {
 		if r.URL.Path != "/" {
-			http.NotFound(w, r)
+			renderNotFound(w, r, minChars)
 			return
 		}
-		payload, err := fetchHomePayload(db, table)
+		payload, err := fetchHomePayload(db, table, cols, idCol, cfg.BudgetMaxPrice, cfg.HideUnavailable)
 		if err != nil {
 			http.Error(w, "internal error", http.StatusInternalServerError)
-			log.Printf("home payload error: %v", err)
+			metrics.observeDBError("home")
+			logRequestf(r, "home payload error: %v", err)
+			return
+		}
+		w.Header().Add("Vary", "Accept")
+		if wantsJSON(r) {
+			writeJSON(w, payload, cfg.CompactJSON)
 			return
 		}
 		w.Header().Set("Content-Type", "text/html; charset=utf-8")
 		if err := homePageTemplate.Execute(w, map[string]any{
-			"title":         "dimi",
-			"sections_html": renderHomeSectionsHTML(payload),
+			"title":            "dimi",
+			"sections_html":    renderHomeSectionsHTML(payload),
+			"search_min_chars": minChars,
 		}); err != nil {
-			log.Printf("template error: %v", err)
+			logRequestf(r, "template error: %v", err)
 		}
-	})
+	}))
 	mux.HandleFunc("/search", func(w http.ResponseWriter, r *http.Request) {
 		if r.URL.Path != "/search" {
 			http.NotFound(w, r)
@@ -155,29 +415,55 @@ func main() {
 		}
 		q := strings.TrimSpace(r.URL.Query().Get("q"))
 		page := 1
+		perPage := cfg.SearchPageSize
 		var payload *searchPayload
-		var searchErr string
+		// searchErr is shown on the HTML page; errCode and errStatus shape the
+		// ?format=json error like /api/search.
+		var searchErr, errCode string
+		errStatus := http.StatusBadRequest
 		if q != "" {
 			var ok bool
-			if len([]rune(q)) < searchMinChars {
-				searchErr = fmt.Sprintf("query must be at least %d characters", searchMinChars)
+			if utf8.RuneCountInString(normalizeSearchQuery(q)) < minChars {
+				searchErr, errCode = fmt.Sprintf("query must be at least %d characters", minChars), "query_too_short"
 			} else if page, ok = parsePageQueryParam(r, "page", 1); !ok {
-				searchErr = "invalid page"
+				searchErr, errCode = "invalid page", "invalid_page"
+			} else if perPage, ok = parsePerPageQueryParam(r, cfg.SearchPageSize); !ok {
+				searchErr, errCode = "invalid per_page", "invalid_per_page"
+			} else if msg := searchPageTooDeep(page, cfg.MaxSearchPage); msg != "" {
+				searchErr, errCode = msg, "page_too_deep"
 			} else {
-				offset, ok := pageOffset(page, searchPageSize)
+				offset, ok := pageOffset(page, perPage)
 				if !ok {
-					searchErr = "page value is too large"
+					searchErr, errCode = "page value is too large", "page_out_of_range"
 				} else {
-					p, err := fetchSearchPayload(db, table, cols, *idCol, q, page, searchPageSize, offset)
+					p, err := fetchSearchPayload(db, table, cols, idCol, q, page, perPage, offset, cfg.FTSTable, cfg.HideUnavailable)
 					if err != nil {
-						searchErr = "Could not load search results right now."
-						log.Printf("search error: %v", err)
+						searchErr, errCode = "Could not load search results right now.", "internal_error"
+						errStatus = http.StatusInternalServerError
+						metrics.observeDBError("search")
+						logRequestf(r, "search error: %v", err)
 					} else {
+						p.MinQueryLength = minChars
+						p.MaxSearchPage = cfg.MaxSearchPage
 						payload = &p
 					}
 				}
 			}
 		}
+		if payload != nil {
+			setPaginationHeaders(w, r, cfg.BaseURL.requestBaseURL(r), payload)
+		}
+		if wantsJSON(r) {
+			switch {
+			case searchErr != "" && payload == nil:
+				writeJSONError(w, errStatus, errCode, searchErr)
+			case payload == nil:
+				writeJSON(w, searchPayload{Query: q, MinQueryLength: minChars, Page: page, MinPage: 1, PerPage: perPage, Items: []map[string]any{}}, cfg.CompactJSON)
+			default:
+				writeJSON(w, payload, cfg.CompactJSON)
+			}
+			return
+		}
 		w.Header().Set("Content-Type", "text/html; charset=utf-8")
 		if err := searchPageTemplate.Execute(w, map[string]any{
 			"title":              "Search | dimi",
@@ -195,11 +481,127 @@ func main() {
 			"next_page":          searchNextPage(payload),
 			"has_prev":           searchHasPrev(payload),
 			"has_next":           searchHasNext(payload),
+			"per_page":           perPage,
+			"custom_per_page":    perPage != cfg.SearchPageSize,
+			"search_min_chars":   minChars,
 		}); err != nil {
-			log.Printf("template error: %v", err)
+			logRequestf(r, "template error: %v", err)
+		}
+	})
+	mux.HandleFunc("/api/search", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeJSONError(w, http.StatusMethodNotAllowed, "method_not_allowed", "method not allowed")
+			return
+		}
+		q := strings.TrimSpace(r.URL.Query().Get("q"))
+		if utf8.RuneCountInString(normalizeSearchQuery(q)) < minChars {
+			writeJSONError(w, http.StatusBadRequest, "query_too_short", fmt.Sprintf("query must be at least %d characters", minChars))
+			return
+		}
+		page, ok := parsePageQueryParam(r, "page", 1)
+		if !ok {
+			writeJSONError(w, http.StatusBadRequest, "invalid_page", "page must be a positive integer")
+			return
+		}
+		perPage, ok := parsePerPageQueryParam(r, cfg.SearchPageSize)
+		if !ok {
+			writeJSONError(w, http.StatusBadRequest, "invalid_per_page", "per_page must be a positive integer")
+			return
+		}
+		if msg := searchPageTooDeep(page, cfg.MaxSearchPage); msg != "" {
+			writeJSONError(w, http.StatusBadRequest, "page_too_deep", msg)
+			return
+		}
+		offset, ok := pageOffset(page, perPage)
+		if !ok {
+			writeJSONError(w, http.StatusBadRequest, "page_out_of_range", "page value is too large")
+			return
+		}
+		p, err := fetchSearchPayload(db, table, cols, idCol, q, page, perPage, offset, cfg.FTSTable, cfg.HideUnavailable)
+		if err != nil {
+			metrics.observeDBError("search")
+			logRequestf(r, "search error: %v", err)
+			writeJSONError(w, http.StatusInternalServerError, "internal_error", "could not load search results")
+			return
+		}
+		p.MinQueryLength = minChars
+		p.MaxSearchPage = cfg.MaxSearchPage
+		if p.TotalPages > 0 && page > p.TotalPages {
+			writeJSONError(w, http.StatusBadRequest, "page_out_of_range", fmt.Sprintf("page %d is beyond the last page (%d)", page, p.TotalPages))
+			return
+		}
+		if p.Items == nil {
+			p.Items = []map[string]any{}
+		}
+		setPaginationHeaders(w, r, cfg.BaseURL.requestBaseURL(r), &p)
+		writeJSON(w, p, cfg.CompactJSON)
+	})
+	mux.HandleFunc("/api/products", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeJSONError(w, http.StatusMethodNotAllowed, "method_not_allowed", "method not allowed")
+			return
 		}
+		ids := splitCommaList(r.URL.Query().Get("ids"))
+		if len(ids) == 0 {
+			writeJSONError(w, http.StatusBadRequest, "missing_ids", "ids must list at least one product id")
+			return
+		}
+		if len(ids) > bulkMaxIDs {
+			writeJSONError(w, http.StatusBadRequest, "too_many_ids", fmt.Sprintf("at most %d ids per request, got %d", bulkMaxIDs, len(ids)))
+			return
+		}
+		rows, err := fetchByIDs(db, table, cols, idCol, ids)
+		if err != nil {
+			metrics.observeDBError("products")
+			logRequestf(r, "bulk fetch error: %v", err)
+			writeJSONError(w, http.StatusInternalServerError, "internal_error", "could not load products")
+			return
+		}
+		writeJSON(w, rows, cfg.CompactJSON)
+	})
+	mux.HandleFunc("/api/schema", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeJSONError(w, http.StatusMethodNotAllowed, "method_not_allowed", "method not allowed")
+			return
+		}
+		fields, err := tableSchema(db, table)
+		if err != nil {
+			metrics.observeDBError("schema")
+			logRequestf(r, "schema error: %v", err)
+			writeJSONError(w, http.StatusInternalServerError, "internal_error", "could not load schema")
+			return
+		}
+		writeJSON(w, schemaPayload{Table: table, Columns: cols, Fields: fields}, cfg.CompactJSON)
+	})
+	mux.HandleFunc("/p/", func(w http.ResponseWriter, r *http.Request) {
+		slug := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/p/"), "/")
+		if slug == "" {
+			renderNotFound(w, r, minChars)
+			return
+		}
+		row, err := fetchBySlug(db, table, cols, slug)
+		if errors.Is(err, sql.ErrNoRows) {
+			renderNotFound(w, r, minChars)
+			return
+		}
+		if err != nil {
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			metrics.observeDBError("product")
+			logRequestf(r, "slug fetch error: %v", err)
+			return
+		}
+		segment, ok := productPathSegment(getString(row, idCol))
+		if !ok {
+			renderNotFound(w, r, minChars)
+			return
+		}
+		target := "/product/" + segment
+		if r.URL.RawQuery != "" {
+			target += "?" + r.URL.RawQuery
+		}
+		http.Redirect(w, r, target, http.StatusMovedPermanently)
 	})
-	mux.HandleFunc("/product/", func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc("/product/", cache.wrap(func(w http.ResponseWriter, r *http.Request) {
 		id := strings.TrimPrefix(r.URL.Path, "/product/")
 		if id == "" || id == r.URL.Path {
 			http.Error(w, "missing product id", http.StatusBadRequest)
@@ -207,70 +609,769 @@ func main() {
 		}
 		id = strings.TrimSuffix(id, "/")
 
-		row, err := fetchByID(db, table, cols, *idCol, id)
+		row, err := fetchByID(db, table, cols, idCol, id)
 		if errors.Is(err, sql.ErrNoRows) {
-			http.Error(w, "not found", http.StatusNotFound)
+			renderNotFound(w, r, minChars)
 			return
 		}
 		if err != nil {
 			http.Error(w, "internal error", http.StatusInternalServerError)
-			log.Printf("fetch error: %v", err)
+			metrics.observeDBError("product")
+			logRequestf(r, "fetch error: %v", err)
 			return
 		}
-		similar, err := fetchSimilar(db, table, *idCol, id)
+		w.Header().Add("Vary", "Accept")
+		if wantsJSON(r) {
+			writeJSON(w, row, cfg.CompactJSON)
+			return
+		}
+		similarPerPage := similarPageSize(cfg.SimilarLimit)
+		similar, err := fetchSimilarPage(db, table, cols, idCol, id, similarPerPage+1, 0, cfg.SimilarMode)
 		if errors.Is(err, sql.ErrNoRows) {
-			similar = []map[string]any{}
-		} else if err != nil {
+			similar, err = []map[string]any{}, nil
+		}
+		if err == nil && len(similar) == 0 && cfg.SimilarFallback {
+			similar, err = fetchPopularFallback(db, table, cols, idCol, id, similarPerPage, cfg.HideUnavailable)
+		}
+		if err != nil {
 			http.Error(w, "internal error", http.StatusInternalServerError)
-			log.Printf("similar error: %v", err)
+			metrics.observeDBError("similar")
+			logRequestf(r, "similar error: %v", err)
 			return
 		}
+		data := productPageData(id, row, similar)
+		if len(similar) > similarPerPage {
+			data = productPageData(id, row, similar[:similarPerPage])
+			data["similar_more_url"] = "/api/similar/" + url.PathEscape(id)
+		}
+		data["search_min_chars"] = minChars
 
 		w.Header().Set("Content-Type", "text/html; charset=utf-8")
-		if err := productPageTemplate.Execute(w, map[string]any{
-			"id":           id,
-			"name":         firstNonEmpty(getString(row, "name"), getString(row, "title_headline"), "Product "+id),
-			"brand":        firstNonEmpty(getString(row, "brand"), getString(row, "seo_brand"), "Unknown brand"),
-			"price":        firstNonEmpty(getString(row, "price_raw"), getString(row, "price_eur"), getString(row, "metadata_price_eur")),
-			"category":     firstNonEmpty(getString(row, "category_path"), getString(row, "seo_category")),
-			"image":        firstNonEmpty(getString(row, "image"), getString(row, "image_url"), getString(row, "img"), getString(row, "thumbnail")),
-			"desc":         firstNonEmpty(getString(row, "desc_productbeschreibung"), getString(row, "metadata_description")),
-			"rating_html":  renderProductRatingHTML(row),
-			"has_rating":   hasProductRating(row),
-			"details_html": renderAdditionalDetailsTableRowsHTML(row),
-			"has_details":  hasAdditionalDetails(row),
-			"similar_html": renderSimilarCardsHTML(similar),
-			"has_similar":  len(similar) > 0,
-		}); err != nil {
-			log.Printf("template error: %v", err)
+		if err := productPageTemplate.Execute(w, data); err != nil {
+			logRequestf(r, "template error: %v", err)
+		}
+	}))
+	mux.HandleFunc("/api/similar/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeJSONError(w, http.StatusMethodNotAllowed, "method_not_allowed", "method not allowed")
+			return
+		}
+		id := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/api/similar/"), "/")
+		if id == "" {
+			writeJSONError(w, http.StatusBadRequest, "missing_id", "missing product id")
+			return
+		}
+		page, ok := parsePageQueryParam(r, "page", 1)
+		if !ok {
+			writeJSONError(w, http.StatusBadRequest, "invalid_page", "page must be a positive integer")
+			return
+		}
+		perPage := similarPageSize(cfg.SimilarLimit)
+		offset, ok := pageOffset(page, perPage)
+		if !ok {
+			writeJSONError(w, http.StatusBadRequest, "page_out_of_range", "page value is too large")
+			return
+		}
+		items, err := fetchSimilarPage(db, table, cols, idCol, id, perPage+1, offset, cfg.SimilarMode)
+		if errors.Is(err, sql.ErrNoRows) {
+			writeJSONError(w, http.StatusNotFound, "not_found", fmt.Sprintf("product %q not found", id))
+			return
+		}
+		if err != nil {
+			metrics.observeDBError("similar")
+			logRequestf(r, "similar error: %v", err)
+			writeJSONError(w, http.StatusInternalServerError, "internal_error", "could not load similar products")
+			return
+		}
+		if len(items) == 0 && offset == 0 && cfg.SimilarFallback {
+			// The fallback is a single page; later pages stay empty.
+			if items, err = fetchPopularFallback(db, table, cols, idCol, id, perPage, cfg.HideUnavailable); err != nil {
+				metrics.observeDBError("similar")
+				logRequestf(r, "similar fallback error: %v", err)
+				writeJSONError(w, http.StatusInternalServerError, "internal_error", "could not load similar products")
+				return
+			}
+		}
+		p := similarPayload{ID: id, Page: page, PerPage: perPage, Items: items}
+		if len(items) > perPage {
+			p.Items = items[:perPage]
+			p.HasMore = true
+		}
+		if p.Items == nil {
+			p.Items = []map[string]any{}
+		}
+		p.CardsHTML = string(renderSimilarCardsHTML(p.Items))
+		writeJSON(w, p, cfg.CompactJSON)
+	})
+
+	return withRequestID(withAPICORS(withAdminAuth(mux, cfg.AdminAuth), cfg.CORSOrigin))
+}
+
+const defaultPageCacheEntries = 512
+
+// pageCache is a TTL+LRU cache of rendered GET responses keyed by path and
+// response format. The DB is read-only at runtime, so entries only expire.
+type pageCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	max     int
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+type pageCacheEntry struct {
+	key         string
+	body        []byte
+	contentType string
+	etag        string
+	expires     time.Time
+}
+
+func newPageCache(maxEntries int, ttl time.Duration) *pageCache {
+	return &pageCache{
+		ttl:     ttl,
+		max:     maxEntries,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+func (c *pageCache) get(key string) (*pageCacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	e := el.Value.(*pageCacheEntry)
+	if time.Now().After(e.expires) {
+		c.order.Remove(el)
+		delete(c.entries, key)
+		return nil, false
+	}
+	c.order.MoveToFront(el)
+	return e, true
+}
+
+func (c *pageCache) put(e *pageCacheEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[e.key]; ok {
+		el.Value = e
+		c.order.MoveToFront(el)
+		return
+	}
+	c.entries[e.key] = c.order.PushFront(e)
+	for c.order.Len() > c.max {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*pageCacheEntry).key)
+	}
+}
+
+// wrap serves GET requests from the cache when possible and stores successful
+// responses. A nil cache returns next unchanged.
+func (c *pageCache) wrap(next http.HandlerFunc) http.HandlerFunc {
+	if c == nil {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			next(w, r)
+			return
+		}
+		format := "html"
+		if wantsJSON(r) {
+			format = "json"
+		}
+		key := format + " " + r.URL.Path
+		if e, ok := c.get(key); ok {
+			writeCachedPage(w, r, e)
+			return
+		}
+		rec := &bufferedResponseWriter{header: make(http.Header)}
+		next(rec, r)
+		if rec.status != http.StatusOK {
+			rec.flushTo(w)
+			return
+		}
+		sum := sha256.Sum256(rec.body.Bytes())
+		e := &pageCacheEntry{
+			key:         key,
+			body:        rec.body.Bytes(),
+			contentType: rec.header.Get("Content-Type"),
+			etag:        `"` + hex.EncodeToString(sum[:8]) + `"`,
+			expires:     time.Now().Add(c.ttl),
+		}
+		c.put(e)
+		writeCachedPage(w, r, e)
+	}
+}
+
+func writeCachedPage(w http.ResponseWriter, r *http.Request, e *pageCacheEntry) {
+	h := w.Header()
+	h.Set("Content-Type", e.contentType)
+	h.Set("ETag", e.etag)
+	h.Add("Vary", "Accept")
+	if match := r.Header.Get("If-None-Match"); match != "" && match == e.etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	_, _ = w.Write(e.body)
+}
+
+// bufferedResponseWriter captures a handler's response so it can be cached
+// before being sent.
+type bufferedResponseWriter struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (b *bufferedResponseWriter) Header() http.Header { return b.header }
+
+func (b *bufferedResponseWriter) WriteHeader(code int) {
+	if b.status == 0 {
+		b.status = code
+	}
+}
+
+func (b *bufferedResponseWriter) Write(p []byte) (int, error) {
+	if b.status == 0 {
+		b.status = http.StatusOK
+	}
+	return b.body.Write(p)
+}
+
+func (b *bufferedResponseWriter) flushTo(w http.ResponseWriter) {
+	for k, v := range b.header {
+		w.Header()[k] = v
+	}
+	if b.status == 0 {
+		b.status = http.StatusOK
+	}
+	w.WriteHeader(b.status)
+	_, _ = w.Write(b.body.Bytes())
+}
+
+// withAPICORS adds CORS headers to /api/ responses and answers their OPTIONS
+// preflights when origin is set. Other routes are passed through untouched.
+func withAPICORS(next http.Handler, origin string) http.Handler {
+	if origin == "" {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, "/api/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		h := w.Header()
+		h.Set("Access-Control-Allow-Origin", origin)
+		h.Add("Vary", "Origin")
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			h.Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+			allowHeaders := strings.TrimSpace(r.Header.Get("Access-Control-Request-Headers"))
+			if allowHeaders == "" {
+				allowHeaders = "Accept, Content-Type"
+			}
+			h.Set("Access-Control-Allow-Headers", allowHeaders)
+			h.Set("Access-Control-Max-Age", "600")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// adminAuth holds the optional basic auth credentials guarding /metrics and,
+// with ProtectAPI, the /api/ routes. /api/similar/ stays public since product
+// pages fetch it from the "Show more" button. An empty User disables it.
+type adminAuth struct {
+	User       string
+	Pass       string
+	ProtectAPI bool
+}
+
+func (a adminAuth) protects(path string) bool {
+	if a.User == "" {
+		return false
+	}
+	if path == "/metrics" {
+		return true
+	}
+	return a.ProtectAPI && strings.HasPrefix(path, "/api/") && !strings.HasPrefix(path, "/api/similar/")
+}
+
+// allows compares the request's basic auth credentials in constant time.
+func (a adminAuth) allows(r *http.Request) bool {
+	user, pass, ok := r.BasicAuth()
+	if !ok {
+		return false
+	}
+	userOK := subtle.ConstantTimeCompare([]byte(user), []byte(a.User)) == 1
+	passOK := subtle.ConstantTimeCompare([]byte(pass), []byte(a.Pass)) == 1
+	return userOK && passOK
+}
+
+// withAdminAuth answers 401 with a WWW-Authenticate challenge for protected
+// routes unless the request carries the admin credentials.
+func withAdminAuth(next http.Handler, auth adminAuth) http.Handler {
+	if auth.User == "" {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if auth.protects(r.URL.Path) && !auth.allows(r) {
+			w.Header().Set("WWW-Authenticate", `Basic realm="medium-server-2", charset="UTF-8"`)
+			if strings.HasPrefix(r.URL.Path, "/api/") {
+				writeJSONError(w, http.StatusUnauthorized, "unauthorized", "unauthorized")
+				return
+			}
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// loggingResponseWriter records the status code and body size written by a
+// handler so they can be logged once the request completes.
+type loggingResponseWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (lw *loggingResponseWriter) WriteHeader(code int) {
+	if lw.status == 0 {
+		lw.status = code
+	}
+	lw.ResponseWriter.WriteHeader(code)
+}
+
+func (lw *loggingResponseWriter) Write(b []byte) (int, error) {
+	if lw.status == 0 {
+		lw.status = http.StatusOK
+	}
+	n, err := lw.ResponseWriter.Write(b)
+	lw.bytes += n
+	return n, err
+}
+
+type requestLogEntry struct {
+	Method     string  `json:"method"`
+	Path       string  `json:"path"`
+	Status     int     `json:"status"`
+	Bytes      int     `json:"bytes"`
+	DurationMS float64 `json:"duration_ms"`
+	RequestID  string  `json:"request_id,omitempty"`
+}
+
+// staticFiles holds the favicon and any other assets served under /static/.
+//
+//go:embed static
+var staticFiles embed.FS
+
+const staticCacheControl = "public, max-age=86400"
+
+func withStaticCaching(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", staticCacheControl)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// withRequestLogging logs one line per request and, when metrics is non-nil,
+// records it there as well.
+func withRequestLogging(next http.Handler, logger *log.Logger, format string, metrics *serverMetrics) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		lw := &loggingResponseWriter{ResponseWriter: w}
+		next.ServeHTTP(lw, r)
+		if lw.status == 0 {
+			lw.status = http.StatusOK
+		}
+		elapsed := time.Since(start)
+		if metrics != nil {
+			metrics.observeRequest(r.URL.Path, lw.status, elapsed)
+		}
+		entry := requestLogEntry{
+			Method:     r.Method,
+			Path:       r.URL.Path,
+			Status:     lw.status,
+			Bytes:      lw.bytes,
+			DurationMS: float64(elapsed.Microseconds()) / 1000,
+			RequestID:  lw.Header().Get(requestIDHeader),
+		}
+		if format == "json" {
+			b, _ := json.Marshal(entry)
+			logger.Print(string(b))
+			return
+		}
+		if entry.RequestID != "" {
+			logger.Printf("method=%s path=%q status=%d bytes=%d duration_ms=%.3f request_id=%s", entry.Method, entry.Path, entry.Status, entry.Bytes, entry.DurationMS, entry.RequestID)
+			return
+		}
+		logger.Printf("method=%s path=%q status=%d bytes=%d duration_ms=%.3f", entry.Method, entry.Path, entry.Status, entry.Bytes, entry.DurationMS)
+	})
+}
+
+const (
+	requestIDHeader    = "X-Request-ID"
+	maxRequestIDLength = 128
+)
+
+type requestIDKey struct{}
+
+// withRequestID reuses a client-supplied X-Request-ID (when short and
+// printable) or generates one, stores it in the request context and echoes it
+// in the response header.
+func withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := strings.TrimSpace(r.Header.Get(requestIDHeader))
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(requestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+	})
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 16)
+	}
+	return hex.EncodeToString(b[:])
+}
+
+// requestIDFrom returns the ID stored by withRequestID, or "".
+func requestIDFrom(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// logRequestf is log.Printf with the request's ID prepended, so handler error
+// lines can be matched to the client request and its access log line.
+func logRequestf(r *http.Request, format string, args ...any) {
+	if id := requestIDFrom(r.Context()); id != "" {
+		log.Printf("request_id=%s "+format, append([]any{id}, args...)...)
+		return
+	}
+	log.Printf(format, args...)
+}
+
+var requestDurationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5}
+
+type requestMetricKey struct {
+	pathClass string
+	status    int
+}
+
+// serverMetrics holds the counters exposed at /metrics in the Prometheus text
+// exposition format.
+type serverMetrics struct {
+	mu       sync.Mutex
+	requests map[requestMetricKey]uint64
+	dbErrors map[string]uint64
+
+	durationBuckets []atomic.Uint64
+	durationCount   atomic.Uint64
+	durationSumUS   atomic.Uint64
+}
+
+func newServerMetrics() *serverMetrics {
+	return &serverMetrics{
+		requests:        make(map[requestMetricKey]uint64),
+		dbErrors:        make(map[string]uint64),
+		durationBuckets: make([]atomic.Uint64, len(requestDurationBuckets)),
+	}
+}
+
+func metricsPathClass(path string) string {
+	switch {
+	case path == "/":
+		return "home"
+	case path == "/search":
+		return "search"
+	case strings.HasPrefix(path, "/product/") || strings.HasPrefix(path, "/p/"):
+		return "product"
+	case path == "/sitemap.xml" || strings.HasPrefix(path, "/sitemaps/"):
+		return "sitemap"
+	case path == "/health":
+		return "health"
+	case path == "/metrics":
+		return "metrics"
+	case strings.HasPrefix(path, "/api/"):
+		return "api"
+	}
+	return "other"
+}
+
+func (m *serverMetrics) observeRequest(path string, status int, elapsed time.Duration) {
+	key := requestMetricKey{pathClass: metricsPathClass(path), status: status}
+	m.mu.Lock()
+	m.requests[key]++
+	m.mu.Unlock()
+
+	secs := elapsed.Seconds()
+	for i, le := range requestDurationBuckets {
+		if secs <= le {
+			m.durationBuckets[i].Add(1)
+		}
+	}
+	m.durationCount.Add(1)
+	m.durationSumUS.Add(uint64(elapsed.Microseconds()))
+}
+
+func (m *serverMetrics) observeDBError(operation string) {
+	m.mu.Lock()
+	m.dbErrors[operation]++
+	m.mu.Unlock()
+}
+
+func (m *serverMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	m.mu.Lock()
+	reqKeys := make([]requestMetricKey, 0, len(m.requests))
+	for k := range m.requests {
+		reqKeys = append(reqKeys, k)
+	}
+	reqCounts := make(map[requestMetricKey]uint64, len(m.requests))
+	for k, v := range m.requests {
+		reqCounts[k] = v
+	}
+	dbOps := make([]string, 0, len(m.dbErrors))
+	dbCounts := make(map[string]uint64, len(m.dbErrors))
+	for k, v := range m.dbErrors {
+		dbOps = append(dbOps, k)
+		dbCounts[k] = v
+	}
+	m.mu.Unlock()
+	sort.Slice(reqKeys, func(i, j int) bool {
+		if reqKeys[i].pathClass != reqKeys[j].pathClass {
+			return reqKeys[i].pathClass < reqKeys[j].pathClass
+		}
+		return reqKeys[i].status < reqKeys[j].status
+	})
+	sort.Strings(dbOps)
+
+	var b strings.Builder
+	b.WriteString("# HELP http_requests_total Total HTTP requests by path class and status code.\n")
+	b.WriteString("# TYPE http_requests_total counter\n")
+	for _, k := range reqKeys {
+		fmt.Fprintf(&b, "http_requests_total{path=%q,status=\"%d\"} %d\n", k.pathClass, k.status, reqCounts[k])
+	}
+	b.WriteString("# HELP http_request_duration_seconds HTTP request latency.\n")
+	b.WriteString("# TYPE http_request_duration_seconds histogram\n")
+	for i, le := range requestDurationBuckets {
+		fmt.Fprintf(&b, "http_request_duration_seconds_bucket{le=\"%s\"} %d\n", strconv.FormatFloat(le, 'g', -1, 64), m.durationBuckets[i].Load())
+	}
+	count := m.durationCount.Load()
+	fmt.Fprintf(&b, "http_request_duration_seconds_bucket{le=\"+Inf\"} %d\n", count)
+	fmt.Fprintf(&b, "http_request_duration_seconds_sum %g\n", float64(m.durationSumUS.Load())/1e6)
+	fmt.Fprintf(&b, "http_request_duration_seconds_count %d\n", count)
+	b.WriteString("# HELP db_query_errors_total Database query errors by operation.\n")
+	b.WriteString("# TYPE db_query_errors_total counter\n")
+	for _, op := range dbOps {
+		fmt.Fprintf(&b, "db_query_errors_total{operation=%q} %d\n", op, dbCounts[op])
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_, _ = w.Write([]byte(b.String()))
+}
+
+func productPageData(id string, row map[string]any, similar []map[string]any) map[string]any {
+	return map[string]any{
+		"id":            id,
+		"name":          firstNonEmpty(getString(row, "name"), getString(row, "title_headline"), "Product "+id),
+		"brand":         firstNonEmpty(getString(row, "brand"), getString(row, "seo_brand"), "Unknown brand"),
+		"price":         firstNonEmpty(getString(row, "price_raw"), getString(row, "price_eur"), getString(row, "metadata_price_eur")),
+		"price_stable":  priceStableSince(row),
+		"unit_price":    formatUnitPrice(row),
+		"category":      firstNonEmpty(getString(row, "category_path"), getString(row, "seo_category")),
+		"image":         firstNonEmpty(getString(row, "image"), getString(row, "image_url"), getString(row, "img"), getString(row, "thumbnail")),
+		"desc":          renderDescription(firstNonEmpty(getString(row, "desc_productbeschreibung"), getString(row, "metadata_description"))),
+		"rating_html":   renderProductRatingHTML(row),
+		"has_rating":    hasProductRating(row),
+		"details_html":  renderAdditionalDetailsTableRowsHTML(row),
+		"has_details":   hasAdditionalDetails(row),
+		"similar_html":  renderSimilarCardsHTML(similar),
+		"has_similar":   len(similar) > 0,
+		"canonical_url": canonicalProductURL(row),
+		"breadcrumbs":   productBreadcrumbs(row),
+	}
+}
+
+// canonicalProductURL returns the first absolute http(s) URL among the
+// canonical_url, metadata_canonical and product_url columns, or "".
+func canonicalProductURL(row map[string]any) string {
+	for _, col := range []string{"canonical_url", "metadata_canonical", "product_url"} {
+		raw := strings.TrimSpace(getString(row, col))
+		if raw == "" {
+			continue
+		}
+		u, err := url.Parse(raw)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			continue
+		}
+		return u.String()
+	}
+	return ""
+}
+
+// breadcrumbLink is one level of the product page breadcrumb trail.
+type breadcrumbLink struct {
+	Label string
+	Href  string
+}
+
+// productBreadcrumbs builds one link per non-empty breadcrumb_1..4 level (or
+// breadcrumbs_path segment when those are missing), each pointing at the
+// cumulative /category/{path} up to that level.
+func productBreadcrumbs(row map[string]any) []breadcrumbLink {
+	var labels []string
+	for _, col := range []string{"breadcrumb_1", "breadcrumb_2", "breadcrumb_3", "breadcrumb_4"} {
+		if s := strings.TrimSpace(getString(row, col)); s != "" {
+			labels = append(labels, s)
+		}
+	}
+	if len(labels) == 0 {
+		for _, part := range strings.Split(getString(row, "breadcrumbs_path"), ">") {
+			if s := strings.TrimSpace(part); s != "" {
+				labels = append(labels, s)
+			}
+		}
+	}
+	out := make([]breadcrumbLink, 0, len(labels))
+	path := ""
+	for _, label := range labels {
+		path += "/" + url.PathEscape(label)
+		out = append(out, breadcrumbLink{Label: label, Href: "/category" + path})
+	}
+	return out
+}
+
+// renderDescription escapes each line of a newline-joined description and
+// joins the lines with <br> so paragraphs survive without allowing markup.
+func renderDescription(raw string) template.HTML {
+	var lines []string
+	for _, line := range strings.Split(strings.ReplaceAll(raw, "\r\n", "\n"), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, template.HTMLEscapeString(line))
+		}
+	}
+	return template.HTML(strings.Join(lines, "<br>"))
+}
+
+type sitemapIndexXML struct {
+	XMLName xml.Name        `xml:"sitemapindex"`
+	Xmlns   string          `xml:"xmlns,attr"`
+	Items   []sitemapRefXML `xml:"sitemap"`
+}
+
+type sitemapRefXML struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod,omitempty"`
+}
+
+type urlSetXML struct {
+	XMLName    xml.Name     `xml:"urlset"`
+	Xmlns      string       `xml:"xmlns,attr"`
+	XmlnsImage string       `xml:"xmlns:image,attr,omitempty"`
+	Items      []urlItemXML `xml:"url"`
+}
+
+type urlItemXML struct {
+	Loc    string          `xml:"loc"`
+	Images []imageEntryXML `xml:"image:image,omitempty"`
+}
+
+type imageEntryXML struct {
+	Loc string `xml:"image:loc"`
+}
+
+// sitemapProduct is one product entry in a product sitemap page. Slug, when
+// set, is used for the loc instead of the id.
+type sitemapProduct struct {
+	ID    string
+	Image string
+	Slug  string
+}
+
+// wantsJSON reports whether the client asked for JSON via ?format=json or an
+// Accept header; an explicit format parameter always wins.
+func wantsJSON(r *http.Request) bool {
+	if format := strings.TrimSpace(r.URL.Query().Get("format")); format != "" {
+		return strings.EqualFold(format, "json")
+	}
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType := strings.TrimSpace(strings.SplitN(part, ";", 2)[0])
+		if strings.EqualFold(mediaType, "application/json") {
+			return true
 		}
-	})
+	}
+	return false
+}
 
-	log.Printf("medium-server-2 listening on %s (table=%s id=%s)", *addr, table, *idCol)
-	if err := http.ListenAndServe(*addr, mux); err != nil {
-		log.Fatalf("server error: %v", err)
+// availabilityFilterSuffix is availabilityCondition as an " AND ..." suffix,
+// or "" when the filter is off.
+func availabilityFilterSuffix(prefix string, hideUnavailable bool) string {
+	if cond := availabilityCondition(prefix, hideUnavailable); cond != "" {
+		return " AND " + cond
 	}
+	return ""
 }
 
-type sitemapIndexXML struct {
-	XMLName xml.Name        `xml:"sitemapindex"`
-	Xmlns   string          `xml:"xmlns,attr"`
-	Items   []sitemapRefXML `xml:"sitemap"`
+// availabilityCondition returns the SQL condition for -hide-unavailable
+// (serverConfig.HideUnavailable, dropping products with available = 0) with
+// prefix (e.g. "t.") on the column, or "" when the filter is off.
+func availabilityCondition(prefix string, hideUnavailable bool) string {
+	if !hideUnavailable {
+		return ""
+	}
+	return fmt.Sprintf("(%savailable = 1 OR %savailable IS NULL)", prefix, prefix)
 }
 
-type sitemapRefXML struct {
-	Loc     string `xml:"loc"`
-	LastMod string `xml:"lastmod,omitempty"`
+// writeJSON encodes v indented, or minified with compact (-compact-json) for
+// machine consumers.
+func writeJSON(w http.ResponseWriter, v any, compact bool) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	if !compact {
+		enc.SetIndent("", "  ")
+	}
+	if err := enc.Encode(v); err != nil {
+		log.Printf("encode error: %v", err)
+	}
 }
 
-type urlSetXML struct {
-	XMLName xml.Name     `xml:"urlset"`
-	Xmlns   string       `xml:"xmlns,attr"`
-	Items   []urlItemXML `xml:"url"`
+type jsonError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
 }
 
-type urlItemXML struct {
-	Loc string `xml:"loc"`
+// writeJSONError writes {"error":{"code":...,"message":...}} with status.
+func writeJSONError(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(map[string]jsonError{"error": {Code: code, Message: message}}); err != nil {
+		log.Printf("encode error: %v", err)
+	}
 }
 
 func writeXML(w http.ResponseWriter, v any) {
@@ -311,36 +1412,196 @@ func buildSitemapIndexXML(baseURL string, total, chunkSize int) sitemapIndexXML
 	}
 }
 
-func buildProductURLSetXML(baseURL string, ids []string) urlSetXML {
-	items := make([]urlItemXML, 0, len(ids))
-	for _, id := range ids {
-		items = append(items, urlItemXML{
-			Loc: fmt.Sprintf("%s/product/%s", baseURL, id),
-		})
+// buildProductURLSetXML renders one sitemap file. Products beyond the
+// protocol's 50000-URL limit are dropped with a log line; the handlers never
+// pass more than -sitemap-chunk-size (itself capped at that limit).
+func buildProductURLSetXML(baseURL string, products []sitemapProduct) urlSetXML {
+	if len(products) > sitemapProtocolMaxURLs {
+		log.Printf("sitemap: %d products exceed the %d URLs allowed per file; dropping %d", len(products), sitemapProtocolMaxURLs, len(products)-sitemapProtocolMaxURLs)
+		products = products[:sitemapProtocolMaxURLs]
+	}
+	items := make([]urlItemXML, 0, len(products))
+	hasImages := false
+	for _, p := range products {
+		var loc string
+		if slugSegment, ok := productPathSegment(p.Slug); ok {
+			loc = fmt.Sprintf("%s/p/%s", baseURL, slugSegment)
+		} else if segment, ok := productPathSegment(p.ID); ok {
+			loc = fmt.Sprintf("%s/product/%s", baseURL, segment)
+		} else {
+			continue
+		}
+		item := urlItemXML{Loc: loc}
+		if img := absoluteImageURL(baseURL, p.Image); img != "" {
+			item.Images = []imageEntryXML{{Loc: img}}
+			hasImages = true
+		}
+		items = append(items, item)
 	}
-	return urlSetXML{
+	out := urlSetXML{
 		Xmlns: "http://www.sitemaps.org/schemas/sitemap/0.9",
 		Items: items,
 	}
+	if hasImages {
+		out.XmlnsImage = "http://www.google.com/schemas/sitemap-image/1.1"
+	}
+	return out
 }
 
-func requestBaseURL(r *http.Request) string {
-	scheme := "http"
-	if proto := strings.TrimSpace(r.Header.Get("X-Forwarded-Proto")); proto != "" {
-		if i := strings.Index(proto, ","); i >= 0 {
-			proto = proto[:i]
+// productPathSegment percent-encodes id for use as a single URL path segment
+// via url.QueryEscape, with spaces as %20. Unlike JS encodeURIComponent it also
+// escapes !'()*; both forms decode to the same id. Ids that are blank, not
+// valid UTF-8 or contain control characters are rejected.
+func productPathSegment(id string) (string, bool) {
+	if strings.TrimSpace(id) == "" || !utf8.ValidString(id) {
+		return "", false
+	}
+	for _, r := range id {
+		if unicode.IsControl(r) {
+			return "", false
+		}
+	}
+	return strings.ReplaceAll(url.QueryEscape(id), "+", "%20"), true
+}
+
+func absoluteImageURL(baseURL, src string) string {
+	src = strings.TrimSpace(src)
+	switch {
+	case strings.HasPrefix(src, "https://") || strings.HasPrefix(src, "http://"):
+		return src
+	case strings.HasPrefix(src, "//"):
+		return "https:" + src
+	case strings.HasPrefix(src, "/"):
+		return baseURL + src
+	}
+	return ""
+}
+
+// sitemapImageColumn picks the first image-like column the table has, or "".
+func sitemapImageColumn(cols []string) string {
+	for _, c := range []string{"image", "image_url", "img"} {
+		if contains(cols, c) {
+			return c
+		}
+	}
+	return ""
+}
+
+var reHostPort = regexp.MustCompile(`^(\[[0-9A-Fa-f:.]+\]|[A-Za-z0-9](?:[A-Za-z0-9.-]*[A-Za-z0-9])?)(:[0-9]{1,5})?$`)
+
+// baseURLPolicy decides which scheme and host absolute URLs (sitemaps, Link
+// headers) are built from. Forwarded headers are only honored when
+// TrustForwarded is set or, with TrustedProxies, when the peer address is in
+// one of those ranges; hosts must be well-formed and, when AllowedHosts is
+// non-empty, listed there. FallbackHost is used for anything rejected.
+type baseURLPolicy struct {
+	TrustForwarded bool
+	TrustedProxies []netip.Prefix
+	AllowedHosts   []string
+	FallbackHost   string
+}
+
+// trustsForwarded reports whether r's X-Forwarded-* headers may be used. A
+// non-empty TrustedProxies list takes precedence over TrustForwarded.
+func (p baseURLPolicy) trustsForwarded(r *http.Request) bool {
+	if len(p.TrustedProxies) == 0 {
+		return p.TrustForwarded
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range p.TrustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseTrustedProxies parses the -trusted-proxies list. Bare addresses are
+// taken as single-host ranges.
+func parseTrustedProxies(s string) ([]netip.Prefix, error) {
+	var out []netip.Prefix
+	for _, part := range splitCommaList(s) {
+		if !strings.Contains(part, "/") {
+			addr, err := netip.ParseAddr(part)
+			if err != nil {
+				return nil, fmt.Errorf("%q is not an IP address or CIDR", part)
+			}
+			addr = addr.Unmap()
+			out = append(out, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(part)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not an IP address or CIDR", part)
 		}
-		scheme = strings.TrimSpace(proto)
-	} else if r.TLS != nil {
+		if prefix.Addr().Is4In6() && prefix.Bits() >= 96 {
+			prefix = netip.PrefixFrom(prefix.Addr().Unmap(), prefix.Bits()-96)
+		}
+		out = append(out, prefix.Masked())
+	}
+	return out, nil
+}
+
+func (p baseURLPolicy) requestBaseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
 		scheme = "https"
 	}
 	host := r.Host
-	if host == "" {
-		host = "127.0.0.1:8080"
+	if p.trustsForwarded(r) {
+		if proto := strings.ToLower(firstForwardedValue(r.Header.Get("X-Forwarded-Proto"))); proto == "http" || proto == "https" {
+			scheme = proto
+		}
+		if fwdHost := firstForwardedValue(r.Header.Get("X-Forwarded-Host")); fwdHost != "" {
+			host = fwdHost
+		}
+	}
+	if !p.hostAllowed(host) {
+		host = p.fallbackHost()
 	}
 	return scheme + "://" + host
 }
 
+func (p baseURLPolicy) hostAllowed(host string) bool {
+	if !reHostPort.MatchString(host) {
+		return false
+	}
+	if len(p.AllowedHosts) == 0 {
+		return true
+	}
+	for _, h := range p.AllowedHosts {
+		if strings.EqualFold(h, host) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p baseURLPolicy) fallbackHost() string {
+	if len(p.AllowedHosts) > 0 {
+		return p.AllowedHosts[0]
+	}
+	if reHostPort.MatchString(p.FallbackHost) {
+		return p.FallbackHost
+	}
+	return defaultAddr
+}
+
+func firstForwardedValue(v string) string {
+	if i := strings.Index(v, ","); i >= 0 {
+		v = v[:i]
+	}
+	return strings.TrimSpace(v)
+}
+
 func parseProductSitemapPage(path string) (int, bool) {
 	const prefix = "/sitemaps/products-"
 	const suffix = ".xml"
@@ -376,16 +1637,35 @@ func countNonEmptyIDs(db *sql.DB, table, idCol string) (int, error) {
 	return n, nil
 }
 
-func fetchProductIDsPage(db *sql.DB, table, idCol string, limit, offset int) ([]string, error) {
+// fetchProductIDsPage returns one sitemap page of ids, plus each row's image
+// and slug when imageCol/slugCol are non-empty. A slug is only returned for
+// the row /p/{slug} resolves to (ordered by slugOrder, see slugOwnerOrder);
+// other rows sharing it get no slug and are listed under /product/{id}.
+func fetchProductIDsPage(db *sql.DB, table, idCol, imageCol, slugCol, slugOrder string, limit, offset int) ([]sitemapProduct, error) {
 	if limit <= 0 {
 		limit = defaultSitemapChunkSize
 	}
+	if limit > sitemapProtocolMaxURLs {
+		limit = sitemapProtocolMaxURLs
+	}
+	imageSelect, slugSelect := "NULL", "NULL"
+	if imageCol != "" {
+		imageSelect = "t." + quoteIdent(imageCol)
+	}
+	if slugCol != "" {
+		slugSelect = fmt.Sprintf(
+			`CASE WHEN t.rowid = (SELECT o.rowid FROM %s AS o WHERE o.%s = t.%s ORDER BY %s LIMIT 1) THEN t.%s END`,
+			quoteIdent(table), quoteIdent(slugCol), quoteIdent(slugCol), slugOrder, quoteIdent(slugCol),
+		)
+	}
 	q := fmt.Sprintf(
-		`SELECT %s FROM %s
-		 WHERE %s IS NOT NULL AND TRIM(CAST(%s AS TEXT)) != ''
-		 ORDER BY %s
+		`SELECT t.%s, %s, %s FROM %s AS t
+		 WHERE t.%s IS NOT NULL AND TRIM(CAST(t.%s AS TEXT)) != ''
+		 ORDER BY t.%s
 		 LIMIT ? OFFSET ?`,
 		quoteIdent(idCol),
+		imageSelect,
+		slugSelect,
 		quoteIdent(table),
 		quoteIdent(idCol),
 		quoteIdent(idCol),
@@ -397,17 +1677,18 @@ func fetchProductIDsPage(db *sql.DB, table, idCol string, limit, offset int) ([]
 	}
 	defer rows.Close()
 
-	out := make([]string, 0, limit)
+	out := make([]sitemapProduct, 0, limit)
 	for rows.Next() {
 		var v any
-		if err := rows.Scan(&v); err != nil {
+		var img, slug sql.NullString
+		if err := rows.Scan(&v, &img, &slug); err != nil {
 			return nil, err
 		}
 		s := strings.TrimSpace(fmt.Sprint(normalizeValue(v)))
 		if s == "" || s == "<nil>" {
 			continue
 		}
-		out = append(out, s)
+		out = append(out, sitemapProduct{ID: s, Image: img.String, Slug: strings.TrimSpace(slug.String)})
 	}
 	if err := rows.Err(); err != nil {
 		return nil, err
@@ -415,16 +1696,71 @@ func fetchProductIDsPage(db *sql.DB, table, idCol string, limit, offset int) ([]
 	return out, nil
 }
 
-func firstUserTable(db *sql.DB) (string, error) {
-	const q = `SELECT name FROM sqlite_master WHERE type='table' AND name NOT LIKE 'sqlite_%' ORDER BY name LIMIT 1`
-	var name string
-	if err := db.QueryRow(q).Scan(&name); err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return "", fmt.Errorf("no user tables found")
+// retryWithBackoff calls fn up to retries+1 times, doubling the delay after
+// each busy or locked failure. It returns nil on the first success, else the
+// first non-transient error (e.g. a missing table) or the last error.
+func retryWithBackoff(retries int, fn func() error) error {
+	delay := dbRetryBaseDelay
+	for attempt := 0; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= retries || !isTransientSQLiteError(err) {
+			return err
+		}
+		log.Printf("sqlite not ready (attempt %d/%d): %v; retrying in %s", attempt+1, retries+1, err, delay)
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// isTransientSQLiteError reports whether err is SQLITE_BUSY or SQLITE_LOCKED,
+// the only failures worth waiting out at startup.
+func isTransientSQLiteError(err error) bool {
+	var serr *sqlite.Error
+	if errors.As(err, &serr) {
+		code := serr.Code() & 0xff
+		return code == sqlite3.SQLITE_BUSY || code == sqlite3.SQLITE_LOCKED
+	}
+	return strings.Contains(err.Error(), "database is locked") || strings.Contains(err.Error(), "database table is locked")
+}
+
+func userTables(db *sql.DB) ([]string, error) {
+	const q = `SELECT name FROM sqlite_master WHERE type='table' AND name NOT LIKE 'sqlite_%' ORDER BY name`
+	rows, err := db.Query(q)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
 		}
+		names = append(names, name)
+	}
+	return names, rows.Err()
+}
+
+// resolveTable returns the requested table after checking it exists, or the
+// alphabetically-first user table when none was requested.
+func resolveTable(db *sql.DB, requested string) (string, error) {
+	tables, err := userTables(db)
+	if err != nil {
 		return "", err
 	}
-	return name, nil
+	if len(tables) == 0 {
+		return "", fmt.Errorf("no user tables found")
+	}
+	if requested != "" {
+		if !contains(tables, requested) {
+			return "", fmt.Errorf("table %q not found (available tables: %s)", requested, strings.Join(tables, ", "))
+		}
+		return requested, nil
+	}
+	if len(tables) > 1 {
+		log.Printf("warning: no -table given, using %q (available tables: %s)", tables[0], strings.Join(tables, ", "))
+	}
+	return tables[0], nil
 }
 
 func tableColumns(db *sql.DB, table string) ([]string, error) {
@@ -456,6 +1792,75 @@ func tableColumns(db *sql.DB, table string) ([]string, error) {
 	return cols, nil
 }
 
+type schemaPayload struct {
+	Table   string         `json:"table"`
+	Columns []string       `json:"columns"`
+	Fields  []schemaColumn `json:"fields"`
+}
+
+type schemaColumn struct {
+	Name       string `json:"name"`
+	Type       string `json:"type"`
+	Affinity   string `json:"affinity"`
+	NotNull    bool   `json:"not_null"`
+	PrimaryKey bool   `json:"primary_key"`
+}
+
+// tableSchema walks PRAGMA table_info like tableColumns, keeping the declared
+// type and the SQLite column affinity derived from it.
+func tableSchema(db *sql.DB, table string) ([]schemaColumn, error) {
+	q := fmt.Sprintf("PRAGMA table_info(%s)", quoteIdent(table))
+	rows, err := db.Query(q)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var fields []schemaColumn
+	for rows.Next() {
+		var cid int
+		var name, ctype string
+		var notnull int
+		var dflt sql.NullString
+		var pk int
+		if err := rows.Scan(&cid, &name, &ctype, &notnull, &dflt, &pk); err != nil {
+			return nil, err
+		}
+		fields = append(fields, schemaColumn{
+			Name:       name,
+			Type:       ctype,
+			Affinity:   sqliteAffinity(ctype),
+			NotNull:    notnull != 0,
+			PrimaryKey: pk != 0,
+		})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("no columns found for table %q", table)
+	}
+	return fields, nil
+}
+
+// sqliteAffinity applies SQLite's column affinity rules (section 3.1 of the
+// datatype docs) to a declared column type.
+func sqliteAffinity(declared string) string {
+	t := strings.ToUpper(declared)
+	switch {
+	case strings.Contains(t, "INT"):
+		return "INTEGER"
+	case strings.Contains(t, "CHAR"), strings.Contains(t, "CLOB"), strings.Contains(t, "TEXT"):
+		return "TEXT"
+	case t == "" || strings.Contains(t, "BLOB"):
+		return "BLOB"
+	case strings.Contains(t, "REAL"), strings.Contains(t, "FLOA"), strings.Contains(t, "DOUB"):
+		return "REAL"
+	default:
+		return "NUMERIC"
+	}
+}
+
 func fetchByID(db *sql.DB, table string, cols []string, idCol, id string) (map[string]any, error) {
 	q := fmt.Sprintf("SELECT %s FROM %s WHERE %s = ? LIMIT 1", joinIdents(cols), quoteIdent(table), quoteIdent(idCol))
 	row := db.QueryRow(q, id)
@@ -476,7 +1881,104 @@ func fetchByID(db *sql.DB, table string, cols []string, idCol, id string) (map[s
 	return out, nil
 }
 
-func fetchSimilar(db *sql.DB, table, idCol, id string) ([]map[string]any, error) {
+// slugOwnerOrder is the ORDER BY picking the row /p/{slug} resolves to when
+// several share a slug: the newest scrape, then the highest rowid. prefix
+// qualifies the columns (e.g. "o.").
+func slugOwnerOrder(cols []string, prefix string) string {
+	for _, c := range []string{"scraped_at_utc", "scraped_at"} {
+		if contains(cols, c) {
+			return prefix + quoteIdent(c) + " DESC, " + prefix + "rowid DESC"
+		}
+	}
+	return prefix + "rowid DESC"
+}
+
+// fetchBySlug looks a product up by its slug. When several rows share a slug
+// the most recently scraped one wins.
+func fetchBySlug(db *sql.DB, table string, cols []string, slug string) (map[string]any, error) {
+	if !contains(cols, "slug") {
+		return nil, sql.ErrNoRows
+	}
+	q := fmt.Sprintf("SELECT %s FROM %s WHERE slug = ? ORDER BY %s LIMIT 1", joinIdents(cols), quoteIdent(table), slugOwnerOrder(cols, ""))
+	values := make([]any, len(cols))
+	scans := make([]any, len(cols))
+	for i := range values {
+		scans[i] = &values[i]
+	}
+	if err := db.QueryRow(q, slug).Scan(scans...); err != nil {
+		return nil, err
+	}
+	out := make(map[string]any, len(cols))
+	for i, col := range cols {
+		out[col] = normalizeValue(values[i])
+	}
+	return out, nil
+}
+
+// fetchByIDs loads the rows for ids with a single IN query. The result is in
+// the order of ids, with a nil entry for every id that has no row.
+func fetchByIDs(db *sql.DB, table string, cols []string, idCol string, ids []string) ([]map[string]any, error) {
+	out := make([]map[string]any, len(ids))
+	if len(ids) == 0 {
+		return out, nil
+	}
+	placeholders := make([]string, len(ids))
+	args := make([]any, len(ids))
+	for i, id := range ids {
+		placeholders[i] = "?"
+		args[i] = id
+	}
+	q := fmt.Sprintf("SELECT %s FROM %s WHERE %s IN (%s)", joinIdents(cols), quoteIdent(table), quoteIdent(idCol), strings.Join(placeholders, ", "))
+	rows, err := db.Query(q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	byID := make(map[string]map[string]any, len(ids))
+	for rows.Next() {
+		values := make([]any, len(cols))
+		scans := make([]any, len(cols))
+		for i := range values {
+			scans[i] = &values[i]
+		}
+		if err := rows.Scan(scans...); err != nil {
+			return nil, err
+		}
+		row := make(map[string]any, len(cols))
+		for i, col := range cols {
+			row[col] = normalizeValue(values[i])
+		}
+		if id := getString(row, idCol); byID[id] == nil {
+			byID[id] = row
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for i, id := range ids {
+		out[i] = byID[id]
+	}
+	return out, nil
+}
+
+// fetchSimilar returns up to limit products sharing the brand or category of
+// id. In similarModeCategory same-category rows rank first; in
+// similarModeBrandCategory same brand+category ranks above brand-only, which
+// ranks above category-only.
+func fetchSimilar(db *sql.DB, table string, cols []string, idCol, id string, limit int, mode string) ([]map[string]any, error) {
+	return fetchSimilarPage(db, table, cols, idCol, id, limit, 0, mode)
+}
+
+// fetchSimilarPage is fetchSimilar starting at offset; ties are broken by the
+// id column so consecutive pages never overlap.
+func fetchSimilarPage(db *sql.DB, table string, cols []string, idCol, id string, limit, offset int, mode string) ([]map[string]any, error) {
+	if !contains(cols, "brand") || !contains(cols, "category_path") {
+		return []map[string]any{}, nil
+	}
+	if limit <= 0 {
+		limit = defaultSimilarLimit
+	}
 	idColQ := quoteIdent(idCol)
 	tableQ := quoteIdent(table)
 
@@ -492,7 +1994,7 @@ func fetchSimilar(db *sql.DB, table, idCol, id string) ([]map[string]any, error)
 		return []map[string]any{}, nil
 	}
 
-	baseSelect := fmt.Sprintf("SELECT gtin, name, brand, price_eur, currency, category_path, rating_value, rating_count FROM %s WHERE %s != ?", tableQ, idColQ)
+	baseSelect := fmt.Sprintf("SELECT %s, name, brand, price_eur, currency, category_path, rating_value, rating_count FROM %s WHERE %s != ?", idColQ, tableQ, idColQ)
 	var args []any
 	args = append(args, id)
 
@@ -508,14 +2010,17 @@ func fetchSimilar(db *sql.DB, table, idCol, id string) ([]map[string]any, error)
 		args = append(args, brandVal)
 	}
 
-	order := " ORDER BY CASE WHEN category_path = ? THEN 0 ELSE 1 END, rating_value DESC, rating_count DESC LIMIT 8"
-	if catVal == "" {
-		order = " ORDER BY rating_value DESC, rating_count DESC LIMIT 8"
-	}
-
-	if catVal != "" {
+	order := " ORDER BY rating_value DESC, rating_count DESC"
+	switch {
+	case mode == similarModeBrandCategory && brandVal != "" && catVal != "":
+		order = " ORDER BY CASE WHEN brand = ? AND category_path = ? THEN 0 WHEN brand = ? THEN 1 ELSE 2 END, rating_value DESC, rating_count DESC"
+		args = append(args, brandVal, catVal, brandVal)
+	case catVal != "":
+		order = " ORDER BY CASE WHEN category_path = ? THEN 0 ELSE 1 END, rating_value DESC, rating_count DESC"
 		args = append(args, catVal)
 	}
+	order += ", " + idColQ + " LIMIT ? OFFSET ?"
+	args = append(args, limit, offset)
 
 	q := baseSelect + where + order
 	rows, err := db.Query(q, args...)
@@ -526,15 +2031,19 @@ func fetchSimilar(db *sql.DB, table, idCol, id string) ([]map[string]any, error)
 
 	var out []map[string]any
 	for rows.Next() {
-		var gtin, name, brandOut, currency, categoryOut sql.NullString
+		var idVal, name, brandOut, currency, categoryOut sql.NullString
 		var price sql.NullFloat64
 		var ratingVal sql.NullFloat64
 		var ratingCount sql.NullInt64
-		if err := rows.Scan(&gtin, &name, &brandOut, &price, &currency, &categoryOut, &ratingVal, &ratingCount); err != nil {
+		if err := rows.Scan(&idVal, &name, &brandOut, &price, &currency, &categoryOut, &ratingVal, &ratingCount); err != nil {
 			return nil, err
 		}
-		out = append(out, map[string]any{
-			"gtin":          gtin.String,
+		seg, ok := productPathSegment(idVal.String)
+		if !ok {
+			continue
+		}
+		item := map[string]any{
+			"id":            idVal.String,
 			"name":          name.String,
 			"brand":         brandOut.String,
 			"price_eur":     price.Float64,
@@ -542,7 +2051,12 @@ func fetchSimilar(db *sql.DB, table, idCol, id string) ([]map[string]any, error)
 			"category_path": categoryOut.String,
 			"rating_value":  ratingVal.Float64,
 			"rating_count":  ratingCount.Int64,
-		})
+			"product_path":  "/product/" + seg,
+		}
+		if idCol == "gtin" {
+			item["gtin"] = idVal.String
+		}
+		out = append(out, item)
 	}
 	if err := rows.Err(); err != nil {
 		return nil, err
@@ -550,6 +2064,33 @@ func fetchSimilar(db *sql.DB, table, idCol, id string) ([]map[string]any, error)
 	return out, nil
 }
 
+// fetchPopularFallback returns the top-rated products other than id, used for
+// the similar section when a product has no brand/category matches.
+func fetchPopularFallback(db *sql.DB, table string, cols []string, idCol, id string, limit int, hideUnavailable bool) ([]map[string]any, error) {
+	where := topRatedWhere + " AND " + quoteIdent(idCol) + " != ?"
+	return fetchHomeSectionItems(db, table, cols, idCol, hideUnavailable, where, topRatedOrder, limit, id)
+}
+
+type similarPayload struct {
+	ID        string           `json:"id"`
+	Page      int              `json:"page"`
+	PerPage   int              `json:"per_page"`
+	HasMore   bool             `json:"has_more"`
+	Items     []map[string]any `json:"items"`
+	CardsHTML string           `json:"cards_html"`
+}
+
+// similarPageSize is the -similar-limit page size capped for /api/similar.
+func similarPageSize(limit int) int {
+	if limit <= 0 {
+		limit = defaultSimilarLimit
+	}
+	if limit > similarMaxPageSize {
+		limit = similarMaxPageSize
+	}
+	return limit
+}
+
 type homePayload struct {
 	GeneratedAt string        `json:"generated_at"`
 	Table       string        `json:"table"`
@@ -574,11 +2115,23 @@ type searchPayload struct {
 	Total          int              `json:"total"`
 	TotalPages     int              `json:"total_pages"`
 	Returned       int              `json:"returned"`
+	MaxSearchPage  int              `json:"max_search_page,omitempty"`
 	SearchFields   []string         `json:"search_fields"`
 	Items          []map[string]any `json:"items"`
 }
 
-func fetchHomePayload(db *sql.DB, table string) (homePayload, error) {
+// The home "top-rated" section query, shared with the similar-products fallback.
+const (
+	topRatedWhere = "price_eur IS NOT NULL AND rating_count >= 20"
+	topRatedOrder = "rating_value DESC, rating_count DESC, price_eur ASC"
+)
+
+// fetchHomePayload builds the home sections; budgetMaxPrice caps Budget Finds
+// (<= 0 means defaultBudgetMaxPrice).
+func fetchHomePayload(db *sql.DB, table string, cols []string, idCol string, budgetMaxPrice float64, hideUnavailable bool) (homePayload, error) {
+	if budgetMaxPrice <= 0 {
+		budgetMaxPrice = defaultBudgetMaxPrice
+	}
 	sections := []homeSection{}
 
 	queries := []struct {
@@ -590,8 +2143,8 @@ func fetchHomePayload(db *sql.DB, table string) (homePayload, error) {
 			id:    "top-rated",
 			title: "Top Rated Picks",
 			desc:  "Strong ratings with enough review volume to be meaningful.",
-			where: "price_eur IS NOT NULL AND rating_count >= 20",
-			order: "rating_value DESC, rating_count DESC, price_eur ASC",
+			where: topRatedWhere,
+			order: topRatedOrder,
 			limit: 12,
 		},
 		{
@@ -606,8 +2159,9 @@ func fetchHomePayload(db *sql.DB, table string) (homePayload, error) {
 			id:    "budget-finds",
 			title: "Budget Finds",
 			desc:  "Low-price items with good customer feedback.",
-			where: "price_eur IS NOT NULL AND price_eur <= 5 AND rating_count >= 5",
+			where: "price_eur IS NOT NULL AND price_eur <= ? AND rating_count >= 5",
 			order: "rating_value DESC, rating_count DESC, price_eur ASC",
+			args:  []any{budgetMaxPrice},
 			limit: 12,
 		},
 		{
@@ -629,7 +2183,7 @@ func fetchHomePayload(db *sql.DB, table string) (homePayload, error) {
 	}
 
 	for _, q := range queries {
-		items, err := fetchHomeSectionItems(db, table, q.where, q.order, q.limit, q.args...)
+		items, err := fetchHomeSectionItems(db, table, cols, idCol, hideUnavailable, q.where, q.order, q.limit, q.args...)
 		if err != nil {
 			return homePayload{}, err
 		}
@@ -651,44 +2205,100 @@ func fetchHomePayload(db *sql.DB, table string) (homePayload, error) {
 	}, nil
 }
 
-func fetchSearchPayload(db *sql.DB, table string, cols []string, idCol, query string, page, perPage, offset int) (searchPayload, error) {
+const searchNormalizedColumn = "name_normalized"
+
+// foldSearchFunc is the SQL function applying foldSearchText, so the LIKE
+// fallback matches accents and non-ASCII case on tables without
+// name_normalized.
+const foldSearchFunc = "fold_search"
+
+func init() {
+	sqlite.MustRegisterDeterministicScalarFunction(foldSearchFunc, 1, func(_ *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+		switch v := args[0].(type) {
+		case string:
+			return foldSearchText(v), nil
+		case []byte:
+			return foldSearchText(string(v)), nil
+		default:
+			return v, nil
+		}
+	})
+}
+
+// foldSearchText lowercases s and strips combining marks, matching the
+// name_normalized column written by process-products.
+func foldSearchText(s string) string {
+	var b strings.Builder
+	for _, r := range norm.NFD.String(s) {
+		if unicode.Is(unicode.Mn, r) {
+			continue
+		}
+		b.WriteRune(unicode.ToLower(r))
+	}
+	return norm.NFC.String(b.String())
+}
+
+// collapseSearchQuery trims and collapses whitespace and composes the query to
+// NFC, matching how text is stored.
+func collapseSearchQuery(q string) string {
+	return strings.Join(strings.Fields(norm.NFC.String(q)), " ")
+}
+
+// normalizeSearchQuery is collapseSearchQuery lowercased. The LIKE path keeps
+// the collapsed form because SQLite LIKE only folds ASCII case, so lowering
+// "Öl" would stop it matching stored capitalized umlauts.
+func normalizeSearchQuery(q string) string {
+	return strings.ToLower(collapseSearchQuery(q))
+}
+
+func searchFieldsFor(cols []string) []string {
 	searchFields := make([]string, 0, 3)
 	for _, c := range []string{"name", "brand", "category_path"} {
 		if contains(cols, c) {
 			searchFields = append(searchFields, c)
 		}
 	}
+	return searchFields
+}
+
+// fetchSearchPayload searches name/brand/category_path with LIKE, or through
+// ftsTable with MATCH when an FTS5 index is available.
+func fetchSearchPayload(db *sql.DB, table string, cols []string, idCol, query string, page, perPage, offset int, ftsTable string, hideUnavailable bool) (searchPayload, error) {
+	searchFields := searchFieldsFor(cols)
 	if len(searchFields) == 0 {
 		return searchPayload{}, fmt.Errorf("no searchable columns available")
 	}
 
-	idSelectName := "gtin"
-	if !contains(cols, "gtin") {
-		idSelectName = idCol
-	}
-	if !contains(cols, idSelectName) {
-		return searchPayload{}, fmt.Errorf("id column %q not found for search result selection", idSelectName)
-	}
-
-	pattern := "%" + escapeLikePattern(query) + "%"
-	whereParts := make([]string, 0, len(searchFields))
-	whereArgs := make([]any, 0, len(searchFields))
-	for _, f := range searchFields {
-		whereParts = append(whereParts, fmt.Sprintf("%s LIKE ? ESCAPE '\\'", quoteIdent(f)))
-		whereArgs = append(whereArgs, pattern)
+	if !contains(cols, idCol) {
+		return searchPayload{}, fmt.Errorf("id column %q not found for search result selection", idCol)
 	}
-	whereClause := strings.Join(whereParts, " OR ")
-	tableQ := quoteIdent(table)
 
-	countQ := fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE (%s)", tableQ, whereClause)
 	var total int
-	if err := db.QueryRow(countQ, whereArgs...).Scan(&total); err != nil {
-		return searchPayload{}, err
-	}
-
-	items, err := fetchSearchItems(db, table, searchFields, idSelectName, perPage, offset, whereClause, whereArgs...)
-	if err != nil {
-		return searchPayload{}, err
+	var items []map[string]any
+	if matchExpr := ftsMatchExpression(normalizeSearchQuery(query)); ftsTable != "" && matchExpr != "" {
+		ftsQ := quoteIdent(ftsTable)
+		countQ := fmt.Sprintf("SELECT COUNT(*) FROM %s.%s AS f WHERE %s MATCH ?", searchIndexSchema, ftsQ, ftsQ)
+		if cond := availabilityCondition("t.", hideUnavailable); cond != "" {
+			countQ = fmt.Sprintf("SELECT COUNT(*) FROM %s.%s AS f JOIN %s AS t ON t.rowid = f.rowid WHERE %s MATCH ? AND %s", searchIndexSchema, ftsQ, quoteIdent(table), ftsQ, cond)
+		}
+		if err := db.QueryRow(countQ, matchExpr).Scan(&total); err != nil {
+			return searchPayload{}, err
+		}
+		var err error
+		items, err = fetchSearchItemsFTS(db, table, ftsTable, idCol, perPage, offset, matchExpr, hideUnavailable)
+		if err != nil {
+			return searchPayload{}, err
+		}
+	} else {
+		var err error
+		foldCol := ""
+		if contains(cols, searchNormalizedColumn) {
+			foldCol = searchNormalizedColumn
+		}
+		total, items, err = fetchSearchLike(db, table, searchFields, idCol, collapseSearchQuery(query), perPage, offset, foldCol, hideUnavailable)
+		if err != nil {
+			return searchPayload{}, err
+		}
 	}
 	totalPages := 0
 	if total > 0 {
@@ -697,7 +2307,7 @@ func fetchSearchPayload(db *sql.DB, table string, cols []string, idCol, query st
 
 	return searchPayload{
 		Query:          query,
-		MinQueryLength: searchMinChars,
+		MinQueryLength: defaultSearchMinChars,
 		Page:           page,
 		MinPage:        1,
 		MaxPage:        totalPages,
@@ -711,6 +2321,48 @@ func fetchSearchPayload(db *sql.DB, table string, cols []string, idCol, query st
 	}, nil
 }
 
+// fetchSearchLike matches query as a substring of the search fields. When
+// foldCol (a lowercased, diacritic-free copy of name written by
+// process-products -add-search-column) is set, the folded query is matched
+// against it too, so "creme" finds "Crème"; otherwise it is matched against
+// each field folded by fold_search.
+func fetchSearchLike(db *sql.DB, table string, searchFields []string, idSelectName, query string, perPage, offset int, foldCol string, hideUnavailable bool) (int, []map[string]any, error) {
+	pattern := "%" + escapeLikePattern(query) + "%"
+	whereParts := make([]string, 0, len(searchFields)+1)
+	whereArgs := make([]any, 0, len(searchFields)+1)
+	for _, f := range searchFields {
+		whereParts = append(whereParts, fmt.Sprintf("%s LIKE ? ESCAPE '\\'", quoteIdent(f)))
+		whereArgs = append(whereArgs, pattern)
+	}
+	foldPattern := "%" + escapeLikePattern(foldSearchText(query)) + "%"
+	if foldCol != "" {
+		whereParts = append(whereParts, fmt.Sprintf("%s LIKE ? ESCAPE '\\'", quoteIdent(foldCol)))
+		whereArgs = append(whereArgs, foldPattern)
+	} else {
+		for _, f := range searchFields {
+			whereParts = append(whereParts, fmt.Sprintf("%s(%s) LIKE ? ESCAPE '\\'", foldSearchFunc, quoteIdent(f)))
+			whereArgs = append(whereArgs, foldPattern)
+		}
+	}
+	whereClause := strings.Join(whereParts, " OR ")
+	if cond := availabilityCondition("", hideUnavailable); cond != "" {
+		whereClause = "(" + whereClause + ") AND " + cond
+	}
+	tableQ := quoteIdent(table)
+
+	countQ := fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE (%s)", tableQ, whereClause)
+	var total int
+	if err := db.QueryRow(countQ, whereArgs...).Scan(&total); err != nil {
+		return 0, nil, err
+	}
+
+	items, err := fetchSearchItems(db, table, searchFields, idSelectName, perPage, offset, whereClause, whereArgs...)
+	if err != nil {
+		return 0, nil, err
+	}
+	return total, items, nil
+}
+
 func fetchSearchItems(db *sql.DB, table string, searchFields []string, idCol string, limit, offset int, whereClause string, whereArgs ...any) ([]map[string]any, error) {
 	tableQ := quoteIdent(table)
 	idColQ := quoteIdent(idCol)
@@ -747,7 +2399,29 @@ func fetchSearchItems(db *sql.DB, table string, searchFields []string, idCol str
 		return nil, err
 	}
 	defer rows.Close()
+	return scanSearchItems(rows, idCol)
+}
+
+func fetchSearchItemsFTS(db *sql.DB, table, ftsTable, idCol string, limit, offset int, matchExpr string, hideUnavailable bool) ([]map[string]any, error) {
+	ftsQ := quoteIdent(ftsTable)
+	q := fmt.Sprintf(
+		`SELECT t.%s, t.name, t.brand, t.price_eur, t.currency, t.category_path, t.rating_value, t.rating_count
+		 FROM %s.%s AS f
+		 JOIN %s AS t ON t.rowid = f.rowid
+		 WHERE %s MATCH ?%s
+		 ORDER BY bm25(%s), t.rating_count DESC, t.rating_value DESC, t.name ASC
+		 LIMIT ? OFFSET ?`,
+		quoteIdent(idCol), searchIndexSchema, ftsQ, quoteIdent(table), ftsQ, availabilityFilterSuffix("t.", hideUnavailable), ftsQ,
+	)
+	rows, err := db.Query(q, matchExpr, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanSearchItems(rows, idCol)
+}
 
+func scanSearchItems(rows *sql.Rows, idCol string) ([]map[string]any, error) {
 	var out []map[string]any
 	for rows.Next() {
 		var idVal, name, brand, currency, category sql.NullString
@@ -758,6 +2432,10 @@ func fetchSearchItems(db *sql.DB, table string, searchFields []string, idCol str
 			return nil, err
 		}
 		id := idVal.String
+		seg, ok := productPathSegment(id)
+		if !ok {
+			continue
+		}
 		item := map[string]any{
 			"id":            id,
 			"name":          name.String,
@@ -767,7 +2445,7 @@ func fetchSearchItems(db *sql.DB, table string, searchFields []string, idCol str
 			"category_path": category.String,
 			"rating_value":  ratingVal.Float64,
 			"rating_count":  ratingCount.Int64,
-			"product_path":  "/product/" + id,
+			"product_path":  "/product/" + seg,
 		}
 		if idCol == "gtin" {
 			item["gtin"] = id
@@ -780,16 +2458,187 @@ func fetchSearchItems(db *sql.DB, table string, searchFields []string, idCol str
 	return out, nil
 }
 
-func fetchHomeSectionItems(db *sql.DB, table, where, order string, limit int, args ...any) ([]map[string]any, error) {
+func sqliteHasFTS5(db *sql.DB) bool {
+	var n int
+	err := db.QueryRow(`SELECT sqlite_compileoption_used('ENABLE_FTS5')`).Scan(&n)
+	if err == nil && n == 1 {
+		return true
+	}
+	// Some builds register fts5 without the compile option; probe directly.
+	_, err = db.Exec(`CREATE VIRTUAL TABLE temp.fts5_probe USING fts5(x)`)
+	if err != nil {
+		return false
+	}
+	_, _ = db.Exec(`DROP TABLE temp.fts5_probe`)
+	return true
+}
+
+// searchIndexSchema is the schema name the -fts scratch database is attached
+// under on every connection; the index lives there, not in the served file.
+const searchIndexSchema = "search_index"
+
+// openProductsDB opens the served database. With a non-empty indexPath each
+// pooled connection also attaches indexPath as searchIndexSchema, so the FTS
+// index can be built without writing to the (read-only at runtime) served file.
+func openProductsDB(path, indexPath string) (*sql.DB, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil || indexPath == "" {
+		return db, err
+	}
+	drv := db.Driver()
+	db.Close()
+	return sql.OpenDB(searchIndexConnector{drv: drv, dsn: path, indexPath: indexPath}), nil
+}
+
+type searchIndexConnector struct {
+	drv       driver.Driver
+	dsn       string
+	indexPath string
+}
+
+func (c searchIndexConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.drv.Open(c.dsn)
+	if err != nil {
+		return nil, err
+	}
+	execer, ok := conn.(driver.ExecerContext)
+	if !ok {
+		conn.Close()
+		return nil, fmt.Errorf("sqlite driver cannot attach %s", c.indexPath)
+	}
+	if _, err := execer.ExecContext(ctx, "ATTACH DATABASE ? AS "+searchIndexSchema, []driver.NamedValue{{Ordinal: 1, Value: c.indexPath}}); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+func (c searchIndexConnector) Driver() driver.Driver { return c.drv }
+
+// defaultSearchIndexPath names a scratch index file in the OS temp dir, unique
+// per served database path.
+func defaultSearchIndexPath(dbPath string) string {
+	if abs, err := filepath.Abs(dbPath); err == nil {
+		dbPath = abs
+	}
+	sum := sha256.Sum256([]byte(dbPath))
+	return filepath.Join(os.TempDir(), "medium-server-2-fts-"+hex.EncodeToString(sum[:8])+".sqlite")
+}
+
+// searchSourceStamp identifies the served file's content cheaply: its size,
+// modification time and the change counter SQLite bumps in the header on
+// every committed write.
+func searchSourceStamp(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	var counter [4]byte
+	if _, err := f.ReadAt(counter[:], 24); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d:%d:%d", fi.Size(), fi.ModTime().UnixNano(), binary.BigEndian.Uint32(counter[:])), nil
+}
+
+// ensureSearchFTS builds <table>_fts over the search fields in the attached
+// search_index database, keyed by the base table's rowid, and records the
+// fields and stamp (see searchSourceStamp) in <table>_fts_state. An existing
+// index is reused only while both still match.
+func ensureSearchFTS(db *sql.DB, table string, searchFields []string, stamp string) (string, error) {
+	if len(searchFields) == 0 {
+		return "", fmt.Errorf("no searchable columns available")
+	}
+	ftsTable := table + "_fts"
+	ftsQ := searchIndexSchema + "." + quoteIdent(ftsTable)
+	stateQ := searchIndexSchema + "." + quoteIdent(table+"_fts_state")
+	fieldList := joinIdents(searchFields)
+	fieldsKey := strings.Join(searchFields, ",")
+
+	var existing int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM `+searchIndexSchema+`.sqlite_master WHERE name = ?`, ftsTable).Scan(&existing); err != nil {
+		return "", err
+	}
+	if existing > 0 {
+		var fields, got string
+		err := db.QueryRow(fmt.Sprintf("SELECT fields, stamp FROM %s", stateQ)).Scan(&fields, &got)
+		if err == nil && fields == fieldsKey && got == stamp {
+			return ftsTable, nil
+		}
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+	stmts := []string{
+		fmt.Sprintf("DROP TABLE IF EXISTS %s", ftsQ),
+		fmt.Sprintf("CREATE VIRTUAL TABLE %s USING fts5(%s, tokenize = 'unicode61 remove_diacritics 2')", ftsQ, fieldList),
+		fmt.Sprintf("INSERT INTO %s(rowid, %s) SELECT rowid, %s FROM main.%s", ftsQ, fieldList, fieldList, quoteIdent(table)),
+		fmt.Sprintf("DROP TABLE IF EXISTS %s", stateQ),
+		fmt.Sprintf("CREATE TABLE %s (fields TEXT NOT NULL, stamp TEXT NOT NULL)", stateQ),
+	}
+	for _, stmt := range stmts {
+		if _, err := tx.Exec(stmt); err != nil {
+			return "", err
+		}
+	}
+	if _, err := tx.Exec(fmt.Sprintf("INSERT INTO %s (fields, stamp) VALUES (?, ?)", stateQ), fieldsKey, stamp); err != nil {
+		return "", err
+	}
+	if err := tx.Commit(); err != nil {
+		return "", err
+	}
+	return ftsTable, nil
+}
+
+// ftsMatchExpression turns free text into an FTS5 query where every word must
+// match as a prefix, in any column and any order.
+func ftsMatchExpression(query string) string {
+	words := strings.FieldsFunc(query, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+	terms := make([]string, 0, len(words))
+	for _, w := range words {
+		terms = append(terms, `"`+w+`"*`)
+	}
+	return strings.Join(terms, " ")
+}
+
+// fetchHomeSectionItems lists product cards for a home section. The unit
+// price columns are optional (-columns exports may omit them) and read as NULL
+// when cols lacks them.
+func fetchHomeSectionItems(db *sql.DB, table string, cols []string, idCol string, hideUnavailable bool, where, order string, limit int, args ...any) ([]map[string]any, error) {
 	if limit <= 0 {
 		limit = 12
 	}
 
+	unitSelects := make([]string, 0, 3)
+	for _, c := range []string{"unit_price_eur", "unit_price_per_quantity", "unit_price_per_unit"} {
+		if contains(cols, c) {
+			unitSelects = append(unitSelects, quoteIdent(c))
+		} else {
+			unitSelects = append(unitSelects, "NULL")
+		}
+	}
 	tableQ := quoteIdent(table)
 	q := fmt.Sprintf(
-		`SELECT gtin, name, brand, price_eur, currency, category_path, rating_value, rating_count
-		 FROM %s`, tableQ,
+		`SELECT %s, name, brand, price_eur, currency, category_path, rating_value, rating_count,
+		        %s
+		 FROM %s`, quoteIdent(idCol), strings.Join(unitSelects, ", "), tableQ,
 	)
+	if cond := availabilityCondition("", hideUnavailable); cond != "" {
+		if strings.TrimSpace(where) != "" {
+			where = "(" + where + ") AND " + cond
+		} else {
+			where = cond
+		}
+	}
 	if strings.TrimSpace(where) != "" {
 		q += " WHERE " + where
 	}
@@ -806,17 +2655,21 @@ func fetchHomeSectionItems(db *sql.DB, table, where, order string, limit int, ar
 
 	var out []map[string]any
 	for rows.Next() {
-		var gtin, name, brand, currency, category sql.NullString
-		var price sql.NullFloat64
+		var idVal, name, brand, currency, category, unitPer sql.NullString
+		var price, unitPrice, unitQty sql.NullFloat64
 		var ratingVal sql.NullFloat64
 		var ratingCount sql.NullInt64
-		if err := rows.Scan(&gtin, &name, &brand, &price, &currency, &category, &ratingVal, &ratingCount); err != nil {
+		if err := rows.Scan(&idVal, &name, &brand, &price, &currency, &category, &ratingVal, &ratingCount, &unitPrice, &unitQty, &unitPer); err != nil {
 			return nil, err
 		}
 
-		gtinVal := gtin.String
-		out = append(out, map[string]any{
-			"gtin":          gtinVal,
+		id := idVal.String
+		seg, ok := productPathSegment(id)
+		if !ok {
+			continue
+		}
+		item := map[string]any{
+			"id":            id,
 			"name":          name.String,
 			"brand":         brand.String,
 			"price_eur":     price.Float64,
@@ -824,8 +2677,19 @@ func fetchHomeSectionItems(db *sql.DB, table, where, order string, limit int, ar
 			"category_path": category.String,
 			"rating_value":  ratingVal.Float64,
 			"rating_count":  ratingCount.Int64,
-			"product_path":  "/product/" + gtinVal,
-		})
+			"product_path":  "/product/" + seg,
+		}
+		if idCol == "gtin" {
+			item["gtin"] = id
+		}
+		if unitPrice.Valid && unitPer.Valid {
+			item["unit_price_eur"] = unitPrice.Float64
+			item["unit_price_per_unit"] = unitPer.String
+			if unitQty.Valid {
+				item["unit_price_per_quantity"] = unitQty.Float64
+			}
+		}
+		out = append(out, item)
 	}
 	if err := rows.Err(); err != nil {
 		return nil, err
@@ -833,6 +2697,19 @@ func fetchHomeSectionItems(db *sql.DB, table, where, order string, limit int, ar
 	return out, nil
 }
 
+// itemProductPath is the card link for item: its product_path, or one built
+// from its gtin/id. Items whose id productPathSegment rejects get no card.
+func itemProductPath(item map[string]any) (string, bool) {
+	if p := getString(item, "product_path"); p != "" {
+		return p, true
+	}
+	seg, ok := productPathSegment(firstNonEmpty(getString(item, "gtin"), getString(item, "id")))
+	if !ok {
+		return "", false
+	}
+	return "/product/" + seg, true
+}
+
 func renderHomeSectionsHTML(payload homePayload) template.HTML {
 	var b strings.Builder
 	for _, section := range payload.Sections {
@@ -862,12 +2739,15 @@ func renderHomeSectionsHTML(payload homePayload) template.HTML {
 }
 
 func renderHomeCardHTML(item map[string]any) string {
-	gtin := getString(item, "gtin")
-	href := firstNonEmpty(getString(item, "product_path"), "/product/"+gtin)
+	href, ok := itemProductPath(item)
+	if !ok {
+		return ""
+	}
 	brand := firstNonEmpty(getString(item, "brand"), "Unknown brand")
 	name := firstNonEmpty(getString(item, "name"), "Product")
 	category := getString(item, "category_path")
 	price := formatCurrencyFromMap(item)
+	unitPrice := formatUnitPrice(item)
 	rating := formatRatingSummary(item)
 
 	var b strings.Builder
@@ -879,7 +2759,13 @@ func renderHomeCardHTML(item map[string]any) string {
 	b.WriteString(template.HTMLEscapeString(name))
 	b.WriteString(`</div><div class="card-category">`)
 	b.WriteString(template.HTMLEscapeString(category))
-	b.WriteString(`</div><div class="card-foot"><span class="price">`)
+	b.WriteString(`</div>`)
+	if unitPrice != "" {
+		b.WriteString(`<div class="card-unit-price">`)
+		b.WriteString(template.HTMLEscapeString(unitPrice))
+		b.WriteString(`</div>`)
+	}
+	b.WriteString(`<div class="card-foot"><span class="price">`)
 	b.WriteString(template.HTMLEscapeString(price))
 	b.WriteString(`</span><span>`)
 	b.WriteString(template.HTMLEscapeString(rating))
@@ -890,17 +2776,21 @@ func renderHomeCardHTML(item map[string]any) string {
 func renderSimilarCardsHTML(items []map[string]any) template.HTML {
 	var b strings.Builder
 	for _, item := range items {
-		gtin := getString(item, "gtin")
+		href, ok := itemProductPath(item)
+		if !ok {
+			continue
+		}
 		name := firstNonEmpty(getString(item, "name"), "Product")
 		brand := firstNonEmpty(getString(item, "brand"), "Unknown brand")
 		price := formatCurrencyFromMap(item)
 		rating := ""
 		if rv, ok := getFloat(item, "rating_value"); ok && rv > 0 {
-			rating = fmt.Sprintf("★ %.1f", rv)
+			rc, _ := getInt(item, "rating_count")
+			rating = formatStars(rv, rc)
 		}
 
-		b.WriteString(`<a class="rec-card" href="/product/`)
-		b.WriteString(template.URLQueryEscaper(gtin))
+		b.WriteString(`<a class="rec-card" href="`)
+		b.WriteString(template.HTMLEscapeString(href))
 		b.WriteString(`"><div class="rec-brand">`)
 		b.WriteString(template.HTMLEscapeString(brand))
 		b.WriteString(`</div><div class="rec-name">`)
@@ -914,6 +2804,23 @@ func renderSimilarCardsHTML(items []map[string]any) template.HTML {
 	return template.HTML(b.String())
 }
 
+// priceStableSince returns the gross (or net) "not increased since" date in a
+// readable form, or "" when neither is set.
+func priceStableSince(row map[string]any) string {
+	raw := strings.TrimSpace(firstNonEmpty(getString(row, "gross_not_increased_since"), getString(row, "net_not_increased_since")))
+	if raw == "" {
+		return ""
+	}
+	if len(raw) > len("2006-01-02") {
+		raw = raw[:len("2006-01-02")]
+	}
+	t, err := time.Parse("2006-01-02", raw)
+	if err != nil {
+		return ""
+	}
+	return t.Format("2 January 2006")
+}
+
 func hasProductRating(row map[string]any) bool {
 	if rv, ok := getFloat(row, "rating_value"); ok && rv > 0 {
 		return true
@@ -941,19 +2848,8 @@ func renderProductRatingHTML(row map[string]any) template.HTML {
 	}
 
 	if hasRV {
-		rounded := rv
-		if rounded < 0 {
-			rounded = 0
-		}
-		if rounded > 5 {
-			rounded = 5
-		}
-		full := int(rounded + 0.5)
-		stars := strings.Repeat("★", full) + strings.Repeat("☆", 5-full)
 		b.WriteString(`<div class="rating-stars">`)
-		b.WriteString(template.HTMLEscapeString(stars))
-		b.WriteString(` `)
-		b.WriteString(template.HTMLEscapeString(fmt.Sprintf("%.1f", rounded)))
+		b.WriteString(template.HTMLEscapeString(formatStars(rv, 0)))
 		b.WriteString(`</div>`)
 		if hasRC {
 			b.WriteString(`<div class="rating-text">`)
@@ -979,7 +2875,8 @@ func isAdditionalDetailField(key string) bool {
 		"category_path", "seo_category",
 		"image", "image_url", "img", "thumbnail",
 		"desc_productbeschreibung", "metadata_description",
-		"rating_value", "rating_count":
+		"rating_value", "rating_count",
+		"gross_not_increased_since", "net_not_increased_since":
 		return false
 	default:
 		return true
@@ -1063,7 +2960,10 @@ func renderSearchResultsHTML(payload *searchPayload) template.HTML {
 }
 
 func renderSearchResultCardHTML(item map[string]any) string {
-	href := firstNonEmpty(getString(item, "product_path"), "/product/"+firstNonEmpty(getString(item, "gtin"), getString(item, "id")))
+	href, ok := itemProductPath(item)
+	if !ok {
+		return ""
+	}
 	brand := firstNonEmpty(getString(item, "brand"), "Unknown brand")
 	name := firstNonEmpty(getString(item, "name"), "Product")
 	category := getString(item, "category_path")
@@ -1128,11 +3028,71 @@ func searchNextPage(p *searchPayload) int {
 func formatCurrencyFromMap(item map[string]any) string {
 	if price, ok := getFloat(item, "price_eur"); ok {
 		currency := firstNonEmpty(getString(item, "currency"), "EUR")
-		return fmt.Sprintf("%.2f %s", price, currency)
+		return formatPrice(price, currency)
 	}
 	return "Price unavailable"
 }
 
+// formatPrice renders amounts the way shoppers expect per currency: de-DE style
+// for EUR ("4,99 €"), prefixed symbols for USD/GBP, and "4.99 XYZ" otherwise.
+func formatPrice(amount float64, currency string) string {
+	code := strings.ToUpper(strings.TrimSpace(currency))
+	switch code {
+	case "EUR":
+		return groupedAmount(amount, ".", ",") + " €"
+	case "USD":
+		return signedSymbol(amount, "$") + groupedAmount(math.Abs(amount), ",", ".")
+	case "GBP":
+		return signedSymbol(amount, "£") + groupedAmount(math.Abs(amount), ",", ".")
+	}
+	return fmt.Sprintf("%.2f %s", amount, currency)
+}
+
+// formatUnitPrice renders the base price line (e.g. "0,35 € / 100 ml"), or ""
+// when the unit-price fields are missing.
+func formatUnitPrice(row map[string]any) string {
+	amount, ok := getFloat(row, "unit_price_eur")
+	unit := strings.TrimSpace(getString(row, "unit_price_per_unit"))
+	if !ok || unit == "" {
+		return ""
+	}
+	currency := firstNonEmpty(getString(row, "currency"), "EUR")
+	per := unit
+	if qty, ok := getFloat(row, "unit_price_per_quantity"); ok && qty > 0 && qty != 1 {
+		q := strconv.FormatFloat(qty, 'f', -1, 64)
+		if strings.EqualFold(currency, "EUR") {
+			q = strings.ReplaceAll(q, ".", ",")
+		}
+		per = q + " " + unit
+	}
+	return formatPrice(amount, currency) + " / " + per
+}
+
+func signedSymbol(amount float64, symbol string) string {
+	if amount < 0 && math.Round(amount*100) != 0 {
+		return "-" + symbol
+	}
+	return symbol
+}
+
+func groupedAmount(amount float64, thousandsSep, decimalSep string) string {
+	s := strconv.FormatFloat(math.Abs(amount), 'f', 2, 64)
+	intPart, frac := s[:len(s)-3], s[len(s)-2:]
+	var b strings.Builder
+	if amount < 0 && s != "0.00" {
+		b.WriteString("-")
+	}
+	for i, d := range intPart {
+		if i > 0 && (len(intPart)-i)%3 == 0 {
+			b.WriteString(thousandsSep)
+		}
+		b.WriteRune(d)
+	}
+	b.WriteString(decimalSep)
+	b.WriteString(frac)
+	return b.String()
+}
+
 func escapeLikePattern(s string) string {
 	replacer := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
 	return replacer.Replace(s)
@@ -1155,6 +3115,46 @@ func parsePageQueryParam(r *http.Request, key string, fallback int) (int, bool)
 	return int(n64), true
 }
 
+// setPaginationHeaders emits X-Total-Count and an RFC 5988 Link header with
+// first/prev/next/last page URLs for a search result page.
+func setPaginationHeaders(w http.ResponseWriter, r *http.Request, baseURL string, p *searchPayload) {
+	w.Header().Set("X-Total-Count", strconv.Itoa(p.Total))
+	pageURL := func(n int) string {
+		q := r.URL.Query()
+		q.Set("page", strconv.Itoa(n))
+		return baseURL + r.URL.Path + "?" + q.Encode()
+	}
+	var links []string
+	if p.TotalPages > 0 {
+		links = append(links, fmt.Sprintf(`<%s>; rel="first"`, pageURL(1)))
+	}
+	if p.Page > 1 && p.TotalPages > 0 {
+		links = append(links, fmt.Sprintf(`<%s>; rel="prev"`, pageURL(min(p.Page-1, p.TotalPages))))
+	}
+	if p.Page < p.TotalPages {
+		links = append(links, fmt.Sprintf(`<%s>; rel="next"`, pageURL(p.Page+1)))
+	}
+	if p.TotalPages > 0 {
+		links = append(links, fmt.Sprintf(`<%s>; rel="last"`, pageURL(p.TotalPages)))
+	}
+	if len(links) > 0 {
+		w.Header().Set("Link", strings.Join(links, ", "))
+	}
+}
+
+// parsePerPageQueryParam validates ?per_page= like page and clamps it to
+// searchMaxPageSize.
+func parsePerPageQueryParam(r *http.Request, fallback int) (int, bool) {
+	n, ok := parsePageQueryParam(r, "per_page", fallback)
+	if !ok {
+		return 0, false
+	}
+	if n > searchMaxPageSize {
+		n = searchMaxPageSize
+	}
+	return n, true
+}
+
 func pageOffset(page, perPage int) (int, bool) {
 	if page < 1 || perPage < 1 {
 		return 0, false
@@ -1167,14 +3167,37 @@ func pageOffset(page, perPage int) (int, bool) {
 	return int(p * sz), true
 }
 
+// searchPageTooDeep returns the error shown for a search page beyond the
+// -max-search-page cap, or "" when page is within it (or the cap is off).
+func searchPageTooDeep(page, maxPage int) string {
+	if maxPage <= 0 || page <= maxPage {
+		return ""
+	}
+	return fmt.Sprintf("page %d is too deep (max %d); narrow your query to see more results", page, maxPage)
+}
+
 func maxIntValue() int64 { return int64(^uint(0) >> 1) }
 
+// formatStars renders a rating as five filled/empty stars, the value and,
+// when count > 0, the count: "★★★★☆ 4.2 (120)". The value is clamped to
+// 0..5 and rounded to whole stars like medium-server-1's product page.
+func formatStars(value float64, count int64) string {
+	if math.IsNaN(value) {
+		value = 0
+	}
+	value = math.Max(0, math.Min(5, value))
+	full := int(math.Round(value))
+	out := strings.Repeat("★", full) + strings.Repeat("☆", 5-full) + fmt.Sprintf(" %.1f", value)
+	if count > 0 {
+		out += fmt.Sprintf(" (%d)", count)
+	}
+	return out
+}
+
 func formatRatingSummary(item map[string]any) string {
 	if rv, ok := getFloat(item, "rating_value"); ok && rv > 0 {
-		if rc, ok2 := getInt(item, "rating_count"); ok2 && rc > 0 {
-			return fmt.Sprintf("★ %.1f (%d)", rv, rc)
-		}
-		return fmt.Sprintf("★ %.1f", rv)
+		rc, _ := getInt(item, "rating_count")
+		return formatStars(rv, rc)
 	}
 	if rc, ok := getInt(item, "rating_count"); ok && rc > 0 {
 		return fmt.Sprintf("%d reviews", rc)
@@ -1214,12 +3237,76 @@ func quoteIdent(s string) string {
 	return `"` + strings.ReplaceAll(s, `"`, `""`) + `"`
 }
 
+// templateOverride is a page template that -templates-dir can replace with
+// <dir>/<name>.html.
+type templateOverride struct {
+	name   string
+	target **template.Template
+	sample map[string]any
+}
+
+// loadTemplateOverrides parses <dir>/{home,product,search}.html where present
+// and executes each against sample page data before swapping any of them in.
+// It runs once in main before the server starts, so handlers only ever read
+// the package-level templates. It returns the names that were overridden.
+func loadTemplateOverrides(dir string) ([]string, error) {
+	type parsed struct {
+		target **template.Template
+		tmpl   *template.Template
+	}
+	var loaded []parsed
+	var names []string
+	for _, o := range pageTemplateOverrides() {
+		path := filepath.Join(dir, o.name+".html")
+		src, err := os.ReadFile(path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		tmpl, err := template.New(o.name).Parse(string(src))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		if err := tmpl.Execute(io.Discard, o.sample); err != nil {
+			return nil, fmt.Errorf("%s: sample render: %w", path, err)
+		}
+		loaded = append(loaded, parsed{o.target, tmpl})
+		names = append(names, o.name)
+	}
+	for _, p := range loaded {
+		*p.target = p.tmpl
+	}
+	return names, nil
+}
+
+// pageTemplateOverrides lists the overridable templates with data shaped like
+// what their handlers pass.
+func pageTemplateOverrides() []templateOverride {
+	row := map[string]any{"gtin": "4000000000001", "name": "Sample product", "brand": "Sample brand", "price_eur": 1.99, "currency": "EUR", "category_path": "Pflege > Seife"}
+	product := productPageData("4000000000001", row, []map[string]any{row})
+	product["search_min_chars"] = defaultSearchMinChars
+	return []templateOverride{
+		{"home", &homePageTemplate, map[string]any{"title": "dimi", "sections_html": template.HTML(""), "search_min_chars": defaultSearchMinChars}},
+		{"product", &productPageTemplate, product},
+		{"search", &searchPageTemplate, map[string]any{
+			"title": "Search | dimi", "query": "sample", "search_error": "",
+			"search_results": template.HTML(""), "has_search_results": false, "has_query": true,
+			"page": 1, "total": 0, "returned": 0, "current_page": 1, "max_page": 1,
+			"prev_page": 1, "next_page": 1, "has_prev": false, "has_next": false,
+			"per_page": defaultSearchPageSize, "custom_per_page": false, "search_min_chars": defaultSearchMinChars,
+		}},
+	}
+}
+
 var productPageTemplate = template.Must(template.New("product").Parse(`<!doctype html>
 <html lang="en">
 <head>
   <meta charset="utf-8" />
   <meta name="viewport" content="width=device-width, initial-scale=1" />
   <title>Product {{ .id }} | dimi</title>
+  {{ if .canonical_url }}<link rel="canonical" href="{{ .canonical_url }}" />{{ end }}
   <style>
     :root {
       --bg: #f5f3ef;
@@ -1307,6 +3394,9 @@ var productPageTemplate = template.Must(template.New("product").Parse(`<!doctype
     }
     .wrap { max-width: 1040px; margin: 40px auto 64px; padding: 0 20px; }
     .crumbs { font-size: 14px; color: var(--muted); margin-bottom: 14px; text-transform: capitalize; }
+    .crumbs a { color: inherit; text-decoration: none; }
+    .crumbs a:hover { color: var(--ink); text-decoration: underline; }
+    .crumb-sep { margin: 0 6px; }
     .card {
       background: var(--card);
       border: 1px solid var(--border);
@@ -1337,6 +3427,8 @@ var productPageTemplate = template.Must(template.New("product").Parse(`<!doctype
     .price-row { display: flex; align-items: center; gap: 12px; flex-wrap: wrap; margin: 14px 0; }
     .price { font-size: 26px; font-weight: 700; }
     .pill { font-size: 12px; color: var(--accent-2); border: 1px solid #fed7aa; background: #fff7ed; padding: 4px 10px; border-radius: 999px; }
+    .pill-stable { color: #0f766e; border-color: #99f6e4; background: #f0fdfa; }
+    .unit-price { color: var(--muted); font-size: 13px; margin: -8px 0 14px; }
     .meta { color: var(--muted); font-size: 14px; margin-bottom: 16px; }
     .meta span { display: inline-block; margin-right: 12px; }
     .cta {
@@ -1440,6 +3532,16 @@ var productPageTemplate = template.Must(template.New("product").Parse(`<!doctype
     }
     .rec-price { color: var(--ink); font-weight: 700; font-size: 13px; }
     .recs-status { color: var(--muted); font-size: 14px; }
+    .recs-more {
+      margin-top: 14px;
+      padding: 8px 16px;
+      border: 1px solid var(--border);
+      border-radius: 999px;
+      background: #fff;
+      font: inherit;
+      cursor: pointer;
+    }
+    .recs-more[disabled] { opacity: 0.6; cursor: default; }
     @media (max-width: 900px) {
       .card { grid-template-columns: 1fr; }
       .desc { max-width: none; }
@@ -1459,7 +3561,7 @@ var productPageTemplate = template.Must(template.New("product").Parse(`<!doctype
     <div class="topbar">
       <a class="logo" href="/">dimi</a>
       <form class="search-form" action="/search" method="get" role="search">
-        <input class="search-input" type="search" name="q" minlength="3" required placeholder="Search products, brands, categories" />
+        <input class="search-input" type="search" name="q" minlength="{{ .search_min_chars }}" required placeholder="Search products, brands, categories" />
         <button class="search-submit" type="submit">Search</button>
       </form>
       <div class="top-actions">
@@ -1469,7 +3571,7 @@ var productPageTemplate = template.Must(template.New("product").Parse(`<!doctype
     </div>
   </div>
   <div class="wrap">
-    <div class="crumbs">{{ if .category }}{{ .category }}{{ else }}Product details{{ end }}</div>
+    <div class="crumbs">{{ if .breadcrumbs }}{{ range $i, $c := .breadcrumbs }}{{ if $i }}<span class="crumb-sep">&rsaquo;</span>{{ end }}<a href="{{ $c.Href }}">{{ $c.Label }}</a>{{ end }}{{ else if .category }}{{ .category }}{{ else }}Product details{{ end }}</div>
     <div class="card">
       <div class="media">
         {{ if .image }}
@@ -1484,7 +3586,9 @@ var productPageTemplate = template.Must(template.New("product").Parse(`<!doctype
         <div class="price-row">
           <div class="price">{{ if .price }}{{ .price }}{{ else }}Price not available{{ end }}</div>
           <div class="pill">In stock</div>
+          {{ if .price_stable }}<div class="pill pill-stable">Price stable since {{ .price_stable }}</div>{{ end }}
         </div>
+        {{ if .unit_price }}<div class="unit-price">{{ .unit_price }}</div>{{ end }}
         <div class="meta">
           <span>Product ID: <span>{{ .id }}</span></span>
           {{ if .category }}<span>Category: <span>{{ .category }}</span></span>{{ end }}
@@ -1520,12 +3624,36 @@ var productPageTemplate = template.Must(template.New("product").Parse(`<!doctype
       <div class="recs-sub">Related suggestions selected on the server.</div>
       {{ if .has_similar }}
       <div class="recs-status">Suggestions ready.</div>
-      <div class="recs-grid">{{ .similar_html }}</div>
+      <div class="recs-grid" id="similar-grid">{{ .similar_html }}</div>
+      {{ if .similar_more_url }}<button class="recs-more" type="button" id="similar-more" data-url="{{ .similar_more_url }}" data-next-page="2">Show more</button>{{ end }}
       {{ else }}
       <div class="recs-status">No suggestions available right now.</div>
       {{ end }}
     </section>
   </div>
+  <script>
+    (function () {
+      var moreBtn = document.getElementById("similar-more");
+      var gridEl = document.getElementById("similar-grid");
+      if (!moreBtn || !gridEl) return;
+      moreBtn.addEventListener("click", function () {
+        var page = moreBtn.getAttribute("data-next-page");
+        moreBtn.disabled = true;
+        fetch(moreBtn.getAttribute("data-url") + "?page=" + encodeURIComponent(page), { headers: { Accept: "application/json" } })
+          .then(function (res) { return res.ok ? res.json() : Promise.reject(res.status); })
+          .then(function (data) {
+            gridEl.insertAdjacentHTML("beforeend", data.cards_html || "");
+            if (data.has_more) {
+              moreBtn.setAttribute("data-next-page", String(data.page + 1));
+              moreBtn.disabled = false;
+            } else {
+              moreBtn.remove();
+            }
+          })
+          .catch(function () { moreBtn.disabled = false; });
+      });
+    })();
+  </script>
 </body>
 </html>`))
 
@@ -1787,6 +3915,11 @@ var homePageTemplate = template.Must(template.New("home").Parse(`<!doctype html>
       overflow: hidden;
       text-overflow: ellipsis;
     }
+    .card-unit-price {
+      font-size: 11px;
+      color: var(--muted);
+      margin-bottom: 6px;
+    }
     .card-foot {
       display: flex;
       align-items: center;
@@ -1829,7 +3962,7 @@ var homePageTemplate = template.Must(template.New("home").Parse(`<!doctype html>
     <div class="topbar">
       <div class="logo">dimi</div>
       <form class="search-form" action="/search" method="get" role="search">
-        <input class="search-input" type="search" name="q" minlength="3" required placeholder="Search products, brands, categories" />
+        <input class="search-input" type="search" name="q" minlength="{{ .search_min_chars }}" required placeholder="Search products, brands, categories" />
         <button class="search-submit" type="submit">Search</button>
       </form>
       <div class="top-actions">
@@ -1963,7 +4096,7 @@ var searchPageTemplate = template.Must(template.New("search").Parse(`<!doctype h
     <div class="topbar">
       <a class="logo" href="/">dimi</a>
       <form class="search-form" action="/search" method="get" role="search">
-        <input class="search-input" type="search" name="q" minlength="3" required placeholder="Search products, brands, categories" value="{{ .query }}" />
+        <input class="search-input" type="search" name="q" minlength="{{ .search_min_chars }}" required placeholder="Search products, brands, categories" value="{{ .query }}" />
         <button class="search-submit" type="submit">Search</button>
       </form>
       <div class="top-actions">
@@ -1983,8 +4116,8 @@ var searchPageTemplate = template.Must(template.New("search").Parse(`<!doctype h
       <div class="pager">
         <div class="pager-info">{{ if gt .max_page 0 }}Page {{ .current_page }} of {{ .max_page }}{{ else }}No pages{{ end }}</div>
         <div class="pager-actions">
-          <a class="pager-btn {{ if not .has_prev }}disabled{{ end }}" href="{{ if .has_prev }}/search?q={{ .query }}&page={{ .prev_page }}{{ else }}#{{ end }}">Previous</a>
-          <a class="pager-btn {{ if not .has_next }}disabled{{ end }}" href="{{ if .has_next }}/search?q={{ .query }}&page={{ .next_page }}{{ else }}#{{ end }}">Next</a>
+          <a class="pager-btn {{ if not .has_prev }}disabled{{ end }}" href="{{ if .has_prev }}/search?q={{ .query }}&page={{ .prev_page }}{{ if .custom_per_page }}&per_page={{ .per_page }}{{ end }}{{ else }}#{{ end }}">Previous</a>
+          <a class="pager-btn {{ if not .has_next }}disabled{{ end }}" href="{{ if .has_next }}/search?q={{ .query }}&page={{ .next_page }}{{ if .custom_per_page }}&per_page={{ .per_page }}{{ end }}{{ else }}#{{ end }}">Next</a>
         </div>
       </div>
       {{ end }}
@@ -1993,6 +4126,61 @@ var searchPageTemplate = template.Must(template.New("search").Parse(`<!doctype h
 </body>
 </html>`))
 
+// renderNotFound writes the styled 404 page used for unknown pages and
+// missing products; minChars is the search form's minimum query length.
+func renderNotFound(w http.ResponseWriter, r *http.Request, minChars int) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusNotFound)
+	if err := notFoundPageTemplate.Execute(w, map[string]any{
+		"title":            "Page not found | dimi",
+		"path":             r.URL.Path,
+		"search_min_chars": minChars,
+	}); err != nil {
+		logRequestf(r, "template error: %v", err)
+	}
+}
+
+var notFoundPageTemplate = template.Must(template.New("notfound").Parse(`<!doctype html>
+<html lang="en">
+<head>
+  <meta charset="utf-8" />
+  <meta name="viewport" content="width=device-width, initial-scale=1" />
+  <title>{{ .title }}</title>
+  <style>
+    :root { --bg:#f3f0e7; --ink:#0f172a; --muted:#667085; --line:rgba(15,23,42,.12); --card:rgba(255,255,255,.88); --brand:#0f766e; --shadow:0 14px 32px rgba(15,23,42,.08);}
+    * { box-sizing: border-box; }
+    body { margin:0; color:var(--ink); font-family:"Georgia","Times New Roman",serif; background:radial-gradient(900px 500px at 8% -5%, rgba(245,158,11,.14), transparent 60%), radial-gradient(900px 500px at 95% 0%, rgba(16,185,129,.12), transparent 60%), linear-gradient(180deg, #f7f4ec 0%, #f3f0e7 45%, #efede6 100%); }
+    .shell { max-width:1180px; margin:0 auto; padding:20px 20px 56px; }
+    .topbar { display:flex; align-items:center; justify-content:space-between; gap:12px; flex-wrap:wrap; padding:10px 14px; border:1px solid var(--line); background:rgba(255,255,255,.72); border-radius:999px; backdrop-filter:blur(6px); position:sticky; top:10px; z-index:10; }
+    .logo { font-size:14px; letter-spacing:.16em; text-transform:uppercase; font-weight:700; color:var(--brand); text-decoration:none; }
+    .panel { margin-top:18px; border:1px solid var(--line); border-radius:20px; background:var(--card); box-shadow:var(--shadow); padding:32px 24px; text-align:center; }
+    .panel h1 { margin:0 0 8px; font-size:26px; }
+    .panel-sub { color:var(--muted); font-size:14px; margin-bottom:20px; word-break:break-all; }
+    .search-form { display:flex; align-items:center; gap:8px; max-width:560px; margin:0 auto 18px; }
+    .search-input { flex:1; min-width:0; border:1px solid var(--line); background:rgba(255,255,255,.95); border-radius:999px; padding:10px 14px; font-size:14px; outline:none; }
+    .search-submit { border:1px solid rgba(15,118,110,.2); background:#0f766e; color:#fff; border-radius:999px; padding:10px 14px; font-size:13px; cursor:pointer; white-space:nowrap; }
+    .chip { display:inline-flex; align-items:center; padding:8px 12px; border:1px solid var(--line); border-radius:999px; background:rgba(255,255,255,.85); font-size:13px; text-decoration:none; color:#1f2937; }
+    @media (max-width:760px){ .topbar{border-radius:18px;} .search-form{flex-direction:column; align-items:stretch;} }
+  </style>
+</head>
+<body>
+  <div class="shell">
+    <div class="topbar">
+      <a class="logo" href="/">dimi</a>
+    </div>
+    <section class="panel">
+      <h1>We couldn't find that page</h1>
+      <div class="panel-sub">Nothing lives at {{ .path }}. Try searching for a product instead.</div>
+      <form class="search-form" action="/search" method="get" role="search">
+        <input class="search-input" type="search" name="q" minlength="{{ .search_min_chars }}" required placeholder="Search products, brands, categories" />
+        <button class="search-submit" type="submit">Search</button>
+      </form>
+      <a class="chip" href="/">Back to home</a>
+    </section>
+  </div>
+</body>
+</html>`))
+
 func getString(row map[string]any, key string) string {
 	v, ok := row[key]
 	if !ok || v == nil {
@@ -0,0 +1,1630 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+func TestFormatPrice(t *testing.T) {
+	cases := []struct {
+		amount   float64
+		currency string
+		want     string
+	}{
+		{4.99, "EUR", "4,99 €"},
+		{1234.5, "EUR", "1.234,50 €"},
+		{4.99, "USD", "$4.99"},
+		{1234.5, "usd", "$1,234.50"},
+		{4.99, "GBP", "£4.99"},
+		{-2.5, "GBP", "-£2.50"},
+		{4.99, "CHF", "4.99 CHF"},
+	}
+	for _, tc := range cases {
+		if got := formatPrice(tc.amount, tc.currency); got != tc.want {
+			t.Errorf("formatPrice(%v, %q) = %q, want %q", tc.amount, tc.currency, got, tc.want)
+		}
+	}
+}
+
+func TestRenderCardsUseFormattedPrice(t *testing.T) {
+	item := map[string]any{"gtin": "4000000000001", "name": "Soap", "price_eur": 4.99, "currency": "EUR"}
+	if got := renderHomeCardHTML(item); !strings.Contains(got, "4,99 €") {
+		t.Fatalf("expected home card to contain 4,99 €, got %s", got)
+	}
+	if got := string(renderSimilarCardsHTML([]map[string]any{item})); !strings.Contains(got, "4,99 €") {
+		t.Fatalf("expected similar card to contain 4,99 €, got %s", got)
+	}
+}
+
+func TestParsePerPageQueryParam(t *testing.T) {
+	cases := []struct {
+		query  string
+		want   int
+		wantOK bool
+	}{
+		{"", 10, true},
+		{"per_page=25", 25, true},
+		{"per_page=500", searchMaxPageSize, true},
+		{"per_page=0", 0, false},
+		{"per_page=abc", 0, false},
+	}
+	for _, tc := range cases {
+		r := httptest.NewRequest(http.MethodGet, "/search?q=soap&"+tc.query, nil)
+		got, ok := parsePerPageQueryParam(r, 10)
+		if got != tc.want || ok != tc.wantOK {
+			t.Errorf("parsePerPageQueryParam(%q) = %d, %v; want %d, %v", tc.query, got, ok, tc.want, tc.wantOK)
+		}
+	}
+}
+
+func openTestProductsDB(t *testing.T) *sql.DB {
+	t.Helper()
+	dir := t.TempDir()
+	db, err := openProductsDB(filepath.Join(dir, "products.sqlite"), filepath.Join(dir, "fts.sqlite"))
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	stmts := []string{
+		`CREATE TABLE products (gtin TEXT, name TEXT, brand TEXT, price_eur REAL, currency TEXT, category_path TEXT, rating_value REAL, rating_count INTEGER,
+			unit_price_eur REAL, unit_price_per_quantity REAL, unit_price_per_unit TEXT, product_is_pharmacy INTEGER, has_eyecatchers INTEGER, has_pills INTEGER)`,
+		`INSERT INTO products VALUES
+			('4000000000001', 'Shampoo Repair', 'Dove', 3.49, 'EUR', 'Haare > Shampoo', 4.5, 120, 1.40, 100, 'ml', 0, 1, 0),
+			('4000000000002', 'Duschgel Sensitive', 'Dove', 1.95, 'EUR', 'Körper > Duschgel', 4.2, 80, NULL, NULL, NULL, 0, 0, 0),
+			('4000000000003', 'Shampoo Volumen', 'Balea', 0.95, 'EUR', 'Haare > Shampoo', 4.0, 300, 0.38, 100, 'ml', 0, 0, 0),
+			('4000000000004', 'Zahnpasta Kräuter', 'Elmex', 2.45, 'EUR', 'Mund > Zahnpasta', 4.7, 55, 3.27, 100, 'ml', 1, 0, 1)`,
+	}
+	for _, q := range stmts {
+		if _, err := db.Exec(q); err != nil {
+			t.Fatalf("seed db: %v", err)
+		}
+	}
+	return db
+}
+
+// testSourceStamp is searchSourceStamp for the main database file of db.
+func testSourceStamp(t *testing.T, db *sql.DB) string {
+	t.Helper()
+	var path string
+	if err := db.QueryRow(`SELECT file FROM pragma_database_list WHERE name = 'main'`).Scan(&path); err != nil {
+		t.Fatalf("database path: %v", err)
+	}
+	stamp, err := searchSourceStamp(path)
+	if err != nil {
+		t.Fatalf("searchSourceStamp: %v", err)
+	}
+	return stamp
+}
+
+func TestFetchSearchPayload_FTSMatchesWordsAcrossColumns(t *testing.T) {
+	db := openTestProductsDB(t)
+	if !sqliteHasFTS5(db) {
+		t.Skip("sqlite build without FTS5")
+	}
+	cols, err := tableColumns(db, "products")
+	if err != nil {
+		t.Fatalf("tableColumns: %v", err)
+	}
+	stamp := testSourceStamp(t, db)
+	ftsTable, err := ensureSearchFTS(db, "products", searchFieldsFor(cols), stamp)
+	if err != nil {
+		t.Fatalf("ensureSearchFTS: %v", err)
+	}
+	var served int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM main.sqlite_master WHERE name LIKE 'products_fts%'`).Scan(&served); err != nil || served != 0 {
+		t.Fatalf("expected the FTS index outside the served database, found %d tables (err=%v)", served, err)
+	}
+
+	like, err := fetchSearchPayload(db, "products", cols, "gtin", "dove shampoo", 1, 10, 0, "", false)
+	if err != nil {
+		t.Fatalf("LIKE search: %v", err)
+	}
+	if like.Total != 0 {
+		t.Fatalf("expected LIKE to miss multi-word query, got %d results", like.Total)
+	}
+	fts, err := fetchSearchPayload(db, "products", cols, "gtin", "dove shampoo", 1, 10, 0, ftsTable, false)
+	if err != nil {
+		t.Fatalf("FTS search: %v", err)
+	}
+	if fts.Total != 1 || len(fts.Items) != 1 || fts.Items[0]["gtin"] != "4000000000001" {
+		t.Fatalf("expected FTS to find the Dove shampoo, got %+v", fts.Items)
+	}
+
+	prefix, err := fetchSearchPayload(db, "products", cols, "gtin", "sham", 1, 10, 0, ftsTable, false)
+	if err != nil {
+		t.Fatalf("FTS prefix search: %v", err)
+	}
+	if prefix.Total != 2 || prefix.PerPage != 10 || prefix.MaxPage != 1 {
+		t.Fatalf("expected 2 prefix matches on one page, got total=%d per_page=%d max_page=%d", prefix.Total, prefix.PerPage, prefix.MaxPage)
+	}
+
+	again, err := ensureSearchFTS(db, "products", searchFieldsFor(cols), stamp)
+	if err != nil || again != ftsTable {
+		t.Fatalf("expected existing FTS index to be reused, got %q, %v", again, err)
+	}
+
+	if _, err := db.Exec(`UPDATE products SET name = 'Conditioner Repair' WHERE gtin = '4000000000001'`); err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	edited := testSourceStamp(t, db)
+	if edited == stamp {
+		t.Fatalf("expected the source stamp to change after an edit, still %q", stamp)
+	}
+	if _, err := ensureSearchFTS(db, "products", searchFieldsFor(cols), edited); err != nil {
+		t.Fatalf("ensureSearchFTS after edit: %v", err)
+	}
+	rebuilt, err := fetchSearchPayload(db, "products", cols, "gtin", "conditioner", 1, 10, 0, ftsTable, false)
+	if err != nil {
+		t.Fatalf("FTS search after edit: %v", err)
+	}
+	if rebuilt.Total != 1 || rebuilt.Items[0]["gtin"] != "4000000000001" {
+		t.Fatalf("expected the FTS index to be rebuilt after an edit with the same row count, got %+v", rebuilt.Items)
+	}
+}
+
+func TestWithRequestLogging_LogsNotFound(t *testing.T) {
+	var buf bytes.Buffer
+	mux := http.NewServeMux()
+	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) { _, _ = w.Write([]byte("ok")) })
+	h := withRequestLogging(mux, log.New(&buf, "", 0), "text", nil)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/missing-page", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("expected 404, got %d", rec.Code)
+	}
+	line := buf.String()
+	if !strings.Contains(line, "status=404") || !strings.Contains(line, `path="/missing-page"`) || !strings.Contains(line, "method=GET") {
+		t.Fatalf("unexpected log line: %q", line)
+	}
+
+	buf.Reset()
+	h = withRequestLogging(mux, log.New(&buf, "", 0), "json", nil)
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/health", nil))
+	var entry requestLogEntry
+	if err := json.Unmarshal(bytes.TrimSpace(buf.Bytes()), &entry); err != nil {
+		t.Fatalf("expected JSON log line, got %q: %v", buf.String(), err)
+	}
+	if entry.Status != http.StatusOK || entry.Path != "/health" || entry.Bytes != 2 {
+		t.Fatalf("unexpected JSON log entry: %+v", entry)
+	}
+}
+
+func TestMetricsEndpoint_CountsRequests(t *testing.T) {
+	metrics := newServerMetrics()
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics)
+	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) { _, _ = w.Write([]byte("ok")) })
+	h := withRequestLogging(mux, log.New(io.Discard, "", 0), "text", metrics)
+
+	for _, path := range []string{"/health", "/health", "/product/does-not-exist/x?y"} {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+	metrics.observeDBError("search")
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body := rec.Body.String()
+	for _, want := range []string{
+		`http_requests_total{path="health",status="200"} 2`,
+		`http_requests_total{path="product",status="404"} 1`,
+		`http_request_duration_seconds_count 3`,
+		`db_query_errors_total{operation="search"} 1`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Fatalf("expected %q in metrics output:\n%s", want, body)
+		}
+	}
+}
+
+func TestRenderNotFound_StyledPageWithSearchForm(t *testing.T) {
+	rec := httptest.NewRecorder()
+	renderNotFound(rec, httptest.NewRequest(http.MethodGet, "/product/unknown", nil), 4)
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("expected 404, got %d", rec.Code)
+	}
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/html") {
+		t.Fatalf("expected HTML content type, got %q", ct)
+	}
+	body := rec.Body.String()
+	for _, want := range []string{`<form class="search-form" action="/search"`, `name="q" minlength="4"`, `href="/"`, "/product/unknown"} {
+		if !strings.Contains(body, want) {
+			t.Fatalf("expected %q in 404 body", want)
+		}
+	}
+}
+
+func TestProductPage_PriceStableBadge(t *testing.T) {
+	render := func(row map[string]any) string {
+		var b strings.Builder
+		if err := productPageTemplate.Execute(&b, productPageData("4000000000001", row, nil)); err != nil {
+			t.Fatalf("template error: %v", err)
+		}
+		return b.String()
+	}
+
+	withDate := render(map[string]any{"name": "Soap", "price_eur": 1.95, "gross_not_increased_since": "2025-03-07"})
+	if !strings.Contains(withDate, "Price stable since 7 March 2025") {
+		t.Fatalf("expected price-stable badge in product page")
+	}
+	without := render(map[string]any{"name": "Soap", "price_eur": 1.95})
+	if strings.Contains(without, "Price stable since") {
+		t.Fatalf("expected no price-stable badge without gross_not_increased_since")
+	}
+}
+
+func TestFormatUnitPrice(t *testing.T) {
+	full := map[string]any{"unit_price_eur": 0.35, "unit_price_per_quantity": 100.0, "unit_price_per_unit": "ml", "currency": "EUR"}
+	if got, want := formatUnitPrice(full), "0,35 € / 100 ml"; got != want {
+		t.Fatalf("formatUnitPrice(full) = %q, want %q", got, want)
+	}
+	perLitre := map[string]any{"unit_price_eur": 2.5, "unit_price_per_quantity": 1.0, "unit_price_per_unit": "l"}
+	if got, want := formatUnitPrice(perLitre), "2,50 € / l"; got != want {
+		t.Fatalf("formatUnitPrice(perLitre) = %q, want %q", got, want)
+	}
+	if got := formatUnitPrice(map[string]any{"price_eur": 1.95}); got != "" {
+		t.Fatalf("expected empty unit price without unit fields, got %q", got)
+	}
+
+	var b strings.Builder
+	if err := productPageTemplate.Execute(&b, productPageData("1", full, nil)); err != nil {
+		t.Fatalf("template error: %v", err)
+	}
+	if !strings.Contains(b.String(), `<div class="unit-price">0,35 € / 100 ml</div>`) {
+		t.Fatalf("expected unit price line on product page")
+	}
+	if card := renderHomeCardHTML(map[string]any{"gtin": "1", "price_eur": 1.95}); strings.Contains(card, "card-unit-price") {
+		t.Fatalf("expected no unit price line on card without unit fields")
+	}
+}
+
+func newTestServer(t *testing.T) (*sql.DB, http.Handler) {
+	t.Helper()
+	db := openTestProductsDB(t)
+	cols, err := tableColumns(db, "products")
+	if err != nil {
+		t.Fatalf("tableColumns: %v", err)
+	}
+	mux := newServerMux(db, serverConfig{
+		Table:            "products",
+		Cols:             cols,
+		IDCol:            "gtin",
+		SitemapChunkSize: defaultSitemapChunkSize,
+		SearchPageSize:   defaultSearchPageSize,
+	}, newServerMetrics())
+	return db, mux
+}
+
+func TestFormatJSON_HomeAndProduct(t *testing.T) {
+	_, h := newTestServer(t)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/?format=json", nil))
+	if ct := rec.Header().Get("Content-Type"); rec.Code != http.StatusOK || !strings.HasPrefix(ct, "application/json") {
+		t.Fatalf("expected JSON home response, got %d %q", rec.Code, ct)
+	}
+	var home homePayload
+	if err := json.Unmarshal(rec.Body.Bytes(), &home); err != nil {
+		t.Fatalf("decode home payload: %v", err)
+	}
+	if home.Table != "products" || len(home.Sections) == 0 {
+		t.Fatalf("expected home sections in JSON payload, got %+v", home)
+	}
+
+	rec = httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/product/4000000000003", nil)
+	req.Header.Set("Accept", "application/json")
+	h.ServeHTTP(rec, req)
+	var row map[string]any
+	if err := json.Unmarshal(rec.Body.Bytes(), &row); err != nil {
+		t.Fatalf("decode product row: %v (body %q)", err, rec.Body.String())
+	}
+	if row["gtin"] != "4000000000003" || row["brand"] != "Balea" {
+		t.Fatalf("unexpected product JSON: %v", row)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/product/4000000000003", nil))
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/html") {
+		t.Fatalf("expected HTML product page by default, got %q", ct)
+	}
+}
+
+func TestFetchSimilar_LimitRankingAndMissingColumns(t *testing.T) {
+	db := openTestProductsDB(t)
+	if _, err := db.Exec(`INSERT INTO products (gtin, name, brand, price_eur, currency, category_path, rating_value, rating_count) VALUES
+		('4000000000005', 'Shampoo Care', 'Dove', 2.95, 'EUR', 'Haare > Shampoo', 3.0, 10),
+		('4000000000006', 'Shampoo Mild', 'Balea', 0.85, 'EUR', 'Haare > Shampoo', 4.9, 40)`); err != nil {
+		t.Fatalf("seed extra rows: %v", err)
+	}
+	cols, err := tableColumns(db, "products")
+	if err != nil {
+		t.Fatalf("tableColumns: %v", err)
+	}
+	gtins := func(items []map[string]any) []string {
+		out := make([]string, 0, len(items))
+		for _, it := range items {
+			out = append(out, getString(it, "gtin"))
+		}
+		return out
+	}
+
+	weighted, err := fetchSimilar(db, "products", cols, "gtin", "4000000000001", 10, similarModeBrandCategory)
+	if err != nil {
+		t.Fatalf("fetchSimilar weighted: %v", err)
+	}
+	if got, want := strings.Join(gtins(weighted), ","), "4000000000005,4000000000002,4000000000006,4000000000003"; got != want {
+		t.Fatalf("brand-category order = %s, want %s", got, want)
+	}
+
+	byCategory, err := fetchSimilar(db, "products", cols, "gtin", "4000000000001", 10, similarModeCategory)
+	if err != nil {
+		t.Fatalf("fetchSimilar category: %v", err)
+	}
+	if got, want := strings.Join(gtins(byCategory), ","), "4000000000006,4000000000003,4000000000005,4000000000002"; got != want {
+		t.Fatalf("category order = %s, want %s", got, want)
+	}
+
+	limited, err := fetchSimilar(db, "products", cols, "gtin", "4000000000001", 2, similarModeBrandCategory)
+	if err != nil {
+		t.Fatalf("fetchSimilar limited: %v", err)
+	}
+	if len(limited) != 2 {
+		t.Fatalf("expected 2 similar items with limit 2, got %d", len(limited))
+	}
+
+	var noBrand []string
+	for _, c := range cols {
+		if c != "brand" {
+			noBrand = append(noBrand, c)
+		}
+	}
+	empty, err := fetchSimilar(db, "products", noBrand, "gtin", "4000000000001", 10, similarModeCategory)
+	if err != nil || len(empty) != 0 {
+		t.Fatalf("expected empty result without brand column, got %v, %v", empty, err)
+	}
+}
+
+func TestBuildProductURLSetXML_ImageExtension(t *testing.T) {
+	var buf bytes.Buffer
+	payload := buildProductURLSetXML("https://shop.example", []sitemapProduct{
+		{ID: "4000000000001", Image: "https://img.example/p/1.jpg"},
+		{ID: "4000000000002"},
+	})
+	if err := xml.NewEncoder(&buf).Encode(payload); err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	out := buf.String()
+	for _, want := range []string{
+		`xmlns:image="http://www.google.com/schemas/sitemap-image/1.1"`,
+		`<url><loc>https://shop.example/product/4000000000001</loc><image:image><image:loc>https://img.example/p/1.jpg</image:loc></image:image></url>`,
+		`<url><loc>https://shop.example/product/4000000000002</loc></url>`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("expected %q in sitemap XML:\n%s", want, out)
+		}
+	}
+
+	buf.Reset()
+	if err := xml.NewEncoder(&buf).Encode(buildProductURLSetXML("https://shop.example", []sitemapProduct{{ID: "1"}})); err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	if strings.Contains(buf.String(), "image") {
+		t.Fatalf("expected no image namespace without images: %s", buf.String())
+	}
+}
+
+func TestBuildProductURLSetXML_EncodesIDs(t *testing.T) {
+	payload := buildProductURLSetXML("https://shop.example", []sitemapProduct{
+		{ID: "pack of 2"},
+		{ID: "salt&pepper"},
+		{ID: "a/b"},
+		{ID: "  "},
+		{ID: "bad\nid"},
+	})
+	var locs []string
+	for _, it := range payload.Items {
+		locs = append(locs, it.Loc)
+	}
+	want := []string{
+		"https://shop.example/product/pack%20of%202",
+		"https://shop.example/product/salt%26pepper",
+		"https://shop.example/product/a%2Fb",
+	}
+	if strings.Join(locs, " ") != strings.Join(want, " ") {
+		t.Fatalf("locs = %v, want %v", locs, want)
+	}
+	for _, loc := range locs {
+		u, err := url.Parse(loc)
+		if err != nil || u.Scheme != "https" || strings.Count(u.EscapedPath(), "/") != 2 {
+			t.Fatalf("expected %q to parse as a single-segment product URL (err %v)", loc, err)
+		}
+	}
+	u, _ := url.Parse(locs[1])
+	if got := strings.TrimPrefix(u.Path, "/product/"); got != "salt&pepper" {
+		t.Fatalf("expected loc to decode back to the id, got %q", got)
+	}
+}
+
+func TestWithAPICORS(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/ping", func(w http.ResponseWriter, r *http.Request) { writeJSON(w, map[string]string{"ok": "yes"}, false) })
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) { _, _ = w.Write([]byte("home")) })
+
+	h := withAPICORS(mux, "https://app.example")
+	pre := httptest.NewRequest(http.MethodOptions, "/api/ping", nil)
+	pre.Header.Set("Origin", "https://app.example")
+	pre.Header.Set("Access-Control-Request-Method", "GET")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, pre)
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("expected 204 preflight, got %d", rec.Code)
+	}
+	if got := rec.Header().Get("Access-Control-Allow-Origin"); got != "https://app.example" {
+		t.Fatalf("preflight Allow-Origin = %q", got)
+	}
+	if got := rec.Header().Get("Access-Control-Allow-Methods"); !strings.Contains(got, "GET") {
+		t.Fatalf("preflight Allow-Methods = %q", got)
+	}
+	if rec.Header().Get("Access-Control-Allow-Headers") == "" {
+		t.Fatalf("expected Allow-Headers on preflight")
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/ping", nil))
+	if got := rec.Header().Get("Access-Control-Allow-Origin"); got != "https://app.example" {
+		t.Fatalf("GET Allow-Origin = %q", got)
+	}
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if got := rec.Header().Get("Access-Control-Allow-Origin"); got != "" {
+		t.Fatalf("expected HTML route without CORS headers, got %q", got)
+	}
+
+	rec = httptest.NewRecorder()
+	withAPICORS(mux, "").ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/ping", nil))
+	if got := rec.Header().Get("Access-Control-Allow-Origin"); got != "" {
+		t.Fatalf("expected no CORS headers when unconfigured, got %q", got)
+	}
+}
+
+func TestSlugRoutes(t *testing.T) {
+	db, h := newTestServer(t)
+	for _, q := range []string{
+		`ALTER TABLE products ADD COLUMN slug TEXT`,
+		`ALTER TABLE products ADD COLUMN scraped_at_utc TEXT`,
+		`UPDATE products SET slug = 'dove-shampoo-repair', scraped_at_utc = '2026-01-01T10:00:00Z' WHERE gtin = '4000000000001'`,
+		`UPDATE products SET slug = 'balea-shampoo', scraped_at_utc = '2026-02-01T10:00:00Z' WHERE gtin = '4000000000003'`,
+		`UPDATE products SET slug = 'dove-shampoo-repair', scraped_at_utc = '2026-03-01T10:00:00Z' WHERE gtin = '4000000000002'`,
+	} {
+		if _, err := db.Exec(q); err != nil {
+			t.Fatalf("%s: %v", q, err)
+		}
+	}
+	cols, err := tableColumns(db, "products")
+	if err != nil {
+		t.Fatalf("tableColumns: %v", err)
+	}
+	h = newServerMux(db, serverConfig{Table: "products", Cols: cols, IDCol: "gtin", SitemapChunkSize: 10, SearchPageSize: 10}, newServerMetrics())
+
+	row, err := fetchBySlug(db, "products", cols, "balea-shampoo")
+	if err != nil || getString(row, "gtin") != "4000000000003" {
+		t.Fatalf("fetchBySlug(balea-shampoo) = %v, %v", row, err)
+	}
+	dup, err := fetchBySlug(db, "products", cols, "dove-shampoo-repair")
+	if err != nil || getString(dup, "gtin") != "4000000000002" {
+		t.Fatalf("expected newest duplicate-slug row, got %v, %v", dup, err)
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/p/balea-shampoo", nil))
+	if rec.Code != http.StatusMovedPermanently || rec.Header().Get("Location") != "/product/4000000000003" {
+		t.Fatalf("expected 301 to canonical product URL, got %d %q", rec.Code, rec.Header().Get("Location"))
+	}
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/p/unknown-slug", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for unknown slug, got %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/sitemaps/products-1.xml", nil))
+	body := rec.Body.String()
+	if !strings.Contains(body, "/p/balea-shampoo</loc>") || strings.Count(body, "/p/dove-shampoo-repair</loc>") != 1 {
+		t.Fatalf("expected slug locs (deduplicated) in sitemap:\n%s", body)
+	}
+	if !strings.Contains(body, "/product/4000000000001</loc>") || strings.Contains(body, "/product/4000000000002</loc>") {
+		t.Fatalf("expected the non-owner duplicate under /product/{id}:\n%s", body)
+	}
+}
+
+func TestPageCache_ServesStaleBodyWithinTTL(t *testing.T) {
+	db := openTestProductsDB(t)
+	cols, err := tableColumns(db, "products")
+	if err != nil {
+		t.Fatalf("tableColumns: %v", err)
+	}
+	h := newServerMux(db, serverConfig{Table: "products", Cols: cols, IDCol: "gtin", SitemapChunkSize: 10, SearchPageSize: 10, CacheTTL: time.Minute}, newServerMetrics())
+	get := func(path string, hdr map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		for k, v := range hdr {
+			req.Header.Set(k, v)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	first := get("/product/4000000000003", nil)
+	if first.Code != http.StatusOK || !strings.Contains(first.Body.String(), "Balea") {
+		t.Fatalf("unexpected first response: %d", first.Code)
+	}
+	if _, err := db.Exec(`UPDATE products SET brand = 'Renamed' WHERE gtin = '4000000000003'`); err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	second := get("/product/4000000000003", nil)
+	if second.Body.String() != first.Body.String() {
+		t.Fatalf("expected cached body within TTL")
+	}
+	if ct := second.Header().Get("Content-Type"); ct != "text/html; charset=utf-8" {
+		t.Fatalf("cached Content-Type = %q", ct)
+	}
+	etag := second.Header().Get("ETag")
+	if etag == "" || etag != first.Header().Get("ETag") {
+		t.Fatalf("expected stable ETag, got %q vs %q", etag, first.Header().Get("ETag"))
+	}
+	if rec := get("/product/4000000000003", map[string]string{"If-None-Match": etag}); rec.Code != http.StatusNotModified {
+		t.Fatalf("expected 304 for matching ETag, got %d", rec.Code)
+	}
+
+	js := get("/product/4000000000003?format=json", nil)
+	if ct := js.Header().Get("Content-Type"); ct != "application/json" || !strings.Contains(js.Body.String(), "Renamed") {
+		t.Fatalf("expected JSON to be cached separately from HTML, got %q %s", ct, js.Body.String())
+	}
+	if rec := get("/product/unknown", nil); rec.Code != http.StatusNotFound {
+		t.Fatalf("expected uncached 404, got %d", rec.Code)
+	}
+}
+
+func TestSearchJSON_PaginationHeaders(t *testing.T) {
+	db, h := newTestServer(t)
+	get := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec
+	}
+
+	first := get("/search?q=shampoo&format=json&per_page=1")
+	if first.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", first.Code, first.Body.String())
+	}
+	if got := first.Header().Get("X-Total-Count"); got != "2" {
+		t.Fatalf("X-Total-Count = %q, want 2", got)
+	}
+	link := first.Header().Get("Link")
+	if !strings.Contains(link, `page=2&per_page=1&q=shampoo>; rel="next"`) || strings.Contains(link, `rel="prev"`) {
+		t.Fatalf("unexpected Link on first page: %q", link)
+	}
+	var payload searchPayload
+	if err := json.Unmarshal(first.Body.Bytes(), &payload); err != nil || payload.Returned != 1 {
+		t.Fatalf("expected JSON search payload with one item, got %v (%s)", err, first.Body.String())
+	}
+
+	last := get("/search?q=shampoo&format=json&per_page=1&page=2")
+	link = last.Header().Get("Link")
+	if strings.Contains(link, `rel="next"`) || !strings.Contains(link, `rel="prev"`) {
+		t.Fatalf("unexpected Link on last page: %q", link)
+	}
+
+	if rec := get("/search?q=ab&format=json"); rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), `"code":"query_too_short"`) {
+		t.Fatalf("expected 400 JSON error for short query, got %d %s", rec.Code, rec.Body.String())
+	}
+	if _, err := db.Exec(`DROP TABLE products`); err != nil {
+		t.Fatalf("drop table: %v", err)
+	}
+	if rec := get("/search?q=shampoo&format=json"); rec.Code != http.StatusInternalServerError || !strings.Contains(rec.Body.String(), `"code":"internal_error"`) {
+		t.Fatalf("expected 500 JSON error when the query fails, got %d %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestBaseURLPolicy_ForwardedHeaders(t *testing.T) {
+	req := func() *http.Request {
+		r := httptest.NewRequest(http.MethodGet, "/sitemap.xml", nil)
+		r.Host = "shop.example"
+		return r
+	}
+
+	spoofed := req()
+	spoofed.Header.Set("X-Forwarded-Proto", "javascript")
+	if got := (baseURLPolicy{TrustForwarded: true}).requestBaseURL(spoofed); got != "http://shop.example" {
+		t.Fatalf("expected invalid forwarded scheme to be ignored, got %q", got)
+	}
+
+	trusted := req()
+	trusted.Header.Set("X-Forwarded-Proto", "https")
+	if got := (baseURLPolicy{TrustForwarded: true}).requestBaseURL(trusted); got != "https://shop.example" {
+		t.Fatalf("expected trusted https to be honored, got %q", got)
+	}
+	if got := (baseURLPolicy{}).requestBaseURL(trusted); got != "http://shop.example" {
+		t.Fatalf("expected forwarded headers ignored when untrusted, got %q", got)
+	}
+
+	badHost := req()
+	badHost.Host = `evil.example"><script>`
+	if got := (baseURLPolicy{FallbackHost: "127.0.0.1:18745"}).requestBaseURL(badHost); got != "http://127.0.0.1:18745" {
+		t.Fatalf("expected malformed Host to fall back, got %q", got)
+	}
+	other := req()
+	other.Host = "attacker.example"
+	if got := (baseURLPolicy{AllowedHosts: []string{"shop.example"}}).requestBaseURL(other); got != "http://shop.example" {
+		t.Fatalf("expected host outside allowlist to fall back, got %q", got)
+	}
+}
+
+func TestAPISearch(t *testing.T) {
+	_, h := newTestServer(t)
+	get := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec
+	}
+	decodeErr := func(rec *httptest.ResponseRecorder) jsonError {
+		var body struct {
+			Error jsonError `json:"error"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+			t.Fatalf("decode error body %q: %v", rec.Body.String(), err)
+		}
+		return body.Error
+	}
+
+	ok := get("/api/search?q=shampoo&per_page=1")
+	if ok.Code != http.StatusOK || ok.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("expected JSON 200, got %d %q", ok.Code, ok.Header().Get("Content-Type"))
+	}
+	var payload searchPayload
+	if err := json.Unmarshal(ok.Body.Bytes(), &payload); err != nil {
+		t.Fatalf("decode payload: %v", err)
+	}
+	if payload.Total != 2 || payload.PerPage != 1 || len(payload.Items) != 1 {
+		t.Fatalf("unexpected payload: total=%d per_page=%d items=%d", payload.Total, payload.PerPage, len(payload.Items))
+	}
+
+	short := get("/api/search?q=ab")
+	if short.Code != http.StatusBadRequest || decodeErr(short).Code != "query_too_short" {
+		t.Fatalf("expected 400 query_too_short, got %d %s", short.Code, short.Body.String())
+	}
+	beyond := get("/api/search?q=shampoo&page=9")
+	if beyond.Code != http.StatusBadRequest || decodeErr(beyond).Code != "page_out_of_range" {
+		t.Fatalf("expected 400 page_out_of_range, got %d %s", beyond.Code, beyond.Body.String())
+	}
+	invalid := get("/api/search?q=shampoo&page=0")
+	if invalid.Code != http.StatusBadRequest || decodeErr(invalid).Code != "invalid_page" {
+		t.Fatalf("expected 400 invalid_page, got %d %s", invalid.Code, invalid.Body.String())
+	}
+}
+
+func TestRetryWithBackoffSucceedsAfterTransientFailures(t *testing.T) {
+	prev := dbRetryBaseDelay
+	dbRetryBaseDelay = time.Millisecond
+	defer func() { dbRetryBaseDelay = prev }()
+
+	calls := 0
+	err := retryWithBackoff(3, func() error {
+		calls++
+		if calls <= 2 {
+			return errors.New("database is locked")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("expected success after retries, got %v", err)
+	}
+	if calls != 3 {
+		t.Fatalf("calls = %d, want 3", calls)
+	}
+
+	calls = 0
+	err = retryWithBackoff(1, func() error {
+		calls++
+		return errors.New("database is locked")
+	})
+	if err == nil || calls != 2 {
+		t.Fatalf("expected failure after 2 attempts, got err=%v calls=%d", err, calls)
+	}
+
+	calls = 0
+	err = retryWithBackoff(3, func() error {
+		calls++
+		return errors.New(`table "products" not found`)
+	})
+	if err == nil || calls != 1 {
+		t.Fatalf("expected a non-transient error to fail without retrying, got err=%v calls=%d", err, calls)
+	}
+}
+
+func TestResolveTable(t *testing.T) {
+	db := openTestProductsDB(t)
+	if _, err := db.Exec(`CREATE TABLE categories (name TEXT)`); err != nil {
+		t.Fatalf("create table: %v", err)
+	}
+
+	table, err := resolveTable(db, "products")
+	if err != nil || table != "products" {
+		t.Fatalf("explicit table: got %q, %v", table, err)
+	}
+
+	_, err = resolveTable(db, "missing")
+	if err == nil {
+		t.Fatalf("expected error for unknown table")
+	}
+	for _, want := range []string{`"missing"`, "categories", "products"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Fatalf("error %q should mention %s", err, want)
+		}
+	}
+
+	table, err = resolveTable(db, "")
+	if err != nil || table != "categories" {
+		t.Fatalf("fallback: got %q, %v (want first table alphabetically)", table, err)
+	}
+}
+
+func TestHomeSectionItems_SkipUnlinkableIDs(t *testing.T) {
+	db := openTestProductsDB(t)
+	if _, err := db.Exec("INSERT INTO products VALUES ('bad\x01id', 'Shampoo Broken', 'Dove', 1.00, 'EUR', 'Haare > Shampoo', 4.9, 500, NULL, NULL, NULL, 0, 0, 0), ('a b/c', 'Shampoo Spaced', 'Dove', 1.00, 'EUR', 'Haare > Shampoo', 4.8, 400, NULL, NULL, NULL, 0, 0, 0)"); err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+	cols, err := tableColumns(db, "products")
+	if err != nil {
+		t.Fatalf("tableColumns: %v", err)
+	}
+	items, err := fetchHomeSectionItems(db, "products", cols, "gtin", false, topRatedWhere, topRatedOrder, 10)
+	if err != nil {
+		t.Fatalf("home section: %v", err)
+	}
+	paths := map[string]bool{}
+	for _, item := range items {
+		paths[getString(item, "product_path")] = true
+		if strings.ContainsRune(getString(item, "id"), 1) {
+			t.Fatalf("expected the control-character id to be skipped, got %v", item)
+		}
+	}
+	if !paths["/product/a%20b%2Fc"] {
+		t.Fatalf("expected the spaced id to link via productPathSegment, got %v", paths)
+	}
+	if html := renderSearchResultCardHTML(map[string]any{"id": "bad\x01id", "name": "Broken"}); html != "" {
+		t.Fatalf("expected no card for an unlinkable id, got %q", html)
+	}
+}
+
+func TestFetchHomePayload_WithoutUnitPriceColumns(t *testing.T) {
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "subset.sqlite"))
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	defer db.Close()
+	if _, err := db.Exec(`CREATE TABLE products (gtin TEXT, name TEXT, brand TEXT, price_eur REAL, currency TEXT, category_path TEXT, rating_value REAL, rating_count INTEGER, product_is_pharmacy INTEGER, has_eyecatchers INTEGER, has_pills INTEGER);
+		INSERT INTO products VALUES ('1', 'Shampoo', 'Dove', 3.49, 'EUR', 'Haare', 4.5, 120, 0, 0, 0), ('2', 'Conditioner', 'Dove', 2.49, 'EUR', 'Haare', 4.2, 80, 0, 0, 0);`); err != nil {
+		t.Fatalf("seed db: %v", err)
+	}
+	cols, err := tableColumns(db, "products")
+	if err != nil {
+		t.Fatalf("tableColumns: %v", err)
+	}
+	if _, err := fetchHomePayload(db, "products", cols, "gtin", 0, false); err != nil {
+		t.Fatalf("fetchHomePayload without unit price columns: %v", err)
+	}
+	items, err := fetchPopularFallback(db, "products", cols, "gtin", "1", 10, false)
+	if err != nil || len(items) != 1 {
+		t.Fatalf("expected the popular fallback to work without unit price columns, got %v, %v", items, err)
+	}
+}
+
+func TestProductPathsUseConfiguredIDColumn(t *testing.T) {
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "dan.sqlite"))
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	defer db.Close()
+	stmts := []string{
+		`CREATE TABLE products (dan INTEGER, name TEXT, brand TEXT, price_eur REAL, currency TEXT, category_path TEXT, rating_value REAL, rating_count INTEGER,
+			unit_price_eur REAL, unit_price_per_quantity REAL, unit_price_per_unit TEXT, product_is_pharmacy INTEGER, has_eyecatchers INTEGER, has_pills INTEGER)`,
+		`INSERT INTO products VALUES
+			(101, 'Shampoo Repair', 'Dove', 3.49, 'EUR', 'Haare > Shampoo', 4.5, 120, NULL, NULL, NULL, 0, 0, 0),
+			(102, 'Shampoo Volumen', 'Balea', 0.95, 'EUR', 'Haare > Shampoo', 4.0, 300, NULL, NULL, NULL, 0, 0, 0)`,
+	}
+	for _, q := range stmts {
+		if _, err := db.Exec(q); err != nil {
+			t.Fatalf("seed db: %v", err)
+		}
+	}
+	cols, err := tableColumns(db, "products")
+	if err != nil {
+		t.Fatalf("tableColumns: %v", err)
+	}
+
+	home, err := fetchHomePayload(db, "products", cols, "dan", 0, false)
+	if err != nil {
+		t.Fatalf("fetchHomePayload: %v", err)
+	}
+	if len(home.Sections) == 0 {
+		t.Fatalf("expected home sections")
+	}
+	for _, section := range home.Sections {
+		for _, item := range section.Items {
+			if path := getString(item, "product_path"); path != "/product/"+getString(item, "id") || path == "/product/" {
+				t.Fatalf("home item %v has product_path %q", item, path)
+			}
+		}
+	}
+	if html := string(renderHomeSectionsHTML(home)); !strings.Contains(html, `href="/product/101"`) {
+		t.Fatalf("home cards should link via dan, got %s", html)
+	}
+
+	search, err := fetchSearchPayload(db, "products", cols, "dan", "shampoo", 1, 10, 0, "", false)
+	if err != nil || len(search.Items) != 2 || getString(search.Items[0], "product_path") == "/product/" {
+		t.Fatalf("search items = %v, err=%v", search.Items, err)
+	}
+	similar, err := fetchSimilar(db, "products", cols, "dan", "101", 8, similarModeCategory)
+	if err != nil || len(similar) != 1 || getString(similar[0], "product_path") != "/product/102" {
+		t.Fatalf("similar items = %v, err=%v", similar, err)
+	}
+}
+
+func TestRenderDescriptionKeepsLineBreaksAndEscapesMarkup(t *testing.T) {
+	got := string(renderDescription("Sanfte Pflege\n<script>alert(1)</script>\r\n\nFür jeden Tag"))
+	want := "Sanfte Pflege<br>&lt;script&gt;alert(1)&lt;/script&gt;<br>Für jeden Tag"
+	if got != want {
+		t.Fatalf("renderDescription = %q, want %q", got, want)
+	}
+	if renderDescription("  ") != "" {
+		t.Fatalf("blank description should render empty")
+	}
+}
+
+func TestNewHTTPServerAppliesLimitFlags(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	limits := registerHTTPServerFlags(fs)
+	if err := fs.Parse([]string{"-read-header-timeout=2s", "-read-timeout=7s", "-write-timeout=9s", "-idle-timeout=11s", "-max-header-bytes=4096"}); err != nil {
+		t.Fatalf("parse flags: %v", err)
+	}
+	srv := newHTTPServer("127.0.0.1:0", http.NewServeMux(), *limits)
+	if srv.ReadHeaderTimeout != 2*time.Second || srv.ReadTimeout != 7*time.Second || srv.WriteTimeout != 9*time.Second || srv.IdleTimeout != 11*time.Second {
+		t.Fatalf("timeouts not applied: %+v", srv)
+	}
+	if srv.MaxHeaderBytes != 4096 || srv.Addr != "127.0.0.1:0" {
+		t.Fatalf("server fields not applied: addr=%q max_header_bytes=%d", srv.Addr, srv.MaxHeaderBytes)
+	}
+
+	defaults := registerHTTPServerFlags(flag.NewFlagSet("defaults", flag.ContinueOnError))
+	if defaults.ReadHeaderTimeout <= 0 || defaults.WriteTimeout <= 0 || defaults.MaxHeaderBytes <= 0 {
+		t.Fatalf("defaults should bound every limit: %+v", defaults)
+	}
+}
+
+func TestAPISimilarPaginates(t *testing.T) {
+	db := openTestProductsDB(t)
+	for i := 0; i < 20; i++ {
+		if _, err := db.Exec(`INSERT INTO products (gtin, name, brand, price_eur, currency, category_path, rating_value, rating_count)
+			VALUES (?, ?, 'Balea', 1.25, 'EUR', 'Haare > Shampoo', 4.0, ?)`, fmt.Sprintf("41%011d", i), fmt.Sprintf("Shampoo %02d", i), 10+i%3); err != nil {
+			t.Fatalf("seed: %v", err)
+		}
+	}
+	cols, err := tableColumns(db, "products")
+	if err != nil {
+		t.Fatalf("tableColumns: %v", err)
+	}
+	h := newServerMux(db, serverConfig{Table: "products", Cols: cols, IDCol: "gtin", SitemapChunkSize: 10, SearchPageSize: 10, SimilarLimit: 8, SimilarMode: similarModeCategory}, newServerMetrics())
+	getPage := func(path string) similarPayload {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status %d body %s", path, rec.Code, rec.Body.String())
+		}
+		var p similarPayload
+		if err := json.Unmarshal(rec.Body.Bytes(), &p); err != nil {
+			t.Fatalf("decode %s: %v", path, err)
+		}
+		return p
+	}
+
+	top, err := fetchSimilar(db, "products", cols, "gtin", "4000000000003", 8, similarModeCategory)
+	if err != nil {
+		t.Fatalf("fetchSimilar: %v", err)
+	}
+	first := getPage("/api/similar/4000000000003")
+	second := getPage("/api/similar/4000000000003?page=2")
+	if len(first.Items) != 8 || !first.HasMore || len(second.Items) != 8 {
+		t.Fatalf("unexpected page sizes: first=%d (more=%v) second=%d", len(first.Items), first.HasMore, len(second.Items))
+	}
+	seen := map[string]bool{}
+	for i, item := range first.Items {
+		if getString(item, "gtin") != getString(top[i], "gtin") {
+			t.Fatalf("page 1 item %d = %s, want top-8 %s", i, getString(item, "gtin"), getString(top[i], "gtin"))
+		}
+		seen[getString(item, "gtin")] = true
+	}
+	for _, item := range second.Items {
+		if seen[getString(item, "gtin")] {
+			t.Fatalf("page 2 repeats %s from page 1", getString(item, "gtin"))
+		}
+	}
+	if last := getPage("/api/similar/4000000000003?page=3"); last.HasMore || len(last.Items) == 0 {
+		t.Fatalf("last page: items=%d has_more=%v", len(last.Items), last.HasMore)
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/similar/missing", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("missing product: status %d", rec.Code)
+	}
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/product/4000000000003", nil))
+	if !strings.Contains(rec.Body.String(), `data-url="/api/similar/4000000000003"`) {
+		t.Fatalf("product page should offer a show-more button")
+	}
+}
+
+func TestSearchQueryNormalization(t *testing.T) {
+	if got := normalizeSearchQuery("  Niveä \t  Créme  "); got != "niveä créme" {
+		t.Fatalf("normalizeSearchQuery = %q", got)
+	}
+	if got := collapseSearchQuery("Nivea\u0308"); got != "Nive\u00e4" {
+		t.Fatalf("collapseSearchQuery should compose to NFC, got %q", got)
+	}
+
+	db := openTestProductsDB(t)
+	if _, err := db.Exec(`INSERT INTO products (gtin, name, brand, price_eur, currency, category_path, rating_value, rating_count)
+		VALUES ('4000000000005', 'Nivea Creme', 'Nivea', 2.95, 'EUR', 'Körper > Creme', 4.8, 900)`); err != nil {
+		t.Fatalf("seed: %v", err)
+	}
+	cols, err := tableColumns(db, "products")
+	if err != nil {
+		t.Fatalf("tableColumns: %v", err)
+	}
+	spaced, err := fetchSearchPayload(db, "products", cols, "gtin", "  Shampoo    Repair ", 1, 10, 0, "", false)
+	if err != nil || spaced.Total != 1 || spaced.Query != "  Shampoo    Repair " {
+		t.Fatalf("whitespace-collapsed search: total=%d query=%q err=%v", spaced.Total, spaced.Query, err)
+	}
+
+	h := newServerMux(db, serverConfig{Table: "products", Cols: cols, IDCol: "gtin", SitemapChunkSize: 10, SearchPageSize: 10, SearchMinChars: 5}, newServerMetrics())
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/search?q="+url.QueryEscape("  dove  "), nil))
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "at least 5 characters") {
+		t.Fatalf("expected -search-min-chars=5 to reject %q, got %d %s", "dove", rec.Code, rec.Body.String())
+	}
+
+	like, err := fetchSearchPayload(db, "products", cols, "gtin", "  Niveä  ", 1, 10, 0, "", false)
+	if err != nil || like.Total != 1 || getString(like.Items[0], "gtin") != "4000000000005" {
+		t.Fatalf("diacritic-insensitive LIKE search: total=%d err=%v", like.Total, err)
+	}
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/search", nil))
+	if !strings.Contains(rec.Body.String(), `minlength="5"`) {
+		t.Fatalf("expected the search form to use -search-min-chars=5")
+	}
+
+	if !sqliteHasFTS5(db) {
+		t.Skip("sqlite build lacks FTS5")
+	}
+	ftsTable, err := ensureSearchFTS(db, "products", searchFieldsFor(cols), testSourceStamp(t, db))
+	if err != nil {
+		t.Fatalf("ensureSearchFTS: %v", err)
+	}
+	accented, err := fetchSearchPayload(db, "products", cols, "gtin", "  Niveä  ", 1, 10, 0, ftsTable, false)
+	if err != nil || accented.Total != 1 || getString(accented.Items[0], "gtin") != "4000000000005" {
+		t.Fatalf("diacritic-insensitive search: total=%d err=%v", accented.Total, err)
+	}
+}
+
+func TestFetchSearchPayload_NormalizedColumnFoldsAccents(t *testing.T) {
+	db := openTestProductsDB(t)
+	if _, err := db.Exec(`ALTER TABLE products ADD COLUMN name_normalized TEXT`); err != nil {
+		t.Fatalf("add column: %v", err)
+	}
+	if _, err := db.Exec(`INSERT INTO products (gtin, name, brand, price_eur, currency, name_normalized)
+		VALUES ('4000000000006', 'Crème Brûlée Duschgel', 'Balea', 1.25, 'EUR', ?)`, foldSearchText("Crème Brûlée Duschgel")); err != nil {
+		t.Fatalf("seed: %v", err)
+	}
+	cols, err := tableColumns(db, "products")
+	if err != nil {
+		t.Fatalf("tableColumns: %v", err)
+	}
+
+	got, err := fetchSearchPayload(db, "products", cols, "gtin", "creme brulee", 1, 10, 0, "", false)
+	if err != nil {
+		t.Fatalf("search: %v", err)
+	}
+	if got.Total != 1 || getString(got.Items[0], "gtin") != "4000000000006" {
+		t.Fatalf("expected unaccented query to match via name_normalized, got total=%d items=%+v", got.Total, got.Items)
+	}
+
+	plain := []string{}
+	for _, c := range cols {
+		if c != searchNormalizedColumn {
+			plain = append(plain, c)
+		}
+	}
+	without, err := fetchSearchPayload(db, "products", plain, "gtin", "creme brulee", 1, 10, 0, "", false)
+	if err != nil || without.Total != 1 {
+		t.Fatalf("expected fold_search to match without the normalized column, got total=%d err=%v", without.Total, err)
+	}
+}
+
+func TestAPIProducts_BulkFetch(t *testing.T) {
+	_, h := newTestServer(t)
+	get := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec
+	}
+
+	rec := get("/api/products?ids=4000000000003,missing,4000000000001")
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("expected JSON 200, got %d %s", rec.Code, rec.Body.String())
+	}
+	var rows []map[string]any
+	if err := json.Unmarshal(rec.Body.Bytes(), &rows); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(rows) != 3 || rows[1] != nil {
+		t.Fatalf("expected 3 entries with null for the missing id, got %+v", rows)
+	}
+	if getString(rows[0], "gtin") != "4000000000003" || getString(rows[2], "gtin") != "4000000000001" {
+		t.Fatalf("expected input order to be preserved, got %q, %q", getString(rows[0], "gtin"), getString(rows[2], "gtin"))
+	}
+
+	ids := make([]string, bulkMaxIDs+1)
+	for i := range ids {
+		ids[i] = fmt.Sprintf("id%d", i)
+	}
+	over := get("/api/products?ids=" + strings.Join(ids, ","))
+	if over.Code != http.StatusBadRequest || !strings.Contains(over.Body.String(), "too_many_ids") {
+		t.Fatalf("expected 400 too_many_ids, got %d %s", over.Code, over.Body.String())
+	}
+	if empty := get("/api/products?ids=,"); empty.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for empty ids, got %d", empty.Code)
+	}
+}
+
+func TestAdminAuth_MetricsAndAPI(t *testing.T) {
+	db := openTestProductsDB(t)
+	cols, err := tableColumns(db, "products")
+	if err != nil {
+		t.Fatalf("tableColumns: %v", err)
+	}
+	newMux := func(auth adminAuth) http.Handler {
+		return newServerMux(db, serverConfig{Table: "products", Cols: cols, IDCol: "gtin", SitemapChunkSize: 10, SearchPageSize: 10, AdminAuth: auth}, newServerMetrics())
+	}
+	get := func(h http.Handler, path, user, pass string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if user != "" {
+			req.SetBasicAuth(user, pass)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	open := newMux(adminAuth{})
+	if rec := get(open, "/metrics", "", ""); rec.Code != http.StatusOK {
+		t.Fatalf("expected open /metrics without admin auth configured, got %d", rec.Code)
+	}
+
+	h := newMux(adminAuth{User: "ops", Pass: "s3cret", ProtectAPI: true})
+	if rec := get(h, "/metrics", "ops", "s3cret"); rec.Code != http.StatusOK {
+		t.Fatalf("expected 200 with correct credentials, got %d", rec.Code)
+	}
+	wrong := get(h, "/metrics", "ops", "nope")
+	if wrong.Code != http.StatusUnauthorized || !strings.HasPrefix(wrong.Header().Get("WWW-Authenticate"), "Basic ") {
+		t.Fatalf("expected 401 with Basic challenge, got %d %q", wrong.Code, wrong.Header().Get("WWW-Authenticate"))
+	}
+	if rec := get(h, "/api/products?ids=4000000000001", "", ""); rec.Code != http.StatusUnauthorized || !strings.Contains(rec.Body.String(), `"code":"unauthorized"`) {
+		t.Fatalf("expected /api/ to require auth with a JSON error, got %d %s", rec.Code, rec.Body.String())
+	}
+	if rec := get(h, "/api/similar/4000000000001", "", ""); rec.Code == http.StatusUnauthorized {
+		t.Fatalf("expected /api/similar/ to stay public for the Show more button")
+	}
+	if rec := get(h, "/api/products?ids=4000000000001", "ops", "s3cret"); rec.Code != http.StatusOK {
+		t.Fatalf("expected authorized /api/ request to succeed, got %d", rec.Code)
+	}
+	if rec := get(h, "/", "", ""); rec.Code != http.StatusOK {
+		t.Fatalf("expected storefront pages to stay public, got %d", rec.Code)
+	}
+}
+
+func TestFormatStars(t *testing.T) {
+	cases := []struct {
+		value float64
+		count int64
+		want  string
+	}{
+		{4.2, 120, "★★★★☆ 4.2 (120)"},
+		{0, 0, "☆☆☆☆☆ 0.0"},
+		{7.5, 3, "★★★★★ 5.0 (3)"},
+		{-1, 0, "☆☆☆☆☆ 0.0"},
+	}
+	for _, tc := range cases {
+		if got := formatStars(tc.value, tc.count); got != tc.want {
+			t.Errorf("formatStars(%v, %d) = %q; want %q", tc.value, tc.count, got, tc.want)
+		}
+	}
+
+	card := string(renderSimilarCardsHTML([]map[string]any{{"id": "1", "name": "Soap", "rating_value": 4.2, "rating_count": int64(120)}}))
+	if !strings.Contains(card, "★★★★☆ 4.2 (120)") {
+		t.Fatalf("expected similar card to use formatStars, got %s", card)
+	}
+	if got := formatRatingSummary(map[string]any{"rating_value": 4.2, "rating_count": int64(120)}); got != "★★★★☆ 4.2 (120)" {
+		t.Fatalf("expected home card summary to use formatStars, got %q", got)
+	}
+}
+
+func TestWriteJSON_CompactJSON(t *testing.T) {
+	payload := map[string]any{"name": "Shampoo Repair", "tags": []string{"hair", "care"}}
+
+	rec := httptest.NewRecorder()
+	writeJSON(rec, payload, true)
+	body := strings.TrimSuffix(rec.Body.String(), "\n")
+	if strings.ContainsAny(body, "\n\t") || strings.Contains(body, "  ") {
+		t.Fatalf("expected compact JSON, got %q", body)
+	}
+	var got map[string]any
+	if err := json.Unmarshal([]byte(body), &got); err != nil || got["name"] != "Shampoo Repair" {
+		t.Fatalf("compact JSON does not round-trip: %v %+v", err, got)
+	}
+
+	rec = httptest.NewRecorder()
+	writeJSON(rec, payload, false)
+	if !strings.Contains(rec.Body.String(), "\n  \"name\"") {
+		t.Fatalf("expected indented JSON by default, got %q", rec.Body.String())
+	}
+}
+
+func TestProductPage_CanonicalLink(t *testing.T) {
+	render := func(row map[string]any) string {
+		var b strings.Builder
+		if err := productPageTemplate.Execute(&b, productPageData("1", row, nil)); err != nil {
+			t.Fatalf("template error: %v", err)
+		}
+		return b.String()
+	}
+
+	page := render(map[string]any{"gtin": "1", "name": "Soap", "metadata_canonical": `https://shop.example/soap?a=1&b="2"`})
+	if want := `<link rel="canonical" href="https://shop.example/soap?a=1&amp;b=%222%22" />`; !strings.Contains(page, want) {
+		t.Fatalf("expected escaped canonical link %s in page", want)
+	}
+	fallback := render(map[string]any{"gtin": "1", "metadata_canonical": "javascript:alert(1)", "product_url": "https://shop.example/p/1"})
+	if !strings.Contains(fallback, `<link rel="canonical" href="https://shop.example/p/1" />`) {
+		t.Fatalf("expected product_url fallback when the canonical is not http(s)")
+	}
+	if page := render(map[string]any{"gtin": "1", "name": "Soap"}); strings.Contains(page, `rel="canonical"`) {
+		t.Fatalf("expected no canonical link without a canonical column value")
+	}
+}
+
+func TestProductPage_BreadcrumbLinks(t *testing.T) {
+	render := func(row map[string]any) string {
+		var b strings.Builder
+		if err := productPageTemplate.Execute(&b, productPageData("1", row, nil)); err != nil {
+			t.Fatalf("template error: %v", err)
+		}
+		return b.String()
+	}
+
+	page := render(map[string]any{
+		"gtin": "1", "name": "Soap", "category_path": "pflege > seife",
+		"breadcrumb_1": "Pflege", "breadcrumb_2": "", "breadcrumb_3": "Seife & Duschgel", "breadcrumb_4": "Flüssigseife",
+	})
+	want := []string{
+		`<a href="/category/Pflege">Pflege</a>`,
+		`<a href="/category/Pflege/Seife%20&amp;%20Duschgel">Seife &amp; Duschgel</a>`,
+		`<a href="/category/Pflege/Seife%20&amp;%20Duschgel/Fl%C3%BCssigseife">Flüssigseife</a>`,
+	}
+	last := -1
+	for _, link := range want {
+		i := strings.Index(page, link)
+		if i < 0 || i < last {
+			t.Fatalf("expected breadcrumb link %s after the previous level in page", link)
+		}
+		last = i
+	}
+	if n := strings.Count(page, `href="/category/`); n != len(want) {
+		t.Fatalf("expected %d breadcrumb links (one per non-empty level), got %d", len(want), n)
+	}
+
+	fromPath := productBreadcrumbs(map[string]any{"breadcrumbs_path": "Haushalt > Putzmittel"})
+	if len(fromPath) != 2 || fromPath[1].Href != "/category/Haushalt/Putzmittel" {
+		t.Fatalf("expected breadcrumbs_path fallback, got %+v", fromPath)
+	}
+	if page := render(map[string]any{"gtin": "1", "category_path": "pflege"}); strings.Contains(page, `href="/category/`) || !strings.Contains(page, `<div class="crumbs">pflege</div>`) {
+		t.Fatalf("expected the plain category crumb without breadcrumb columns")
+	}
+}
+
+func TestSimilarFallbackPopular(t *testing.T) {
+	db := openTestProductsDB(t)
+	cols, err := tableColumns(db, "products")
+	if err != nil {
+		t.Fatalf("tableColumns: %v", err)
+	}
+	similarIDs := func(fallback bool) []string {
+		t.Helper()
+		h := newServerMux(db, serverConfig{Table: "products", Cols: cols, IDCol: "gtin", SitemapChunkSize: 10, SearchPageSize: 10, SimilarLimit: 8, SimilarMode: similarModeCategory, SimilarFallback: fallback}, newServerMetrics())
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/similar/4000000000004", nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status %d body %s", rec.Code, rec.Body.String())
+		}
+		var p similarPayload
+		if err := json.Unmarshal(rec.Body.Bytes(), &p); err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		if p.HasMore {
+			t.Fatalf("expected a single page of similar items")
+		}
+		ids := []string{}
+		for _, item := range p.Items {
+			ids = append(ids, getString(item, "gtin"))
+		}
+
+		page := httptest.NewRecorder()
+		h.ServeHTTP(page, httptest.NewRequest(http.MethodGet, "/product/4000000000004", nil))
+		if got := strings.Contains(page.Body.String(), "/product/4000000000001"); got != fallback {
+			t.Fatalf("product page similar card for 4000000000001 present=%v, want %v", got, fallback)
+		}
+		return ids
+	}
+
+	if ids := similarIDs(false); len(ids) != 0 {
+		t.Fatalf("expected no similar items without the fallback, got %v", ids)
+	}
+	if ids := similarIDs(true); strings.Join(ids, ",") != "4000000000001,4000000000002,4000000000003" {
+		t.Fatalf("expected top-rated fallback excluding the product itself, got %v", ids)
+	}
+}
+
+func TestHideUnavailable_FiltersHomeAndSearch(t *testing.T) {
+	db := openTestProductsDB(t)
+	if _, err := db.Exec(`ALTER TABLE products ADD COLUMN available INTEGER`); err != nil {
+		t.Fatalf("add column: %v", err)
+	}
+	if _, err := db.Exec(`UPDATE products SET available = CASE gtin WHEN '4000000000003' THEN 0 WHEN '4000000000001' THEN 1 END`); err != nil {
+		t.Fatalf("set availability: %v", err)
+	}
+	cols, err := tableColumns(db, "products")
+	if err != nil {
+		t.Fatalf("tableColumns: %v", err)
+	}
+	ftsTable := ""
+	if sqliteHasFTS5(db) {
+		if ftsTable, err = ensureSearchFTS(db, "products", searchFieldsFor(cols), testSourceStamp(t, db)); err != nil {
+			t.Fatalf("ensureSearchFTS: %v", err)
+		}
+	}
+	for _, hide := range []bool{false, true} {
+		want := 2
+		if hide {
+			want = 1
+		}
+		for _, fts := range []string{"", ftsTable} {
+			payload, err := fetchSearchPayload(db, "products", cols, "gtin", "shampoo", 1, 10, 0, fts, hide)
+			if err != nil {
+				t.Fatalf("search (fts=%q): %v", fts, err)
+			}
+			if payload.Total != want || len(payload.Items) != want {
+				t.Fatalf("hide=%v fts=%q: expected %d results, got total=%d items=%d", hide, fts, want, payload.Total, len(payload.Items))
+			}
+		}
+		items, err := fetchHomeSectionItems(db, "products", cols, "gtin", hide, topRatedWhere, topRatedOrder, 10)
+		if err != nil {
+			t.Fatalf("home section: %v", err)
+		}
+		if got := len(items); got != want+2 {
+			t.Fatalf("hide=%v: expected %d top-rated items, got %d", hide, want+2, got)
+		}
+	}
+}
+
+func TestAPISchema_ListsColumnsAndTypes(t *testing.T) {
+	_, h := newTestServer(t)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/schema", nil))
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("expected JSON 200, got %d %s", rec.Code, rec.Body.String())
+	}
+	var p schemaPayload
+	if err := json.Unmarshal(rec.Body.Bytes(), &p); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	want := "gtin,name,brand,price_eur,currency,category_path,rating_value,rating_count,unit_price_eur,unit_price_per_quantity,unit_price_per_unit,product_is_pharmacy,has_eyecatchers,has_pills"
+	if p.Table != "products" || strings.Join(p.Columns, ",") != want {
+		t.Fatalf("unexpected table/columns %q %v", p.Table, p.Columns)
+	}
+	if len(p.Fields) != len(p.Columns) {
+		t.Fatalf("expected one field per column, got %d", len(p.Fields))
+	}
+	types := map[string]string{}
+	for _, f := range p.Fields {
+		types[f.Name] = f.Type + "/" + f.Affinity
+	}
+	if types["gtin"] != "TEXT/TEXT" || types["price_eur"] != "REAL/REAL" || types["rating_count"] != "INTEGER/INTEGER" {
+		t.Fatalf("unexpected inferred types %v", types)
+	}
+
+	post := httptest.NewRecorder()
+	h.ServeHTTP(post, httptest.NewRequest(http.MethodPost, "/api/schema", nil))
+	if post.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("expected 405 for POST, got %d", post.Code)
+	}
+	if a := sqliteAffinity("VARCHAR(20)"); a != "TEXT" {
+		t.Fatalf("unexpected affinity %q", a)
+	}
+	if a := sqliteAffinity(""); a != "BLOB" {
+		t.Fatalf("unexpected affinity for untyped column %q", a)
+	}
+}
+
+func TestRequestID_EchoedAndLoggedOnError(t *testing.T) {
+	db, h := newTestServer(t)
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	req := httptest.NewRequest(http.MethodGet, "/health", nil)
+	req.Header.Set("X-Request-ID", "client-abc-123")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if got := rec.Header().Get("X-Request-ID"); got != "client-abc-123" {
+		t.Fatalf("expected the client request ID to be echoed, got %q", got)
+	}
+
+	generated := httptest.NewRecorder()
+	bad := httptest.NewRequest(http.MethodGet, "/health", nil)
+	bad.Header.Set("X-Request-ID", "has spaces")
+	h.ServeHTTP(generated, bad)
+	if id := generated.Header().Get("X-Request-ID"); len(id) != 16 || id == "has spaces" {
+		t.Fatalf("expected a generated 16-char request ID, got %q", id)
+	}
+
+	db.Close()
+	req = httptest.NewRequest(http.MethodGet, "/api/products?ids=4000000000001", nil)
+	req.Header.Set("X-Request-ID", "forced-error-7")
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusInternalServerError || rec.Header().Get("X-Request-ID") != "forced-error-7" {
+		t.Fatalf("expected 500 with the request ID echoed, got %d %q", rec.Code, rec.Header().Get("X-Request-ID"))
+	}
+	if line := buf.String(); !strings.Contains(line, "request_id=forced-error-7 bulk fetch error:") {
+		t.Fatalf("expected the error log line to carry the request ID, got %q", line)
+	}
+}
+
+func TestSearch_MaxSearchPageRejectsDeepPages(t *testing.T) {
+	db := openTestProductsDB(t)
+	cols, err := tableColumns(db, "products")
+	if err != nil {
+		t.Fatalf("tableColumns: %v", err)
+	}
+	h := newServerMux(db, serverConfig{Table: "products", Cols: cols, IDCol: "gtin", SitemapChunkSize: 10, SearchPageSize: 1, MaxSearchPage: 2}, newServerMetrics())
+	get := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec
+	}
+
+	ok := get("/search?q=shampoo&page=2&format=json")
+	var p searchPayload
+	if err := json.Unmarshal(ok.Body.Bytes(), &p); err != nil || ok.Code != http.StatusOK {
+		t.Fatalf("expected page 2 to be served, got %d %s", ok.Code, ok.Body.String())
+	}
+	if p.MaxSearchPage != 2 || p.Returned != 1 {
+		t.Fatalf("expected max_search_page 2 and one result, got %+v", p)
+	}
+
+	const want = "page 3 is too deep (max 2); narrow your query to see more results"
+	deep := get("/search?q=shampoo&page=3&format=json")
+	if deep.Code != http.StatusBadRequest || !strings.Contains(deep.Body.String(), want) {
+		t.Fatalf("expected 400 with the too-deep message, got %d %s", deep.Code, deep.Body.String())
+	}
+	if html := get("/search?q=shampoo&page=3"); !strings.Contains(html.Body.String(), "narrow your query") {
+		t.Fatalf("expected the HTML search page to show the too-deep message")
+	}
+	api := get("/api/search?q=shampoo&page=3")
+	if api.Code != http.StatusBadRequest || !strings.Contains(api.Body.String(), `"page_too_deep"`) || !strings.Contains(api.Body.String(), want) {
+		t.Fatalf("expected /api/search to reject the page with page_too_deep, got %d %s", api.Code, api.Body.String())
+	}
+	if msg := searchPageTooDeep(1000000, 0); msg != "" {
+		t.Fatalf("expected a zero cap to disable the guard, got %q", msg)
+	}
+}
+
+func TestSitemapURLCapAndIndexPageCount(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	products := make([]sitemapProduct, sitemapProtocolMaxURLs+1)
+	for i := range products {
+		products[i] = sitemapProduct{ID: fmt.Sprintf("%d", i)}
+	}
+	if n := len(buildProductURLSetXML("https://shop.example", products[:defaultSitemapChunkSize]).Items); n != defaultSitemapChunkSize {
+		t.Fatalf("expected a full default-sized page, got %d URLs", n)
+	}
+	if n := len(buildProductURLSetXML("https://shop.example", products).Items); n != sitemapProtocolMaxURLs {
+		t.Fatalf("expected the URL set to be capped at %d, got %d", sitemapProtocolMaxURLs, n)
+	}
+	if !strings.Contains(buf.String(), "dropping 1") {
+		t.Fatalf("expected the truncation to be logged, got %q", buf.String())
+	}
+
+	cases := []struct {
+		total, chunk, want int
+	}{
+		{50000, sitemapProtocolMaxURLs, 1},
+		{50001, sitemapProtocolMaxURLs, 2},
+		{100000, sitemapProtocolMaxURLs, 2},
+		{50000, defaultSitemapChunkSize, 5},
+		{50001, defaultSitemapChunkSize, 6},
+		{100000, defaultSitemapChunkSize, 10},
+		{100000, sitemapProtocolMaxURLs + 1, 2},
+		{0, defaultSitemapChunkSize, 1},
+	}
+	for _, c := range cases {
+		idx := buildSitemapIndexXML("https://shop.example", c.total, c.chunk)
+		if len(idx.Items) != c.want {
+			t.Fatalf("total=%d chunk=%d: expected %d sitemap files, got %d", c.total, c.chunk, c.want, len(idx.Items))
+		}
+		if last := idx.Items[len(idx.Items)-1].Loc; last != fmt.Sprintf("https://shop.example/sitemaps/products-%d.xml", c.want) {
+			t.Fatalf("total=%d chunk=%d: unexpected last sitemap %q", c.total, c.chunk, last)
+		}
+	}
+}
+
+func TestLoadTemplateOverrides_CustomProductTemplate(t *testing.T) {
+	orig := productPageTemplate
+	defer func() { productPageTemplate = orig }()
+	dir := t.TempDir()
+
+	if err := os.WriteFile(filepath.Join(dir, "product.html"), []byte(`<p>custom {{.name}}{{.name.Missing}}</p>`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadTemplateOverrides(dir); err == nil || !strings.Contains(err.Error(), "sample render") {
+		t.Fatalf("expected the sample render to reject the template, got %v", err)
+	}
+	if productPageTemplate != orig {
+		t.Fatalf("expected a failing override to leave the built-in template in place")
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "product.html"), []byte(`<p>custom {{.name}} by {{.brand}}</p>`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	names, err := loadTemplateOverrides(dir)
+	if err != nil || strings.Join(names, ",") != "product" {
+		t.Fatalf("expected only product to be overridden, got %v, %v", names, err)
+	}
+	_, h := newTestServer(t)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/product/4000000000001", nil))
+	if got := strings.TrimSpace(rec.Body.String()); got != "<p>custom Shampoo Repair by Dove</p>" {
+		t.Fatalf("expected the custom product template, got %q", got)
+	}
+	home := httptest.NewRecorder()
+	h.ServeHTTP(home, httptest.NewRequest(http.MethodGet, "/", nil))
+	if !strings.Contains(home.Body.String(), "<!doctype html>") {
+		t.Fatalf("expected the built-in home template without an override")
+	}
+}
+
+func TestFaviconAndStaticRoutes(t *testing.T) {
+	_, h := newTestServer(t)
+	for _, path := range []string{"/favicon.ico", "/static/favicon.ico"} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: expected 200, got %d", path, rec.Code)
+		}
+		if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "image/") {
+			t.Fatalf("%s: expected an image content type, got %q", path, ct)
+		}
+		if !bytes.HasPrefix(rec.Body.Bytes(), []byte{0, 0, 1, 0}) || rec.Header().Get("Cache-Control") != staticCacheControl {
+			t.Fatalf("%s: expected a cacheable ICO body", path)
+		}
+	}
+	missing := httptest.NewRecorder()
+	h.ServeHTTP(missing, httptest.NewRequest(http.MethodGet, "/static/nope.css", nil))
+	if missing.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for a missing static file, got %d", missing.Code)
+	}
+}
+
+func TestFetchHomePayload_BudgetMaxPrice(t *testing.T) {
+	db := openTestProductsDB(t)
+	if _, err := db.Exec(`INSERT INTO products VALUES ('4000000000005', 'Parfum', 'Chanel', 89.0, 'EUR', 'Duft > Parfum', 4.9, 40, NULL, NULL, NULL, 0, 0, 0)`); err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+	cols, err := tableColumns(db, "products")
+	if err != nil {
+		t.Fatalf("tableColumns: %v", err)
+	}
+	budgetIDs := func(maxPrice float64) []string {
+		t.Helper()
+		home, err := fetchHomePayload(db, "products", cols, "gtin", maxPrice, false)
+		if err != nil {
+			t.Fatalf("fetchHomePayload: %v", err)
+		}
+		var ids []string
+		for _, s := range home.Sections {
+			if s.ID == "budget-finds" {
+				for _, item := range s.Items {
+					ids = append(ids, getString(item, "gtin"))
+				}
+			}
+		}
+		return ids
+	}
+	if ids := budgetIDs(0); len(ids) != 4 || contains(ids, "4000000000005") {
+		t.Fatalf("expected the default 5 EUR cap to list the 4 cheap products, got %v", ids)
+	}
+	if ids := budgetIDs(2); len(ids) != 2 {
+		t.Fatalf("expected a 2 EUR cap to list 2 products, got %v", ids)
+	}
+	if ids := budgetIDs(100); len(ids) != 5 || ids[0] != "4000000000005" {
+		t.Fatalf("expected a 100 EUR cap to include the best-rated perfume first, got %v", ids)
+	}
+}
+
+func TestBaseURLPolicy_TrustedProxies(t *testing.T) {
+	proxies, err := parseTrustedProxies("10.0.0.0/8, 192.168.1.7, ::1")
+	if err != nil {
+		t.Fatalf("parseTrustedProxies: %v", err)
+	}
+	policy := baseURLPolicy{TrustedProxies: proxies}
+	req := func(remote string) *http.Request {
+		r := httptest.NewRequest(http.MethodGet, "/sitemap.xml", nil)
+		r.Host = "internal:8080"
+		r.RemoteAddr = remote
+		r.Header.Set("X-Forwarded-Proto", "https")
+		r.Header.Set("X-Forwarded-Host", "shop.example")
+		return r
+	}
+
+	for _, remote := range []string{"10.1.2.3:4000", "192.168.1.7:4000", "[::1]:4000", "[::ffff:10.9.9.9]:4000"} {
+		if got := policy.requestBaseURL(req(remote)); got != "https://shop.example" {
+			t.Fatalf("%s: expected forwarded headers from a trusted proxy to be honored, got %q", remote, got)
+		}
+	}
+	for _, remote := range []string{"203.0.113.5:4000", "192.168.1.8:4000", "[2001:db8::1]:4000", "garbage"} {
+		if got := policy.requestBaseURL(req(remote)); got != "http://internal:8080" {
+			t.Fatalf("%s: expected forwarded headers from an untrusted peer to be ignored, got %q", remote, got)
+		}
+	}
+	policy.TrustForwarded = true
+	if got := policy.requestBaseURL(req("203.0.113.5:4000")); got != "http://internal:8080" {
+		t.Fatalf("expected -trusted-proxies to take precedence over -trust-forwarded, got %q", got)
+	}
+
+	for _, bad := range []string{"10.0.0.0/33", "not-an-ip", "10.0.0.1/8/1"} {
+		if _, err := parseTrustedProxies(bad); err == nil {
+			t.Fatalf("expected %q to be rejected", bad)
+		}
+	}
+}
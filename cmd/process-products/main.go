@@ -2,40 +2,84 @@ package main
 
 import (
 	"bufio"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"html"
 	"io"
 	"math"
+	"math/rand"
+	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
 	"regexp"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
+	"unicode"
 
+	"golang.org/x/text/unicode/norm"
 	_ "modernc.org/sqlite"
 )
 
 type Row map[string]any
 
 var (
-	inputPath  = flag.String("input", "outputs/sample_products_all.jl", "Input JSON Lines file")
-	outputDir  = flag.String("out-dir", "outputs", "Output directory")
-	csvPath    = flag.String("csv", "", "Reference CSV output path (default outputs/sample_products_reference.csv)")
-	sqlitePath = flag.String("sqlite", "", "SQLite output path (default outputs/sample_products_cleaned.sqlite)")
-	profilePath = flag.String("profile", "", "Profile markdown output path (default outputs/sample_products_profile.md)")
-	limitRows   = flag.Int("limit", 0, "Optional limit for testing (0 = all rows)")
+	outputDir       = flag.String("out-dir", "outputs", "Output directory")
+	csvPath         = flag.String("csv", "", "Reference CSV output path (default outputs/sample_products_reference.csv)")
+	sqlitePath      = flag.String("sqlite", "", "SQLite output path (default outputs/sample_products_cleaned.sqlite)")
+	profilePath     = flag.String("profile", "", "Profile markdown output path (default outputs/sample_products_profile.md)")
+	limitRows       = flag.Int("limit", 0, "Optional limit for testing (0 = all rows)")
+	priceLocale     = flag.String("price-locale", "auto", "Hint for ambiguous prices like 1.234 or 1,234: auto (separator is decimal), de (1.234 = 1234), en (1,234 = 1234)")
+	timestampLayout = flag.String("timestamp-layout", "", "Go time layout tried first for scraped_at_utc, before RFC 3339 and a few common layouts (used for dedup ordering)")
+	dateFormats     = flag.String("date-formats", "2006-01-02,01/02/2006", "Comma-separated Go date layouts tried in order after dd.mm.yyyy for not-increased-since dates")
+	sampleRate      = flag.Float64("sample-rate", 1, "Fraction of rows (0<r<=1) sampled for the profile")
+	sampleSeed      = flag.Int64("seed", 1, "Random seed for -sample-rate")
+	sampleOut       = flag.Bool("sample-output", false, "Write only the sampled rows to CSV/SQLite (default: all rows)")
+	priceBuckets    = flag.String("price-buckets", "0,1,5,10,20", "Comma-separated ascending price_eur edges for the profile histogram")
+	boolNull        = flag.String("bool-null", "null", "How unknown booleans are stored in SQLite: null or zero")
+	columnsFlag     = flag.String("columns", "", "Comma-separated export columns (default: built-in reference column list)")
+	dedupSecondary  = flag.Bool("dedup-secondary", false, "After GTIN dedup, also drop rows without a GTIN sharing a normalized name+brand, keeping the newest scrape")
+	addSearchCol    = flag.Bool("add-search-column", false, "Add a name_normalized column (lowercased, diacritics stripped) to SQLite for accent-insensitive search")
+	dbOpenRetries   = flag.Int("db-open-retries", 3, "Retries with exponential backoff when the SQLite output is busy or locked")
+	floatPrecision  = flag.Int("float-precision", -1, "Fixed decimal places for price columns in the reference CSV (-1 = pandas-like shortest form, e.g. 4.99 and 5.0)")
+	profileHTML     = flag.String("profile-html", "", "Optional path for an HTML rendering of the profile, with tables for numeric summaries and value counts")
+	manifestPath    = flag.String("manifest", "", "Optional path for a JSON manifest of the run: input, row counts, output sizes and SHA-256 hashes, flags")
+	showProgress    = flag.Bool("progress", false, "Log lines read and rate to stderr every few seconds while parsing the input")
+	quiet           = flag.Bool("quiet", false, "Suppress the summary; only errors are printed")
+	verbose         = flag.Bool("verbose", false, "Also print per-stage timings (parse, normalize, dedup, write)")
+	crlf            = flag.Bool("crlf", false, "End reference CSV records with \\r\\n instead of \\n")
+	noBOM           = flag.Bool("no-bom", false, "Write the reference CSV without the leading UTF-8 byte order mark")
+	csvGzip         = flag.Bool("csv-gzip", false, "Gzip the reference CSV and append .gz to its path")
+	strictSchema    = flag.Bool("strict-schema", false, "Exit non-zero when input rows carry top-level keys outside the known scraper schema")
+	verifyDB        = flag.Bool("verify-sqlite", false, "Reopen the written SQLite file and check its row count and gtin index, failing on mismatch")
+	failOnInvalid   = flag.Bool("fail-on-invalid-json", false, "Exit non-zero when the input has malformed JSON lines instead of skipping them")
+	validateRanges  = flag.String("validate-ranges", "", `JSON object of allowed numeric ranges per column, e.g. {"rating_value":{"min":0,"max":5},"price_eur":{"min":0}}; violations are listed in the profile`)
+	failOnRange     = flag.Bool("fail-on-range-violation", false, "Exit non-zero (after writing the profile) when -validate-ranges finds out-of-range values")
+	pingURLs        = flag.String("ping-urls", "", "Comma-separated endpoints notified with ?sitemap=<-sitemap-url> after SQLite is written (search engines or a server's cache hook)")
+	sitemapURL      = flag.String("sitemap-url", "", "Public sitemap URL sent to -ping-urls, e.g. https://shop.example/sitemap.xml")
 )
 
 var (
-	reDigits     = regexp.MustCompile(`\D+`)
-	reInt        = regexp.MustCompile(`(\d+)`)
-	reDateDE     = regexp.MustCompile(`(\d{2}\.\d{2}\.\d{4})`)
-	reNonNum     = regexp.MustCompile(`[^0-9.\-]`)
-	reUnitInfo   = regexp.MustCompile(`^\s*([0-9]+(?:[.,][0-9]+)?)\s*([A-Za-z]+)\s*\(([^)]*?)\s*je\s*([0-9]+(?:[.,][0-9]+)?)\s*([A-Za-z]+)\s*\)\s*$`)
+	reDigits      = regexp.MustCompile(`\D+`)
+	reInt         = regexp.MustCompile(`(\d+)`)
+	reDateDE      = regexp.MustCompile(`(\d{2}\.\d{2}\.\d{4})`)
+	rePriceChars  = regexp.MustCompile(`[^0-9.,\-]`)
+	rePriceNum    = regexp.MustCompile(`^-?[0-9]+(\.[0-9]+)?$`)
+	reUnitQty     = regexp.MustCompile(`^\s*(?:([0-9]+)\s*[xX×]\s*)?([0-9]+(?:[.,][0-9]+)?)\s*([A-Za-z]+)\s*(?:\(([^)]*)\))?\s*$`)
+	reUnitPriceEq = regexp.MustCompile(`^\s*(?:([0-9]+(?:[.,][0-9]+)?)\s*)?([A-Za-z]+)\s*=\s*(.*?)\s*$`)
+	reUnitPriceJe = regexp.MustCompile(`^\s*(.*?)\s*je\s*([0-9]+(?:[.,][0-9]+)?)\s*([A-Za-z]+)\s*$`)
+	reUnitInfo    = regexp.MustCompile(`^\s*([0-9]+(?:[.,][0-9]+)?)\s*([A-Za-z]+)\s*\(([^)]*?)\s*je\s*([0-9]+(?:[.,][0-9]+)?)\s*([A-Za-z]+)\s*\)\s*$`)
 )
 
 var descriptionHeaderMap = map[string]string{
@@ -55,6 +99,14 @@ var descriptionHeaderMap = map[string]string{
 	"Lieferumfang":                           "desc_lieferumfang",
 }
 
+// booleanColumns are the columns -bool-null applies to. The availability
+// columns keep their TEXT declared type in SQLite; only the flag columns are
+// declared INTEGER in writeSQLite.
+var booleanColumns = map[string]bool{
+	"product_is_pharmacy": true, "has_variants": true, "has_videos": true, "has_seals": true, "has_pills": true, "has_eyecatchers": true,
+	"available_raw": true, "available_norm": true, "available": true,
+}
+
 var exportColumns = []string{
 	"gtin", "dan", "name", "brand", "title_subheadline", "price_eur", "currency",
 	"unit_quantity", "unit_quantity_unit", "unit_price_eur", "unit_price_per_quantity", "unit_price_per_unit",
@@ -63,11 +115,50 @@ var exportColumns = []string{
 	"eyecatchers", "pills", "desc_productbeschreibung", "desc_produktmerkmale", "desc_verwendungshinweise",
 	"desc_inhaltsstoffe", "desc_aufbewahrungshinweise", "desc_warnhinweise", "desc_hergestellt_in",
 	"desc_pflichthinweise", "desc_nachhaltigkeit", "desc_material", "desc_zutaten", "desc_naehrwerte",
-	"desc_allergene", "desc_lieferumfang",
+	"desc_allergene", "desc_lieferumfang", "image_url", "canonical_url", "available",
 }
 
+const defaultInputPath = "outputs/sample_products_all.jl"
+
 func main() {
+	var inputs stringListFlag
+	flag.Var(&inputs, "input", "Input JSON Lines file or glob (repeatable; files are read in sorted order; default "+defaultInputPath+")")
 	flag.Parse()
+	if *floatPrecision < -1 {
+		fatalf("invalid -float-precision %d (want -1 or >= 0)", *floatPrecision)
+	}
+	switch *priceLocale {
+	case "auto", "de", "en":
+	default:
+		fatalf("invalid -price-locale %q (want auto, de or en)", *priceLocale)
+	}
+	if *sampleRate <= 0 || *sampleRate > 1 {
+		fatalf("invalid -sample-rate %v (want 0<r<=1)", *sampleRate)
+	}
+	if _, err := parsePriceBuckets(*priceBuckets); err != nil {
+		fatalf("invalid -price-buckets: %v", err)
+	}
+	if *boolNull != "null" && *boolNull != "zero" {
+		fatalf("invalid -bool-null %q (want null or zero)", *boolNull)
+	}
+	if *pingURLs != "" && *sitemapURL == "" {
+		fatalf("-ping-urls requires -sitemap-url")
+	}
+	if *quiet && *verbose {
+		fatalf("-quiet and -verbose are mutually exclusive")
+	}
+	ranges, err := parseColumnRanges(*validateRanges)
+	if err != nil {
+		fatalf("invalid -validate-ranges: %v", err)
+	}
+	if *failOnRange && len(ranges) == 0 {
+		fatalf("-fail-on-range-violation requires -validate-ranges")
+	}
+	inputPaths, err := resolveInputPaths(inputs)
+	if err != nil {
+		fatalf("input: %v", err)
+	}
+	logger := newLevelLogger(os.Stdout, *quiet, *verbose)
 
 	outCSV := *csvPath
 	outSQLite := *sqlitePath
@@ -75,6 +166,9 @@ func main() {
 	if outCSV == "" {
 		outCSV = filepath.Join(*outputDir, "sample_products_reference.csv")
 	}
+	if *csvGzip && !strings.HasSuffix(outCSV, ".gz") {
+		outCSV += ".gz"
+	}
 	if outSQLite == "" {
 		outSQLite = filepath.Join(*outputDir, "sample_products_cleaned.sqlite")
 	}
@@ -82,58 +176,342 @@ func main() {
 		outProfile = filepath.Join(*outputDir, "sample_products_profile.md")
 	}
 
-	if err := os.MkdirAll(*outputDir, 0o755); err != nil {
-		fatalf("mkdir outputs: %v", err)
+	// Fail before the (possibly long) parse when an output can't be written.
+	outDirs := []string{*outputDir, filepath.Dir(outCSV), filepath.Dir(outSQLite), filepath.Dir(outProfile)}
+	if *profileHTML != "" {
+		outDirs = append(outDirs, filepath.Dir(*profileHTML))
+	}
+	if err := prepareOutputDirs(outDirs...); err != nil {
+		fatalf("outputs: %v", err)
 	}
 
-	rows, headerCounts, sourceRows, invalidRows, err := loadAndParseRows(*inputPath, *limitRows)
+	done := logger.stage("parse")
+	var sampler *rand.Rand
+	if *sampleRate < 1 {
+		sampler = rand.New(rand.NewSource(*sampleSeed))
+	}
+	rows, headerCounts, keyCounts, files, err := loadAndParseInputs(inputPaths, *limitRows, sampler)
 	if err != nil {
 		fatalf("load jsonl: %v", err)
 	}
+	done()
+	sourceRows, invalidRows := 0, 0
+	var invalidErrs []error
+	for _, f := range files {
+		sourceRows += f.SourceRows
+		invalidRows += len(f.InvalidLines)
+		if len(f.InvalidLines) > 0 {
+			invalidErrs = append(invalidErrs, invalidJSONError(f.Path, f.InvalidLines))
+		}
+	}
+	if *failOnInvalid && len(invalidErrs) > 0 {
+		fatalf("load jsonl: %v", errors.Join(invalidErrs...))
+	}
+	unexpected := unexpectedTopLevelKeys(keyCounts)
+	if *strictSchema && len(unexpected) > 0 {
+		fatalf("strict schema: %s", formatKeyCounts(unexpected, keyCounts))
+	}
 
+	done = logger.stage("normalize")
 	normalizeAndReconcile(rows)
+	done()
+	done = logger.stage("dedup")
 	before := len(rows)
 	sortAndDedupeRows(&rows)
+	gtinDeduped := before - len(rows)
+	nameBrandDups := findNameBrandDuplicates(rows)
+	if *dedupSecondary {
+		rows = dropNameBrandDuplicates(rows, nameBrandDups)
+	}
 	deduped := before - len(rows)
+	done()
 
-	profile := buildProfile(rows, headerCounts, sourceRows, invalidRows)
-	profile += fmt.Sprintf("\n## Deduplication applied\n- Dropped duplicate GTIN rows: %s\n", fmtInt(deduped))
+	if err := checkRangeColumns(ranges, rows); err != nil {
+		fatalf("invalid -validate-ranges: %v", err)
+	}
+	profileRows := sampleRows(rows, *sampleRate)
+	written := rows
+	if *sampleOut {
+		written = profileRows
+	}
+	profile := buildProfile(profileRows, written, headerCounts, sourceRows, invalidRows)
+	profile += fmt.Sprintf("\n## Deduplication applied\n- Dropped duplicate GTIN rows: %s\n", fmtInt(gtinDeduped))
+	if *dedupSecondary {
+		profile += fmt.Sprintf("- Dropped name+brand duplicates (-dedup-secondary): %s\n", fmtInt(deduped-gtinDeduped))
+	}
+	profile += "\n" + formatNameBrandDuplicates(nameBrandDups, *dedupSecondary)
+	if len(files) > 1 {
+		profile += "\n## Input files\n"
+		for _, f := range files {
+			profile += fmt.Sprintf("- `%s`: %s rows read, %s invalid\n", f.Path, fmtInt(f.SourceRows), fmtInt(len(f.InvalidLines)))
+		}
+	}
+	if len(unexpected) > 0 {
+		profile += "\n## Unexpected top-level keys\n"
+		for _, k := range unexpected {
+			profile += fmt.Sprintf("- `%s`: %s rows\n", k, fmtInt(keyCounts[k]))
+		}
+	}
+	violations := checkColumnRanges(written, ranges)
+	if len(ranges) > 0 {
+		profile += "\n" + formatRangeViolations(violations)
+	}
+	if *sampleRate < 1 {
+		profile += fmt.Sprintf("\n## Sampling\n- Profiled %s of %s rows (rate=%g, seed=%d)\n", fmtInt(len(profileRows)), fmtInt(len(rows)), *sampleRate, *sampleSeed)
+	}
+	rows = written
+	done = logger.stage("write")
 	if err := os.WriteFile(outProfile, []byte(profile), 0o644); err != nil {
 		fatalf("write profile: %v", err)
 	}
+	if *profileHTML != "" {
+		if err := os.WriteFile(*profileHTML, []byte(buildProfileHTML(profile, profileRows)), 0o644); err != nil {
+			fatalf("write profile html: %v", err)
+		}
+	}
+	if *failOnRange {
+		if n := countRangeViolations(violations); n > 0 {
+			fatalf("range validation: %s out-of-range values (see %s)", fmtInt(n), outProfile)
+		}
+	}
 
-	exportRows := buildExportRows(rows)
-	if err := writeReferenceCSV(outCSV, exportColumns, exportRows); err != nil {
+	cols, err := resolveExportColumns(*columnsFlag, rows)
+	if err != nil {
+		fatalf("columns: %v", err)
+	}
+	exportRows := buildExportRows(rows, cols)
+	if err := writeReferenceCSV(outCSV, cols, exportRows); err != nil {
 		fatalf("write csv: %v", err)
 	}
-	if err := writeSQLite(outSQLite, exportColumns, exportRows); err != nil {
+	sqliteCols := cols
+	if *addSearchCol {
+		sqliteCols = addSearchColumn(cols, exportRows)
+	}
+	if err := writeSQLite(outSQLite, sqliteCols, exportRows); err != nil {
 		fatalf("write sqlite: %v", err)
 	}
+	if *verifyDB {
+		if err := verifySQLite(outSQLite, "sample_products_cleaned", len(exportRows)); err != nil {
+			fatalf("verify sqlite: %v", err)
+		}
+	}
+	done()
+
+	logger.Infof("Rows read: %d", sourceRows)
+	logger.Infof("Rows written (cleaned): %d", len(exportRows))
+	logger.Infof("Columns written (cleaned): %d", len(cols))
+	logger.Infof("CSV: %s", outCSV)
+	logger.Infof("SQLite: %s", outSQLite)
+	logger.Infof("Profile: %s", outProfile)
+	if *profileHTML != "" {
+		logger.Infof("Profile HTML: %s", *profileHTML)
+	}
+
+	if *manifestPath != "" {
+		paths := map[string]string{"csv": outCSV, "sqlite": outSQLite, "profile": outProfile}
+		if *profileHTML != "" {
+			paths["profile_html"] = *profileHTML
+		}
+		outputs, err := digestOutputs(paths)
+		if err != nil {
+			fatalf("manifest: %v", err)
+		}
+		m := runManifest{
+			Inputs:      inputPaths,
+			SourceRows:  sourceRows,
+			InvalidRows: invalidRows,
+			DedupedRows: deduped,
+			RowsWritten: len(exportRows),
+			Outputs:     outputs,
+			Flags:       flagValues(flag.CommandLine),
+		}
+		if err := writeManifest(*manifestPath, m); err != nil {
+			fatalf("write manifest: %v", err)
+		}
+		logger.Infof("Manifest: %s", *manifestPath)
+	}
+
+	if *pingURLs != "" {
+		if err := pingSitemap(strings.Split(*pingURLs, ","), *sitemapURL); err != nil {
+			fmt.Fprintf(os.Stderr, "sitemap ping: %v\n", err)
+		}
+	}
+}
+
+// runManifest records what a run consumed and produced so downstream steps
+// can verify they read the exact artifacts.
+type runManifest struct {
+	Inputs      []string                 `json:"inputs"`
+	SourceRows  int                      `json:"source_rows"`
+	InvalidRows int                      `json:"invalid_rows"`
+	DedupedRows int                      `json:"deduplicated_rows"`
+	RowsWritten int                      `json:"rows_written"`
+	Outputs     map[string]manifestEntry `json:"outputs"`
+	Flags       map[string]string        `json:"flags"`
+}
+
+type manifestEntry struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// digestOutputs hashes each named output file.
+func digestOutputs(paths map[string]string) (map[string]manifestEntry, error) {
+	out := make(map[string]manifestEntry, len(paths))
+	for name, path := range paths {
+		e, err := digestFile(path)
+		if err != nil {
+			return nil, err
+		}
+		out[name] = e
+	}
+	return out, nil
+}
+
+func digestFile(path string) (manifestEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return manifestEntry{}, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return manifestEntry{}, err
+	}
+	return manifestEntry{Path: path, Size: n, SHA256: hex.EncodeToString(h.Sum(nil))}, nil
+}
+
+func flagValues(fs *flag.FlagSet) map[string]string {
+	out := map[string]string{}
+	fs.VisitAll(func(f *flag.Flag) {
+		out[f.Name] = f.Value.String()
+	})
+	return out
+}
+
+func writeManifest(path string, m runManifest) error {
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(b, '\n'), 0o644)
+}
+
+// levelLogger prints the run summary unless quiet and per-stage timings when
+// verbose. Errors bypass it and always go to stderr via fatalf.
+type levelLogger struct {
+	out     io.Writer
+	quiet   bool
+	verbose bool
+}
+
+func newLevelLogger(out io.Writer, quiet, verbose bool) *levelLogger {
+	return &levelLogger{out: out, quiet: quiet, verbose: verbose}
+}
+
+func (l *levelLogger) Infof(format string, args ...any) {
+	if l.quiet {
+		return
+	}
+	fmt.Fprintf(l.out, format+"\n", args...)
+}
+
+func (l *levelLogger) Debugf(format string, args ...any) {
+	if !l.verbose {
+		return
+	}
+	fmt.Fprintf(l.out, format+"\n", args...)
+}
+
+// stage starts timing name; the returned func logs the elapsed time.
+func (l *levelLogger) stage(name string) func() {
+	start := time.Now()
+	return func() {
+		l.Debugf("Stage %s: %s", name, time.Since(start).Round(time.Millisecond))
+	}
+}
+
+var sitemapPingTimeout = 10 * time.Second
 
-	fmt.Printf("Rows read: %d\n", sourceRows)
-	fmt.Printf("Rows written (cleaned): %d\n", len(exportRows))
-	fmt.Printf("Columns written (cleaned): %d\n", len(exportColumns))
-	fmt.Printf("CSV: %s\n", outCSV)
-	fmt.Printf("SQLite: %s\n", outSQLite)
-	fmt.Printf("Profile: %s\n", outProfile)
+// pingSitemap sends GET <url>?sitemap=<sitemapURL> to every url, each bounded
+// by sitemapPingTimeout. All urls are tried; failures are returned together.
+func pingSitemap(urls []string, sitemapURL string) error {
+	var failed []string
+	for _, raw := range urls {
+		raw = strings.TrimSpace(raw)
+		if raw == "" {
+			continue
+		}
+		if err := pingOne(raw, sitemapURL); err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", raw, err))
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("%d ping(s) failed: %s", len(failed), strings.Join(failed, "; "))
+	}
+	return nil
 }
 
-func loadAndParseRows(path string, limit int) ([]Row, map[string]int, int, int, error) {
+func pingOne(endpoint, sitemapURL string) error {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return err
+	}
+	q := u.Query()
+	q.Set("sitemap", sitemapURL)
+	u.RawQuery = q.Encode()
+
+	ctx, cancel := context.WithTimeout(context.Background(), sitemapPingTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// loadAndParseRows parses the JSON Lines input. Malformed lines are skipped
+// and their 1-based line numbers returned in invalidLines. A non-nil sampler
+// tags each row with a random sampleKeyField for sampleRows.
+func loadAndParseRows(path string, limit int, sampler *rand.Rand) ([]Row, map[string]int, map[string]int, int, []int, error) {
 	f, err := os.Open(path)
 	if err != nil {
-		return nil, nil, 0, 0, err
+		return nil, nil, nil, 0, nil, err
 	}
 	defer f.Close()
 
 	var rows []Row
 	headerCounts := map[string]int{}
+	keyCounts := map[string]int{}
 	sourceRows := 0
-	invalidRows := 0
+	var invalidLines []int
+	var progress *progressReporter
+	if *showProgress {
+		progress = startProgress(progressOut, progressInterval)
+		defer progress.stop()
+	}
 
 	sc := bufio.NewScanner(f)
 	buf := make([]byte, 0, 1024*1024)
 	sc.Buffer(buf, 20*1024*1024)
+	lineNo := 0
 	for sc.Scan() {
+		lineNo++
+		progress.add(1)
 		line := strings.TrimSpace(sc.Text())
 		if line == "" {
 			continue
@@ -141,22 +519,238 @@ func loadAndParseRows(path string, limit int) ([]Row, map[string]int, int, int,
 		sourceRows++
 		var raw map[string]any
 		if err := json.Unmarshal([]byte(line), &raw); err != nil {
-			invalidRows++
+			invalidLines = append(invalidLines, lineNo)
 			continue
 		}
+		for k := range raw {
+			keyCounts[k]++
+		}
 		row, headers := parseRow(raw)
 		for _, h := range headers {
 			headerCounts[h]++
 		}
+		if sampler != nil {
+			row[sampleKeyField] = sampler.Float64()
+		}
 		rows = append(rows, row)
 		if limit > 0 && len(rows) >= limit {
 			break
 		}
 	}
 	if err := sc.Err(); err != nil {
-		return nil, nil, 0, 0, err
+		return nil, nil, nil, 0, nil, err
+	}
+	return rows, headerCounts, keyCounts, sourceRows, invalidLines, nil
+}
+
+// knownTopLevelKeys are the raw scraper keys parseRow understands; anything
+// else showing up is a sign the upstream JSON format drifted.
+var knownTopLevelKeys = map[string]bool{
+	"gtin": true, "dan": true, "name": true, "brand": true, "price": true, "available": true,
+	"product_url": true, "detail_api_url": true, "slug": true, "scraped_at_utc": true,
+	"images": true, "image": true, "product": true,
+}
+
+func unexpectedTopLevelKeys(keyCounts map[string]int) []string {
+	var out []string
+	for k := range keyCounts {
+		if !knownTopLevelKeys[k] {
+			out = append(out, k)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+func formatKeyCounts(keys []string, counts map[string]int) string {
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		parts[i] = fmt.Sprintf("%s (%s rows)", k, fmtInt(counts[k]))
+	}
+	return fmt.Sprintf("%d unexpected top-level key(s): %s", len(keys), strings.Join(parts, ", "))
+}
+
+// prepareOutputDirs creates each directory (recursively) and probes that it
+// is writable by creating and removing a temp file.
+func prepareOutputDirs(dirs ...string) error {
+	seen := map[string]bool{}
+	for _, dir := range dirs {
+		dir = filepath.Clean(dir)
+		if seen[dir] {
+			continue
+		}
+		seen[dir] = true
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return fmt.Errorf("create %s: %w", dir, err)
+		}
+		f, err := os.CreateTemp(dir, ".write-probe-*")
+		if err != nil {
+			return fmt.Errorf("%s is not writable: %w", dir, err)
+		}
+		name := f.Name()
+		f.Close()
+		if err := os.Remove(name); err != nil {
+			return fmt.Errorf("remove write probe in %s: %w", dir, err)
+		}
+	}
+	return nil
+}
+
+type stringListFlag []string
+
+func (f *stringListFlag) String() string { return strings.Join(*f, ",") }
+
+func (f *stringListFlag) Set(v string) error {
+	*f = append(*f, v)
+	return nil
+}
+
+// resolveInputPaths expands each -input value as a glob (plain paths pass
+// through) and returns the distinct files in sorted order.
+func resolveInputPaths(values []string) ([]string, error) {
+	if len(values) == 0 {
+		values = []string{defaultInputPath}
+	}
+	seen := map[string]bool{}
+	var out []string
+	for _, v := range values {
+		matches, err := filepath.Glob(v)
+		if err != nil {
+			return nil, fmt.Errorf("bad glob %q: %w", v, err)
+		}
+		if len(matches) == 0 {
+			if strings.ContainsAny(v, "*?[") {
+				return nil, fmt.Errorf("no files match %q", v)
+			}
+			matches = []string{v}
+		}
+		for _, m := range matches {
+			if !seen[m] {
+				seen[m] = true
+				out = append(out, m)
+			}
+		}
+	}
+	sort.Strings(out)
+	return out, nil
+}
+
+// inputFileStats is the per-file outcome of loadAndParseInputs.
+type inputFileStats struct {
+	Path         string
+	SourceRows   int
+	InvalidLines []int
+}
+
+// loadAndParseInputs reads every path with loadAndParseRows and concatenates
+// the rows; limit applies to the combined total and sampler is shared so the
+// sample keys only depend on the seed and the input order.
+func loadAndParseInputs(paths []string, limit int, sampler *rand.Rand) ([]Row, map[string]int, map[string]int, []inputFileStats, error) {
+	var rows []Row
+	headerCounts := map[string]int{}
+	keyCounts := map[string]int{}
+	files := make([]inputFileStats, 0, len(paths))
+	for _, path := range paths {
+		remaining := 0
+		if limit > 0 {
+			if remaining = limit - len(rows); remaining <= 0 {
+				break
+			}
+		}
+		fileRows, fileHeaders, fileKeys, sourceRows, invalidLines, err := loadAndParseRows(path, remaining, sampler)
+		if err != nil {
+			return nil, nil, nil, nil, err
+		}
+		rows = append(rows, fileRows...)
+		for h, n := range fileHeaders {
+			headerCounts[h] += n
+		}
+		for k, n := range fileKeys {
+			keyCounts[k] += n
+		}
+		files = append(files, inputFileStats{Path: path, SourceRows: sourceRows, InvalidLines: invalidLines})
+	}
+	return rows, headerCounts, keyCounts, files, nil
+}
+
+var (
+	progressOut      io.Writer = os.Stderr
+	progressInterval           = 5 * time.Second
+)
+
+// progressReporter logs the number of input lines read and the rate every
+// interval from a ticker goroutine; the parse loop only bumps an atomic
+// counter. A nil reporter is a no-op.
+type progressReporter struct {
+	out   io.Writer
+	start time.Time
+	lines atomic.Int64
+	quit  chan struct{}
+	wg    sync.WaitGroup
+}
+
+func startProgress(out io.Writer, interval time.Duration) *progressReporter {
+	p := &progressReporter{out: out, start: time.Now(), quit: make(chan struct{})}
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				p.report("progress")
+			case <-p.quit:
+				return
+			}
+		}
+	}()
+	return p
+}
+
+func (p *progressReporter) add(n int64) {
+	if p != nil {
+		p.lines.Add(n)
+	}
+}
+
+// stop ends the ticker and logs the final count.
+func (p *progressReporter) stop() {
+	if p == nil {
+		return
+	}
+	close(p.quit)
+	p.wg.Wait()
+	p.report("done")
+}
+
+func (p *progressReporter) report(label string) {
+	n := p.lines.Load()
+	elapsed := time.Since(p.start)
+	rate := 0.0
+	if secs := elapsed.Seconds(); secs > 0 {
+		rate = float64(n) / secs
 	}
-	return rows, headerCounts, sourceRows, invalidRows, nil
+	fmt.Fprintf(p.out, "%s: %s lines read in %s (%.0f lines/s)\n", label, fmtInt(int(n)), elapsed.Round(time.Millisecond), rate)
+}
+
+const maxReportedInvalidLines = 5
+
+// invalidJSONError names the first few malformed lines of path.
+func invalidJSONError(path string, lines []int) error {
+	shown := lines
+	if len(shown) > maxReportedInvalidLines {
+		shown = shown[:maxReportedInvalidLines]
+	}
+	nums := make([]string, len(shown))
+	for i, n := range shown {
+		nums[i] = strconv.Itoa(n)
+	}
+	msg := fmt.Sprintf("%s: %d invalid JSON line(s) at line %s", path, len(lines), strings.Join(nums, ", "))
+	if len(lines) > len(shown) {
+		msg += ", ..."
+	}
+	return errors.New(msg)
 }
 
 func parseRow(raw map[string]any) (Row, []string) {
@@ -223,46 +817,46 @@ func parseRow(raw map[string]any) (Row, []string) {
 	descriptionHeadersJSON, _ := json.Marshal(descriptionHeaders)
 
 	row := Row{
-		"gtin":                     normalizeGTIN(raw["gtin"]),
-		"dan":                      toInt64(raw["dan"]),
-		"product_url":              textOrNil(raw["product_url"]),
-		"detail_api_url":           textOrNil(raw["detail_api_url"]),
-		"slug":                     textOrNil(raw["slug"]),
-		"scraped_at_utc":           textOrNil(raw["scraped_at_utc"]),
-		"name":                     textOrNil(raw["name"]),
-		"brand":                    textOrNil(raw["brand"]),
-		"available_raw":            boolOrNil(raw["available"]),
-		"price_raw":                textOrNil(raw["price"]),
-		"price_eur_top":            parseEUR(raw["price"]),
-		"product_gtin":             normalizeGTIN(product["gtin"]),
-		"product_dan":              toInt64(product["dan"]),
-		"product_self_slug":        textOrNil(product["self"]),
-		"product_is_pharmacy":      boolOrNil(product["isPharmacy"]),
-		"show_cbm_web":             boolOrNil(product["showConfidenceBuildingMeasuresWeb"]),
-		"show_cbm_app":             boolOrNil(product["showConfidenceBuildingMeasuresApp"]),
-		"brand_product_name":       textOrNil(pBrand["name"]),
-		"title_headline":           textOrNil(pTitle["headline"]),
-		"title_subheadline":        textOrNil(pTitle["subheadline"]),
-		"a11y_label":               textOrNil(product["a11yLabel"]),
-		"breadcrumbs_count":        len(breadcrumbs),
-		"breadcrumb_1":             sliceTextOrNil(breadcrumbs, 0),
-		"breadcrumb_2":             sliceTextOrNil(breadcrumbs, 1),
-		"breadcrumb_3":             sliceTextOrNil(breadcrumbs, 2),
-		"breadcrumb_4":             sliceTextOrNil(breadcrumbs, 3),
-		"breadcrumbs_path":         joinTexts(breadcrumbStrings, " > "),
-		"rating_count":             toInt64(pRating["ratingCount"]),
-		"rating_value":             toFloat64(pRating["ratingValue"]),
-		"metadata_canonical":       textOrNil(pMeta["canonical"]),
-		"metadata_currency":        textOrNil(pMeta["currency"]),
-		"metadata_price_eur":       parseEUR(pMeta["price"]),
-		"metadata_page_title":      textOrNil(pMeta["pageTitle"]),
-		"metadata_is_pharmacy":     boolOrNil(pMeta["isPharmacy"]),
-		"metadata_category_codes":  joinTexts(anySliceToTexts(asSlice(pMeta["categoryCodes"])), "|"),
-		"metadata_description":     textOrNil(pMeta["description"]),
-		"gross_price_current_eur":  extractCurrentPrice(pPrice),
-		"net_price_current_eur":    extractCurrentPrice(pNetPrice),
-		"gross_price_infos":        joinTexts(anySliceToTexts(asSlice(pPrice["infos"])), " | "),
-		"net_price_infos":          joinTexts(anySliceToTexts(asSlice(pNetPrice["infos"])), " | "),
+		"gtin":                      normalizeGTIN(raw["gtin"]),
+		"dan":                       toInt64(raw["dan"]),
+		"product_url":               textOrNil(raw["product_url"]),
+		"detail_api_url":            textOrNil(raw["detail_api_url"]),
+		"slug":                      textOrNil(raw["slug"]),
+		"scraped_at_utc":            textOrNil(raw["scraped_at_utc"]),
+		"name":                      textOrNil(raw["name"]),
+		"brand":                     textOrNil(raw["brand"]),
+		"available_raw":             boolOrNil(raw["available"]),
+		"price_raw":                 textOrNil(raw["price"]),
+		"price_eur_top":             parseEUR(raw["price"]),
+		"product_gtin":              normalizeGTIN(product["gtin"]),
+		"product_dan":               toInt64(product["dan"]),
+		"product_self_slug":         textOrNil(product["self"]),
+		"product_is_pharmacy":       boolOrNil(product["isPharmacy"]),
+		"show_cbm_web":              boolOrNil(product["showConfidenceBuildingMeasuresWeb"]),
+		"show_cbm_app":              boolOrNil(product["showConfidenceBuildingMeasuresApp"]),
+		"brand_product_name":        textOrNil(pBrand["name"]),
+		"title_headline":            textOrNil(pTitle["headline"]),
+		"title_subheadline":         textOrNil(pTitle["subheadline"]),
+		"a11y_label":                textOrNil(product["a11yLabel"]),
+		"breadcrumbs_count":         len(breadcrumbs),
+		"breadcrumb_1":              sliceTextOrNil(breadcrumbs, 0),
+		"breadcrumb_2":              sliceTextOrNil(breadcrumbs, 1),
+		"breadcrumb_3":              sliceTextOrNil(breadcrumbs, 2),
+		"breadcrumb_4":              sliceTextOrNil(breadcrumbs, 3),
+		"breadcrumbs_path":          joinTexts(breadcrumbStrings, " > "),
+		"rating_count":              toInt64(pRating["ratingCount"]),
+		"rating_value":              toFloat64(pRating["ratingValue"]),
+		"metadata_canonical":        textOrNil(pMeta["canonical"]),
+		"metadata_currency":         textOrNil(pMeta["currency"]),
+		"metadata_price_eur":        parseEUR(pMeta["price"]),
+		"metadata_page_title":       textOrNil(pMeta["pageTitle"]),
+		"metadata_is_pharmacy":      boolOrNil(pMeta["isPharmacy"]),
+		"metadata_category_codes":   joinTexts(anySliceToTexts(asSlice(pMeta["categoryCodes"])), "|"),
+		"metadata_description":      textOrNil(pMeta["description"]),
+		"gross_price_current_eur":   extractCurrentPrice(pPrice),
+		"net_price_current_eur":     extractCurrentPrice(pNetPrice),
+		"gross_price_infos":         joinTexts(anySliceToTexts(asSlice(pPrice["infos"])), " | "),
+		"net_price_infos":           joinTexts(anySliceToTexts(asSlice(pNetPrice["infos"])), " | "),
 		"gross_not_increased_since": grossNotInc,
 		"net_not_increased_since":   netNotInc,
 		"payback_info":              textOrNil(pPrice["paybackInfo"]),
@@ -283,6 +877,8 @@ func parseRow(raw map[string]any) (Row, []string) {
 		"description_headers_count": len(descriptionHeaders),
 		"description_headers":       joinTexts(descriptionHeaders, " | "),
 		"description_headers_json":  string(descriptionHeadersJSON),
+		"image_url":                 firstImageURL(product["images"], product["media"], raw["images"], raw["image"]),
+		"canonical_url":             canonicalURL(pMeta["canonical"], raw["product_url"]),
 	}
 	for k, v := range unitInfo {
 		row[k] = v
@@ -291,13 +887,72 @@ func parseRow(raw map[string]any) (Row, []string) {
 		row[k] = v
 	}
 	if s := asString(row["scraped_at_utc"]); s != "" {
-		if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		if t, ok := parseScrapedAt(s); ok {
 			row["_scraped_at_time"] = t
 		}
 	}
 	return row, descriptionHeaders
 }
 
+// commonTimestampLayouts are tried for scraped_at_utc after -timestamp-layout.
+// Layouts without a zone are read as UTC.
+var commonTimestampLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02 15:04:05.999999999",
+	"02.01.2006 15:04:05",
+	time.RFC1123Z,
+	time.RFC1123,
+}
+
+func parseScrapedAt(s string) (time.Time, bool) {
+	s = strings.TrimSpace(s)
+	layouts := commonTimestampLayouts
+	if *timestampLayout != "" {
+		layouts = append([]string{*timestampLayout}, layouts...)
+	}
+	for _, layout := range layouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// sampleKeyField holds the random key loadAndParseRows draws for each row
+// when -sample-rate < 1. The leading underscore keeps it out of the outputs.
+const sampleKeyField = "_sample_key"
+
+// sampleRows is a reservoir sample by random keys: it keeps the
+// ceil(rate*len(rows)) rows with the smallest sampleKeyField, in row order.
+// The keys come from the -seed source, so the same input, rate and seed
+// always select the same rows. Rows without a key are picked last.
+func sampleRows(rows []Row, rate float64) []Row {
+	if rate >= 1 {
+		return rows
+	}
+	k := int(math.Ceil(rate * float64(len(rows))))
+	key := func(i int) float64 {
+		if v, ok := rows[i][sampleKeyField].(float64); ok {
+			return v
+		}
+		return math.Inf(1)
+	}
+	order := make([]int, len(rows))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool { return key(order[a]) < key(order[b]) })
+	picked := order[:k]
+	sort.Ints(picked)
+	out := make([]Row, 0, k)
+	for _, i := range picked {
+		out = append(out, rows[i])
+	}
+	return out
+}
+
 func normalizeAndReconcile(rows []Row) {
 	for _, r := range rows {
 		if v, ok := r["available_raw"].(bool); ok {
@@ -305,6 +960,7 @@ func normalizeAndReconcile(rows []Row) {
 		} else {
 			r["available_norm"] = nil
 		}
+		r["available"] = r["available_norm"]
 		fillText(r, "brand", "brand_product_name")
 		fillText(r, "gtin", "product_gtin")
 		fillInt(r, "dan", "product_dan")
@@ -350,24 +1006,171 @@ func sortAndDedupeRows(rows *[]Row) {
 		}
 		return false
 	})
+	// Rows without a GTIN are distinct products as far as GTIN dedup goes;
+	// -dedup-secondary is what merges those by name + brand.
 	lastByGTIN := make(map[string]int, len(rs))
 	for i, r := range rs {
-		lastByGTIN[asString(r["gtin"])] = i
+		if g := asString(r["gtin"]); !isBlankGTIN(g) {
+			lastByGTIN[g] = i
+		}
 	}
 	out := make([]Row, 0, len(rs))
 	for i, r := range rs {
-		if lastByGTIN[asString(r["gtin"])] == i {
+		if g := asString(r["gtin"]); isBlankGTIN(g) || lastByGTIN[g] == i {
 			out = append(out, r)
 		}
 	}
 	*rows = out
 }
 
-func buildExportRows(rows []Row) []Row {
+func isBlankGTIN(g string) bool {
+	return strings.TrimSpace(g) == ""
+}
+
+// nameBrandGroup is a set of rows sharing a normalized (name, brand), in row
+// order.
+type nameBrandGroup struct {
+	name, brand string
+	rows        []int
+}
+
+// nameBrandKey folds case, diacritics and whitespace so "Crème  Brûlée" and
+// "creme brulee" match. Rows without a name get no key.
+func nameBrandKey(r Row) (string, bool) {
+	name := strings.Join(strings.Fields(foldSearchText(asString(r["name"]))), " ")
+	if name == "" {
+		return "", false
+	}
+	brand := strings.Join(strings.Fields(foldSearchText(asString(r["brand"]))), " ")
+	return name + "\x00" + brand, true
+}
+
+// findNameBrandDuplicates groups rows without a GTIN whose normalized name and
+// brand match, largest groups first. Rows with a GTIN were already deduped on
+// it; sharing a name+brand there usually means a size or shade variant.
+func findNameBrandDuplicates(rows []Row) []nameBrandGroup {
+	byKey := map[string]*nameBrandGroup{}
+	var keys []string
+	for i, r := range rows {
+		if !isBlankGTIN(asString(r["gtin"])) {
+			continue
+		}
+		k, ok := nameBrandKey(r)
+		if !ok {
+			continue
+		}
+		g := byKey[k]
+		if g == nil {
+			g = &nameBrandGroup{name: asString(r["name"]), brand: asString(r["brand"])}
+			byKey[k] = g
+			keys = append(keys, k)
+		}
+		g.rows = append(g.rows, i)
+	}
+	var out []nameBrandGroup
+	for _, k := range keys {
+		if g := byKey[k]; len(g.rows) > 1 {
+			out = append(out, *g)
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool { return len(out[i].rows) > len(out[j].rows) })
+	return out
+}
+
+// dropNameBrandDuplicates keeps one row per group: the newest scraped_at_utc,
+// or the last in row order on ties, as GTIN dedup does.
+func dropNameBrandDuplicates(rows []Row, groups []nameBrandGroup) []Row {
+	drop := map[int]bool{}
+	for _, g := range groups {
+		keep := g.rows[0]
+		for _, i := range g.rows[1:] {
+			kt, kok := rows[keep]["_scraped_at_time"].(time.Time)
+			it, iok := rows[i]["_scraped_at_time"].(time.Time)
+			if !kok || (iok && !it.Before(kt)) {
+				keep = i
+			}
+		}
+		for _, i := range g.rows {
+			if i != keep {
+				drop[i] = true
+			}
+		}
+	}
+	out := make([]Row, 0, len(rows)-len(drop))
+	for i, r := range rows {
+		if !drop[i] {
+			out = append(out, r)
+		}
+	}
+	return out
+}
+
+const maxNameBrandExamples = 10
+
+func formatNameBrandDuplicates(groups []nameBrandGroup, dropped bool) string {
+	extra := 0
+	for _, g := range groups {
+		extra += len(g.rows) - 1
+	}
+	var b strings.Builder
+	b.WriteString("## Likely duplicates by name + brand\n")
+	fmt.Fprintf(&b, "- Groups of rows without a GTIN sharing a normalized name and brand: %s\n", fmtInt(len(groups)))
+	if dropped {
+		fmt.Fprintf(&b, "- Extra rows in those groups: %s (dropped by -dedup-secondary)\n", fmtInt(extra))
+	} else {
+		fmt.Fprintf(&b, "- Extra rows in those groups: %s (kept; pass -dedup-secondary to drop them)\n", fmtInt(extra))
+	}
+	for i, g := range groups {
+		if i == maxNameBrandExamples {
+			break
+		}
+		fmt.Fprintf(&b, "- `%s` / `%s`: %s rows\n", g.name, g.brand, fmtInt(len(g.rows)))
+	}
+	return b.String()
+}
+
+// resolveExportColumns parses a -columns value and checks every name against
+// the keys available in the parsed rows. An empty spec selects exportColumns.
+func resolveExportColumns(spec string, rows []Row) ([]string, error) {
+	if strings.TrimSpace(spec) == "" {
+		return exportColumns, nil
+	}
+	available := map[string]bool{}
+	for _, c := range exportColumns {
+		available[c] = true
+	}
+	for _, c := range allColumns(rows) {
+		available[c] = true
+	}
+	var cols []string
+	seen := map[string]bool{}
+	var unknown []string
+	for _, part := range strings.Split(spec, ",") {
+		c := strings.TrimSpace(part)
+		if c == "" || seen[c] {
+			continue
+		}
+		seen[c] = true
+		if !available[c] {
+			unknown = append(unknown, c)
+			continue
+		}
+		cols = append(cols, c)
+	}
+	if len(unknown) > 0 {
+		return nil, fmt.Errorf("unknown column(s): %s", strings.Join(unknown, ", "))
+	}
+	if len(cols) == 0 {
+		return nil, fmt.Errorf("no columns selected")
+	}
+	return cols, nil
+}
+
+func buildExportRows(rows []Row, cols []string) []Row {
 	out := make([]Row, 0, len(rows))
 	for _, r := range rows {
 		row := Row{}
-		for _, c := range exportColumns {
+		for _, c := range cols {
 			row[c] = r[c]
 		}
 		out = append(out, row)
@@ -375,6 +1178,8 @@ func buildExportRows(rows []Row) []Row {
 	return out
 }
 
+// writeReferenceCSV writes the BOM-prefixed reference CSV to path, gzipping
+// it when path ends in ".gz".
 func writeReferenceCSV(path string, cols []string, rows []Row) error {
 	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
 		return err
@@ -384,10 +1189,35 @@ func writeReferenceCSV(path string, cols []string, rows []Row) error {
 		return err
 	}
 	defer f.Close()
-	if _, err := f.Write([]byte{0xEF, 0xBB, 0xBF}); err != nil {
+	if !strings.HasSuffix(path, ".gz") {
+		if err := writeReferenceCSVTo(f, cols, rows); err != nil {
+			return err
+		}
+		return f.Close()
+	}
+	zw := gzip.NewWriter(f)
+	zw.Name = strings.TrimSuffix(filepath.Base(path), ".gz")
+	if err := writeReferenceCSVTo(zw, cols, rows); err != nil {
 		return err
 	}
-	if err := writeCSVRecordWithTerminator(f, cols, "\n"); err != nil {
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+func writeReferenceCSVTo(w io.Writer, cols []string, rows []Row) error {
+	bw := bufio.NewWriter(w)
+	if !*noBOM {
+		if _, err := bw.Write([]byte{0xEF, 0xBB, 0xBF}); err != nil {
+			return err
+		}
+	}
+	terminator := "\n"
+	if *crlf {
+		terminator = "\r\n"
+	}
+	if err := writeCSVRecordWithTerminator(bw, cols, terminator); err != nil {
 		return err
 	}
 	for _, r := range rows {
@@ -395,11 +1225,63 @@ func writeReferenceCSV(path string, cols []string, rows []Row) error {
 		for i, c := range cols {
 			rec[i] = csvStringForColumn(c, r[c])
 		}
-		if err := writeCSVRecordWithTerminator(f, rec, "\n"); err != nil {
+		if err := writeCSVRecordWithTerminator(bw, rec, terminator); err != nil {
 			return err
 		}
 	}
-	return nil
+	return bw.Flush()
+}
+
+const searchColumn = "name_normalized"
+
+// addSearchColumn fills searchColumn with the folded name on every row and
+// returns cols extended with it. Without a name column cols is returned as is.
+func addSearchColumn(cols []string, rows []Row) []string {
+	hasName := false
+	for _, c := range cols {
+		if c == "name" {
+			hasName = true
+		}
+	}
+	if !hasName {
+		return cols
+	}
+	for _, r := range rows {
+		if name, ok := r["name"].(string); ok {
+			r[searchColumn] = foldSearchText(name)
+		}
+	}
+	return append(append([]string(nil), cols...), searchColumn)
+}
+
+// foldSearchText lowercases s and strips combining marks, so "Crème" becomes
+// "creme".
+func foldSearchText(s string) string {
+	var b strings.Builder
+	for _, r := range norm.NFD.String(s) {
+		if unicode.Is(unicode.Mn, r) {
+			continue
+		}
+		b.WriteRune(unicode.ToLower(r))
+	}
+	return norm.NFC.String(b.String())
+}
+
+var dbRetryBaseDelay = 100 * time.Millisecond
+
+// retryWithBackoff calls fn up to retries+1 times, doubling the delay after
+// each failure. It returns nil on the first success, else the last error.
+func retryWithBackoff(retries int, fn func() error) error {
+	delay := dbRetryBaseDelay
+	for attempt := 0; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= retries {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "sqlite not ready (attempt %d/%d): %v; retrying in %s\n", attempt+1, retries+1, err, delay)
+		time.Sleep(delay)
+		delay *= 2
+	}
 }
 
 func writeSQLite(path string, cols []string, rows []Row) error {
@@ -409,6 +1291,9 @@ func writeSQLite(path string, cols []string, rows []Row) error {
 		return err
 	}
 	defer db.Close()
+	if err := retryWithBackoff(*dbOpenRetries, db.Ping); err != nil {
+		return err
+	}
 
 	colTypes := map[string]string{
 		"dan": "INTEGER", "rating_count": "INTEGER",
@@ -442,34 +1327,96 @@ func writeSQLite(path string, cols []string, rows []Row) error {
 	for _, r := range rows {
 		args := make([]any, 0, len(cols))
 		for _, c := range cols {
-			args = append(args, sqliteValue(r[c]))
+			v := r[c]
+			if v == nil && booleanColumns[c] && *boolNull == "zero" {
+				v = false
+			}
+			args = append(args, sqliteValue(v))
 		}
 		if _, err := stmt.Exec(args...); err != nil {
 			return err
 		}
 	}
-	for _, idx := range []string{
-		`CREATE INDEX IF NOT EXISTS idx_sample_products_cleaned_gtin ON sample_products_cleaned(gtin)`,
-		`CREATE INDEX IF NOT EXISTS idx_sample_products_cleaned_dan ON sample_products_cleaned(dan)`,
-		`CREATE INDEX IF NOT EXISTS idx_sample_products_cleaned_brand ON sample_products_cleaned(brand)`,
-		`CREATE INDEX IF NOT EXISTS idx_sample_products_cleaned_category ON sample_products_cleaned(category_path)`,
+	present := map[string]bool{}
+	for _, c := range cols {
+		present[c] = true
+	}
+	for _, idx := range []struct {
+		cols []string
+		sql  string
+	}{
+		{[]string{"gtin"}, `CREATE INDEX IF NOT EXISTS idx_sample_products_cleaned_gtin ON sample_products_cleaned(gtin)`},
+		{[]string{"dan"}, `CREATE INDEX IF NOT EXISTS idx_sample_products_cleaned_dan ON sample_products_cleaned(dan)`},
+		{[]string{"brand"}, `CREATE INDEX IF NOT EXISTS idx_sample_products_cleaned_brand ON sample_products_cleaned(brand)`},
+		{[]string{"category_path"}, `CREATE INDEX IF NOT EXISTS idx_sample_products_cleaned_category ON sample_products_cleaned(category_path)`},
+		// Similar-product and home-section lookups filter by brand/category and sort by rating.
+		{[]string{"brand", "category_path"}, `CREATE INDEX IF NOT EXISTS idx_sample_products_cleaned_brand_category ON sample_products_cleaned(brand, category_path)`},
+		{[]string{"rating_count", "rating_value"}, `CREATE INDEX IF NOT EXISTS idx_sample_products_cleaned_rating ON sample_products_cleaned(rating_count, rating_value)`},
 	} {
-		if _, err := db.Exec(idx); err != nil {
+		missing := false
+		for _, c := range idx.cols {
+			if !present[c] {
+				missing = true
+			}
+		}
+		if missing {
+			continue
+		}
+		if _, err := db.Exec(idx.sql); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
-func buildProfile(rows []Row, headerCounts map[string]int, sourceRows, invalidRows int) string {
+// verifySQLite reopens a written database and checks that table holds
+// expected rows and, when it has a gtin column, that gtin is indexed.
+func verifySQLite(path, table string, expected int) error {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	var n int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM "` + strings.ReplaceAll(table, `"`, `""`) + `"`).Scan(&n); err != nil {
+		return fmt.Errorf("count rows in %s: %w", table, err)
+	}
+	if n != expected {
+		return fmt.Errorf("%s has %d rows, expected %d", table, n, expected)
+	}
+
+	var hasGTIN int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = 'gtin'`, table).Scan(&hasGTIN); err != nil {
+		return fmt.Errorf("read columns of %s: %w", table, err)
+	}
+	if hasGTIN == 0 {
+		return nil
+	}
+	var indexed int
+	q := `SELECT COUNT(*) FROM pragma_index_list(?) AS il, pragma_index_info(il.name) AS ii WHERE ii.name = 'gtin'`
+	if err := db.QueryRow(q, table).Scan(&indexed); err != nil {
+		return fmt.Errorf("read indexes of %s: %w", table, err)
+	}
+	if indexed == 0 {
+		return fmt.Errorf("%s has no index on gtin", table)
+	}
+	return nil
+}
+
+// buildProfile renders the markdown profile. The statistics describe rows
+// (the -sample-rate sample, or every row); the written-row count and the
+// timestamp parsing and missing price audits cover all written rows.
+func buildProfile(rows, written []Row, headerCounts map[string]int, sourceRows, invalidRows int) string {
+	columns := allColumns(rows)
 	lines := []string{
 		"# sample_products_all profiling + cleaning report",
 		"",
 		"## Dataset shape",
 		fmt.Sprintf("- Source rows read: %s", fmtInt(sourceRows)),
 		fmt.Sprintf("- Invalid JSON rows skipped: %s", fmtInt(invalidRows)),
-		fmt.Sprintf("- Clean rows written: %s", fmtInt(len(rows))),
-		fmt.Sprintf("- Columns: %s", fmtInt(len(allColumns(rows)))),
+		fmt.Sprintf("- Clean rows written: %s", fmtInt(len(written))),
+		fmt.Sprintf("- Columns: %s", fmtInt(len(columns))),
 		"",
 		"## Uniqueness / duplicates",
 	}
@@ -480,9 +1427,12 @@ func buildProfile(rows []Row, headerCounts map[string]int, sourceRows, invalidRo
 	lines = append(lines, "")
 
 	lines = append(lines, "## Missingness (top 20 columns by null %)")
-	type miss struct{ col string; pct float64 }
+	type miss struct {
+		col string
+		pct float64
+	}
 	var misses []miss
-	for _, col := range allColumns(rows) {
+	for _, col := range columns {
 		nulls := 0
 		for _, r := range rows {
 			if isMissingValue(r[col]) {
@@ -491,7 +1441,6 @@ func buildProfile(rows []Row, headerCounts map[string]int, sourceRows, invalidRo
 		}
 		misses = append(misses, miss{col, safeDiv(float64(nulls)*100, float64(len(rows)))})
 	}
-	sort.Slice(misses, func(i, j int) bool { return misses[i].pct > misses[j].pct })
 	missingTieRank := map[string]int{
 		"breadcrumb_4":   0,
 		"available_raw":  1,
@@ -518,40 +1467,168 @@ func buildProfile(rows []Row, headerCounts map[string]int, sourceRows, invalidRo
 	}
 	lines = append(lines, "")
 
-	var minT, maxT *time.Time
-	for _, r := range rows {
-		if t, ok := r["_scraped_at_time"].(time.Time); ok {
-			if minT == nil || t.Before(*minT) {
-				t2 := t
-				minT = &t2
+	var minT, maxT *time.Time
+	unparsedTimes := 0
+	for _, r := range written {
+		if _, ok := r["_scraped_at_time"].(time.Time); !ok && asString(r["scraped_at_utc"]) != "" {
+			unparsedTimes++
+		}
+	}
+	for _, r := range rows {
+		if t, ok := r["_scraped_at_time"].(time.Time); ok {
+			if minT == nil || t.Before(*minT) {
+				t2 := t
+				minT = &t2
+			}
+			if maxT == nil || t.After(*maxT) {
+				t2 := t
+				maxT = &t2
+			}
+		}
+	}
+	if minT != nil && maxT != nil {
+		lines = append(lines, "## Scrape timestamp range")
+		lines = append(lines, fmt.Sprintf("- min: %s", minT.Format("2006-01-02 15:04:05.999999999-07:00")))
+		lines = append(lines, fmt.Sprintf("- max: %s", maxT.Format("2006-01-02 15:04:05.999999999-07:00")))
+		lines = append(lines, "")
+	}
+	if unparsedTimes > 0 {
+		lines = append(lines, "## Scrape timestamp parsing")
+		lines = append(lines, fmt.Sprintf("- Rows with unparseable scraped_at_utc: %s (see -timestamp-layout)", fmtInt(unparsedTimes)))
+		lines = append(lines, "")
+	}
+
+	lines = append(lines, "## Numeric summaries")
+	for _, ns := range numericSummaries(rows) {
+		lines = append(lines, fmt.Sprintf("- `%s`: count=%s, min=%s, median=%s, mean=%s, max=%s, p25=%s, p75=%s, p95=%s",
+			ns.col, fmtInt(ns.count), fmt4g(ns.min), fmt4g(ns.median), fmt4g(ns.mean), fmt4g(ns.max),
+			fmt4g(ns.p25), fmt4g(ns.p75), fmt4g(ns.p95),
+		))
+	}
+	lines = append(lines, "")
+
+	if edges, err := parsePriceBuckets(*priceBuckets); err == nil {
+		lines = append(lines, "## Price histogram (`price_eur`)")
+		for _, b := range priceHistogram(rows, edges) {
+			lines = append(lines, fmt.Sprintf("- %s: %s", b.label, fmtInt(b.count)))
+		}
+		lines = append(lines, "")
+	}
+
+	lines = append(lines, "## Value counts (top 20)")
+	for _, vc := range valueCountTables(rows) {
+		lines = append(lines, fmt.Sprintf("### `%s`", vc.col))
+		for _, it := range vc.items {
+			lines = append(lines, fmt.Sprintf("- %s: %s", it.value, fmtInt(it.count)))
+		}
+		lines = append(lines, "")
+	}
+
+	lines = append(lines, "## Top description group headers")
+	type hc struct {
+		h string
+		c int
+	}
+	var hcs []hc
+	for h, c := range headerCounts {
+		hcs = append(hcs, hc{h, c})
+	}
+	sort.Slice(hcs, func(i, j int) bool {
+		if hcs[i].c == hcs[j].c {
+			return hcs[i].h < hcs[j].h
+		}
+		return hcs[i].c > hcs[j].c
+	})
+	for i := 0; i < len(hcs) && i < 30; i++ {
+		lines = append(lines, fmt.Sprintf("- %s: %s", hcs[i].h, fmtInt(hcs[i].c)))
+	}
+	lines = append(lines, "")
+
+	lines = append(lines, "## Price consistency")
+	for _, pair := range []struct{ name, a, b string }{
+		{"top_vs_gross_abs_diff", "price_eur_top", "gross_price_current_eur"},
+		{"top_vs_meta_abs_diff", "price_eur_top", "metadata_price_eur"},
+		{"gross_vs_meta_abs_diff", "gross_price_current_eur", "metadata_price_eur"},
+	} {
+		n := 0
+		for _, r := range rows {
+			av, aok := anyFloat64(r[pair.a])
+			bv, bok := anyFloat64(r[pair.b])
+			if !aok || !bok {
+				continue
 			}
-			if maxT == nil || t.After(*maxT) {
-				t2 := t
-				maxT = &t2
+			if math.Abs(av-bv) > 0.01 {
+				n++
 			}
 		}
+		lines = append(lines, fmt.Sprintf("- `%s` > 0.01 EUR: %s rows", pair.name, fmtInt(n)))
 	}
-	if minT != nil && maxT != nil {
-		lines = append(lines, "## Scrape timestamp range")
-		lines = append(lines, fmt.Sprintf("- min: %s", minT.Format("2006-01-02 15:04:05.999999999-07:00")))
-		lines = append(lines, fmt.Sprintf("- max: %s", maxT.Format("2006-01-02 15:04:05.999999999-07:00")))
-		lines = append(lines, "")
+	lines = append(lines, "")
+
+	lines = append(lines, "## Price difference magnitude")
+	for _, pair := range [][2]string{
+		{"price_eur_top", "gross_price_current_eur"},
+		{"price_eur_top", "metadata_price_eur"},
+		{"gross_price_current_eur", "metadata_price_eur"},
+		{"metadata_price_eur", "seo_price_eur"},
+	} {
+		st := comparePriceColumns(rows, pair[0], pair[1])
+		lines = append(lines, fmt.Sprintf("- `%s` vs `%s`: both=%s, mean_abs_diff=%s, max_abs_diff=%s, coverage_mismatch=%s",
+			pair[0], pair[1], fmtInt(st.both), fmt4g(st.meanAbs), fmt4g(st.maxAbs), fmtInt(st.coverageMismatch)))
 	}
+	lines = append(lines, "")
 
-	lines = append(lines, "## Numeric summaries")
+	audit := auditMissingPrices(written)
+	lines = append(lines, "## Products without a price")
+	lines = append(lines, fmt.Sprintf("- `price_eur` missing after reconciliation: %s rows", fmtInt(audit.missing)))
+	lines = append(lines, fmt.Sprintf("- with an unparsed or unused source price (`price_raw`, `net_price_current_eur`): %s", fmtInt(audit.withSource)))
+	lines = append(lines, fmt.Sprintf("- with no source price at all: %s", fmtInt(audit.missing-audit.withSource)))
+	if len(audit.examples) > 0 {
+		lines = append(lines, fmt.Sprintf("- example GTINs: %s", strings.Join(audit.examples, ", ")))
+	}
+	lines = append(lines, "")
+	return strings.Join(lines, "\n")
+}
+
+type numericSummary struct {
+	col                                   string
+	count                                 int
+	min, median, mean, max, p25, p75, p95 float64
+}
+
+// numericSummaries backs the "Numeric summaries" section of both the markdown
+// and the HTML profile; columns without numbers are skipped.
+func numericSummaries(rows []Row) []numericSummary {
+	var out []numericSummary
 	for _, col := range []string{"price_eur_top", "gross_price_current_eur", "net_price_current_eur", "metadata_price_eur", "seo_price_eur", "rating_count", "rating_value"} {
 		nums := gatherNums(rows, col)
 		if len(nums) == 0 {
 			continue
 		}
 		sort.Float64s(nums)
-		lines = append(lines, fmt.Sprintf("- `%s`: count=%s, min=%s, median=%s, mean=%s, max=%s",
-			col, fmtInt(len(nums)), fmt4g(nums[0]), fmt4g(median(nums)), fmt4g(mean(nums)), fmt4g(nums[len(nums)-1]),
-		))
+		out = append(out, numericSummary{
+			col: col, count: len(nums),
+			min: nums[0], median: median(nums), mean: mean(nums), max: nums[len(nums)-1],
+			p25: percentile(nums, 25), p75: percentile(nums, 75), p95: percentile(nums, 95),
+		})
 	}
-	lines = append(lines, "")
+	return out
+}
 
-	lines = append(lines, "## Value counts (top 20)")
+type valueCount struct {
+	value string
+	count int
+}
+
+type valueCountTable struct {
+	col   string
+	items []valueCount
+}
+
+// valueCountTables backs the "Value counts (top 20)" section: the 20 most
+// frequent values per column, missing values counted as <NA>.
+func valueCountTables(rows []Row) []valueCountTable {
+	var out []valueCountTable
 	for _, col := range []string{"brand", "brand_product_name", "breadcrumb_1", "breadcrumb_2", "breadcrumb_3", "seo_category", "metadata_currency", "seo_price_currency", "available_norm", "has_variants", "has_videos", "has_seals", "has_pills", "has_eyecatchers"} {
 		counts := map[string]int{}
 		for _, r := range rows {
@@ -561,65 +1638,360 @@ func buildProfile(rows []Row, headerCounts map[string]int, sourceRows, invalidRo
 			}
 			counts[k]++
 		}
-		type kv struct{ k string; v int }
-		var items []kv
+		var items []valueCount
 		for k, v := range counts {
-			items = append(items, kv{k, v})
+			items = append(items, valueCount{k, v})
 		}
 		sort.Slice(items, func(i, j int) bool {
-			if items[i].v == items[j].v {
-				return items[i].k < items[j].k
+			if items[i].count == items[j].count {
+				return items[i].value < items[j].value
 			}
-			return items[i].v > items[j].v
+			return items[i].count > items[j].count
 		})
 		if len(items) == 0 {
 			continue
 		}
-		lines = append(lines, fmt.Sprintf("### `%s`", col))
-		for i := 0; i < len(items) && i < 20; i++ {
-			lines = append(lines, fmt.Sprintf("- %s: %s", items[i].k, fmtInt(items[i].v)))
+		if len(items) > 20 {
+			items = items[:20]
 		}
-		lines = append(lines, "")
+		out = append(out, valueCountTable{col: col, items: items})
 	}
+	return out
+}
 
-	lines = append(lines, "## Top description group headers")
-	type hc struct{ h string; c int }
-	var hcs []hc
-	for h, c := range headerCounts {
-		hcs = append(hcs, hc{h, c})
+const profileHTMLStyle = `body{font:15px/1.5 system-ui,sans-serif;max-width:960px;margin:2rem auto;padding:0 1rem;color:#1d2430}
+h1{font-size:1.6rem}h2{margin-top:2rem;border-bottom:1px solid #dde3ea;padding-bottom:.25rem}h3{font-size:1rem;margin-bottom:.25rem}
+code{background:#f1f4f8;padding:0 .25rem;border-radius:3px}
+table{border-collapse:collapse;margin:.5rem 0 1rem}th,td{border:1px solid #dde3ea;padding:.25rem .6rem;text-align:left}
+th{background:#f6f8fa}td.num{text-align:right;font-variant-numeric:tabular-nums}`
+
+// buildProfileHTML renders the markdown profile as a standalone page. The
+// numeric summaries and value counts become tables built from the same
+// numericSummaries/valueCountTables results as the markdown; every other
+// section is converted line by line.
+func buildProfileHTML(markdown string, rows []Row) string {
+	var b strings.Builder
+	b.WriteString("<!doctype html>\n<html lang=\"en\">\n<head>\n<meta charset=\"utf-8\">\n<title>Product data profile</title>\n<style>")
+	b.WriteString(profileHTMLStyle)
+	b.WriteString("</style>\n</head>\n<body>\n")
+	inList, skip := false, false
+	closeList := func() {
+		if inList {
+			b.WriteString("</ul>\n")
+			inList = false
+		}
+	}
+	for _, line := range strings.Split(markdown, "\n") {
+		switch {
+		case strings.HasPrefix(line, "## "):
+			closeList()
+			title := strings.TrimPrefix(line, "## ")
+			fmt.Fprintf(&b, "<h2>%s</h2>\n", profileInlineHTML(title))
+			skip = true
+			switch title {
+			case "Numeric summaries":
+				writeNumericSummaryTable(&b, numericSummaries(rows))
+			case "Value counts (top 20)":
+				for _, vc := range valueCountTables(rows) {
+					writeValueCountTable(&b, vc)
+				}
+			default:
+				skip = false
+			}
+		case skip:
+		case strings.HasPrefix(line, "### "):
+			closeList()
+			fmt.Fprintf(&b, "<h3>%s</h3>\n", profileInlineHTML(strings.TrimPrefix(line, "### ")))
+		case strings.HasPrefix(line, "# "):
+			closeList()
+			fmt.Fprintf(&b, "<h1>%s</h1>\n", profileInlineHTML(strings.TrimPrefix(line, "# ")))
+		case strings.HasPrefix(line, "- "):
+			if !inList {
+				b.WriteString("<ul>\n")
+				inList = true
+			}
+			fmt.Fprintf(&b, "<li>%s</li>\n", profileInlineHTML(strings.TrimPrefix(line, "- ")))
+		case strings.TrimSpace(line) == "":
+			closeList()
+		default:
+			closeList()
+			fmt.Fprintf(&b, "<p>%s</p>\n", profileInlineHTML(line))
+		}
 	}
-	sort.Slice(hcs, func(i, j int) bool {
-		if hcs[i].c == hcs[j].c {
-			return hcs[i].h < hcs[j].h
+	closeList()
+	b.WriteString("</body>\n</html>\n")
+	return b.String()
+}
+
+// profileInlineHTML escapes s and turns `code` spans into <code> elements.
+func profileInlineHTML(s string) string {
+	parts := strings.Split(html.EscapeString(s), "`")
+	var b strings.Builder
+	for i, p := range parts {
+		switch {
+		case i%2 == 0:
+			b.WriteString(p)
+		case i == len(parts)-1:
+			b.WriteString("`" + p) // unmatched backtick
+		default:
+			b.WriteString("<code>" + p + "</code>")
 		}
-		return hcs[i].c > hcs[j].c
-	})
-	for i := 0; i < len(hcs) && i < 30; i++ {
-		lines = append(lines, fmt.Sprintf("- %s: %s", hcs[i].h, fmtInt(hcs[i].c)))
 	}
-	lines = append(lines, "")
+	return b.String()
+}
 
-	lines = append(lines, "## Price consistency")
-	for _, pair := range []struct{ name, a, b string }{
-		{"top_vs_gross_abs_diff", "price_eur_top", "gross_price_current_eur"},
-		{"top_vs_meta_abs_diff", "price_eur_top", "metadata_price_eur"},
-		{"gross_vs_meta_abs_diff", "gross_price_current_eur", "metadata_price_eur"},
-	} {
-		n := 0
+func writeNumericSummaryTable(b *strings.Builder, summaries []numericSummary) {
+	b.WriteString("<table>\n<tr><th>Column</th><th>Count</th><th>Min</th><th>Median</th><th>Mean</th><th>Max</th><th>p25</th><th>p75</th><th>p95</th></tr>\n")
+	for _, ns := range summaries {
+		fmt.Fprintf(b, "<tr><td><code>%s</code></td><td class=\"num\">%s</td>", html.EscapeString(ns.col), fmtInt(ns.count))
+		for _, v := range []float64{ns.min, ns.median, ns.mean, ns.max, ns.p25, ns.p75, ns.p95} {
+			fmt.Fprintf(b, "<td class=\"num\">%s</td>", fmt4g(v))
+		}
+		b.WriteString("</tr>\n")
+	}
+	b.WriteString("</table>\n")
+}
+
+func writeValueCountTable(b *strings.Builder, vc valueCountTable) {
+	fmt.Fprintf(b, "<h3><code>%s</code></h3>\n<table>\n<tr><th>Value</th><th>Count</th></tr>\n", html.EscapeString(vc.col))
+	for _, it := range vc.items {
+		fmt.Fprintf(b, "<tr><td>%s</td><td class=\"num\">%s</td></tr>\n", html.EscapeString(it.value), fmtInt(it.count))
+	}
+	b.WriteString("</table>\n")
+}
+
+const maxMissingPriceExamples = 5
+
+type missingPriceAudit struct {
+	missing, withSource int
+	examples            []string
+}
+
+// auditMissingPrices counts rows whose reconciled price_eur is missing, and
+// how many of those still carried some source price that did not make it.
+func auditMissingPrices(rows []Row) missingPriceAudit {
+	var a missingPriceAudit
+	for _, r := range rows {
+		if !isMissingValue(r["price_eur"]) {
+			continue
+		}
+		a.missing++
+		if !isMissingValue(r["price_raw"]) || !isMissingValue(r["net_price_current_eur"]) {
+			a.withSource++
+		}
+		if g := asString(r["gtin"]); g != "" && len(a.examples) < maxMissingPriceExamples {
+			a.examples = append(a.examples, g)
+		}
+	}
+	return a
+}
+
+// columnRange is one -validate-ranges entry; a nil bound is unchecked.
+type columnRange struct {
+	Min *float64 `json:"min"`
+	Max *float64 `json:"max"`
+}
+
+func (c columnRange) String() string {
+	g := func(f float64) string { return strconv.FormatFloat(f, 'g', -1, 64) }
+	switch {
+	case c.Min != nil && c.Max != nil:
+		return fmt.Sprintf("[%s, %s]", g(*c.Min), g(*c.Max))
+	case c.Min != nil:
+		return ">= " + g(*c.Min)
+	default:
+		return "<= " + g(*c.Max)
+	}
+}
+
+// parseColumnRanges parses the -validate-ranges JSON; "" means no checks.
+func parseColumnRanges(s string) (map[string]columnRange, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+	dec := json.NewDecoder(strings.NewReader(s))
+	dec.DisallowUnknownFields()
+	var ranges map[string]columnRange
+	if err := dec.Decode(&ranges); err != nil {
+		return nil, err
+	}
+	for col, r := range ranges {
+		if r.Min == nil && r.Max == nil {
+			return nil, fmt.Errorf("column %q needs a min or max", col)
+		}
+		if r.Min != nil && r.Max != nil && *r.Min > *r.Max {
+			return nil, fmt.Errorf("column %q has min %v > max %v", col, *r.Min, *r.Max)
+		}
+	}
+	return ranges, nil
+}
+
+// checkRangeColumns rejects -validate-ranges keys that are neither export
+// columns nor keys of the parsed rows, so a misspelled column fails instead of
+// reporting zero violations. It runs after parsing since the flag is read first.
+func checkRangeColumns(ranges map[string]columnRange, rows []Row) error {
+	if len(ranges) == 0 {
+		return nil
+	}
+	available := map[string]bool{}
+	for _, c := range exportColumns {
+		available[c] = true
+	}
+	for _, c := range allColumns(rows) {
+		available[c] = true
+	}
+	var unknown []string
+	for col := range ranges {
+		if !available[col] {
+			unknown = append(unknown, col)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return fmt.Errorf("unknown column(s): %s", strings.Join(unknown, ", "))
+	}
+	return nil
+}
+
+const maxRangeViolationExamples = 5
+
+type rangeViolations struct {
+	column   string
+	rng      columnRange
+	count    int
+	examples []string
+}
+
+// checkColumnRanges counts, per column in name order, the numeric values
+// outside the allowed range. Missing or non-numeric values are not counted.
+func checkColumnRanges(rows []Row, ranges map[string]columnRange) []rangeViolations {
+	cols := make([]string, 0, len(ranges))
+	for col := range ranges {
+		cols = append(cols, col)
+	}
+	sort.Strings(cols)
+	out := make([]rangeViolations, 0, len(cols))
+	for _, col := range cols {
+		v := rangeViolations{column: col, rng: ranges[col]}
 		for _, r := range rows {
-			av, aok := anyFloat64(r[pair.a])
-			bv, bok := anyFloat64(r[pair.b])
-			if !aok || !bok {
+			f, ok := anyFloat64(r[col])
+			if !ok || (v.rng.Min == nil || f >= *v.rng.Min) && (v.rng.Max == nil || f <= *v.rng.Max) {
 				continue
 			}
-			if math.Abs(av-bv) > 0.01 {
-				n++
+			v.count++
+			if len(v.examples) < maxRangeViolationExamples {
+				v.examples = append(v.examples, fmt.Sprintf("%s (%s)", asString(r["gtin"]), strconv.FormatFloat(f, 'g', -1, 64)))
 			}
 		}
-		lines = append(lines, fmt.Sprintf("- `%s` > 0.01 EUR: %s rows", pair.name, fmtInt(n)))
+		out = append(out, v)
 	}
-	lines = append(lines, "")
-	return strings.Join(lines, "\n")
+	return out
+}
+
+func countRangeViolations(vs []rangeViolations) int {
+	n := 0
+	for _, v := range vs {
+		n += v.count
+	}
+	return n
+}
+
+func formatRangeViolations(vs []rangeViolations) string {
+	var b strings.Builder
+	b.WriteString("## Numeric range validation\n")
+	for _, v := range vs {
+		fmt.Fprintf(&b, "- `%s` %s: %s rows out of range", v.column, v.rng, fmtInt(v.count))
+		if len(v.examples) > 0 {
+			fmt.Fprintf(&b, "; e.g. %s", strings.Join(v.examples, ", "))
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+type priceDiffStats struct {
+	both             int
+	meanAbs, maxAbs  float64
+	coverageMismatch int
+}
+
+// comparePriceColumns reports absolute differences over rows where both
+// columns hold a price, and counts rows where exactly one of them is set.
+func comparePriceColumns(rows []Row, a, b string) priceDiffStats {
+	var st priceDiffStats
+	sum := 0.0
+	for _, r := range rows {
+		av, aok := anyFloat64(r[a])
+		bv, bok := anyFloat64(r[b])
+		if aok != bok {
+			st.coverageMismatch++
+			continue
+		}
+		if !aok {
+			continue
+		}
+		d := math.Abs(av - bv)
+		sum += d
+		st.both++
+		if d > st.maxAbs {
+			st.maxAbs = d
+		}
+	}
+	st.meanAbs = safeDiv(sum, float64(st.both))
+	return st
+}
+
+func parsePriceBuckets(s string) ([]float64, error) {
+	var edges []float64
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		f, err := strconv.ParseFloat(part, 64)
+		if err != nil {
+			return nil, fmt.Errorf("edge %q is not a number", part)
+		}
+		if len(edges) > 0 && f <= edges[len(edges)-1] {
+			return nil, fmt.Errorf("edges must be strictly ascending")
+		}
+		edges = append(edges, f)
+	}
+	if len(edges) == 0 {
+		return nil, fmt.Errorf("no edges given")
+	}
+	return edges, nil
+}
+
+type histogramBucket struct {
+	label string
+	count int
+}
+
+// priceHistogram counts price_eur into [edges[i], edges[i+1]) buckets plus an
+// open-ended last bucket. Prices below the first edge get a leading bucket
+// that is only reported when non-empty.
+func priceHistogram(rows []Row, edges []float64) []histogramBucket {
+	buckets := make([]histogramBucket, len(edges)+1)
+	buckets[0].label = "<" + fmt4g(edges[0])
+	for i, e := range edges {
+		if i+1 < len(edges) {
+			buckets[i+1].label = fmt4g(e) + "-" + fmt4g(edges[i+1])
+		} else {
+			buckets[i+1].label = fmt4g(e) + "+"
+		}
+	}
+	for _, r := range rows {
+		v, ok := anyFloat64(r["price_eur"])
+		if !ok {
+			continue
+		}
+		buckets[sort.SearchFloat64s(edges, math.Nextafter(v, math.Inf(1)))].count++
+	}
+	if buckets[0].count == 0 {
+		return buckets[1:]
+	}
+	return buckets
 }
 
 func parseDescriptionGroups(v any) ([]string, map[string]any) {
@@ -715,33 +2087,93 @@ func flattenContentBlock(block map[string]any, collector *[]string) {
 
 func parseUnitInfo(priceInfos []any) map[string]any {
 	out := map[string]any{
-		"unit_quantity":            nil,
-		"unit_quantity_unit":       nil,
-		"unit_price_per_quantity":  nil,
-		"unit_price_per_unit":      nil,
-		"unit_info_raw":            nil,
-		"unit_price_eur":           nil,
+		"unit_quantity":           nil,
+		"unit_quantity_unit":      nil,
+		"unit_price_per_quantity": nil,
+		"unit_price_per_unit":     nil,
+		"unit_info_raw":           nil,
+		"unit_price_eur":          nil,
 	}
 	if len(priceInfos) == 0 {
 		return out
 	}
 	texts := anySliceToTexts(priceInfos)
 	out["unit_info_raw"] = joinTexts(texts, " | ")
+	var best map[string]any
+	bestScore := 0
 	for _, s := range texts {
-		m := reUnitInfo.FindStringSubmatch(s)
-		if len(m) != 6 {
-			continue
+		fields, score := parseUnitInfoText(s)
+		if score > bestScore {
+			best, bestScore = fields, score
 		}
-		out["unit_quantity"] = parseSimpleFloat(strings.ReplaceAll(m[1], ",", "."))
-		out["unit_quantity_unit"] = m[2]
-		out["unit_price_per_quantity"] = parseSimpleFloat(strings.ReplaceAll(m[4], ",", "."))
-		out["unit_price_per_unit"] = m[5]
-		out["unit_price_eur"] = parseEUR(m[3])
-		break
+	}
+	for k, v := range best {
+		out[k] = v
 	}
 	return out
 }
 
+// parseUnitInfoText parses one price info line. Supported shapes:
+//
+//	500 ml (0,80 € je 1 l)
+//	500 ml (1 l = 3,98 €)
+//	3 x 100 g (1 kg = 9,90 €)
+//	3 x 100 g
+//
+// The score is the number of unit fields filled, so callers can prefer the
+// most complete line.
+func parseUnitInfoText(s string) (map[string]any, int) {
+	if m := reUnitInfo.FindStringSubmatch(s); len(m) == 6 {
+		return map[string]any{
+			"unit_quantity":           parseSimpleFloat(strings.ReplaceAll(m[1], ",", ".")),
+			"unit_quantity_unit":      m[2],
+			"unit_price_per_quantity": parseSimpleFloat(strings.ReplaceAll(m[4], ",", ".")),
+			"unit_price_per_unit":     m[5],
+			"unit_price_eur":          parseEUR(m[3]),
+		}, 5
+	}
+	m := reUnitQty.FindStringSubmatch(s)
+	if m == nil {
+		return nil, 0
+	}
+	qty := parseSimpleFloat(strings.ReplaceAll(m[2], ",", "."))
+	if m[1] != "" {
+		n, err := strconv.Atoi(m[1])
+		q, ok := qty.(float64)
+		if err != nil || !ok {
+			return nil, 0
+		}
+		qty = float64(n) * q
+	}
+	out := map[string]any{
+		"unit_quantity":      qty,
+		"unit_quantity_unit": m[3],
+	}
+	if m[4] != "" {
+		var perQty, perUnit, price string
+		if pm := reUnitPriceEq.FindStringSubmatch(m[4]); pm != nil {
+			perQty, perUnit, price = pm[1], pm[2], pm[3]
+		} else if pm := reUnitPriceJe.FindStringSubmatch(m[4]); pm != nil {
+			price, perQty, perUnit = pm[1], pm[2], pm[3]
+		} else {
+			return nil, 0
+		}
+		if perQty == "" {
+			perQty = "1"
+		}
+		out["unit_price_per_quantity"] = parseSimpleFloat(strings.ReplaceAll(perQty, ",", "."))
+		out["unit_price_per_unit"] = perUnit
+		out["unit_price_eur"] = parseEUR(price)
+	}
+	score := 0
+	for _, v := range out {
+		if v != nil {
+			score++
+		}
+	}
+	return out, score
+}
+
 func extractCurrentPrice(priceNode map[string]any) any {
 	if priceNode == nil {
 		return nil
@@ -762,15 +2194,31 @@ func parseNotIncreasedSince(v any) any {
 	if !ok {
 		return nil
 	}
-	m := reDateDE.FindStringSubmatch(s)
-	if len(m) < 2 {
-		return nil
+	if m := reDateDE.FindStringSubmatch(s); len(m) >= 2 {
+		if t, err := time.Parse("02.01.2006", m[1]); err == nil {
+			return t.Format("2006-01-02")
+		}
 	}
-	t, err := time.Parse("02.01.2006", m[1])
-	if err != nil {
-		return nil
+	candidates := append([]string{strings.TrimSpace(s)}, strings.Fields(s)...)
+	for _, layout := range splitDateFormats(*dateFormats) {
+		for _, c := range candidates {
+			c = strings.Trim(c, ".,;:()")
+			if t, err := time.Parse(layout, c); err == nil {
+				return t.Format("2006-01-02")
+			}
+		}
+	}
+	return nil
+}
+
+func splitDateFormats(s string) []string {
+	var out []string
+	for _, part := range strings.Split(s, ",") {
+		if p := strings.TrimSpace(part); p != "" {
+			out = append(out, p)
+		}
 	}
-	return t.Format("2006-01-02")
+	return out
 }
 
 func parseEUR(v any) any {
@@ -795,14 +2243,9 @@ func parseEUR(v any) any {
 	if !ok {
 		return nil
 	}
-	s = strings.ReplaceAll(s, "€", "")
-	s = strings.ReplaceAll(s, "EUR", "")
-	s = strings.ReplaceAll(s, " ", "")
-	if strings.Contains(s, ",") {
-		s = strings.ReplaceAll(s, ".", "")
-		s = strings.ReplaceAll(s, ",", ".")
-	} else {
-		s = reNonNum.ReplaceAllString(s, "")
+	s = normalizePriceText(s, *priceLocale)
+	if !rePriceNum.MatchString(s) {
+		return nil
 	}
 	f, err := strconv.ParseFloat(s, 64)
 	if err != nil {
@@ -811,6 +2254,44 @@ func parseEUR(v any) any {
 	return f
 }
 
+// normalizePriceText turns a localized price string into a plain decimal
+// with "." as separator. When both separators appear the last one is the
+// decimal point; a lone separator followed by exactly three digits is
+// ambiguous and resolved by locale ("de", "en", or "auto" = decimal).
+func normalizePriceText(s, locale string) string {
+	s = rePriceChars.ReplaceAllString(s, "")
+	lastDot := strings.LastIndex(s, ".")
+	lastComma := strings.LastIndex(s, ",")
+	if lastDot >= 0 && lastComma >= 0 {
+		dec, thou := ",", "."
+		if lastDot > lastComma {
+			dec, thou = ".", ","
+		}
+		s = strings.ReplaceAll(s, thou, "")
+		return strings.Replace(s, dec, ".", 1)
+	}
+	sep := ""
+	switch {
+	case lastDot >= 0:
+		sep = "."
+	case lastComma >= 0:
+		sep = ","
+	default:
+		return s
+	}
+	if strings.Count(s, sep) > 1 {
+		return strings.ReplaceAll(s, sep, "")
+	}
+	idx := strings.Index(s, sep)
+	if len(s)-idx-1 == 3 {
+		switch {
+		case locale == "de" && sep == ".", locale == "en" && sep == ",":
+			return strings.Replace(s, sep, "", 1)
+		}
+	}
+	return strings.Replace(s, sep, ".", 1)
+}
+
 func parseIntFromText(v any) any {
 	s, ok := textOrString(v)
 	if !ok {
@@ -909,6 +2390,75 @@ func boolOrNil(v any) any {
 	return nil
 }
 
+// firstImageURL returns the first absolute http(s) URL found in sources, or
+// nil. Each source may be a URL string, a {url|src|href} object, an array of
+// either, or a media object wrapping such an array under images/items.
+func firstImageURL(sources ...any) any {
+	for _, src := range sources {
+		if u, ok := imageURLFrom(src, 0); ok {
+			return u
+		}
+	}
+	return nil
+}
+
+func imageURLFrom(v any, depth int) (string, bool) {
+	if depth > 3 {
+		return "", false
+	}
+	switch t := v.(type) {
+	case string:
+		return absoluteHTTPURL(t)
+	case []any:
+		for _, item := range t {
+			if u, ok := imageURLFrom(item, depth+1); ok {
+				return u, true
+			}
+		}
+	case map[string]any:
+		for _, k := range []string{"url", "src", "href"} {
+			if s, ok := t[k].(string); ok {
+				if u, ok := absoluteHTTPURL(s); ok {
+					return u, true
+				}
+			}
+		}
+		for _, k := range []string{"images", "items", "image"} {
+			if u, ok := imageURLFrom(t[k], depth+1); ok {
+				return u, true
+			}
+		}
+	}
+	return "", false
+}
+
+// canonicalURL returns the first candidate that is an absolute http(s) URL,
+// preferring the page's declared canonical over the scraped product URL.
+func canonicalURL(candidates ...any) any {
+	for _, c := range candidates {
+		if s, ok := c.(string); ok {
+			if u, ok := absoluteHTTPURL(s); ok {
+				return u
+			}
+		}
+	}
+	return nil
+}
+
+// absoluteHTTPURL accepts absolute http(s) URLs; protocol-relative
+// "//host/..." URLs are upgraded to https.
+func absoluteHTTPURL(s string) (string, bool) {
+	s = strings.TrimSpace(s)
+	if strings.HasPrefix(s, "//") {
+		s = "https:" + s
+	}
+	u, err := url.Parse(s)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", false
+	}
+	return s, true
+}
+
 func textOrNil(v any) any {
 	if s, ok := textOrString(v); ok {
 		return s
@@ -1052,7 +2602,14 @@ func csvString(v any) string {
 func csvStringForColumn(col string, v any) string {
 	// Match pandas to_csv float formatting for float-typed export columns (e.g. 1.0, 5.0).
 	switch col {
-	case "price_eur", "unit_quantity", "unit_price_eur", "unit_price_per_quantity", "rating_value":
+	case "price_eur", "unit_price_eur":
+		if f, ok := anyFloat64(v); ok {
+			if *floatPrecision >= 0 && !math.IsNaN(f) {
+				return strconv.FormatFloat(f, 'f', *floatPrecision, 64)
+			}
+			return pythonLikeFloatString(f)
+		}
+	case "unit_quantity", "unit_price_per_quantity", "rating_value":
 		if f, ok := anyFloat64(v); ok {
 			return pythonLikeFloatString(f)
 		}
@@ -1259,6 +2816,21 @@ func median(xs []float64) float64 {
 	return (xs[n/2-1] + xs[n/2]) / 2
 }
 
+// percentile returns the p-th percentile (0-100) of sorted xs using linear
+// interpolation between closest ranks.
+func percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	pos := p / 100 * float64(len(sorted)-1)
+	lo := int(math.Floor(pos))
+	hi := int(math.Ceil(pos))
+	if lo == hi {
+		return sorted[lo]
+	}
+	return sorted[lo] + (sorted[hi]-sorted[lo])*(pos-float64(lo))
+}
+
 func safeDiv(a, b float64) float64 {
 	if b == 0 {
 		return 0
@@ -1273,10 +2845,6 @@ func min(a, b int) int {
 	return b
 }
 
-func writeCSVRecordPythonStyle(w io.Writer, rec []string) error {
-	return writeCSVRecordWithTerminator(w, rec, "\r\n")
-}
-
 func writeCSVRecordWithTerminator(w io.Writer, rec []string, terminator string) error {
 	for i, field := range rec {
 		if i > 0 {
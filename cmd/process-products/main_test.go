@@ -0,0 +1,1143 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBuildProfileDeterministic(t *testing.T) {
+	rows := []Row{
+		{"gtin": "4000000000001", "brand": "Dove", "breadcrumb_1": "Pflege", "rating_count": int64(3), "has_pills": true},
+		{"gtin": "4000000000002", "brand": "Balea", "breadcrumb_1": "Pflege", "rating_value": 4.5, "has_pills": false},
+		{"gtin": "4000000000003", "brand": "Elmex", "dan": int64(42), "unit_quantity": 75.0},
+		{"gtin": "4000000000004", "brand": "Alverde", "slug": "alverde-creme", "available_norm": "yes"},
+	}
+	headerCounts := map[string]int{
+		"Zutaten":             2,
+		"Allergene":           2,
+		"Material":            2,
+		"Produktbeschreibung": 4,
+		"Warnhinweise":        1,
+		"Lieferumfang":        1,
+	}
+
+	first := buildProfile(rows, rows, headerCounts, 5, 1)
+	for i := 0; i < 20; i++ {
+		if got := buildProfile(rows, rows, headerCounts, 5, 1); got != first {
+			t.Fatalf("buildProfile output differs on run %d:\n%s\n---\n%s", i+2, first, got)
+		}
+	}
+}
+
+func TestExportColumnSubset(t *testing.T) {
+	rows := []Row{
+		{"gtin": "4000000000001", "name": "Shampoo", "brand": "Dove", "price_eur": 2.95, "gross_not_increased_since": "2024-01-15"},
+		{"gtin": "4000000000002", "name": "Zahnpasta", "brand": "Elmex", "price_eur": 3.45, "gross_not_increased_since": nil},
+	}
+	want := []string{"name", "gtin", "gross_not_increased_since"}
+	cols, err := resolveExportColumns(" name, gtin ,gross_not_increased_since", rows)
+	if err != nil {
+		t.Fatalf("resolveExportColumns: %v", err)
+	}
+	if strings.Join(cols, ",") != strings.Join(want, ",") {
+		t.Fatalf("cols = %v, want %v", cols, want)
+	}
+	if _, err := resolveExportColumns("gtin,nope", rows); err == nil || !strings.Contains(err.Error(), "nope") {
+		t.Fatalf("expected unknown column error naming nope, got %v", err)
+	}
+
+	dir := t.TempDir()
+	exportRows := buildExportRows(rows, cols)
+	csvPath := filepath.Join(dir, "out.csv")
+	if err := writeReferenceCSV(csvPath, cols, exportRows); err != nil {
+		t.Fatalf("writeReferenceCSV: %v", err)
+	}
+	data, err := os.ReadFile(csvPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := strings.SplitN(strings.TrimPrefix(string(data), "\ufeff"), "\n", 2)[0]
+	if header != "name,gtin,gross_not_increased_since" {
+		t.Fatalf("csv header = %q", header)
+	}
+
+	dbPath := filepath.Join(dir, "out.sqlite")
+	if err := writeSQLite(dbPath, cols, exportRows); err != nil {
+		t.Fatalf("writeSQLite: %v", err)
+	}
+	db, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	res, err := db.Query(`SELECT * FROM sample_products_cleaned`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Close()
+	got, err := res.Columns()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("sqlite columns = %v, want %v", got, want)
+	}
+}
+
+func TestParseEURFormats(t *testing.T) {
+	defer func(old string) { *priceLocale = old }(*priceLocale)
+	cases := []struct {
+		locale string
+		in     any
+		want   any
+	}{
+		{"auto", "4,99 €", 4.99},
+		{"auto", "1.234,56", 1234.56},
+		{"auto", "1,234.56", 1234.56},
+		{"auto", "€ 1 234,56", 1234.56},
+		{"auto", "1\u00a0234,56\u00a0EUR", 1234.56},
+		{"auto", "1.234.567", 1234567.0},
+		{"auto", "12.5", 12.5},
+		{"auto", "1.234", 1.234},
+		{"de", "1.234", 1234.0},
+		{"de", "1,234", 1.234},
+		{"en", "1,234", 1234.0},
+		{"en", "1.234", 1.234},
+		{"auto", "-3,10", -3.1},
+		{"auto", 2.5, 2.5},
+		{"auto", "abc", nil},
+		{"auto", "1,2,3.4.5", nil},
+		{"auto", "", nil},
+		{"auto", nil, nil},
+	}
+	for _, tc := range cases {
+		*priceLocale = tc.locale
+		if got := parseEUR(tc.in); got != tc.want {
+			t.Errorf("parseEUR(%q) with locale %s = %v, want %v", tc.in, tc.locale, got, tc.want)
+		}
+	}
+}
+
+func TestParseNotIncreasedSinceFormats(t *testing.T) {
+	defer func(old string) { *dateFormats = old }(*dateFormats)
+	*dateFormats = "2006-01-02,01/02/2006"
+	cases := map[string]any{
+		"Preis nicht erhöht seit 15.01.2024": "2024-01-15",
+		"2024-03-07":                         "2024-03-07",
+		"not increased since 2024-03-07.":    "2024-03-07",
+		"03/07/2024":                         "2024-03-07",
+		"since 12/31/2023":                   "2023-12-31",
+		"31/12/2023":                         nil,
+		"irgendwann":                         nil,
+	}
+	for in, want := range cases {
+		if got := parseNotIncreasedSince(in); got != want {
+			t.Errorf("parseNotIncreasedSince(%q) = %v, want %v", in, got, want)
+		}
+	}
+
+	*dateFormats = "02/01/2006"
+	if got := parseNotIncreasedSince("31/12/2023"); got != "2023-12-31" {
+		t.Errorf("custom layout: got %v, want 2023-12-31", got)
+	}
+}
+
+func TestParseUnitInfoVariants(t *testing.T) {
+	cases := []struct {
+		name  string
+		infos []any
+		want  map[string]any
+	}{
+		{"je", []any{"500 ml (0,80 € je 1 l)"}, map[string]any{
+			"unit_quantity": 500.0, "unit_quantity_unit": "ml", "unit_price_eur": 0.8, "unit_price_per_quantity": 1.0, "unit_price_per_unit": "l",
+		}},
+		{"base equals", []any{"500 ml (1 l = 3,98 €)"}, map[string]any{
+			"unit_quantity": 500.0, "unit_quantity_unit": "ml", "unit_price_eur": 3.98, "unit_price_per_quantity": 1.0, "unit_price_per_unit": "l",
+		}},
+		{"base without quantity", []any{"250 g (kg = 11,96 €)"}, map[string]any{
+			"unit_quantity": 250.0, "unit_quantity_unit": "g", "unit_price_eur": 11.96, "unit_price_per_quantity": 1.0, "unit_price_per_unit": "kg",
+		}},
+		{"multi-pack", []any{"3 x 100 g"}, map[string]any{
+			"unit_quantity": 300.0, "unit_quantity_unit": "g", "unit_price_eur": nil, "unit_price_per_quantity": nil, "unit_price_per_unit": nil,
+		}},
+		{"multi-pack with base", []any{"3 x 100 g (1 kg = 9,90 €)"}, map[string]any{
+			"unit_quantity": 300.0, "unit_quantity_unit": "g", "unit_price_eur": 9.9, "unit_price_per_quantity": 1.0, "unit_price_per_unit": "kg",
+		}},
+		{"best match wins", []any{"3 x 100 g", "300 g (3,30 € je 1 kg)"}, map[string]any{
+			"unit_quantity": 300.0, "unit_quantity_unit": "g", "unit_price_eur": 3.3, "unit_price_per_quantity": 1.0, "unit_price_per_unit": "kg",
+		}},
+		{"no match", []any{"inkl. 19 % MwSt.", "zzgl. Versand"}, map[string]any{
+			"unit_quantity": nil, "unit_quantity_unit": nil, "unit_price_eur": nil, "unit_price_per_quantity": nil, "unit_price_per_unit": nil,
+		}},
+	}
+	for _, tc := range cases {
+		got := parseUnitInfo(tc.infos)
+		for k, want := range tc.want {
+			if got[k] != want {
+				t.Errorf("%s: %s = %v, want %v", tc.name, k, got[k], want)
+			}
+		}
+		if got["unit_info_raw"] == nil {
+			t.Errorf("%s: unit_info_raw should be kept", tc.name)
+		}
+	}
+}
+
+func TestWriteSQLiteIndexes(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out.sqlite")
+	rows := buildExportRows([]Row{{"gtin": "4000000000001", "brand": "Dove", "category_path": "Pflege", "rating_count": int64(3), "rating_value": 4.5}}, exportColumns)
+	if err := writeSQLite(path, exportColumns, rows); err != nil {
+		t.Fatalf("writeSQLite: %v", err)
+	}
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	res, err := db.Query(`PRAGMA index_list(sample_products_cleaned)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Close()
+	names := map[string]bool{}
+	for res.Next() {
+		var seq, unique, partial int
+		var name, origin string
+		if err := res.Scan(&seq, &name, &unique, &origin, &partial); err != nil {
+			t.Fatal(err)
+		}
+		names[name] = true
+	}
+	for _, want := range []string{"idx_sample_products_cleaned_brand_category", "idx_sample_products_cleaned_rating"} {
+		if !names[want] {
+			t.Errorf("missing index %s (have %v)", want, names)
+		}
+	}
+}
+
+// BenchmarkSimilarQuery runs the servers' brand-category similar query against
+// an export with only the single-column indexes and with the full index set.
+func BenchmarkSimilarQuery(b *testing.B) {
+	var rows []Row
+	for i := 0; i < 20000; i++ {
+		rows = append(rows, Row{
+			"gtin":          fmt.Sprintf("40%011d", i),
+			"name":          fmt.Sprintf("Product %d", i),
+			"brand":         fmt.Sprintf("Brand %d", i%200),
+			"category_path": fmt.Sprintf("Cat %d > Sub %d", i%20, i%60),
+			"rating_count":  int64(i % 500),
+			"rating_value":  float64(i%50) / 10,
+		})
+	}
+	path := filepath.Join(b.TempDir(), "bench.sqlite")
+	if err := writeSQLite(path, exportColumns, buildExportRows(rows, exportColumns)); err != nil {
+		b.Fatalf("writeSQLite: %v", err)
+	}
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer db.Close()
+
+	const q = `SELECT gtin, name, brand, price_eur, currency, category_path, rating_value, rating_count
+		FROM sample_products_cleaned WHERE gtin != ? AND brand = ? AND category_path = ?
+		ORDER BY rating_value DESC, rating_count DESC LIMIT 8`
+	run := func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			res, err := db.Query(q, "40000000000001", "Brand 7", "Cat 7 > Sub 7")
+			if err != nil {
+				b.Fatal(err)
+			}
+			for res.Next() {
+			}
+			res.Close()
+		}
+	}
+	b.Run("with_composite_indexes", run)
+	for _, stmt := range []string{
+		`DROP INDEX idx_sample_products_cleaned_brand_category`,
+		`DROP INDEX idx_sample_products_cleaned_rating`,
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.Run("single_column_indexes", run)
+}
+
+func TestWriteSQLiteBoolNull(t *testing.T) {
+	defer func(old string) { *boolNull = old }(*boolNull)
+	cols := []string{"gtin", "available_norm", "has_pills"}
+	rows := buildExportRows([]Row{{"gtin": "4000000000001", "available_norm": nil, "has_pills": true}}, cols)
+	for mode, want := range map[string]sql.NullInt64{
+		"null": {},
+		"zero": {Int64: 0, Valid: true},
+	} {
+		*boolNull = mode
+		path := filepath.Join(t.TempDir(), mode+".sqlite")
+		if err := writeSQLite(path, cols, rows); err != nil {
+			t.Fatalf("%s: writeSQLite: %v", mode, err)
+		}
+		db, err := sql.Open("sqlite", path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got sql.NullInt64
+		var pills int64
+		var availType, pillsType string
+		err = db.QueryRow(`SELECT available_norm, has_pills FROM sample_products_cleaned`).Scan(&got, &pills)
+		if err == nil {
+			err = db.QueryRow(`SELECT (SELECT type FROM pragma_table_info('sample_products_cleaned') WHERE name = 'available_norm'),
+				(SELECT type FROM pragma_table_info('sample_products_cleaned') WHERE name = 'has_pills')`).Scan(&availType, &pillsType)
+		}
+		db.Close()
+		if err != nil {
+			t.Fatalf("%s: query: %v", mode, err)
+		}
+		if availType != "TEXT" || pillsType != "INTEGER" {
+			t.Errorf("%s: column types available_norm=%s has_pills=%s, want TEXT and INTEGER", mode, availType, pillsType)
+		}
+		if got != want || pills != 1 {
+			t.Errorf("%s: available_norm=%+v has_pills=%d, want %+v and 1", mode, got, pills, want)
+		}
+	}
+}
+
+func TestSampleRowsDeterministic(t *testing.T) {
+	input := filepath.Join(t.TempDir(), "products.jl")
+	var lines []string
+	for i := 0; i < 1000; i++ {
+		lines = append(lines, fmt.Sprintf(`{"gtin": "%013d", "name": "Product %d"}`, i, i))
+	}
+	if err := os.WriteFile(input, []byte(strings.Join(lines, "\n")+"\n"), 0o644); err != nil {
+		t.Fatalf("write input: %v", err)
+	}
+	load := func(seed int64) []Row {
+		t.Helper()
+		rows, _, _, _, _, err := loadAndParseRows(input, 0, rand.New(rand.NewSource(seed)))
+		if err != nil {
+			t.Fatalf("loadAndParseRows: %v", err)
+		}
+		return rows
+	}
+	gtins := func(rs []Row) string {
+		var out []string
+		for _, r := range rs {
+			out = append(out, r["gtin"].(string))
+		}
+		return strings.Join(out, ",")
+	}
+
+	rows := load(42)
+	a := sampleRows(rows, 0.1)
+	if b := sampleRows(load(42), 0.1); gtins(a) != gtins(b) {
+		t.Fatal("same seed produced different samples")
+	}
+	if len(a) != 100 {
+		t.Fatalf("reservoir sample has %d rows, want exactly 10%% of 1000", len(a))
+	}
+	if gtins(sampleRows(load(7), 0.1)) == gtins(a) {
+		t.Fatal("different seeds produced identical samples")
+	}
+	if len(rows) != 1000 {
+		t.Fatalf("sampling mutated the input rows: %d", len(rows))
+	}
+	if got := buildExportRows(rows, exportColumns); len(got) != 1000 {
+		t.Fatalf("full export has %d rows, want 1000", len(got))
+	}
+	if cols := allColumns(rows); strings.Contains(strings.Join(cols, ","), sampleKeyField) {
+		t.Fatalf("sample key leaked into the columns: %v", cols)
+	}
+	if got := sampleRows(rows, 1); len(got) != 1000 {
+		t.Fatalf("rate 1 kept %d rows, want 1000", len(got))
+	}
+	profile := buildProfile(a, rows, nil, 1000, 0)
+	if !strings.Contains(profile, "- Clean rows written: 1,000\n") {
+		t.Fatalf("expected the written-row count to cover every row, not the sample:\n%s", profile)
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	var nums []float64
+	for i := 1; i <= 11; i++ {
+		nums = append(nums, float64(i*10))
+	}
+	for p, want := range map[float64]float64{0: 10, 25: 35, 50: 60, 75: 85, 95: 105, 100: 110} {
+		if got := percentile(nums, p); math.Abs(got-want) > 1e-9 {
+			t.Errorf("percentile(%v) = %v, want %v", p, got, want)
+		}
+	}
+	if got := percentile([]float64{1, 2, 3, 4}, 25); math.Abs(got-1.75) > 1e-9 {
+		t.Errorf("interpolated p25 = %v, want 1.75", got)
+	}
+
+	ratingRows := []Row{{"rating_value": 1.0}, {"rating_value": 2.0}, {"rating_value": 3.0}, {"rating_value": 4.0}}
+	profile := buildProfile(ratingRows, ratingRows, nil, 4, 0)
+	if !strings.Contains(profile, "- `rating_value`: count=4, min=1, median=2.5, mean=2.5, max=4, p25=1.75, p75=3.25, p95=3.85") {
+		t.Fatalf("numeric summary missing percentiles:\n%s", profile)
+	}
+}
+
+func TestPriceHistogram(t *testing.T) {
+	edges, err := parsePriceBuckets("0, 1, 5, 10, 20")
+	if err != nil {
+		t.Fatalf("parsePriceBuckets: %v", err)
+	}
+	var rows []Row
+	for _, p := range []any{0.5, 0.99, 1.0, 4.99, 5.0, 9.5, 15.0, 20.0, 99.0, nil} {
+		rows = append(rows, Row{"price_eur": p})
+	}
+	var got []string
+	for _, b := range priceHistogram(rows, edges) {
+		got = append(got, fmt.Sprintf("%s=%d", b.label, b.count))
+	}
+	if want := "0-1=2,1-5=2,5-10=2,10-20=1,20+=2"; strings.Join(got, ",") != want {
+		t.Fatalf("histogram = %s, want %s", strings.Join(got, ","), want)
+	}
+
+	if _, err := parsePriceBuckets("5,1"); err == nil {
+		t.Fatal("expected error for descending edges")
+	}
+}
+
+func TestComparePriceColumns(t *testing.T) {
+	rows := []Row{
+		{"price_eur_top": 2.0, "metadata_price_eur": 2.5},
+		{"price_eur_top": 3.0, "metadata_price_eur": 3.0},
+		{"price_eur_top": 10.0, "metadata_price_eur": 11.5},
+		{"price_eur_top": 4.0, "metadata_price_eur": nil},
+		{"price_eur_top": nil, "metadata_price_eur": 1.0},
+		{"price_eur_top": nil, "metadata_price_eur": nil},
+	}
+	st := comparePriceColumns(rows, "price_eur_top", "metadata_price_eur")
+	if st.both != 3 || st.coverageMismatch != 2 {
+		t.Fatalf("both=%d mismatch=%d, want 3 and 2", st.both, st.coverageMismatch)
+	}
+	if math.Abs(st.meanAbs-2.0/3) > 1e-9 || st.maxAbs != 1.5 {
+		t.Fatalf("mean=%v max=%v, want 0.6667 and 1.5", st.meanAbs, st.maxAbs)
+	}
+
+	profile := buildProfile(rows, rows, nil, len(rows), 0)
+	if !strings.Contains(profile, "- `price_eur_top` vs `metadata_price_eur`: both=3, mean_abs_diff=0.6667, max_abs_diff=1.5, coverage_mismatch=2") {
+		t.Fatalf("profile missing price difference line:\n%s", profile)
+	}
+}
+
+func TestRetryWithBackoffSucceedsAfterTransientFailures(t *testing.T) {
+	prev := dbRetryBaseDelay
+	dbRetryBaseDelay = time.Millisecond
+	defer func() { dbRetryBaseDelay = prev }()
+
+	calls := 0
+	err := retryWithBackoff(3, func() error {
+		calls++
+		if calls <= 2 {
+			return errors.New("database is locked")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("expected success after retries, got %v", err)
+	}
+	if calls != 3 {
+		t.Fatalf("calls = %d, want 3", calls)
+	}
+
+	calls = 0
+	err = retryWithBackoff(1, func() error {
+		calls++
+		return errors.New("database is locked")
+	})
+	if err == nil || calls != 2 {
+		t.Fatalf("expected failure after 2 attempts, got err=%v calls=%d", err, calls)
+	}
+}
+
+func TestPingSitemap(t *testing.T) {
+	var got []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = append(got, r.URL.Query().Get("sitemap"))
+		if r.URL.Path == "/fail" {
+			http.Error(w, "nope", http.StatusInternalServerError)
+		}
+	}))
+	defer srv.Close()
+
+	const sitemap = "https://shop.example/sitemap.xml?v=1"
+	if err := pingSitemap([]string{srv.URL + "/ping", " "}, sitemap); err != nil {
+		t.Fatalf("pingSitemap: %v", err)
+	}
+	if len(got) != 1 || got[0] != sitemap {
+		t.Fatalf("expected one ping carrying %q, got %q", sitemap, got)
+	}
+
+	err := pingSitemap([]string{srv.URL + "/fail", srv.URL + "/ping"}, sitemap)
+	if err == nil || !strings.Contains(err.Error(), "/fail") {
+		t.Fatalf("expected the failing endpoint to be reported, got %v", err)
+	}
+	if len(got) != 3 {
+		t.Fatalf("expected every endpoint to be tried after a failure, got %d pings", len(got))
+	}
+}
+
+func TestFailOnInvalidJSON(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "products.jl")
+	lines := []string{
+		`{"product": {"gtin": "4000000000001", "title": {"headline": "Shampoo"}}}`,
+		`{"product": {"gtin": "4000000000002", "title": `,
+		``,
+		`{"product": {"gtin": "4000000000003"}}`,
+		`not json`,
+	}
+	if err := os.WriteFile(input, []byte(strings.Join(lines, "\n")+"\n"), 0o644); err != nil {
+		t.Fatalf("write input: %v", err)
+	}
+
+	rows, _, _, sourceRows, invalid, err := loadAndParseRows(input, 0, nil)
+	if err != nil {
+		t.Fatalf("loadAndParseRows: %v", err)
+	}
+	if len(rows) != 2 || sourceRows != 4 || fmt.Sprint(invalid) != "[2 5]" {
+		t.Fatalf("expected 2 rows, 4 source rows and invalid lines [2 5], got %d, %d, %v", len(rows), sourceRows, invalid)
+	}
+	if msg := invalidJSONError(input, invalid).Error(); !strings.Contains(msg, "2 invalid JSON line(s) at line 2, 5") {
+		t.Fatalf("unexpected error message %q", msg)
+	}
+	if msg := invalidJSONError(input, []int{1, 2, 3, 4, 5, 6, 7}).Error(); !strings.HasSuffix(msg, "at line 1, 2, 3, 4, 5, ...") {
+		t.Fatalf("expected only the first %d lines to be listed, got %q", maxReportedInvalidLines, msg)
+	}
+
+	cmd := exec.Command("go", "run", ".", "-input", input, "-out-dir", filepath.Join(dir, "out"), "-fail-on-invalid-json")
+	out, err := cmd.CombinedOutput()
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) || exitErr.ExitCode() == 0 {
+		t.Fatalf("expected a non-zero exit, got err=%v output=%s", err, out)
+	}
+	if !strings.Contains(string(out), "at line 2, 5") {
+		t.Fatalf("expected the invalid line numbers in the output, got %s", out)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "out", "sample_products_cleaned.sqlite")); !os.IsNotExist(err) {
+		t.Fatalf("expected no SQLite output after failing on invalid JSON, got %v", err)
+	}
+}
+
+func TestParseRowImageURL(t *testing.T) {
+	cases := []struct {
+		name string
+		raw  map[string]any
+		want any
+	}{
+		{"nested media objects", map[string]any{"product": map[string]any{"media": map[string]any{"images": []any{
+			map[string]any{"type": "video", "url": "not a url"},
+			map[string]any{"url": "https://media.example/img/front.jpg"},
+			map[string]any{"url": "https://media.example/img/back.jpg"},
+		}}}}, "https://media.example/img/front.jpg"},
+		{"string array", map[string]any{"product": map[string]any{"images": []any{"", "//media.example/a.jpg"}}}, "https://media.example/a.jpg"},
+		{"top-level fallback", map[string]any{"product": map[string]any{}, "images": []any{map[string]any{"src": "http://cdn.example/b.png"}}}, "http://cdn.example/b.png"},
+		{"no usable url", map[string]any{"product": map[string]any{"images": []any{"/relative.jpg", "javascript:alert(1)"}}}, nil},
+	}
+	for _, tc := range cases {
+		row, _ := parseRow(tc.raw)
+		if row["image_url"] != tc.want {
+			t.Errorf("%s: image_url = %#v, want %#v", tc.name, row["image_url"], tc.want)
+		}
+	}
+}
+
+func TestParseRowCanonicalURL(t *testing.T) {
+	row, _ := parseRow(map[string]any{
+		"product_url": "https://shop.example/p/dove-shampoo-p4000000000001.html",
+		"product":     map[string]any{"metadata": map[string]any{"canonical": "https://shop.example/dove-shampoo-p4000000000001.html"}},
+	})
+	if row["canonical_url"] != "https://shop.example/dove-shampoo-p4000000000001.html" {
+		t.Fatalf("expected metadata canonical to win, got %#v", row["canonical_url"])
+	}
+	row, _ = parseRow(map[string]any{
+		"product_url": "https://shop.example/p/balea.html",
+		"product":     map[string]any{"metadata": map[string]any{"canonical": "/relative.html"}},
+	})
+	if row["canonical_url"] != "https://shop.example/p/balea.html" {
+		t.Fatalf("expected product_url fallback for a relative canonical, got %#v", row["canonical_url"])
+	}
+}
+
+func TestWriteReferenceCSVGzip(t *testing.T) {
+	rows := []Row{
+		{"gtin": "4000000000001", "name": "Crème \"Soft\", 50 ml", "price_eur": 2.95},
+		{"gtin": "4000000000002", "name": "Zahnpasta\nKräuter", "price_eur": nil},
+	}
+	cols := []string{"gtin", "name", "price_eur"}
+	dir := t.TempDir()
+	plainPath := filepath.Join(dir, "ref.csv")
+	gzPath := filepath.Join(dir, "ref.csv.gz")
+	if err := writeReferenceCSV(plainPath, cols, rows); err != nil {
+		t.Fatalf("write plain: %v", err)
+	}
+	if err := writeReferenceCSV(gzPath, cols, rows); err != nil {
+		t.Fatalf("write gzip: %v", err)
+	}
+
+	plain, err := os.ReadFile(plainPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(gzPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatalf("gzip reader: %v", err)
+	}
+	unzipped, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatalf("decompress: %v", err)
+	}
+	if !bytes.Equal(unzipped, plain) {
+		t.Fatalf("decompressed CSV differs from plain CSV:\n%q\n%q", unzipped, plain)
+	}
+	if !bytes.HasPrefix(unzipped, []byte("\ufeff")) || !bytes.HasSuffix(unzipped, []byte("\n")) || bytes.Contains(unzipped, []byte("\r\n")) {
+		t.Fatalf("expected BOM and \\n terminators inside the gzip stream, got %q", unzipped)
+	}
+}
+
+func TestQuietAndVerboseOutput(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "products.jl")
+	if err := os.WriteFile(input, []byte(`{"gtin": "4000000000001", "name": "Shampoo", "product": {"gtin": "4000000000001"}}`+"\n"), 0o644); err != nil {
+		t.Fatalf("write input: %v", err)
+	}
+	run := func(extra ...string) string {
+		t.Helper()
+		args := append([]string{"run", ".", "-input", input, "-out-dir", filepath.Join(dir, "out")}, extra...)
+		cmd := exec.Command("go", args...)
+		var stdout, stderr bytes.Buffer
+		cmd.Stdout, cmd.Stderr = &stdout, &stderr
+		if err := cmd.Run(); err != nil {
+			t.Fatalf("process-products %v: %v\n%s", extra, err, stderr.String())
+		}
+		return stdout.String()
+	}
+
+	if out := run(); !strings.Contains(out, "Rows read: 1\n") || !strings.Contains(out, "Rows written (cleaned): 1\n") || strings.Contains(out, "Stage ") {
+		t.Fatalf("expected the summary without stage timings by default, got %q", out)
+	}
+	if out := run("-quiet"); out != "" {
+		t.Fatalf("expected no stdout under -quiet, got %q", out)
+	}
+	out := run("-verbose")
+	for _, stage := range []string{"parse", "normalize", "dedup", "write"} {
+		if !strings.Contains(out, "Stage "+stage+": ") {
+			t.Fatalf("expected %s timing under -verbose, got %q", stage, out)
+		}
+	}
+	if !strings.Contains(out, "Rows read: 1\n") {
+		t.Fatalf("expected the summary under -verbose too, got %q", out)
+	}
+}
+
+func TestLoadAndParseRowsProgress(t *testing.T) {
+	defer func(show bool, out io.Writer, every time.Duration) {
+		*showProgress, progressOut, progressInterval = show, out, every
+	}(*showProgress, progressOut, progressInterval)
+
+	input := filepath.Join(t.TempDir(), "products.jl")
+	var lines []string
+	for i := 0; i < 1200; i++ {
+		lines = append(lines, fmt.Sprintf(`{"gtin": "%013d", "product": {}}`, 4000000000000+i))
+	}
+	if err := os.WriteFile(input, []byte(strings.Join(lines, "\n")+"\n"), 0o644); err != nil {
+		t.Fatalf("write input: %v", err)
+	}
+
+	var buf bytes.Buffer
+	progressOut, progressInterval = &buf, time.Hour
+	*showProgress = true
+	if _, _, _, _, _, err := loadAndParseRows(input, 0, nil); err != nil {
+		t.Fatalf("loadAndParseRows: %v", err)
+	}
+	if out := buf.String(); !strings.HasPrefix(out, "done: 1,200 lines read in ") || !strings.Contains(out, " lines/s)") {
+		t.Fatalf("expected the final count to be logged, got %q", out)
+	}
+
+	buf.Reset()
+	*showProgress = false
+	if _, _, _, _, _, err := loadAndParseRows(input, 0, nil); err != nil {
+		t.Fatalf("loadAndParseRows: %v", err)
+	}
+	if buf.Len() != 0 {
+		t.Fatalf("expected no progress output when disabled, got %q", buf.String())
+	}
+}
+
+func TestManifestRecordsOutputHashes(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "products.jl")
+	lines := `{"gtin": "4000000000001", "name": "Shampoo", "product": {"gtin": "4000000000001"}}` + "\n" +
+		`{"gtin": "4000000000001", "name": "Shampoo", "product": {"gtin": "4000000000001"}}` + "\n"
+	if err := os.WriteFile(input, []byte(lines), 0o644); err != nil {
+		t.Fatalf("write input: %v", err)
+	}
+	outDir := filepath.Join(dir, "out")
+	manifest := filepath.Join(dir, "manifest.json")
+	cmd := exec.Command("go", "run", ".", "-input", input, "-out-dir", outDir, "-manifest", manifest, "-quiet")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("process-products: %v\n%s", err, out)
+	}
+
+	raw, err := os.ReadFile(manifest)
+	if err != nil {
+		t.Fatalf("read manifest: %v", err)
+	}
+	var m runManifest
+	if err := json.Unmarshal(raw, &m); err != nil {
+		t.Fatalf("decode manifest: %v", err)
+	}
+	if fmt.Sprint(m.Inputs) != fmt.Sprint([]string{input}) || m.SourceRows != 2 || m.RowsWritten != 1 || m.DedupedRows != 1 {
+		t.Fatalf("unexpected counts in manifest: %+v", m)
+	}
+	if m.Flags["manifest"] != manifest || m.Flags["quiet"] != "true" {
+		t.Fatalf("expected flags to be recorded, got %v", m.Flags)
+	}
+
+	csvEntry, ok := m.Outputs["csv"]
+	if !ok {
+		t.Fatalf("expected a csv entry, got %v", m.Outputs)
+	}
+	data, err := os.ReadFile(csvEntry.Path)
+	if err != nil {
+		t.Fatalf("read csv: %v", err)
+	}
+	sum := sha256.Sum256(data)
+	if got := hex.EncodeToString(sum[:]); got != csvEntry.SHA256 {
+		t.Fatalf("csv hash mismatch: manifest %s, file %s", csvEntry.SHA256, got)
+	}
+	if csvEntry.Size != int64(len(data)) {
+		t.Fatalf("csv size mismatch: manifest %d, file %d", csvEntry.Size, len(data))
+	}
+	for _, name := range []string{"sqlite", "profile"} {
+		if e := m.Outputs[name]; e.Path == "" || e.SHA256 == "" {
+			t.Fatalf("expected %s entry in manifest, got %+v", name, e)
+		}
+	}
+}
+
+func TestCSVStringForColumnFloatPrecision(t *testing.T) {
+	defer func() { *floatPrecision = -1 }()
+
+	*floatPrecision = -1
+	for in, want := range map[float64]string{4.99: "4.99", 5: "5.0"} {
+		if got := csvStringForColumn("price_eur", in); got != want {
+			t.Errorf("default price_eur(%v) = %q, want %q", in, got, want)
+		}
+	}
+
+	*floatPrecision = 2
+	for in, want := range map[float64]string{4.99: "4.99", 5: "5.00", 1.005: "1.00"} {
+		if got := csvStringForColumn("price_eur", in); got != want {
+			t.Errorf("precision 2 price_eur(%v) = %q, want %q", in, got, want)
+		}
+	}
+	if got := csvStringForColumn("unit_price_eur", 12.5); got != "12.50" {
+		t.Errorf("precision 2 unit_price_eur = %q, want 12.50", got)
+	}
+	if got := csvStringForColumn("rating_value", 4.0); got != "4.0" {
+		t.Errorf("non-price float column should stay pandas-like, got %q", got)
+	}
+	if got := csvStringForColumn("price_eur", nil); got != "" {
+		t.Errorf("missing price should stay empty, got %q", got)
+	}
+}
+
+func TestStrictSchemaUnexpectedKeys(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "products.jl")
+	lines := `{"gtin": "4000000000001", "name": "Shampoo", "product": {"gtin": "4000000000001"}, "variantInfo": {"size": "250ml"}}` + "\n" +
+		`{"gtin": "4000000000002", "name": "Soap", "product": {"gtin": "4000000000002"}, "variantInfo": null}` + "\n"
+	if err := os.WriteFile(input, []byte(lines), 0o644); err != nil {
+		t.Fatalf("write input: %v", err)
+	}
+
+	_, _, keyCounts, _, _, err := loadAndParseRows(input, 0, nil)
+	if err != nil {
+		t.Fatalf("loadAndParseRows: %v", err)
+	}
+	if got := unexpectedTopLevelKeys(keyCounts); fmt.Sprint(got) != "[variantInfo]" || keyCounts["variantInfo"] != 2 {
+		t.Fatalf("expected variantInfo x2 as the only unexpected key, got %v (%v)", got, keyCounts)
+	}
+
+	outDir := filepath.Join(dir, "out")
+	if out, err := exec.Command("go", "run", ".", "-input", input, "-out-dir", outDir).CombinedOutput(); err != nil {
+		t.Fatalf("expected success without -strict-schema: %v\n%s", err, out)
+	}
+	profile, err := os.ReadFile(filepath.Join(outDir, "sample_products_profile.md"))
+	if err != nil {
+		t.Fatalf("read profile: %v", err)
+	}
+	if !strings.Contains(string(profile), "## Unexpected top-level keys\n- `variantInfo`: 2 rows\n") {
+		t.Fatalf("expected unexpected key counts in the profile, got:\n%s", profile)
+	}
+
+	out, err := exec.Command("go", "run", ".", "-input", input, "-out-dir", outDir, "-strict-schema").CombinedOutput()
+	if err == nil {
+		t.Fatalf("expected -strict-schema to fail, got:\n%s", out)
+	}
+	if !strings.Contains(string(out), "1 unexpected top-level key(s): variantInfo (2 rows)") {
+		t.Fatalf("unexpected error output %q", out)
+	}
+}
+
+func TestMultipleInputsDedupKeepsNewestAcrossFiles(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+	}
+	write("part_1.jl", `{"gtin": "4000000000001", "name": "Shampoo new", "scraped_at_utc": "2026-02-02T10:00:00Z", "product": {"gtin": "4000000000001"}}`+"\n"+
+		`{"gtin": "4000000000002", "name": "Soap", "scraped_at_utc": "2026-02-01T10:00:00Z", "product": {"gtin": "4000000000002"}}`+"\n")
+	write("part_2.jl", `{"gtin": "4000000000001", "name": "Shampoo old", "scraped_at_utc": "2026-01-01T10:00:00Z", "product": {"gtin": "4000000000001"}}`+"\n"+
+		"{broken\n")
+
+	paths, err := resolveInputPaths([]string{filepath.Join(dir, "part_*.jl")})
+	if err != nil {
+		t.Fatalf("resolveInputPaths: %v", err)
+	}
+	if len(paths) != 2 || filepath.Base(paths[0]) != "part_1.jl" || filepath.Base(paths[1]) != "part_2.jl" {
+		t.Fatalf("expected both parts in sorted order, got %v", paths)
+	}
+	if _, err := resolveInputPaths([]string{filepath.Join(dir, "missing_*.jl")}); err == nil {
+		t.Fatalf("expected an error for a glob without matches")
+	}
+
+	rows, _, _, files, err := loadAndParseInputs(paths, 0, nil)
+	if err != nil {
+		t.Fatalf("loadAndParseInputs: %v", err)
+	}
+	if len(rows) != 3 || files[0].SourceRows != 2 || files[1].SourceRows != 2 || fmt.Sprint(files[1].InvalidLines) != "[2]" {
+		t.Fatalf("unexpected per-file stats: %d rows, %+v", len(rows), files)
+	}
+	normalizeAndReconcile(rows)
+	sortAndDedupeRows(&rows)
+	if len(rows) != 2 || rows[0]["name"] != "Shampoo new" {
+		t.Fatalf("expected the newest duplicate across files to win, got %v", rows)
+	}
+
+	outDir := filepath.Join(dir, "out")
+	cmd := exec.Command("go", "run", ".", "-input", filepath.Join(dir, "part_2.jl"), "-input", filepath.Join(dir, "part_1.jl"), "-out-dir", outDir)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("process-products: %v\n%s", err, out)
+	}
+	profile, err := os.ReadFile(filepath.Join(outDir, "sample_products_profile.md"))
+	if err != nil {
+		t.Fatalf("read profile: %v", err)
+	}
+	for _, want := range []string{"## Input files\n", "part_1.jl`: 2 rows read, 0 invalid", "part_2.jl`: 2 rows read, 1 invalid"} {
+		if !strings.Contains(string(profile), want) {
+			t.Fatalf("expected %q in profile:\n%s", want, profile)
+		}
+	}
+}
+
+func TestPrepareOutputDirs(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "products.jl")
+	if err := os.WriteFile(input, []byte(`{"gtin": "4000000000001", "name": "Shampoo", "product": {"gtin": "4000000000001"}}`+"\n"), 0o644); err != nil {
+		t.Fatalf("write input: %v", err)
+	}
+	profile := filepath.Join(dir, "reports", "nested", "profile.md")
+	sqlitePath := filepath.Join(dir, "db", "products.sqlite")
+	cmd := exec.Command("go", "run", ".", "-input", input, "-out-dir", filepath.Join(dir, "out"), "-profile", profile, "-sqlite", sqlitePath)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("process-products: %v\n%s", err, out)
+	}
+	for _, p := range []string{profile, sqlitePath} {
+		if _, err := os.Stat(p); err != nil {
+			t.Fatalf("expected %s to be written into a created dir: %v", p, err)
+		}
+	}
+	if entries, _ := os.ReadDir(filepath.Dir(profile)); len(entries) != 1 {
+		t.Fatalf("expected the write probe to be removed, got %d entries", len(entries))
+	}
+
+	blocker := filepath.Join(dir, "blocker")
+	if err := os.WriteFile(blocker, nil, 0o644); err != nil {
+		t.Fatalf("write blocker: %v", err)
+	}
+	if err := prepareOutputDirs(filepath.Join(blocker, "sub")); err == nil || !strings.Contains(err.Error(), "create ") {
+		t.Fatalf("expected a create error below a regular file, got %v", err)
+	}
+	if os.Geteuid() != 0 {
+		readOnly := filepath.Join(dir, "readonly")
+		if err := os.Mkdir(readOnly, 0o555); err != nil {
+			t.Fatalf("mkdir: %v", err)
+		}
+		if err := prepareOutputDirs(readOnly); err == nil || !strings.Contains(err.Error(), "is not writable") {
+			t.Fatalf("expected a not-writable error, got %v", err)
+		}
+	}
+}
+
+func TestScrapedAtLayoutsDrivesDedup(t *testing.T) {
+	defer func() { *timestampLayout = "" }()
+
+	rowsFor := func(stamps ...string) []Row {
+		var rows []Row
+		for i, s := range stamps {
+			row, _ := parseRow(map[string]any{"gtin": "4000000000001", "name": fmt.Sprintf("v%d", i), "scraped_at_utc": s, "product": map[string]any{}})
+			rows = append(rows, row)
+		}
+		return rows
+	}
+
+	*timestampLayout = "02/01/2006 15h04"
+	rows := rowsFor("15/03/2026 09h00", "02/04/2026 08h30", "20/01/2026 23h59")
+	for _, r := range rows {
+		if _, ok := r["_scraped_at_time"].(time.Time); !ok {
+			t.Fatalf("expected %v to parse with -timestamp-layout", r["scraped_at_utc"])
+		}
+	}
+	sortAndDedupeRows(&rows)
+	if len(rows) != 1 || rows[0]["name"] != "v1" {
+		t.Fatalf("expected the 02/04/2026 row to win dedup, got %v", rows)
+	}
+
+	*timestampLayout = ""
+	common := rowsFor("2026-03-01 10:00:00", "2026-02-01T10:00:00Z")
+	sortAndDedupeRows(&common)
+	if common[0]["name"] != "v0" {
+		t.Fatalf("expected the common space-separated layout to parse, got %v", common[0])
+	}
+
+	timeRows := rowsFor("2026-02-01T10:00:00Z", "yesterday")
+	profile := buildProfile(timeRows, timeRows, map[string]int{}, 2, 0)
+	if !strings.Contains(profile, "- Rows with unparseable scraped_at_utc: 1 ") {
+		t.Fatalf("expected the unparseable timestamp count in the profile:\n%s", profile)
+	}
+}
+
+func TestVerifySQLite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "products.sqlite")
+	cols := []string{"gtin", "name"}
+	rows := []Row{{"gtin": "4000000000001", "name": "Shampoo"}, {"gtin": "4000000000002", "name": "Soap"}}
+	if err := writeSQLite(path, cols, rows); err != nil {
+		t.Fatalf("writeSQLite: %v", err)
+	}
+	if err := verifySQLite(path, "sample_products_cleaned", 2); err != nil {
+		t.Fatalf("expected a good write to verify, got %v", err)
+	}
+	if err := verifySQLite(path, "sample_products_cleaned", 3); err == nil || !strings.Contains(err.Error(), "has 2 rows, expected 3") {
+		t.Fatalf("expected a row count mismatch, got %v", err)
+	}
+
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	if _, err := db.Exec(`DROP INDEX idx_sample_products_cleaned_gtin`); err != nil {
+		t.Fatalf("drop index: %v", err)
+	}
+	db.Close()
+	if err := verifySQLite(path, "sample_products_cleaned", 2); err == nil || !strings.Contains(err.Error(), "no index on gtin") {
+		t.Fatalf("expected a missing gtin index error, got %v", err)
+	}
+}
+
+func TestWriteReferenceCSVNoBOM(t *testing.T) {
+	defer func() { *noBOM = false }()
+	path := filepath.Join(t.TempDir(), "ref.csv")
+	rows := []Row{{"gtin": "4000000000001", "name": "Shampoo"}}
+
+	*noBOM = true
+	if err := writeReferenceCSV(path, []string{"gtin", "name"}, rows); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(b, []byte("gtin,name\n")) {
+		t.Fatalf("expected the header as the first bytes under -no-bom, got %q", b)
+	}
+}
+
+func TestWriteReferenceCSVTerminator(t *testing.T) {
+	defer func() { *crlf = false }()
+	rows := []Row{{"gtin": "4000000000001", "name": "Zahnpasta\nKräuter"}, {"gtin": "4000000000002", "name": "Soap"}}
+	write := func() string {
+		t.Helper()
+		var b bytes.Buffer
+		if err := writeReferenceCSVTo(&b, []string{"gtin", "name"}, rows); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		return strings.TrimPrefix(b.String(), "\ufeff")
+	}
+
+	if got, want := write(), "gtin,name\n4000000000001,\"Zahnpasta\nKräuter\"\n4000000000002,Soap\n"; got != want {
+		t.Fatalf("default terminator:\n got %q\nwant %q", got, want)
+	}
+	*crlf = true
+	if got, want := write(), "gtin,name\r\n4000000000001,\"Zahnpasta\nKräuter\"\r\n4000000000002,Soap\r\n"; got != want {
+		t.Fatalf("-crlf terminator:\n got %q\nwant %q", got, want)
+	}
+}
+
+func TestProfileMissingPriceAudit(t *testing.T) {
+	raws := []map[string]any{
+		{"gtin": "4000000000001", "price": "2,95 €", "product": map[string]any{}},
+		{"gtin": "4000000000002", "product": map[string]any{}},
+		{"gtin": "4000000000003", "product": map[string]any{"title": map[string]any{"headline": "No price"}}},
+		{"gtin": "4000000000004", "price": "ask in store", "product": map[string]any{}},
+	}
+	var rows []Row
+	for _, raw := range raws {
+		row, _ := parseRow(raw)
+		rows = append(rows, row)
+	}
+	normalizeAndReconcile(rows)
+
+	audit := auditMissingPrices(rows)
+	if audit.missing != 3 || audit.withSource != 1 || fmt.Sprint(audit.examples) != "[4000000000002 4000000000003 4000000000004]" {
+		t.Fatalf("unexpected audit: %+v", audit)
+	}
+	profile := buildProfile(rows, rows, map[string]int{}, len(rows), 0)
+	for _, want := range []string{
+		"## Products without a price\n",
+		"- `price_eur` missing after reconciliation: 3 rows\n",
+		"- with no source price at all: 2\n",
+		"- example GTINs: 4000000000002, 4000000000003, 4000000000004\n",
+	} {
+		if !strings.Contains(profile, want) {
+			t.Fatalf("expected %q in profile:\n%s", want, profile)
+		}
+	}
+}
+
+func TestCheckColumnRangesFlagsOutOfRangeRating(t *testing.T) {
+	ranges, err := parseColumnRanges(`{"rating_value":{"min":0,"max":5},"price_eur":{"min":0}}`)
+	if err != nil {
+		t.Fatalf("parseColumnRanges: %v", err)
+	}
+	rows := []Row{
+		{"gtin": "4000000000001", "rating_value": 4.5, "price_eur": 3.49},
+		{"gtin": "4000000000002", "rating_value": 6.0, "price_eur": 1.95},
+		{"gtin": "4000000000003", "rating_value": nil, "price_eur": -0.5},
+		{"gtin": "4000000000004", "rating_value": 5.0, "price_eur": nil},
+	}
+	vs := checkColumnRanges(rows, ranges)
+	if countRangeViolations(vs) != 2 {
+		t.Fatalf("expected 2 violations, got %+v", vs)
+	}
+	section := formatRangeViolations(vs)
+	for _, want := range []string{
+		"## Numeric range validation\n",
+		"- `price_eur` >= 0: 1 rows out of range; e.g. 4000000000003 (-0.5)\n",
+		"- `rating_value` [0, 5]: 1 rows out of range; e.g. 4000000000002 (6)\n",
+	} {
+		if !strings.Contains(section, want) {
+			t.Fatalf("expected %q in section:\n%s", want, section)
+		}
+	}
+
+	for _, bad := range []string{`{"rating_value":{}}`, `{"rating_value":{"min":5,"max":0}}`, `{"rating_value":{"low":0}}`, `[1]`} {
+		if _, err := parseColumnRanges(bad); err == nil {
+			t.Fatalf("expected %s to be rejected", bad)
+		}
+	}
+
+	if err := checkRangeColumns(ranges, rows); err != nil {
+		t.Fatalf("expected known columns to pass, got %v", err)
+	}
+	typo, err := parseColumnRanges(`{"rating_valeu":{"max":5},"price_eur":{"min":0}}`)
+	if err != nil {
+		t.Fatalf("parseColumnRanges: %v", err)
+	}
+	if err := checkRangeColumns(typo, rows); err == nil || err.Error() != "unknown column(s): rating_valeu" {
+		t.Fatalf("expected the misspelled column to be rejected, got %v", err)
+	}
+}
+
+func TestNameBrandDuplicatesReportedAndOptionallyDropped(t *testing.T) {
+	older := time.Date(2026, 2, 1, 10, 0, 0, 0, time.UTC)
+	rows := []Row{
+		{"gtin": nil, "name": "Crème  Brûlée Duschgel", "brand": "Balea", "_scraped_at_time": older.Add(time.Hour)},
+		{"gtin": "4000000000001", "name": "Shampoo", "brand": "Dove"},
+		{"gtin": "", "name": "creme brulee duschgel", "brand": "BALEA", "_scraped_at_time": older},
+		{"gtin": "4000000000002", "name": "Shampoo", "brand": "dove"},
+		{"gtin": nil, "name": "Lippenstift", "brand": "Essence"},
+	}
+	// Same order as main: GTIN dedup first, then the name+brand pass.
+	sortAndDedupeRows(&rows)
+	if len(rows) != 5 {
+		t.Fatalf("expected GTIN dedup to keep every row without a GTIN, got %d rows: %v", len(rows), rows)
+	}
+	groups := findNameBrandDuplicates(rows)
+	if len(groups) != 1 || len(groups[0].rows) != 2 {
+		t.Fatalf("expected one group of the two GTIN-less Duschgel rows, got %+v", groups)
+	}
+	section := formatNameBrandDuplicates(groups, false)
+	for _, want := range []string{
+		"- Groups of rows without a GTIN sharing a normalized name and brand: 1\n",
+		"- Extra rows in those groups: 1 (kept; pass -dedup-secondary to drop them)\n",
+		"- `creme brulee duschgel` / `BALEA`: 2 rows\n",
+	} {
+		if !strings.Contains(section, want) {
+			t.Fatalf("expected %q in section:\n%s", want, section)
+		}
+	}
+
+	kept := dropNameBrandDuplicates(rows, groups)
+	if len(kept) != 4 {
+		t.Fatalf("expected only the older GTIN-less duplicate to be dropped, got %+v", kept)
+	}
+	for _, r := range kept {
+		if r["name"] == "creme brulee duschgel" {
+			t.Fatalf("expected the newest duplicate to be kept, got %+v", kept)
+		}
+	}
+}
+
+func TestBuildProfileHTML(t *testing.T) {
+	rows := []Row{
+		{"gtin": "4000000000001", "brand": "Dove", "rating_value": 4.5},
+		{"gtin": "4000000000002", "brand": "Dove", "rating_value": 4.0},
+		{"gtin": "4000000000003", "brand": "Bal<ea>", "rating_value": 3.5},
+	}
+	md := buildProfile(rows, rows, map[string]int{}, 3, 0)
+	page := buildProfileHTML(md+"\n## Deduplication applied\n- Dropped duplicate GTIN rows: 0\n", rows)
+	for _, want := range []string{
+		"<h1>sample_products_all profiling + cleaning report</h1>",
+		"<li>Clean rows written: 3</li>",
+		"<tr><th>Column</th><th>Count</th><th>Min</th>",
+		`<tr><td><code>rating_value</code></td><td class="num">3</td><td class="num">3.5</td><td class="num">4</td>`,
+		"<h3><code>brand</code></h3>\n<table>\n<tr><th>Value</th><th>Count</th></tr>\n<tr><td>Dove</td><td class=\"num\">2</td></tr>\n<tr><td>Bal&lt;ea&gt;</td>",
+		"<h2>Deduplication applied</h2>\n<ul>\n<li>Dropped duplicate GTIN rows: 0</li>\n</ul>",
+	} {
+		if !strings.Contains(page, want) {
+			t.Fatalf("expected %q in HTML profile:\n%s", want, page)
+		}
+	}
+	if strings.Contains(page, "<li><code>rating_value</code>: count=") {
+		t.Fatalf("expected numeric summaries as a table only, not also as a list")
+	}
+}
@@ -2,13 +2,18 @@ package main
 
 import (
 	"bytes"
+	"crypto/sha256"
 	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"io"
 	"math/rand"
 	"os"
 	"path/filepath"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 )
@@ -17,50 +22,700 @@ const (
 	defaultInput  = "outputs/sample_products_reference.csv"
 	defaultOutput = "outputs/sample_products_candidate1.csv"
 	defaultSeed   = int64(20260224)
+
+	mutationSeedSalt = int64(0x5eed)
+	columnSeedSalt   = int64(0xc015)
 )
 
+var (
+	reNumeric = regexp.MustCompile(`^[+-]?(?:\d+\.?\d*|\.\d+)$`)
+
+	// reToken and headerTokenAliases mirror compare-csv so -verify judges
+	// renamed headers the same way the comparator's mapping does.
+	reToken            = regexp.MustCompile(`[a-z0-9]+`)
+	headerTokenAliases = map[string]string{
+		"crumb":      "breadcrumb",
+		"crumbs":     "breadcrumbs",
+		"tree":       "path",
+		"details":    "desc",
+		"highlights": "eyecatchers",
+		"badges":     "pills",
+		"reviews":    "rating",
+		"score":      "value",
+		"qty":        "quantity",
+		"pack":       "unit",
+		"subline":    "subheadline",
+		"amt":        "",
+		"code":       "",
+		"is":         "has",
+		"product":    "",
+	}
+)
+
+type shuffleOptions struct {
+	InputPath           string
+	OutputPath          string
+	Seed                int64
+	SampleRows          int
+	TypoRate            float64
+	DropCellRate        float64
+	NumericReformatRate float64
+	MutateKeys          bool
+	DropCols            int
+	DupCols             int
+	// RenameRules overrides defaultRenameRules when non-nil.
+	RenameRules [][2]string
+	// Delimiter is used for both reading and writing; 0 means ','.
+	Delimiter rune
+	// Terminator ends each written record; "" means "\r\n".
+	Terminator string
+	// NoBOM omits the UTF-8 byte order mark from the output.
+	NoBOM bool
+	// KeepRowOrder skips the row shuffle; columns still shuffle per Seed.
+	KeepRowOrder bool
+	// KeepCols keep their original header and are never dropped or mutated.
+	KeepCols []string
+}
+
+type shuffleResult struct {
+	Columns     []string
+	RenamedCols []string
+	RenameMap   map[string]string
+	Rows        int
+	KeyColumns  []string
+	Mutations   mutationStats
+	DroppedCols []string
+	// DuplicatedCols maps each duplicated source column to the header of its copy.
+	DuplicatedCols map[string]string
+}
+
+type mutationStats struct {
+	Typos            int `json:"typos"`
+	DroppedCells     int `json:"dropped_cells"`
+	NumericReformats int `json:"numeric_reformats"`
+}
+
 func main() {
 	inPath := flag.String("input", defaultInput, "Input CSV path")
 	outPath := flag.String("output", defaultOutput, "Output CSV path")
 	seed := flag.Int64("seed", defaultSeed, "Deterministic shuffle seed")
+	shuffleRows := flag.Bool("shuffle-rows", true, "Shuffle row order (set -shuffle-rows=false to only shuffle/rename columns)")
 	sampleRows := flag.Int("sample-rows", 0, "If > 0, keep only this many rows after shuffling")
+	typoRate := flag.Float64("typo-rate", 0, "Fraction of non-empty text cells that get a single-character typo")
+	dropCellRate := flag.Float64("drop-cell-rate", 0, "Fraction of non-empty cells that are blanked")
+	numericReformatRate := flag.Float64("numeric-reformat-rate", 0, "Fraction of numeric cells that are reformatted (e.g. 5 -> 5.0)")
+	mutateKeys := flag.Bool("mutate-keys", false, "Also apply cell mutations to unique key columns (breaks row alignment)")
+	dropCols := flag.Int("drop-cols", 0, "Remove this many random non-key columns")
+	dupCols := flag.Int("dup-cols", 0, "Duplicate this many random columns under new renamed headers")
+	verify := flag.Bool("verify", false, "After writing, reload both files and check renamed headers still resemble their originals")
+	verifyThreshold := flag.Float64("verify-threshold", 0.5, "Minimum header similarity for a renamed column to count as verified")
+	delimiter := flag.String("delimiter", ",", `Field delimiter for input and output (e.g. ";" or "\t")`)
+	noBOM := flag.Bool("no-bom", false, "Write the output CSV without the leading UTF-8 byte order mark")
+	terminator := flag.String("terminator", "crlf", "Output record terminator: crlf|lf")
+	renameMapPath := flag.String("rename-map", "", "Optional JSON file of ordered [[\"from\",\"to\"],...] header replacements (overrides built-ins)")
+	keepCols := flag.String("keep-cols", "", "Comma-separated columns kept under their original header (not renamed, dropped or mutated)")
+	runReportPath := flag.String("run-report", "", "Optional path to write a JSON run report (seed, paths, counts, options, SHA-256 of the output) for verifying reruns")
+	mappingOut := flag.String("mapping-out", "", "Optional path to write the full {input_column: output_column} rename map as JSON, in output column order (for compare-csv -expected-mapping)")
 	flag.Parse()
 
-	headers, rows, err := loadCSV(*inPath)
+	opts := shuffleOptions{
+		InputPath:           *inPath,
+		OutputPath:          *outPath,
+		Seed:                *seed,
+		SampleRows:          *sampleRows,
+		TypoRate:            *typoRate,
+		DropCellRate:        *dropCellRate,
+		NumericReformatRate: *numericReformatRate,
+		MutateKeys:          *mutateKeys,
+		DropCols:            *dropCols,
+		DupCols:             *dupCols,
+		KeepRowOrder:        !*shuffleRows,
+		NoBOM:               *noBOM,
+		KeepCols:            splitCommaList(*keepCols),
+	}
+	var err error
+	if opts.Delimiter, err = parseDelimiter(*delimiter); err != nil {
+		fmt.Fprintf(os.Stderr, "shuffle error: %v\n", err)
+		os.Exit(1)
+	}
+	switch *terminator {
+	case "crlf":
+		opts.Terminator = "\r\n"
+	case "lf":
+		opts.Terminator = "\n"
+	default:
+		fmt.Fprintf(os.Stderr, "shuffle error: unknown -terminator %q (want crlf or lf)\n", *terminator)
+		os.Exit(1)
+	}
+	if *renameMapPath != "" {
+		rules, err := loadRenameRules(*renameMapPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "shuffle error: %v\n", err)
+			os.Exit(1)
+		}
+		opts.RenameRules = rules
+	}
+	res, err := generateCandidate(opts)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "load csv error: %v\n", err)
+		fmt.Fprintf(os.Stderr, "shuffle error: %v\n", err)
 		os.Exit(1)
 	}
 
-	rng := rand.New(rand.NewSource(*seed))
+	if *mappingOut != "" {
+		if err := writeRenameMap(*mappingOut, res.Columns, res.RenameMap); err != nil {
+			fmt.Fprintf(os.Stderr, "shuffle error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	if *runReportPath != "" {
+		rr, err := buildRunReport(opts, res)
+		if err == nil {
+			err = writeRunReport(*runReportPath, rr)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "shuffle error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	fmt.Printf("Input:  %s\n", opts.InputPath)
+	fmt.Printf("Output: %s\n", opts.OutputPath)
+	fmt.Printf("Seed:   %d\n", opts.Seed)
+	fmt.Printf("Rows:   %d\n", res.Rows)
+	fmt.Printf("Cols:   %d\n", len(res.Columns))
+	if opts.TypoRate > 0 || opts.DropCellRate > 0 || opts.NumericReformatRate > 0 {
+		fmt.Printf("Mutations: typos=%d dropped_cells=%d numeric_reformats=%d (key columns kept: %s)\n",
+			res.Mutations.Typos, res.Mutations.DroppedCells, res.Mutations.NumericReformats, strings.Join(res.KeyColumns, ","))
+	}
+	if len(res.DroppedCols) > 0 {
+		fmt.Printf("Dropped cols: %s\n", strings.Join(res.DroppedCols, ","))
+	}
+	if len(res.DuplicatedCols) > 0 {
+		dups := make([]string, 0, len(res.DuplicatedCols))
+		for c := range res.DuplicatedCols {
+			dups = append(dups, c)
+		}
+		sort.Strings(dups)
+		fmt.Println("Duplicated cols:")
+		for _, c := range dups {
+			fmt.Printf("  %s -> %s\n", c, res.DuplicatedCols[c])
+		}
+	}
+	fmt.Println("Sample column mapping (first 10 in output order):")
+	for i := 0; i < len(res.Columns) && i < 10; i++ {
+		c := res.Columns[i]
+		fmt.Printf("  %s -> %s\n", c, res.RenameMap[c])
+	}
+
+	if *verify {
+		vr, err := verifyCandidateWithDelimiter(opts.InputPath, opts.OutputPath, opts.Delimiter, res, *verifyThreshold)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "verify error: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Verify: %d/%d columns header-match above %.2f\n", vr.Verified, len(vr.Headers), *verifyThreshold)
+		fmt.Println("Inverse column mapping (output -> input):")
+		for _, h := range vr.Headers {
+			mark := ""
+			if vr.Scores[h] < *verifyThreshold {
+				mark = "  [below threshold]"
+			}
+			fmt.Printf("  %s -> %s (%.3f)%s\n", h, vr.Inverse[h], vr.Scores[h], mark)
+		}
+	}
+}
+
+// writeRenameMap stores the ground-truth input->output header mapping so
+// compare-csv can check its column mapping against it. Keys are written in
+// output column order (a plain JSON object, so it still decodes as a map).
+func writeRenameMap(path string, columns []string, renameMap map[string]string) error {
+	order := make([]string, 0, len(renameMap))
+	seen := make(map[string]struct{}, len(renameMap))
+	for _, c := range columns {
+		if _, ok := renameMap[c]; !ok {
+			continue
+		}
+		if _, ok := seen[c]; !ok {
+			seen[c] = struct{}{}
+			order = append(order, c)
+		}
+	}
+	var rest []string
+	for c := range renameMap {
+		if _, ok := seen[c]; !ok {
+			rest = append(rest, c)
+		}
+	}
+	sort.Strings(rest)
+	order = append(order, rest...)
+
+	var b bytes.Buffer
+	b.WriteString("{")
+	for i, c := range order {
+		if i > 0 {
+			b.WriteString(",")
+		}
+		k, err := json.Marshal(c)
+		if err != nil {
+			return err
+		}
+		v, err := json.Marshal(renameMap[c])
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(&b, "\n  %s: %s", k, v)
+	}
+	if len(order) > 0 {
+		b.WriteString("\n")
+	}
+	b.WriteString("}\n")
+	if err := os.WriteFile(path, b.Bytes(), 0o644); err != nil {
+		return fmt.Errorf("write mapping: %w", err)
+	}
+	return nil
+}
+
+// runReport records what a shuffle run did so it can be reproduced and a
+// rerun checked byte-for-byte via OutputSHA256.
+type runReport struct {
+	Seed                int64         `json:"seed"`
+	InputPath           string        `json:"input_path"`
+	OutputPath          string        `json:"output_path"`
+	Rows                int           `json:"rows"`
+	Cols                int           `json:"cols"`
+	SampleRows          int           `json:"sample_rows"`
+	ShuffleRows         bool          `json:"shuffle_rows"`
+	TypoRate            float64       `json:"typo_rate"`
+	DropCellRate        float64       `json:"drop_cell_rate"`
+	NumericReformatRate float64       `json:"numeric_reformat_rate"`
+	MutateKeys          bool          `json:"mutate_keys"`
+	DropCols            []string      `json:"drop_cols"`
+	DupCols             []string      `json:"dup_cols"`
+	KeepCols            []string      `json:"keep_cols"`
+	Mutations           mutationStats `json:"mutations"`
+	OutputSHA256        string        `json:"output_sha256"`
+}
+
+func buildRunReport(opts shuffleOptions, res shuffleResult) (runReport, error) {
+	b, err := os.ReadFile(opts.OutputPath)
+	if err != nil {
+		return runReport{}, fmt.Errorf("digest output: %w", err)
+	}
+	sum := sha256.Sum256(b)
+	dups := make([]string, 0, len(res.DuplicatedCols))
+	for c := range res.DuplicatedCols {
+		dups = append(dups, c)
+	}
+	sort.Strings(dups)
+	return runReport{
+		Seed:                opts.Seed,
+		InputPath:           opts.InputPath,
+		OutputPath:          opts.OutputPath,
+		Rows:                res.Rows,
+		Cols:                len(res.Columns),
+		SampleRows:          opts.SampleRows,
+		ShuffleRows:         !opts.KeepRowOrder,
+		TypoRate:            opts.TypoRate,
+		DropCellRate:        opts.DropCellRate,
+		NumericReformatRate: opts.NumericReformatRate,
+		MutateKeys:          opts.MutateKeys,
+		DropCols:            append([]string{}, res.DroppedCols...),
+		DupCols:             dups,
+		KeepCols:            append([]string{}, opts.KeepCols...),
+		Mutations:           res.Mutations,
+		OutputSHA256:        hex.EncodeToString(sum[:]),
+	}, nil
+}
+
+func writeRunReport(path string, rr runReport) error {
+	b, err := json.MarshalIndent(rr, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, append(b, '\n'), 0o644); err != nil {
+		return fmt.Errorf("write run report: %w", err)
+	}
+	return nil
+}
+
+type verifyResult struct {
+	Headers  []string
+	Inverse  map[string]string
+	Scores   map[string]float64
+	Verified int
+}
+
+// verifyCandidateWithDelimiter reloads the written candidate and scores each
+// output header against the input column it came from.
+func verifyCandidateWithDelimiter(inputPath, outputPath string, delimiter rune, res shuffleResult, threshold float64) (verifyResult, error) {
+	inHeaders, inRows, err := loadCSVWithDelimiter(inputPath, delimiter)
+	if err != nil {
+		return verifyResult{}, fmt.Errorf("load input: %w", err)
+	}
+	outHeaders, outRows, err := loadCSVWithDelimiter(outputPath, delimiter)
+	if err != nil {
+		return verifyResult{}, fmt.Errorf("load output: %w", err)
+	}
+	if len(outRows) != res.Rows {
+		return verifyResult{}, fmt.Errorf("output has %d rows, expected %d", len(outRows), res.Rows)
+	}
+	if len(outRows) > len(inRows) {
+		return verifyResult{}, fmt.Errorf("output has more rows (%d) than input (%d)", len(outRows), len(inRows))
+	}
+
+	inSet := make(map[string]struct{}, len(inHeaders))
+	for _, h := range inHeaders {
+		inSet[h] = struct{}{}
+	}
+	inverse := make(map[string]string, len(outHeaders))
+	for src, renamed := range res.RenameMap {
+		inverse[renamed] = src
+	}
+	for src, dup := range res.DuplicatedCols {
+		inverse[dup] = src
+	}
+
+	vr := verifyResult{
+		Headers: outHeaders,
+		Inverse: make(map[string]string, len(outHeaders)),
+		Scores:  make(map[string]float64, len(outHeaders)),
+	}
+	for _, h := range outHeaders {
+		src, ok := inverse[h]
+		if !ok {
+			return verifyResult{}, fmt.Errorf("output header %q has no source column", h)
+		}
+		if _, ok := inSet[src]; !ok {
+			return verifyResult{}, fmt.Errorf("output header %q maps to unknown input column %q", h, src)
+		}
+		score := headerSimilarity(src, h)
+		vr.Inverse[h] = src
+		vr.Scores[h] = score
+		if score >= threshold {
+			vr.Verified++
+		}
+	}
+	return vr, nil
+}
+
+func headerSimilarity(a, b string) float64 {
+	at := headerTokens(a)
+	bt := headerTokens(b)
+	aNorm := strings.Join(at, "")
+	bNorm := strings.Join(bt, "")
+	if aNorm == "" && bNorm == "" {
+		return 1
+	}
+	seq := normalizedLevenshteinSimilarity(aNorm, bNorm)
+	aSet := make(map[string]struct{}, len(at))
+	bSet := make(map[string]struct{}, len(bt))
+	for _, t := range at {
+		aSet[t] = struct{}{}
+	}
+	for _, t := range bt {
+		bSet[t] = struct{}{}
+	}
+	var jacc float64
+	if len(aSet) == 0 && len(bSet) == 0 {
+		jacc = 1
+	} else if len(aSet) > 0 && len(bSet) > 0 {
+		inter := 0
+		for t := range aSet {
+			if _, ok := bSet[t]; ok {
+				inter++
+			}
+		}
+		jacc = float64(inter) / float64(len(aSet)+len(bSet)-inter)
+	}
+	if seq > jacc {
+		return seq
+	}
+	return jacc
+}
+
+func headerTokens(name string) []string {
+	raw := reToken.FindAllString(strings.ToLower(name), -1)
+	tokens := make([]string, 0, len(raw))
+	for _, t := range raw {
+		ct := t
+		if v, ok := headerTokenAliases[t]; ok {
+			ct = v
+		}
+		if ct != "" {
+			tokens = append(tokens, ct)
+		}
+	}
+	return tokens
+}
+
+func normalizedLevenshteinSimilarity(a, b string) float64 {
+	if a == b {
+		return 1
+	}
+	if a == "" || b == "" {
+		return 0
+	}
+	ar, br := []rune(a), []rune(b)
+	prev := make([]int, len(br)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i, ca := range ar {
+		curr := make([]int, len(br)+1)
+		curr[0] = i + 1
+		for j, cb := range br {
+			sub := prev[j]
+			if ca != cb {
+				sub++
+			}
+			curr[j+1] = min(curr[j]+1, prev[j+1]+1, sub)
+		}
+		prev = curr
+	}
+	return max(0, 1-float64(prev[len(br)])/float64(max(len(ar), len(br))))
+}
+
+func generateCandidate(opts shuffleOptions) (shuffleResult, error) {
+	for _, rate := range []struct {
+		name string
+		v    float64
+	}{
+		{"typo-rate", opts.TypoRate},
+		{"drop-cell-rate", opts.DropCellRate},
+		{"numeric-reformat-rate", opts.NumericReformatRate},
+	} {
+		if rate.v < 0 || rate.v > 1 {
+			return shuffleResult{}, fmt.Errorf("%s must be within [0, 1], got %v", rate.name, rate.v)
+		}
+	}
+	if opts.DropCols < 0 || opts.DupCols < 0 {
+		return shuffleResult{}, fmt.Errorf("drop-cols and dup-cols must be >= 0")
+	}
+
+	headers, rows, err := loadCSVWithDelimiter(opts.InputPath, opts.Delimiter)
+	if err != nil {
+		return shuffleResult{}, fmt.Errorf("load csv: %w", err)
+	}
+
+	rng := rand.New(rand.NewSource(opts.Seed))
 	shuffledCols := append([]string(nil), headers...)
 	rng.Shuffle(len(shuffledCols), func(i, j int) { shuffledCols[i], shuffledCols[j] = shuffledCols[j], shuffledCols[i] })
 
 	shuffledRows := append([]map[string]string(nil), rows...)
-	rng.Shuffle(len(shuffledRows), func(i, j int) { shuffledRows[i], shuffledRows[j] = shuffledRows[j], shuffledRows[i] })
-	if *sampleRows > 0 && *sampleRows < len(shuffledRows) {
-		shuffledRows = shuffledRows[:*sampleRows]
+	if !opts.KeepRowOrder {
+		rng.Shuffle(len(shuffledRows), func(i, j int) { shuffledRows[i], shuffledRows[j] = shuffledRows[j], shuffledRows[i] })
+	}
+	if opts.SampleRows > 0 && opts.SampleRows < len(shuffledRows) {
+		shuffledRows = shuffledRows[:opts.SampleRows]
 	}
 
-	renamedCols, renameMap := buildUniqueNames(shuffledCols)
-	if err := writeCSV(*outPath, renamedCols, shuffledCols, shuffledRows, renameMap); err != nil {
-		fmt.Fprintf(os.Stderr, "write csv error: %v\n", err)
-		os.Exit(1)
+	keep := make(map[string]struct{}, len(opts.KeepCols))
+	for _, c := range opts.KeepCols {
+		if !containsString(headers, c) {
+			return shuffleResult{}, fmt.Errorf("keep-cols: unknown column %q", c)
+		}
+		keep[c] = struct{}{}
 	}
 
-	fmt.Printf("Input:  %s\n", *inPath)
-	fmt.Printf("Output: %s\n", *outPath)
-	fmt.Printf("Seed:   %d\n", *seed)
-	fmt.Printf("Rows:   %d\n", len(shuffledRows))
-	fmt.Printf("Cols:   %d\n", len(shuffledCols))
-	fmt.Println("Sample column mapping (first 10 in output order):")
-	for i := 0; i < len(shuffledCols) && i < 10; i++ {
-		c := shuffledCols[i]
-		fmt.Printf("  %s -> %s\n", c, renameMap[c])
+	keyCols := uniqueKeyColumns(headers, rows)
+	var dropped, duplicated []string
+	if opts.DropCols > 0 || opts.DupCols > 0 {
+		colRng := rand.New(rand.NewSource(opts.Seed ^ columnSeedSalt))
+		protected := append(append([]string(nil), keyCols...), opts.KeepCols...)
+		shuffledCols, dropped, duplicated, err = reshapeColumns(shuffledCols, protected, opts.DropCols, opts.DupCols, colRng)
+		if err != nil {
+			return shuffleResult{}, err
+		}
+	}
+
+	var stats mutationStats
+	if opts.TypoRate > 0 || opts.DropCellRate > 0 || opts.NumericReformatRate > 0 {
+		skip := map[string]struct{}{}
+		for c := range keep {
+			skip[c] = struct{}{}
+		}
+		if !opts.MutateKeys {
+			for _, c := range keyCols {
+				skip[c] = struct{}{}
+			}
+		}
+		// Mutations draw from their own source so enabling them does not
+		// change the row/column shuffle for a given seed.
+		mutRng := rand.New(rand.NewSource(opts.Seed ^ mutationSeedSalt))
+		shuffledRows = cloneRows(shuffledRows)
+		stats = mutateRows(shuffledRows, headers, skip, opts, mutRng)
+	}
+
+	rules := opts.RenameRules
+	if rules == nil {
+		rules = defaultRenameRules
+	}
+	renamedCols, renameMap := buildUniqueNames(shuffledCols, rules, keep)
+	var dupHeaders map[string]string
+	if len(duplicated) > 0 {
+		dupHeaders = make(map[string]string, len(duplicated))
+		seen := make(map[string]struct{}, len(shuffledCols))
+		for i, c := range shuffledCols {
+			if _, ok := seen[c]; ok {
+				dupHeaders[c] = renamedCols[i]
+			}
+			seen[c] = struct{}{}
+		}
+	}
+	if err := writeCSV(opts.OutputPath, renamedCols, shuffledCols, shuffledRows, opts.Delimiter, opts.Terminator, !opts.NoBOM); err != nil {
+		return shuffleResult{}, fmt.Errorf("write csv: %w", err)
+	}
+	return shuffleResult{
+		Columns:        shuffledCols,
+		RenamedCols:    renamedCols,
+		RenameMap:      renameMap,
+		Rows:           len(shuffledRows),
+		KeyColumns:     keyCols,
+		Mutations:      stats,
+		DroppedCols:    dropped,
+		DuplicatedCols: dupHeaders,
+	}, nil
+}
+
+// reshapeColumns removes dropN random non-key columns and inserts a second
+// copy of dupN random remaining columns at random positions.
+func reshapeColumns(cols, keyCols []string, dropN, dupN int, rng *rand.Rand) ([]string, []string, []string, error) {
+	isKey := make(map[string]struct{}, len(keyCols))
+	for _, c := range keyCols {
+		isKey[c] = struct{}{}
+	}
+	droppable := make([]string, 0, len(cols))
+	for _, c := range cols {
+		if _, ok := isKey[c]; !ok {
+			droppable = append(droppable, c)
+		}
+	}
+	if dropN > len(droppable) {
+		return nil, nil, nil, fmt.Errorf("drop-cols %d exceeds %d non-key columns", dropN, len(droppable))
+	}
+	dropped := make([]string, 0, dropN)
+	drop := make(map[string]struct{}, dropN)
+	for _, i := range rng.Perm(len(droppable))[:dropN] {
+		dropped = append(dropped, droppable[i])
+		drop[droppable[i]] = struct{}{}
+	}
+
+	out := make([]string, 0, len(cols)-dropN+dupN)
+	for _, c := range cols {
+		if _, ok := drop[c]; !ok {
+			out = append(out, c)
+		}
+	}
+	if dupN > len(out) {
+		return nil, nil, nil, fmt.Errorf("dup-cols %d exceeds %d remaining columns", dupN, len(out))
+	}
+	duplicated := make([]string, 0, dupN)
+	for _, i := range rng.Perm(len(out))[:dupN] {
+		duplicated = append(duplicated, out[i])
+	}
+	for _, c := range duplicated {
+		pos := rng.Intn(len(out) + 1)
+		out = append(out, "")
+		copy(out[pos+1:], out[pos:])
+		out[pos] = c
+	}
+	return out, dropped, duplicated, nil
+}
+
+func uniqueKeyColumns(headers []string, rows []map[string]string) []string {
+	out := make([]string, 0)
+	for _, h := range headers {
+		seen := make(map[string]struct{}, len(rows))
+		unique := len(rows) > 0
+		for _, row := range rows {
+			v := strings.TrimSpace(row[h])
+			if v == "" {
+				unique = false
+				break
+			}
+			if _, dup := seen[v]; dup {
+				unique = false
+				break
+			}
+			seen[v] = struct{}{}
+		}
+		if unique {
+			out = append(out, h)
+		}
+	}
+	return out
+}
+
+func cloneRows(rows []map[string]string) []map[string]string {
+	out := make([]map[string]string, len(rows))
+	for i, row := range rows {
+		c := make(map[string]string, len(row))
+		for k, v := range row {
+			c[k] = v
+		}
+		out[i] = c
+	}
+	return out
+}
+
+func mutateRows(rows []map[string]string, headers []string, skip map[string]struct{}, opts shuffleOptions, rng *rand.Rand) mutationStats {
+	var stats mutationStats
+	for _, row := range rows {
+		for _, h := range headers {
+			if _, ok := skip[h]; ok {
+				continue
+			}
+			v := row[h]
+			if strings.TrimSpace(v) == "" {
+				continue
+			}
+			// Draw all three rolls for every cell so each rate's selection
+			// is stable regardless of the other rates.
+			dropRoll, numRoll, typoRoll := rng.Float64(), rng.Float64(), rng.Float64()
+			pos := rng.Int()
+			switch {
+			case dropRoll < opts.DropCellRate:
+				row[h] = ""
+				stats.DroppedCells++
+			case reNumeric.MatchString(v):
+				if numRoll < opts.NumericReformatRate {
+					row[h] = reformatNumeric(v)
+					stats.NumericReformats++
+				}
+			case typoRoll < opts.TypoRate:
+				row[h] = injectTypo(v, pos)
+				stats.Typos++
+			}
+		}
 	}
+	return stats
+}
+
+func reformatNumeric(v string) string {
+	s := strings.TrimSpace(v)
+	if strings.Contains(s, ".") {
+		return s + "0"
+	}
+	return s + ".0"
+}
+
+func injectTypo(v string, pos int) string {
+	r := []rune(v)
+	idx := pos % len(r)
+	repl := 'x'
+	if r[idx] == 'x' || r[idx] == 'X' {
+		repl = 'q'
+	}
+	r[idx] = repl
+	return string(r)
 }
 
 func loadCSV(path string) ([]string, []map[string]string, error) {
+	return loadCSVWithDelimiter(path, ',')
+}
+
+func loadCSVWithDelimiter(path string, delimiter rune) ([]string, []map[string]string, error) {
 	b, err := os.ReadFile(path)
 	if err != nil {
 		return nil, nil, err
@@ -68,6 +723,9 @@ func loadCSV(path string) ([]string, []map[string]string, error) {
 	b = bytes.TrimPrefix(b, []byte{0xEF, 0xBB, 0xBF})
 	r := csv.NewReader(bytes.NewReader(b))
 	r.FieldsPerRecord = -1
+	if delimiter != 0 {
+		r.Comma = delimiter
+	}
 	headers, err := r.Read()
 	if err != nil {
 		return nil, nil, err
@@ -94,7 +752,7 @@ func loadCSV(path string) ([]string, []map[string]string, error) {
 	return headers, rows, nil
 }
 
-func writeCSV(path string, renamedCols, shuffledCols []string, rows []map[string]string, renameMap map[string]string) error {
+func writeCSV(path string, renamedCols, shuffledCols []string, rows []map[string]string, delimiter rune, terminator string, bom bool) error {
 	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
 		return err
 	}
@@ -103,68 +761,104 @@ func writeCSV(path string, renamedCols, shuffledCols []string, rows []map[string
 		return err
 	}
 	defer f.Close()
-	if _, err := f.Write([]byte{0xEF, 0xBB, 0xBF}); err != nil {
-		return err
+	if bom {
+		if _, err := f.Write([]byte{0xEF, 0xBB, 0xBF}); err != nil {
+			return err
+		}
 	}
-	if err := writeCSVRecordPythonStyle(f, renamedCols); err != nil {
+	if err := writeCSVRecord(f, renamedCols, delimiter, terminator); err != nil {
 		return err
 	}
 	for _, row := range rows {
 		rec := make([]string, 0, len(shuffledCols))
 		for _, col := range shuffledCols {
-			_ = renameMap[col]
 			rec = append(rec, row[col])
 		}
-		if err := writeCSVRecordPythonStyle(f, rec); err != nil {
+		if err := writeCSVRecord(f, rec, delimiter, terminator); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
+var defaultRenameRules = [][2]string{
+	{"breadcrumbs", "crumbs"},
+	{"breadcrumb", "crumb"},
+	{"category_path", "category_tree"},
+	{"product_is_pharmacy", "is_pharmacy_product"},
+	{"rating_count", "reviews_count"},
+	{"rating_value", "rating_score"},
+	{"price_eur", "price_eur_amt"},
+	{"unit_price", "price_per_unit"},
+	{"unit_quantity", "pack_qty"},
+	{"currency", "currency_code"},
+	{"title_subheadline", "title_subline"},
+	{"has_", "is_"},
+	{"desc_", "details_"},
+	{"eyecatchers", "highlights"},
+	{"pills", "badges"},
+	{"gtin", "gtin_code"},
+	{"dan", "dan_code"},
+	{"name", "product_name"},
+	{"brand", "brand_name"},
+}
+
 func slightRename(col string) string {
+	return applyRenameRules(col, defaultRenameRules)
+}
+
+func applyRenameRules(col string, rules [][2]string) string {
 	out := col
-	replacements := [][2]string{
-		{"breadcrumbs", "crumbs"},
-		{"breadcrumb", "crumb"},
-		{"category_path", "category_tree"},
-		{"product_is_pharmacy", "is_pharmacy_product"},
-		{"rating_count", "reviews_count"},
-		{"rating_value", "rating_score"},
-		{"price_eur", "price_eur_amt"},
-		{"unit_price", "price_per_unit"},
-		{"unit_quantity", "pack_qty"},
-		{"currency", "currency_code"},
-		{"title_subheadline", "title_subline"},
-		{"has_", "is_"},
-		{"desc_", "details_"},
-		{"eyecatchers", "highlights"},
-		{"pills", "badges"},
-		{"gtin", "gtin_code"},
-		{"dan", "dan_code"},
-		{"name", "product_name"},
-		{"brand", "brand_name"},
-	}
-	for _, rep := range replacements {
+	for _, rep := range rules {
 		out = strings.ReplaceAll(out, rep[0], rep[1])
 	}
 	return out
 }
 
-func buildUniqueNames(columns []string) ([]string, map[string]string) {
+// loadRenameRules reads an ordered JSON list of ["from","to"] replacements.
+func loadRenameRules(path string) ([][2]string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var raw [][]string
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return nil, fmt.Errorf("parse rename map %s: %w", path, err)
+	}
+	rules := make([][2]string, 0, len(raw))
+	for i, r := range raw {
+		if len(r) != 2 || r[0] == "" {
+			return nil, fmt.Errorf("rename map %s: entry %d must be a non-empty [\"from\",\"to\"] pair", path, i)
+		}
+		rules = append(rules, [2]string{r[0], r[1]})
+	}
+	return rules, nil
+}
+
+// buildUniqueNames renames columns with rules, suffixing clashes with _N.
+// Columns in keep retain their header, and other columns never take it.
+func buildUniqueNames(columns []string, rules [][2]string, keep map[string]struct{}) ([]string, map[string]string) {
 	renameMap := make(map[string]string, len(columns))
 	used := make(map[string]int)
+	for col := range keep {
+		used[col] = 1
+	}
 	out := make([]string, 0, len(columns))
 	for _, col := range columns {
-		candidate := slightRename(col)
-		if n, ok := used[candidate]; ok {
+		candidate := applyRenameRules(col, rules)
+		_, kept := keep[col]
+		if _, seen := renameMap[col]; kept && !seen {
+			candidate = col
+		} else if n, ok := used[candidate]; ok {
 			n++
 			used[candidate] = n
 			candidate = candidate + "_" + strconv.Itoa(n)
 		} else {
 			used[candidate] = 1
 		}
-		renameMap[col] = candidate
+		if _, ok := renameMap[col]; !ok {
+			renameMap[col] = candidate
+		}
 		out = append(out, candidate)
 	}
 	return out, renameMap
@@ -176,14 +870,23 @@ func normalizeCSVField(s string) string {
 	return s
 }
 
-func writeCSVRecordPythonStyle(w io.Writer, rec []string) error {
+// writeCSVRecord writes rec with Python csv-module quoting; delimiter 0 means
+// ',' and an empty terminator means "\r\n".
+func writeCSVRecord(w io.Writer, rec []string, delimiter rune, terminator string) error {
+	if delimiter == 0 {
+		delimiter = ','
+	}
+	if terminator == "" {
+		terminator = "\r\n"
+	}
+	sep := string(delimiter)
 	for i, field := range rec {
 		if i > 0 {
-			if _, err := io.WriteString(w, ","); err != nil {
+			if _, err := io.WriteString(w, sep); err != nil {
 				return err
 			}
 		}
-		if needsCSVQuote(field) {
+		if needsCSVQuote(field) || strings.Contains(field, sep) {
 			if _, err := io.WriteString(w, `"`); err != nil {
 				return err
 			}
@@ -200,10 +903,42 @@ func writeCSVRecordPythonStyle(w io.Writer, rec []string) error {
 			}
 		}
 	}
-	_, err := io.WriteString(w, "\r\n")
+	_, err := io.WriteString(w, terminator)
 	return err
 }
 
+func splitCommaList(s string) []string {
+	var out []string
+	for _, part := range strings.Split(s, ",") {
+		if p := strings.TrimSpace(part); p != "" {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+func containsString(xs []string, target string) bool {
+	for _, x := range xs {
+		if x == target {
+			return true
+		}
+	}
+	return false
+}
+
+// parseDelimiter accepts a single character, or "\t"/"tab" for tabs.
+func parseDelimiter(s string) (rune, error) {
+	switch s {
+	case `\t`, "tab":
+		return '\t', nil
+	}
+	rs := []rune(s)
+	if len(rs) != 1 || rs[0] == '"' || rs[0] == '\r' || rs[0] == '\n' {
+		return 0, fmt.Errorf("invalid delimiter %q (want a single character other than quote or newline)", s)
+	}
+	return rs[0], nil
+}
+
 func needsCSVQuote(s string) bool {
 	return strings.ContainsAny(s, ",\"\n\r")
 }
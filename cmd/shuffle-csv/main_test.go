@@ -0,0 +1,533 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"testing"
+)
+
+func writeFixtureCSV(t *testing.T, dir string, rows int) string {
+	t.Helper()
+	var b bytes.Buffer
+	b.WriteString("gtin,name,brand,price_eur,rating_count,has_videos\n")
+	for i := 0; i < rows; i++ {
+		fmt.Fprintf(&b, "40000%05d,Product number %d,Brand %d,%d.%02d,%d,%s\n",
+			i, i, i%7, 1+i%20, (i*13)%100, i*3, []string{"True", "False"}[i%2])
+	}
+	path := filepath.Join(dir, "reference.csv")
+	if err := os.WriteFile(path, b.Bytes(), 0o644); err != nil {
+		t.Fatalf("write fixture: %v", err)
+	}
+	return path
+}
+
+func TestGenerateCandidate_FixedSeedProducesStableMutations(t *testing.T) {
+	dir := t.TempDir()
+	input := writeFixtureCSV(t, dir, 200)
+	opts := shuffleOptions{
+		InputPath:           input,
+		Seed:                42,
+		TypoRate:            0.2,
+		DropCellRate:        0.05,
+		NumericReformatRate: 0.3,
+	}
+
+	opts.OutputPath = filepath.Join(dir, "a.csv")
+	first, err := generateCandidate(opts)
+	if err != nil {
+		t.Fatalf("generateCandidate error: %v", err)
+	}
+	opts.OutputPath = filepath.Join(dir, "b.csv")
+	second, err := generateCandidate(opts)
+	if err != nil {
+		t.Fatalf("generateCandidate error: %v", err)
+	}
+	if first.Mutations != second.Mutations {
+		t.Fatalf("expected identical mutation stats for the same seed, got %+v vs %+v", first.Mutations, second.Mutations)
+	}
+	if first.Mutations.Typos == 0 || first.Mutations.DroppedCells == 0 || first.Mutations.NumericReformats == 0 {
+		t.Fatalf("expected every mutation type to fire at these rates, got %+v", first.Mutations)
+	}
+	a, _ := os.ReadFile(filepath.Join(dir, "a.csv"))
+	b, _ := os.ReadFile(filepath.Join(dir, "b.csv"))
+	if !bytes.Equal(a, b) {
+		t.Fatalf("expected byte-identical output for the same seed")
+	}
+	if !containsString(first.KeyColumns, "gtin") {
+		t.Fatalf("expected gtin to be detected as a key column, got %v", first.KeyColumns)
+	}
+
+	headers, rows, err := loadCSV(filepath.Join(dir, "a.csv"))
+	if err != nil {
+		t.Fatalf("loadCSV output error: %v", err)
+	}
+	_, srcRows, _ := loadCSV(input)
+	srcKeys := map[string]struct{}{}
+	for _, r := range srcRows {
+		srcKeys[r["gtin"]] = struct{}{}
+	}
+	keyHeader := first.RenameMap["gtin"]
+	if !containsString(headers, keyHeader) {
+		t.Fatalf("expected renamed key header %q in output", keyHeader)
+	}
+	for _, r := range rows {
+		if _, ok := srcKeys[r[keyHeader]]; !ok {
+			t.Fatalf("expected key column to stay intact, found %q", r[keyHeader])
+		}
+	}
+}
+
+func TestGenerateCandidate_MutationsLowerCompareSimilarity(t *testing.T) {
+	if testing.Short() {
+		t.Skip("runs compare-csv via go run")
+	}
+	dir := t.TempDir()
+	input := writeFixtureCSV(t, dir, 120)
+
+	clean := filepath.Join(dir, "clean.csv")
+	if _, err := generateCandidate(shuffleOptions{InputPath: input, OutputPath: clean, Seed: 7}); err != nil {
+		t.Fatalf("generateCandidate clean error: %v", err)
+	}
+	mutated := filepath.Join(dir, "mutated.csv")
+	if _, err := generateCandidate(shuffleOptions{InputPath: input, OutputPath: mutated, Seed: 7, TypoRate: 0.3}); err != nil {
+		t.Fatalf("generateCandidate mutated error: %v", err)
+	}
+
+	cleanScore := compareSimilarity(t, input, clean)
+	mutatedScore := compareSimilarity(t, input, mutated)
+	if cleanScore != 1.0 {
+		t.Fatalf("expected clean shuffle similarity 1.0, got %.15f", cleanScore)
+	}
+	if !(mutatedScore < cleanScore) {
+		t.Fatalf("expected typos to lower similarity: clean=%.15f mutated=%.15f", cleanScore, mutatedScore)
+	}
+}
+
+func TestGenerateCandidate_SemicolonRoundTripsThroughCompare(t *testing.T) {
+	if testing.Short() {
+		t.Skip("runs compare-csv via go run")
+	}
+	dir := t.TempDir()
+	comma := writeFixtureCSV(t, dir, 80)
+	b, err := os.ReadFile(comma)
+	if err != nil {
+		t.Fatal(err)
+	}
+	input := filepath.Join(dir, "reference_semicolon.csv")
+	// Prices become "1,23" style so the field itself contains the comma.
+	semi := bytes.ReplaceAll(b, []byte(","), []byte(";"))
+	semi = regexp.MustCompile(`;(\d+)\.(\d{2});`).ReplaceAll(semi, []byte(`;"$1,$2";`))
+	if err := os.WriteFile(input, semi, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	out := filepath.Join(dir, "candidate_semicolon.csv")
+	opts := shuffleOptions{InputPath: input, OutputPath: out, Seed: 11, Delimiter: ';', Terminator: "\n"}
+	res, err := generateCandidate(opts)
+	if err != nil {
+		t.Fatalf("generateCandidate error: %v", err)
+	}
+	written, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(written, []byte("\r\n")) || !bytes.Contains(bytes.SplitN(written, []byte("\n"), 2)[0], []byte(";")) {
+		t.Fatalf("expected ';'-delimited LF output, got header %q", bytes.SplitN(written, []byte("\n"), 2)[0])
+	}
+	headers, rows, err := loadCSVWithDelimiter(out, ';')
+	if err != nil {
+		t.Fatalf("reload error: %v", err)
+	}
+	if len(headers) != 6 || len(rows) != res.Rows {
+		t.Fatalf("reloaded %d headers / %d rows, want 6 / %d", len(headers), len(rows), res.Rows)
+	}
+
+	if score := compareSimilarity(t, input, out, "-delimiter", ";"); score != 1.0 {
+		t.Fatalf("expected semicolon round-trip similarity 1.0, got %.15f", score)
+	}
+}
+
+func compareSimilarity(t *testing.T, reference, candidate string, extraArgs ...string) float64 {
+	t.Helper()
+	args := append([]string{"run", "../compare-csv", "-reference", reference, "-candidate", candidate}, extraArgs...)
+	out, err := exec.Command("go", args...).Output()
+	if err != nil {
+		t.Fatalf("compare-csv error: %v", err)
+	}
+	var report struct {
+		Scores struct {
+			DatasetSimilarityEqualWeighted float64 `json:"dataset_similarity_equal_weighted"`
+		} `json:"scores"`
+	}
+	if err := json.Unmarshal(out, &report); err != nil {
+		t.Fatalf("decode compare-csv report: %v", err)
+	}
+	return report.Scores.DatasetSimilarityEqualWeighted
+}
+
+func TestGenerateCandidate_DropAndDuplicateColumns(t *testing.T) {
+	dir := t.TempDir()
+	input := writeFixtureCSV(t, dir, 50)
+	srcHeaders, _, err := loadCSV(input)
+	if err != nil {
+		t.Fatalf("loadCSV input error: %v", err)
+	}
+	out := filepath.Join(dir, "reshaped.csv")
+	res, err := generateCandidate(shuffleOptions{InputPath: input, OutputPath: out, Seed: 3, DropCols: 2, DupCols: 1})
+	if err != nil {
+		t.Fatalf("generateCandidate error: %v", err)
+	}
+
+	headers, rows, err := loadCSV(out)
+	if err != nil {
+		t.Fatalf("loadCSV output error: %v", err)
+	}
+	if want := len(srcHeaders) - 2 + 1; len(headers) != want {
+		t.Fatalf("expected %d output headers, got %d: %v", want, len(headers), headers)
+	}
+	if len(res.DroppedCols) != 2 || len(res.DuplicatedCols) != 1 {
+		t.Fatalf("expected 2 dropped and 1 duplicated column, got %v / %v", res.DroppedCols, res.DuplicatedCols)
+	}
+	for _, c := range res.DroppedCols {
+		if containsString(res.KeyColumns, c) {
+			t.Fatalf("key column %q must not be dropped", c)
+		}
+		if containsString(headers, slightRename(c)) {
+			t.Fatalf("dropped column %q still present as %q", c, slightRename(c))
+		}
+	}
+	for src, dup := range res.DuplicatedCols {
+		orig := res.RenameMap[src]
+		if orig == dup || !containsString(headers, orig) || !containsString(headers, dup) {
+			t.Fatalf("expected %q to appear as both %q and a distinct copy %q in %v", src, orig, dup, headers)
+		}
+		for _, r := range rows {
+			if r[orig] != r[dup] {
+				t.Fatalf("duplicated column %q differs from original: %q vs %q", dup, r[dup], r[orig])
+			}
+		}
+	}
+}
+
+func TestGenerateCandidate_CustomRenameMap(t *testing.T) {
+	dir := t.TempDir()
+	input := writeFixtureCSV(t, dir, 20)
+	mapPath := filepath.Join(dir, "rename.json")
+	if err := os.WriteFile(mapPath, []byte(`[["gtin","barcode"],["name","label"],["brand","label"]]`), 0o644); err != nil {
+		t.Fatalf("write rename map: %v", err)
+	}
+	rules, err := loadRenameRules(mapPath)
+	if err != nil {
+		t.Fatalf("loadRenameRules error: %v", err)
+	}
+
+	out := filepath.Join(dir, "renamed.csv")
+	res, err := generateCandidate(shuffleOptions{InputPath: input, OutputPath: out, Seed: 11, RenameRules: rules})
+	if err != nil {
+		t.Fatalf("generateCandidate error: %v", err)
+	}
+	if got := res.RenameMap["gtin"]; got != "barcode" {
+		t.Fatalf("expected gtin -> barcode, got %q", got)
+	}
+	if got := res.RenameMap["price_eur"]; got != "price_eur" {
+		t.Fatalf("expected custom map to replace built-in rules, got price_eur -> %q", got)
+	}
+	headers, _, err := loadCSV(out)
+	if err != nil {
+		t.Fatalf("loadCSV output error: %v", err)
+	}
+	if !containsString(headers, "barcode") || containsString(headers, "gtin") {
+		t.Fatalf("expected barcode header instead of gtin, got %v", headers)
+	}
+	seen := map[string]bool{}
+	for _, h := range headers {
+		if seen[h] {
+			t.Fatalf("expected unique headers after custom rules, got %v", headers)
+		}
+		seen[h] = true
+	}
+	if res.RenameMap["name"] == res.RenameMap["brand"] {
+		t.Fatalf("expected name and brand to be disambiguated, both got %q", res.RenameMap["name"])
+	}
+}
+
+func TestVerifyCandidate_DefaultRenamesStayRecognizable(t *testing.T) {
+	dir := t.TempDir()
+	header := make([]string, 0, len(defaultRenameRules))
+	for _, r := range defaultRenameRules {
+		if r[0] == "has_" || r[0] == "desc_" {
+			header = append(header, r[0]+"flag")
+			continue
+		}
+		header = append(header, r[0])
+	}
+	var b bytes.Buffer
+	for i, h := range header {
+		if i > 0 {
+			b.WriteString(",")
+		}
+		b.WriteString(h)
+	}
+	b.WriteString("\n")
+	for i := 0; i < 5; i++ {
+		for j := range header {
+			if j > 0 {
+				b.WriteString(",")
+			}
+			fmt.Fprintf(&b, "v%d_%d", i, j)
+		}
+		b.WriteString("\n")
+	}
+	input := filepath.Join(dir, "input.csv")
+	if err := os.WriteFile(input, b.Bytes(), 0o644); err != nil {
+		t.Fatalf("write input: %v", err)
+	}
+
+	out := filepath.Join(dir, "out.csv")
+	res, err := generateCandidate(shuffleOptions{InputPath: input, OutputPath: out, Seed: 5})
+	if err != nil {
+		t.Fatalf("generateCandidate error: %v", err)
+	}
+	const threshold = 0.5
+	vr, err := verifyCandidateWithDelimiter(input, out, ',', res, threshold)
+	if err != nil {
+		t.Fatalf("verifyCandidateWithDelimiter error: %v", err)
+	}
+	if vr.Verified != len(header) {
+		for _, h := range vr.Headers {
+			if vr.Scores[h] < threshold {
+				t.Errorf("%s -> %s scored %.3f", vr.Inverse[h], h, vr.Scores[h])
+			}
+		}
+		t.Fatalf("expected all %d columns to verify, got %d", len(header), vr.Verified)
+	}
+	for _, h := range vr.Headers {
+		if res.RenameMap[vr.Inverse[h]] != h {
+			t.Fatalf("inverse mapping mismatch for %q", h)
+		}
+	}
+}
+
+func TestGenerateCandidate_KeepColsRetainHeaders(t *testing.T) {
+	dir := t.TempDir()
+	input := writeFixtureCSV(t, dir, 30)
+	out := filepath.Join(dir, "kept.csv")
+	res, err := generateCandidate(shuffleOptions{InputPath: input, OutputPath: out, Seed: 5, DropCols: 1, TypoRate: 1, KeepCols: []string{"gtin", "name"}})
+	if err != nil {
+		t.Fatalf("generateCandidate error: %v", err)
+	}
+	if res.RenameMap["gtin"] != "gtin" || res.RenameMap["name"] != "name" {
+		t.Fatalf("expected kept columns to keep their headers, got gtin -> %q, name -> %q", res.RenameMap["gtin"], res.RenameMap["name"])
+	}
+	if res.RenameMap["brand"] != "brand_name" {
+		t.Fatalf("expected other columns to be renamed, got brand -> %q", res.RenameMap["brand"])
+	}
+	if containsString(res.DroppedCols, "name") {
+		t.Fatalf("kept column name must not be dropped: %v", res.DroppedCols)
+	}
+
+	headers, rows, err := loadCSV(out)
+	if err != nil {
+		t.Fatalf("loadCSV output error: %v", err)
+	}
+	if !containsString(headers, "gtin") || !containsString(headers, "name") || containsString(headers, "gtin_code") {
+		t.Fatalf("expected original gtin/name headers in output, got %v", headers)
+	}
+	for _, r := range rows {
+		if !regexp.MustCompile(`^Product number \d+$`).MatchString(r["name"]) {
+			t.Fatalf("kept column name was mutated: %q", r["name"])
+		}
+	}
+
+	if _, err := generateCandidate(shuffleOptions{InputPath: input, OutputPath: out, Seed: 5, KeepCols: []string{"missing"}}); err == nil {
+		t.Fatalf("expected an error for an unknown -keep-cols column")
+	}
+}
+
+func TestGenerateCandidate_KeepRowOrder(t *testing.T) {
+	dir := t.TempDir()
+	input := writeFixtureCSV(t, dir, 25)
+	_, inRows, err := loadCSV(input)
+	if err != nil {
+		t.Fatalf("loadCSV input error: %v", err)
+	}
+	gtins := func(path string) []string {
+		t.Helper()
+		_, rows, err := loadCSV(path)
+		if err != nil {
+			t.Fatalf("loadCSV %s: %v", path, err)
+		}
+		out := make([]string, len(rows))
+		for i, r := range rows {
+			out[i] = r["gtin_code"]
+		}
+		return out
+	}
+	want := make([]string, len(inRows))
+	for i, r := range inRows {
+		want[i] = r["gtin"]
+	}
+
+	kept := filepath.Join(dir, "kept.csv")
+	keptRes, err := generateCandidate(shuffleOptions{InputPath: input, OutputPath: kept, Seed: 9, KeepRowOrder: true})
+	if err != nil {
+		t.Fatalf("generateCandidate error: %v", err)
+	}
+	if got := gtins(kept); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("expected input row order with KeepRowOrder, got %v", got)
+	}
+
+	shuffledA := filepath.Join(dir, "a.csv")
+	shuffledB := filepath.Join(dir, "b.csv")
+	res, err := generateCandidate(shuffleOptions{InputPath: input, OutputPath: shuffledA, Seed: 9})
+	if err != nil {
+		t.Fatalf("generateCandidate error: %v", err)
+	}
+	if _, err := generateCandidate(shuffleOptions{InputPath: input, OutputPath: shuffledB, Seed: 9}); err != nil {
+		t.Fatalf("generateCandidate error: %v", err)
+	}
+	a, b := gtins(shuffledA), gtins(shuffledB)
+	if fmt.Sprint(a) == fmt.Sprint(want) || fmt.Sprint(a) != fmt.Sprint(b) {
+		t.Fatalf("expected a deterministic shuffled row order, got %v and %v", a, b)
+	}
+	if fmt.Sprint(res.Columns) != fmt.Sprint(keptRes.Columns) {
+		t.Fatalf("column shuffle must not depend on row shuffling: %v vs %v", res.Columns, keptRes.Columns)
+	}
+}
+
+func TestWriteRenameMap_CoversEveryColumnInOutputOrder(t *testing.T) {
+	dir := t.TempDir()
+	input := writeFixtureCSV(t, dir, 10)
+	srcHeaders, _, err := loadCSV(input)
+	if err != nil {
+		t.Fatalf("loadCSV input error: %v", err)
+	}
+	res, err := generateCandidate(shuffleOptions{InputPath: input, OutputPath: filepath.Join(dir, "out.csv"), Seed: 4, DupCols: 1})
+	if err != nil {
+		t.Fatalf("generateCandidate error: %v", err)
+	}
+	path := filepath.Join(dir, "mapping.json")
+	if err := writeRenameMap(path, res.Columns, res.RenameMap); err != nil {
+		t.Fatalf("writeRenameMap error: %v", err)
+	}
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read mapping: %v", err)
+	}
+
+	var got map[string]string
+	if err := json.Unmarshal(raw, &got); err != nil {
+		t.Fatalf("decode mapping: %v", err)
+	}
+	if len(got) != len(srcHeaders) {
+		t.Fatalf("expected %d mapped columns, got %d: %v", len(srcHeaders), len(got), got)
+	}
+	for _, h := range srcHeaders {
+		if got[h] == "" || got[h] != slightRename(h) {
+			t.Fatalf("expected %s -> %s, got %q", h, slightRename(h), got[h])
+		}
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	if _, err := dec.Token(); err != nil {
+		t.Fatalf("read opening brace: %v", err)
+	}
+	var keys []string
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			t.Fatalf("read key: %v", err)
+		}
+		keys = append(keys, tok.(string))
+		if _, err := dec.Token(); err != nil {
+			t.Fatalf("read value: %v", err)
+		}
+	}
+	var want []string
+	for _, c := range res.Columns {
+		if !containsString(want, c) {
+			want = append(want, c)
+		}
+	}
+	if fmt.Sprint(keys) != fmt.Sprint(want) {
+		t.Fatalf("expected keys in output column order %v, got %v", want, keys)
+	}
+}
+
+func TestBuildRunReport_DigestFollowsSeed(t *testing.T) {
+	dir := t.TempDir()
+	input := writeFixtureCSV(t, dir, 30)
+	run := func(name string, seed int64) runReport {
+		t.Helper()
+		opts := shuffleOptions{InputPath: input, OutputPath: filepath.Join(dir, name), Seed: seed, SampleRows: 20, TypoRate: 0.2}
+		res, err := generateCandidate(opts)
+		if err != nil {
+			t.Fatalf("generateCandidate error: %v", err)
+		}
+		rr, err := buildRunReport(opts, res)
+		if err != nil {
+			t.Fatalf("buildRunReport error: %v", err)
+		}
+		return rr
+	}
+
+	a, b, c := run("a.csv", 7), run("b.csv", 7), run("c.csv", 8)
+	if len(a.OutputSHA256) != 64 {
+		t.Fatalf("expected a hex SHA-256 digest, got %q", a.OutputSHA256)
+	}
+	if a.OutputSHA256 != b.OutputSHA256 {
+		t.Fatalf("identical seeds produced different digests: %s vs %s", a.OutputSHA256, b.OutputSHA256)
+	}
+	if a.OutputSHA256 == c.OutputSHA256 {
+		t.Fatalf("different seeds produced the same digest %s", a.OutputSHA256)
+	}
+	if a.Seed != 7 || a.Rows != 20 || a.Cols != 6 || a.SampleRows != 20 || !a.ShuffleRows || a.Mutations != b.Mutations {
+		t.Fatalf("unexpected run report fields: %+v", a)
+	}
+
+	path := filepath.Join(dir, "run.json")
+	if err := writeRunReport(path, a); err != nil {
+		t.Fatalf("writeRunReport error: %v", err)
+	}
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read run report: %v", err)
+	}
+	var decoded runReport
+	if err := json.Unmarshal(raw, &decoded); err != nil || decoded.OutputSHA256 != a.OutputSHA256 || decoded.OutputPath != a.OutputPath {
+		t.Fatalf("run report did not round-trip: %v %+v", err, decoded)
+	}
+}
+
+func TestGenerateCandidate_NoBOM(t *testing.T) {
+	dir := t.TempDir()
+	input := writeFixtureCSV(t, dir, 5)
+	withBOM := filepath.Join(dir, "bom.csv")
+	noBOM := filepath.Join(dir, "nobom.csv")
+	if _, err := generateCandidate(shuffleOptions{InputPath: input, OutputPath: withBOM, Seed: 1}); err != nil {
+		t.Fatalf("generateCandidate error: %v", err)
+	}
+	if _, err := generateCandidate(shuffleOptions{InputPath: input, OutputPath: noBOM, Seed: 1, NoBOM: true}); err != nil {
+		t.Fatalf("generateCandidate error: %v", err)
+	}
+	a, err := os.ReadFile(withBOM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(noBOM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bom := []byte{0xEF, 0xBB, 0xBF}
+	if !bytes.HasPrefix(a, bom) {
+		t.Fatalf("expected a BOM by default")
+	}
+	if bytes.HasPrefix(b, bom) || !bytes.Equal(b, a[len(bom):]) {
+		t.Fatalf("expected the same CSV without the BOM, got prefix %q", b[:min(len(b), 20)])
+	}
+}
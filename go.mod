@@ -2,7 +2,10 @@ module extest
 
 go 1.22
 
-require modernc.org/sqlite v1.34.0
+require (
+	golang.org/x/text v0.16.0
+	modernc.org/sqlite v1.34.0
+)
 
 require (
 	github.com/dustin/go-humanize v1.0.1 // indirect